@@ -0,0 +1,105 @@
+package imap
+
+// Right describes a set of operations controlled by the IMAP ACL extension.
+//
+// See RFC 4314 section 2.1.
+type Right byte
+
+const (
+	// Standard rights
+	RightLookup         Right = 'l' // mailbox is visible to LIST/LSUB commands
+	RightRead           Right = 'r' // SELECT the mailbox, perform STATUS
+	RightSeen           Right = 's' // keep seen/unseen information across sessions
+	RightWrite          Right = 'w' // write flags other than \Seen and \Deleted
+	RightInsert         Right = 'i' // perform APPEND, COPY into mailbox
+	RightPost           Right = 'p' // send mail to submission address for mailbox
+	RightCreateMailbox  Right = 'k' // CREATE new sub-mailboxes
+	RightDeleteMailbox  Right = 'x' // DELETE mailbox, old mailbox name in RENAME
+	RightDeleteMessages Right = 't' // set or clear \Deleted flag via STORE
+	RightExpunge        Right = 'e' // perform EXPUNGE and expunge as part of CLOSE
+	RightAdminister     Right = 'a' // perform SETACL/DELETEACL/GETACL/LISTRIGHTS
+)
+
+// RightSet is a set of rights.
+type RightSet []Right
+
+// RightSetAll contains all standard rights.
+var RightSetAll = RightSet("lrswipkxtea")
+
+// String returns the string representation of the right set.
+func (r RightSet) String() string {
+	return string(r)
+}
+
+// Contains checks whether the set contains a right.
+func (r RightSet) Contains(right Right) bool {
+	for _, v := range r {
+		if v == right {
+			return true
+		}
+	}
+	return false
+}
+
+// Add returns a new right set containing rights from both sets.
+func (r RightSet) Add(rights RightSet) RightSet {
+	out := make(RightSet, 0, len(r)+len(rights))
+	for _, right := range r {
+		if !out.Contains(right) {
+			out = append(out, right)
+		}
+	}
+	for _, right := range rights {
+		if !out.Contains(right) {
+			out = append(out, right)
+		}
+	}
+	return out
+}
+
+// Remove returns a new right set containing rights from r which are not in
+// rights.
+func (r RightSet) Remove(rights RightSet) RightSet {
+	out := make(RightSet, 0, len(r))
+	for _, right := range r {
+		if !rights.Contains(right) && !out.Contains(right) {
+			out = append(out, right)
+		}
+	}
+	return out
+}
+
+// RightModification indicates how to mutate a right set.
+type RightModification byte
+
+const (
+	RightModificationReplace RightModification = 0
+	RightModificationAdd     RightModification = '+'
+	RightModificationRemove  RightModification = '-'
+)
+
+// RightsIdentifier is an ACL identifier.
+type RightsIdentifier string
+
+// RightsIdentifierAnyone is the universal identity (matches everyone).
+const RightsIdentifierAnyone RightsIdentifier = "anyone"
+
+// GetACLData is the data returned by the GETACL command.
+type GetACLData struct {
+	Mailbox string
+	Rights  map[RightsIdentifier]RightSet
+}
+
+// ListRightsData is the data returned by the LISTRIGHTS command.
+type ListRightsData struct {
+	Mailbox        string
+	Identifier     RightsIdentifier
+	RequiredRights RightSet
+	OptionalRights []RightSet
+}
+
+// MyRightsData is the data returned by the MYRIGHTS command.
+type MyRightsData struct {
+	Mailbox string
+	Rights  RightSet
+}
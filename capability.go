@@ -40,6 +40,7 @@ const (
 	CapBinary           Cap = "BINARY"             // RFC 3516
 	CapCatenate         Cap = "CATENATE"           // RFC 4469
 	CapChildren         Cap = "CHILDREN"           // RFC 3348
+	CapCompressDeflate  Cap = "COMPRESS=DEFLATE"   // RFC 4978
 	CapCondStore        Cap = "CONDSTORE"          // RFC 7162
 	CapConvert          Cap = "CONVERT"            // RFC 5259
 	CapCreateSpecialUse Cap = "CREATE-SPECIAL-USE" // RFC 6154
@@ -75,6 +76,12 @@ const (
 	CapWithin           Cap = "WITHIN"             // RFC 5032
 )
 
+// Vendor-specific capabilities.
+const (
+	// Gmail extensions, see https://developers.google.com/gmail/imap/imap-extensions
+	CapGmailExt1 Cap = "X-GM-EXT-1"
+)
+
 var imap4rev2Caps = CapSet{
 	CapNamespace:    {},
 	CapUnselect:     {},
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
@@ -21,8 +27,25 @@ var (
 	password     string
 	debug        bool
 	insecureAuth bool
+	stateFile    string
+	imports      importFlag
 )
 
+// importFlag is a list of MAILBOX=PATH pairs.
+type importFlag []string
+
+func (f *importFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *importFlag) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("expected MAILBOX=PATH")
+	}
+	*f = append(*f, s)
+	return nil
+}
+
 func main() {
 	flag.StringVar(&listen, "listen", "localhost:143", "listening address")
 	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate")
@@ -31,6 +54,8 @@ func main() {
 	flag.StringVar(&password, "password", "user", "Password")
 	flag.BoolVar(&debug, "debug", false, "Print all commands and responses")
 	flag.BoolVar(&insecureAuth, "insecure-auth", false, "Allow authentication without TLS")
+	flag.StringVar(&stateFile, "state", "", "Load state from this file at startup and save it on shutdown")
+	flag.Var(&imports, "import", "Import an mbox file into a mailbox, formatted as MAILBOX=PATH (can be repeated, ignored if the state file exists)")
 	flag.Parse()
 
 	var tlsConfig *tls.Config
@@ -58,6 +83,33 @@ func main() {
 		memServer.AddUser(user)
 	}
 
+	stateLoaded := false
+	if stateFile != "" {
+		if err := memServer.LoadFile(stateFile); err == nil {
+			stateLoaded = true
+		} else if !os.IsNotExist(err) {
+			log.Fatalf("Failed to load state: %v", err)
+		}
+	}
+
+	// The state already contains the messages imported on a previous start
+	if stateLoaded && len(imports) > 0 {
+		log.Printf("State loaded from %q, skipping mbox imports", stateFile)
+		imports = nil
+	}
+
+	// The user may have been replaced when loading the state
+	user := memServer.User(username)
+	if len(imports) > 0 && user == nil {
+		log.Fatalf("Cannot import mbox files without a user")
+	}
+	for _, s := range imports {
+		mailbox, path, _ := strings.Cut(s, "=")
+		if err := importMbox(user, mailbox, path); err != nil {
+			log.Fatalf("Failed to import %q into %q: %v", path, mailbox, err)
+		}
+	}
+
 	var debugWriter io.Writer
 	if debug {
 		debugWriter = os.Stdout
@@ -68,14 +120,72 @@ func main() {
 			return memServer.NewSession(), nil, nil
 		},
 		Caps: imap.CapSet{
-			imap.CapIMAP4rev1: {},
-			imap.CapIMAP4rev2: {},
+			imap.CapIMAP4rev1:       {},
+			imap.CapIMAP4rev2:       {},
+			imap.CapCondStore:       {},
+			imap.CapQResync:         {},
+			imap.CapSort:            {},
+			imap.CapESort:           {},
+			imap.CapMetadata:        {},
+			imap.CapMetadataServer:  {},
+			imap.CapQuota:           {},
+			imap.CapQuotaSet:        {},
+			imap.CapACL:             {},
+			imap.CapListMyRights:    {},
+			imap.CapCompressDeflate: {},
+			imap.CapID:              {},
+			imap.CapNotify:          {},
+			"THREAD=ORDEREDSUBJECT": {},
+			"THREAD=REFERENCES":     {},
 		},
 		TLSConfig:    tlsConfig,
 		InsecureAuth: insecureAuth,
 		DebugWriter:  debugWriter,
 	})
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-sigCh
+		log.Printf("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx, nil); err != nil {
+			log.Printf("Shutdown() = %v", err)
+		}
+	}()
+
 	if err := server.Serve(ln); err != nil {
 		log.Fatalf("Serve() = %v", err)
 	}
+
+	// Serve returns as soon as the listener is closed: wait for in-flight
+	// commands to complete before saving the state
+	<-shutdownDone
+
+	if stateFile != "" {
+		if err := memServer.SaveFile(stateFile); err != nil {
+			log.Fatalf("Failed to save state: %v", err)
+		}
+	}
+}
+
+func importMbox(user *imapmemserver.User, mailbox, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The mailbox may already exist
+	user.Create(mailbox, nil)
+
+	n, err := user.ImportMbox(mailbox, f)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %v messages into %q", n, mailbox)
+	return nil
 }
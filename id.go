@@ -0,0 +1,23 @@
+package imap
+
+// IDData is the data sent with the ID command and returned in the ID
+// response, as defined in RFC 2971.
+//
+// Keys are field names, which are case-insensitive. Fields with a NIL value
+// are represented by an empty string.
+type IDData map[string]string
+
+// Standard ID field names.
+const (
+	IDName        = "name"
+	IDVersion     = "version"
+	IDOS          = "os"
+	IDOSVersion   = "os-version"
+	IDVendor      = "vendor"
+	IDSupportURL  = "support-url"
+	IDAddress     = "address"
+	IDDate        = "date"
+	IDCommand     = "command"
+	IDArguments   = "arguments"
+	IDEnvironment = "environment"
+)
@@ -0,0 +1,237 @@
+package imapclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// SetACL sends a SETACL command.
+//
+// This command requires support for the ACL extension.
+func (c *Client) SetACL(mailbox string, ri imap.RightsIdentifier, rm imap.RightModification, rights imap.RightSet) *Command {
+	cmd := &Command{}
+	enc := c.beginCommand("SETACL", cmd)
+	enc.SP().Mailbox(mailbox).SP().String(string(ri)).SP()
+	s := rights.String()
+	if rm != imap.RightModificationReplace {
+		s = string(rm) + s
+	}
+	enc.String(s)
+	enc.end()
+	return cmd
+}
+
+// DeleteACL sends a DELETEACL command.
+//
+// This command requires support for the ACL extension.
+func (c *Client) DeleteACL(mailbox string, ri imap.RightsIdentifier) *Command {
+	cmd := &Command{}
+	enc := c.beginCommand("DELETEACL", cmd)
+	enc.SP().Mailbox(mailbox).SP().String(string(ri))
+	enc.end()
+	return cmd
+}
+
+// GetACL sends a GETACL command.
+//
+// This command requires support for the ACL extension.
+func (c *Client) GetACL(mailbox string) *GetACLCommand {
+	cmd := &GetACLCommand{mailbox: mailbox}
+	enc := c.beginCommand("GETACL", cmd)
+	enc.SP().Mailbox(mailbox)
+	enc.end()
+	return cmd
+}
+
+// ListRights sends a LISTRIGHTS command.
+//
+// This command requires support for the ACL extension.
+func (c *Client) ListRights(mailbox string, ri imap.RightsIdentifier) *ListRightsCommand {
+	cmd := &ListRightsCommand{mailbox: mailbox}
+	enc := c.beginCommand("LISTRIGHTS", cmd)
+	enc.SP().Mailbox(mailbox).SP().String(string(ri))
+	enc.end()
+	return cmd
+}
+
+// MyRights sends a MYRIGHTS command.
+//
+// This command requires support for the ACL extension.
+func (c *Client) MyRights(mailbox string) *MyRightsCommand {
+	cmd := &MyRightsCommand{mailbox: mailbox}
+	enc := c.beginCommand("MYRIGHTS", cmd)
+	enc.SP().Mailbox(mailbox)
+	enc.end()
+	return cmd
+}
+
+func (c *Client) handleACL() error {
+	data, err := readACLResponse(c.dec)
+	if err != nil {
+		return fmt.Errorf("in acl-data: %v", err)
+	}
+
+	cmd := c.findPendingCmdFunc(func(anyCmd command) bool {
+		cmd, ok := anyCmd.(*GetACLCommand)
+		return ok && cmd.mailbox == data.Mailbox
+	})
+	if cmd != nil {
+		cmd := cmd.(*GetACLCommand)
+		cmd.data = *data
+	}
+	return nil
+}
+
+func (c *Client) handleListRights() error {
+	data, err := readListRightsResponse(c.dec)
+	if err != nil {
+		return fmt.Errorf("in listrights-data: %v", err)
+	}
+
+	cmd := c.findPendingCmdFunc(func(anyCmd command) bool {
+		cmd, ok := anyCmd.(*ListRightsCommand)
+		return ok && cmd.mailbox == data.Mailbox
+	})
+	if cmd != nil {
+		cmd := cmd.(*ListRightsCommand)
+		cmd.data = *data
+	}
+	return nil
+}
+
+func (c *Client) handleMyRights() error {
+	data, err := readMyRightsResponse(c.dec)
+	if err != nil {
+		return fmt.Errorf("in myrights-data: %v", err)
+	}
+
+	cmd := c.findPendingCmdFunc(func(anyCmd command) bool {
+		switch cmd := anyCmd.(type) {
+		case *MyRightsCommand:
+			return cmd.mailbox == data.Mailbox
+		case *ListCommand:
+			return cmd.returnMyRights && cmd.pendingData != nil && cmd.pendingData.Mailbox == data.Mailbox
+		default:
+			return false
+		}
+	})
+	switch cmd := cmd.(type) {
+	case *MyRightsCommand:
+		cmd.data = *data
+	case *ListCommand:
+		cmd.pendingData.MyRights = data
+		cmd.flushPendingData()
+	}
+	return nil
+}
+
+// GetACLCommand is a GETACL command.
+type GetACLCommand struct {
+	cmd
+	mailbox string
+	data    imap.GetACLData
+}
+
+func (cmd *GetACLCommand) Wait() (*imap.GetACLData, error) {
+	return &cmd.data, cmd.cmd.Wait()
+}
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *GetACLCommand) WaitContext(ctx context.Context) (*imap.GetACLData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
+// ListRightsCommand is a LISTRIGHTS command.
+type ListRightsCommand struct {
+	cmd
+	mailbox string
+	data    imap.ListRightsData
+}
+
+func (cmd *ListRightsCommand) Wait() (*imap.ListRightsData, error) {
+	return &cmd.data, cmd.cmd.Wait()
+}
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *ListRightsCommand) WaitContext(ctx context.Context) (*imap.ListRightsData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
+// MyRightsCommand is a MYRIGHTS command.
+type MyRightsCommand struct {
+	cmd
+	mailbox string
+	data    imap.MyRightsData
+}
+
+func (cmd *MyRightsCommand) Wait() (*imap.MyRightsData, error) {
+	return &cmd.data, cmd.cmd.Wait()
+}
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *MyRightsCommand) WaitContext(ctx context.Context) (*imap.MyRightsData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
+func readACLResponse(dec *imapwire.Decoder) (*imap.GetACLData, error) {
+	data := imap.GetACLData{Rights: make(map[imap.RightsIdentifier]imap.RightSet)}
+	if !dec.ExpectMailbox(&data.Mailbox) {
+		return nil, dec.Err()
+	}
+	for dec.SP() {
+		var ri, rights string
+		if !dec.ExpectAString(&ri) || !dec.ExpectSP() || !dec.ExpectAString(&rights) {
+			return nil, dec.Err()
+		}
+		data.Rights[imap.RightsIdentifier(ri)] = imap.RightSet(rights)
+	}
+	return &data, nil
+}
+
+func readListRightsResponse(dec *imapwire.Decoder) (*imap.ListRightsData, error) {
+	var (
+		data     imap.ListRightsData
+		ri       string
+		required string
+	)
+	if !dec.ExpectMailbox(&data.Mailbox) || !dec.ExpectSP() || !dec.ExpectAString(&ri) || !dec.ExpectSP() || !dec.ExpectAString(&required) {
+		return nil, dec.Err()
+	}
+	data.Identifier = imap.RightsIdentifier(ri)
+	data.RequiredRights = imap.RightSet(required)
+	for dec.SP() {
+		var optional string
+		if !dec.ExpectAString(&optional) {
+			return nil, dec.Err()
+		}
+		data.OptionalRights = append(data.OptionalRights, imap.RightSet(optional))
+	}
+	return &data, nil
+}
+
+func readMyRightsResponse(dec *imapwire.Decoder) (*imap.MyRightsData, error) {
+	var (
+		data   imap.MyRightsData
+		rights string
+	)
+	if !dec.ExpectMailbox(&data.Mailbox) || !dec.ExpectSP() || !dec.ExpectAString(&rights) {
+		return nil, dec.Err()
+	}
+	data.Rights = imap.RightSet(rights)
+	return &data, nil
+}
@@ -1,6 +1,7 @@
 package imapclient
 
 import (
+	"context"
 	"io"
 
 	"github.com/emersion/go-imap/v2"
@@ -54,3 +55,12 @@ func (cmd *AppendCommand) Close() error {
 func (cmd *AppendCommand) Wait() (*imap.AppendData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *AppendCommand) WaitContext(ctx context.Context) (*imap.AppendData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
@@ -1,10 +1,11 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 // Capability sends a CAPABILITY command.
@@ -37,6 +38,15 @@ func (cmd *CapabilityCommand) Wait() (imap.CapSet, error) {
 	return cmd.caps, err
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *CapabilityCommand) WaitContext(ctx context.Context) (imap.CapSet, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 func readCapabilities(dec *imapwire.Decoder) (imap.CapSet, error) {
 	caps := make(imap.CapSet)
 	for dec.SP() {
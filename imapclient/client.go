@@ -21,6 +21,7 @@ package imapclient
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -33,8 +34,8 @@ import (
 	"time"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 const (
@@ -69,6 +70,13 @@ type Options struct {
 	UnilateralDataHandler *UnilateralDataHandler
 	// Decoder for RFC 2047 words.
 	WordDecoder *mime.WordDecoder
+	// UnknownResponseHandler is called for untagged responses which aren't
+	// natively supported by the client, unless they're collected by a command
+	// sent with Client.Execute. If nil, such responses are a fatal error.
+	//
+	// The handler will block the client while running, and will be invoked
+	// in an arbitrary goroutine.
+	UnknownResponseHandler func(resp *RawResponse)
 }
 
 func (options *Options) wrapReadWriter(rw io.ReadWriter) io.ReadWriter {
@@ -127,6 +135,11 @@ type Client struct {
 	dec           *imapwire.Decoder
 	encMutex      sync.Mutex
 
+	// transport is the data stream wrapped by br and bw, before
+	// Options.DebugWriter is applied. It's only accessed by the read
+	// goroutine.
+	transport io.ReadWriter
+
 	greetingCh   chan struct{}
 	greetingRecv bool
 	greetingErr  error
@@ -162,6 +175,7 @@ func New(conn net.Conn, options *Options) *Client {
 		conn:          conn,
 		closeComplete: make(chan struct{}),
 		options:       *options,
+		transport:     conn,
 		br:            br,
 		bw:            bw,
 		dec:           imapwire.NewDecoder(br, imapwire.ConnSideClient),
@@ -337,6 +351,11 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// Closed returns a channel which is closed when the connection is closed.
+func (c *Client) Closed() <-chan struct{} {
+	return c.decCh
+}
+
 // criticalSectionClose ensures that Client.conn.Close is only executed once
 //   - the first arriving thread closes the channel
 //   - no invocation returns before Client.conn is closed
@@ -547,6 +566,12 @@ func (c *Client) read() {
 			cmdErr = io.ErrUnexpectedEOF
 		}
 		c.closeWithError(cmdErr)
+
+		// Unblock WaitGreeting if the connection is closed early
+		if !c.greetingRecv {
+			c.greetingErr = cmdErr
+			close(c.greetingCh)
+		}
 	}()
 
 	c.setReadTimeout(idleReadTimeout)
@@ -576,8 +601,12 @@ func (c *Client) readResponse() error {
 	}
 
 	var tag, typ string
-	if !c.dec.Expect(c.dec.Special('*') || c.dec.Atom(&tag), "'*' or atom") {
-		return fmt.Errorf("in response: cannot read tag: %v", c.dec.Err())
+	if !c.dec.Special('*') && !c.dec.Atom(&tag) {
+		err := c.dec.Err()
+		if err == nil {
+			err = internal.NewExpectError("'*' or atom")
+		}
+		return fmt.Errorf("in response: cannot read tag: %v", err)
 	}
 	if !c.dec.ExpectSP() {
 		return fmt.Errorf("in response: %v", c.dec.Err())
@@ -587,13 +616,13 @@ func (c *Client) readResponse() error {
 	}
 
 	var (
-		token    string
-		err      error
-		startTLS *startTLSCommand
+		token   string
+		err     error
+		upgrade command
 	)
 	if tag != "" {
 		token = "response-tagged"
-		startTLS, err = c.readResponseTagged(tag, typ)
+		upgrade, err = c.readResponseTagged(tag, typ)
 	} else {
 		token = "response-data"
 		err = c.readResponseData(typ)
@@ -606,9 +635,13 @@ func (c *Client) readResponse() error {
 		return fmt.Errorf("in response: %v", c.dec.Err())
 	}
 
-	if startTLS != nil {
-		c.upgradeStartTLS(startTLS.tlsConfig)
-		close(startTLS.upgradeDone)
+	switch cmd := upgrade.(type) {
+	case *startTLSCommand:
+		c.upgradeStartTLS(cmd.tlsConfig)
+		close(cmd.upgradeDone)
+	case *compressCommand:
+		c.upgradeCompress()
+		close(cmd.upgradeDone)
 	}
 
 	return nil
@@ -639,7 +672,7 @@ func (c *Client) readContinueReq() error {
 	return nil
 }
 
-func (c *Client) readResponseTagged(tag, typ string) (startTLS *startTLSCommand, err error) {
+func (c *Client) readResponseTagged(tag, typ string) (upgrade command, err error) {
 	cmd := c.deletePendingCmdByTag(tag)
 	if cmd == nil {
 		return nil, fmt.Errorf("received tagged response with unknown tag %q", tag)
@@ -695,9 +728,14 @@ func (c *Client) readResponseTagged(tag, typ string) (startTLS *startTLSCommand,
 				cmd.data.SourceUIDs = srcUIDs
 				cmd.data.DestUIDs = dstUIDs
 			}
+		case "READ-ONLY":
+			if cmd, ok := cmd.(*SelectCommand); ok {
+				cmd.data.ReadOnly = true
+			}
 		default: // [SP 1*<any TEXT-CHAR except "]">]
 			if c.dec.SP() {
-				c.dec.DiscardUntilByte(']')
+				var text string
+				c.dec.Func(&text, isRespCodeTextChar)
 			}
 		}
 		if !c.dec.ExpectSpecial(']') {
@@ -726,8 +764,12 @@ func (c *Client) readResponseTagged(tag, typ string) (startTLS *startTLSCommand,
 
 	c.completeCommand(cmd, cmdErr)
 
-	if cmd, ok := cmd.(*startTLSCommand); ok && cmdErr == nil {
-		startTLS = cmd
+	if cmdErr == nil {
+		switch cmd.(type) {
+		case *startTLSCommand, *compressCommand:
+			// These commands upgrade the connection
+			upgrade = cmd
+		}
 	}
 
 	if cmdErr == nil && code != "CAPABILITY" {
@@ -738,7 +780,7 @@ func (c *Client) readResponseTagged(tag, typ string) (startTLS *startTLSCommand,
 		}
 	}
 
-	return startTLS, nil
+	return upgrade, nil
 }
 
 func (c *Client) readResponseData(typ string) error {
@@ -836,7 +878,8 @@ func (c *Client) readResponseData(typ string) error {
 				// ignore
 			default: // [SP 1*<any TEXT-CHAR except "]">]
 				if c.dec.SP() {
-					c.dec.DiscardUntilByte(']')
+					var text string
+					c.dec.Func(&text, isRespCodeTextChar)
 				}
 			}
 			if !c.dec.ExpectSpecial(']') {
@@ -883,6 +926,11 @@ func (c *Client) readResponseData(typ string) error {
 			return c.dec.Err()
 		}
 		return c.handleNamespace()
+	case "ID":
+		if !c.dec.ExpectSP() {
+			return c.dec.Err()
+		}
+		return c.handleID()
 	case "FLAGS":
 		if !c.dec.ExpectSP() {
 			return c.dec.Err()
@@ -902,6 +950,21 @@ func (c *Client) readResponseData(typ string) error {
 			return c.dec.Err()
 		}
 		return c.handleStatus()
+	case "ACL":
+		if !c.dec.ExpectSP() {
+			return c.dec.Err()
+		}
+		return c.handleACL()
+	case "LISTRIGHTS":
+		if !c.dec.ExpectSP() {
+			return c.dec.Err()
+		}
+		return c.handleListRights()
+	case "MYRIGHTS":
+		if !c.dec.ExpectSP() {
+			return c.dec.Err()
+		}
+		return c.handleMyRights()
 	case "FETCH":
 		if !c.dec.ExpectSP() {
 			return c.dec.Err()
@@ -909,6 +972,11 @@ func (c *Client) readResponseData(typ string) error {
 		return c.handleFetch(num)
 	case "EXPUNGE":
 		return c.handleExpunge(num)
+	case "VANISHED":
+		if !c.dec.ExpectSP() {
+			return c.dec.Err()
+		}
+		return c.handleVanished()
 	case "SEARCH":
 		return c.handleSearch()
 	case "ESEARCH":
@@ -933,12 +1001,18 @@ func (c *Client) readResponseData(typ string) error {
 		}
 		return c.handleQuotaRoot()
 	default:
-		return fmt.Errorf("unsupported response type %q", typ)
+		return c.handleUnknown(num, typ)
 	}
 
 	return nil
 }
 
+// isRespCodeTextChar matches the arguments of an unknown response code:
+// TEXT-CHAR except "]".
+func isRespCodeTextChar(ch byte) bool {
+	return ch != ']' && ch != '\r' && ch != '\n'
+}
+
 // WaitGreeting waits for the server's initial greeting.
 func (c *Client) WaitGreeting() error {
 	<-c.greetingCh
@@ -1098,6 +1172,13 @@ type UnilateralDataHandler struct {
 	Expunge func(seqNum uint32)
 	Mailbox func(data *UnilateralDataMailbox)
 	Fetch   func(msg *FetchMessageData)
+
+	// requires QRESYNC
+	Vanished func(data *VanishedData)
+
+	// requires NOTIFY
+	Status func(data *imap.StatusData)
+	List   func(data *imap.ListData)
 }
 
 // command is an interface for IMAP commands.
@@ -1127,6 +1208,68 @@ func (cmd *Command) Wait() error {
 	return cmd.err
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done.
+//
+// IMAP has no way to abort a command once it has been sent. If ctx is done
+// before the command completes, ctx.Err() is returned and the client keeps
+// processing the command's responses in the background, discarding them. The
+// responses won't be delivered to subsequent commands. To abort the command
+// immediately, close the client.
+func (cmd *Command) WaitContext(ctx context.Context) error {
+	if err := cmd.waitContext(ctx); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// waitContext blocks until the command has completed or ctx is done. A non-nil
+// error is returned only if ctx is done first.
+func (cmd *Command) waitContext(ctx context.Context) error {
+	if cmd.err != nil {
+		return nil
+	}
+	select {
+	case err := <-cmd.done:
+		if err != nil {
+			cmd.err = err
+		}
+		return nil
+	case <-ctx.Done():
+		select {
+		case err := <-cmd.done:
+			if err != nil {
+				cmd.err = err
+			}
+			return nil
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// waitContextFunc calls f in a separate goroutine and waits for it to return
+// or for ctx to be done. This is used for commands whose responses need to be
+// consumed: if ctx is done first, f keeps running in the background so that
+// the client decoder isn't blocked.
+func waitContextFunc[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := f()
+		ch <- result{v, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.v, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
 type cmd = Command // type alias to avoid exporting anonymous struct fields
 
 type loginCommand struct {
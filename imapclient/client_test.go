@@ -1,20 +1,30 @@
 package imapclient_test
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/emersion/go-imap/v2/imapserver"
 	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 const (
 	testUsername = "test-user"
 	testPassword = "test-password"
+
+	testOtherUsername = "other-user"
 )
 
 const simpleRawMessage = `MIME-Version: 1.0
@@ -25,6 +35,42 @@ Content-Type: text/plain; charset=utf-8
 This is my letter!`
 
 func newClientServerPair(t *testing.T, initialState imap.ConnState) (*imapclient.Client, io.Closer) {
+	return newClientServerPairWithOptions(t, initialState, nil)
+}
+
+func newClientServerPairWithOptions(t *testing.T, initialState imap.ConnState, options *imapclient.Options) (*imapclient.Client, io.Closer) {
+	server, addr := newTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+
+	client := imapclient.New(conn, options)
+
+	if initialState >= imap.ConnStateAuthenticated {
+		if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+			t.Fatalf("Login().Wait() = %v", err)
+		}
+	}
+	if initialState >= imap.ConnStateSelected {
+		if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+			t.Fatalf("Select().Wait() = %v", err)
+		}
+	}
+
+	return client, server
+}
+
+// newTestServer starts a server backed by an in-memory store and returns its
+// address.
+func newTestServer(t *testing.T) (*imapserver.Server, string) {
+	return newTestServerWithOptions(t, nil)
+}
+
+// newTestServerWithOptions is like newTestServer, but calls configure to
+// customize the server options.
+func newTestServerWithOptions(t *testing.T, configure func(options *imapserver.Options)) (*imapserver.Server, string) {
 	memServer := imapmemserver.New()
 
 	user := imapmemserver.NewUser(testUsername, testPassword)
@@ -37,12 +83,40 @@ func newClientServerPair(t *testing.T, initialState imap.ConnState) (*imapclient
 
 	memServer.AddUser(user)
 
-	server := imapserver.New(&imapserver.Options{
+	// Shares a read-only mailbox with the test user
+	otherUser := imapmemserver.NewUser(testOtherUsername, testPassword)
+	otherUser.Create("Team", nil)
+	otherUser.SetACL("Team", testUsername, imap.RightModificationReplace, imap.RightSet("lr"))
+	memServer.AddUser(otherUser)
+
+	options := &imapserver.Options{
 		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
 			return memServer.NewSession(), nil, nil
 		},
+		Caps: imap.CapSet{
+			imap.CapIMAP4rev1: {},
+			imap.CapMove:      {},
+			imap.CapCondStore: {},
+			imap.CapQResync:   {},
+			imap.CapSort:      {},
+			imap.CapMetadata:  {},
+			imap.CapQuota:     {},
+			imap.CapQuotaSet:  {},
+			imap.CapACL:       {},
+
+			imap.CapCompressDeflate: {},
+			imap.CapID:              {},
+			imap.CapNotify:          {},
+
+			imap.CapListMyRights:    {},
+			"THREAD=ORDEREDSUBJECT": {},
+		},
 		InsecureAuth: true,
-	})
+	}
+	if configure != nil {
+		configure(options)
+	}
+	server := imapserver.New(options)
 
 	ln, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
@@ -55,25 +129,7 @@ func newClientServerPair(t *testing.T, initialState imap.ConnState) (*imapclient
 		}
 	}()
 
-	conn, err := net.Dial("tcp", ln.Addr().String())
-	if err != nil {
-		t.Fatalf("net.Dial() = %v", err)
-	}
-
-	client := imapclient.New(conn, nil)
-
-	if initialState >= imap.ConnStateAuthenticated {
-		if err := client.Login(testUsername, testPassword).Wait(); err != nil {
-			t.Fatalf("Login().Wait() = %v", err)
-		}
-	}
-	if initialState >= imap.ConnStateSelected {
-		if _, err := client.Select("INBOX", nil).Wait(); err != nil {
-			t.Fatalf("Select().Wait() = %v", err)
-		}
-	}
-
-	return client, server
+	return server, ln.Addr().String()
 }
 
 func TestLogin(t *testing.T) {
@@ -130,3 +186,1609 @@ func TestFetch_closeUnreadBody(t *testing.T) {
 		t.Fatalf("UIDFetch().Close() = %v", err)
 	}
 }
+
+func TestStore(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateSelected)
+	defer client.Close()
+	defer server.Close()
+
+	// The updated flags are returned as FETCH responses, which must be routed
+	// to the STORE command
+	storeFlags := imap.StoreFlags{
+		Op:    imap.StoreFlagsAdd,
+		Flags: []imap.Flag{imap.FlagFlagged},
+	}
+	msgs, err := client.Store(imap.NumSetNum(1), &storeFlags, nil).Collect()
+	if err != nil {
+		t.Fatalf("Store().Collect() = %v", err)
+	} else if len(msgs) != 1 {
+		t.Fatalf("len(Store().Collect()) = %v, want 1", len(msgs))
+	} else if msgs[0].SeqNum != 1 || !containsFlag(msgs[0].Flags, imap.FlagFlagged) {
+		t.Errorf("Store().Collect()[0] = seq %v flags %v, want seq 1 with %v", msgs[0].SeqNum, msgs[0].Flags, imap.FlagFlagged)
+	}
+
+	storeFlags.Op = imap.StoreFlagsDel
+	msgs, err = client.UIDStore(imap.NumSetNum(1), &storeFlags, nil).Collect()
+	if err != nil {
+		t.Fatalf("UIDStore().Collect() = %v", err)
+	} else if len(msgs) != 1 {
+		t.Fatalf("len(UIDStore().Collect()) = %v, want 1", len(msgs))
+	} else if msgs[0].UID != 1 || containsFlag(msgs[0].Flags, imap.FlagFlagged) {
+		t.Errorf("UIDStore().Collect()[0] = UID %v flags %v, want UID 1 without %v", msgs[0].UID, msgs[0].Flags, imap.FlagFlagged)
+	}
+}
+
+func containsFlag(flags []imap.Flag, flag imap.Flag) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCondStore(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateAuthenticated)
+	defer client.Close()
+	defer server.Close()
+
+	selectData, err := client.Select("INBOX", &imap.SelectOptions{CondStore: true}).Wait()
+	if err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	} else if selectData.HighestModSeq == 0 {
+		t.Fatalf("Select().Wait() returned zero HighestModSeq")
+	}
+
+	storeFlags := imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagFlagged},
+	}
+	storeOptions := imap.StoreOptions{UnchangedSince: selectData.HighestModSeq}
+	if err := client.Store(imap.NumSetNum(1), &storeFlags, &storeOptions).Close(); err != nil {
+		t.Fatalf("Store().Close() = %v", err)
+	}
+
+	fetchOptions := imap.FetchOptions{ChangedSince: selectData.HighestModSeq}
+	msgs, err := client.Fetch(imap.NumSetNum(1), &fetchOptions).Collect()
+	if err != nil {
+		t.Fatalf("Fetch().Collect() = %v", err)
+	} else if len(msgs) != 1 {
+		t.Fatalf("len(Fetch().Collect()) = %v, want 1", len(msgs))
+	} else if msgs[0].ModSeq <= selectData.HighestModSeq {
+		t.Errorf("Fetch().Collect()[0].ModSeq = %v, want > %v", msgs[0].ModSeq, selectData.HighestModSeq)
+	}
+
+	fetchOptions.ChangedSince = msgs[0].ModSeq
+	msgs, err = client.Fetch(imap.NumSetNum(1), &fetchOptions).Collect()
+	if err != nil {
+		t.Fatalf("Fetch().Collect() = %v", err)
+	} else if len(msgs) != 0 {
+		t.Errorf("len(Fetch().Collect()) = %v, want 0", len(msgs))
+	}
+}
+
+func TestCondStore_unchangedSinceZero(t *testing.T) {
+	server, addr := newTestServer(t)
+	defer server.Close()
+
+	// The client never sends UNCHANGEDSINCE 0, use a raw connection
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	readTagged := func(tag string) (untagged []string, tagged string) {
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			if strings.HasPrefix(line, tag+" ") {
+				return untagged, line
+			}
+			untagged = append(untagged, line)
+		}
+	}
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("failed to read greeting: %v", err)
+	}
+	fmt.Fprintf(conn, "A1 LOGIN %v %v\r\n", testUsername, testPassword)
+	if _, line := readTagged("A1"); !strings.HasPrefix(line, "A1 OK") {
+		t.Fatalf("LOGIN failed: %q", line)
+	}
+	io.WriteString(conn, "A2 SELECT INBOX\r\n")
+	if _, line := readTagged("A2"); !strings.HasPrefix(line, "A2 OK") {
+		t.Fatalf("SELECT failed: %q", line)
+	}
+
+	// UNCHANGEDSINCE 0 always fails
+	io.WriteString(conn, "A3 STORE 1 (UNCHANGEDSINCE 0) +FLAGS (\\Flagged)\r\n")
+	if _, line := readTagged("A3"); !strings.HasPrefix(line, "A3 OK [MODIFIED 1]") {
+		t.Errorf("STORE = %q, want MODIFIED 1", line)
+	}
+	io.WriteString(conn, "A4 FETCH 1 FLAGS\r\n")
+	untagged, line := readTagged("A4")
+	if !strings.HasPrefix(line, "A4 OK") {
+		t.Fatalf("FETCH failed: %q", line)
+	}
+	for _, l := range untagged {
+		if strings.Contains(l, "\\Flagged") {
+			t.Errorf("FETCH = %q, want message without \\Flagged", l)
+		}
+	}
+}
+
+func TestQResync(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateAuthenticated)
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := client.Enable(imap.CapQResync).Wait(); err != nil {
+		t.Fatalf("Enable().Wait() = %v", err)
+	}
+
+	selectData, err := client.Select("INBOX", nil).Wait()
+	if err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	}
+
+	storeFlags := imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+	}
+	if err := client.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store().Close() = %v", err)
+	}
+
+	expungeCmd := client.Expunge()
+	if err := expungeCmd.Close(); err != nil {
+		t.Fatalf("Expunge().Close() = %v", err)
+	} else if vanished := expungeCmd.Vanished(); vanished.String() != "1" {
+		t.Errorf("Expunge().Vanished() = %v, want 1", vanished)
+	}
+
+	fetchCmd := client.UIDFetch(imap.NumSet{imap.NumRange{Start: 1, Stop: 0}}, &imap.FetchOptions{
+		ChangedSince: selectData.HighestModSeq,
+		Vanished:     true,
+	})
+	if err := fetchCmd.Close(); err != nil {
+		t.Fatalf("UIDFetch().Close() = %v", err)
+	} else if vanished := fetchCmd.Vanished(); vanished.String() != "1" {
+		t.Errorf("UIDFetch().Vanished() = %v, want 1", vanished)
+	}
+
+	_, err = client.Select("INBOX", &imap.SelectOptions{
+		QResync: &imap.SelectQResync{
+			UIDValidity: selectData.UIDValidity,
+			ModSeq:      selectData.HighestModSeq,
+		},
+	}).Wait()
+	if err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	}
+}
+
+func TestSort(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateSelected)
+	defer client.Close()
+	defer server.Close()
+
+	appendTestMessage(t, client, "From: <root@nsa.gov>\r\nSubject: A\r\n\r\nHi <3")
+
+	nums, err := client.Sort(&imapclient.SortOptions{
+		SearchCriteria: &imap.SearchCriteria{},
+		SortCriteria: []imap.SortCriterion{
+			{Key: imap.SortKeyArrival, Reverse: true},
+		},
+	}).Wait()
+	if err != nil {
+		t.Fatalf("Sort().Wait() = %v", err)
+	} else if len(nums) != 2 || nums[0] != 2 || nums[1] != 1 {
+		t.Errorf("Sort().Wait() = %v, want [2 1]", nums)
+	}
+}
+
+func TestThread(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateSelected)
+	defer client.Close()
+	defer server.Close()
+
+	appendTestMessage(t, client, "Subject: A\r\n\r\nHi")
+	appendTestMessage(t, client, "Subject: A\r\n\r\nHello")
+
+	threads, err := client.Thread(&imapclient.ThreadOptions{
+		Algorithm:      imap.ThreadOrderedSubject,
+		SearchCriteria: &imap.SearchCriteria{},
+	}).Wait()
+	if err != nil {
+		t.Fatalf("Thread().Wait() = %v", err)
+	}
+	want := []imap.ThreadData{
+		{Chain: []uint32{1}},
+		{Chain: []uint32{2, 3}},
+	}
+	if !reflect.DeepEqual(threads, want) {
+		t.Errorf("Thread().Wait() = %v, want %v", threads, want)
+	}
+}
+
+func appendTestMessage(t *testing.T, client *imapclient.Client, s string) {
+	appendCmd := client.Append("INBOX", int64(len(s)), nil)
+	if _, err := io.WriteString(appendCmd, s); err != nil {
+		t.Fatalf("AppendCommand.Write() = %v", err)
+	}
+	if err := appendCmd.Close(); err != nil {
+		t.Fatalf("AppendCommand.Close() = %v", err)
+	}
+	if _, err := appendCmd.Wait(); err != nil {
+		t.Fatalf("AppendCommand.Wait() = %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateAuthenticated)
+	defer client.Close()
+	defer server.Close()
+
+	color := []byte("red")
+	comment := []byte("This is a very long comment")
+	err := client.SetMetadata("INBOX", map[string]*[]byte{
+		"/private/vendor/example/color": &color,
+		"/shared/comment":               &comment,
+	}).Wait()
+	if err != nil {
+		t.Fatalf("SetMetadata().Wait() = %v", err)
+	}
+
+	maxSize := uint32(10)
+	data, err := client.GetMetadata("INBOX", []string{"/private/vendor", "/shared/comment"}, &imap.GetMetadataOptions{
+		MaxSize: &maxSize,
+		Depth:   imap.GetMetadataDepthInfinity,
+	}).Wait()
+	if err != nil {
+		t.Fatalf("GetMetadata().Wait() = %v", err)
+	}
+	if len(data.EntryValues) != 1 {
+		t.Errorf("GetMetadata().Wait() returned %v entries, want 1", len(data.EntryValues))
+	} else if v := data.EntryValues["/private/vendor/example/color"]; v == nil || string(*v) != "red" {
+		t.Errorf("GetMetadata().Wait() returned %v, want color entry", data.EntryValues)
+	}
+
+	err = client.SetMetadata("INBOX", map[string]*[]byte{
+		"/private/vendor/example/color": nil,
+	}).Wait()
+	if err != nil {
+		t.Fatalf("SetMetadata().Wait() = %v", err)
+	}
+
+	data, err = client.GetMetadata("INBOX", []string{"/private/vendor/example/color"}, nil).Wait()
+	if err != nil {
+		t.Fatalf("GetMetadata().Wait() = %v", err)
+	} else if len(data.EntryValues) != 0 {
+		t.Errorf("GetMetadata().Wait() = %v, want no entries", data.EntryValues)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateAuthenticated)
+	defer client.Close()
+	defer server.Close()
+
+	if types := client.Caps().QuotaResourceTypes(); len(types) == 0 {
+		t.Errorf("QuotaResourceTypes() = %v, want non-empty", types)
+	}
+
+	limits := map[imap.QuotaResourceType]int64{imap.QuotaResourceMessage: 1}
+	if err := client.SetQuota("", limits).Wait(); err != nil {
+		t.Fatalf("SetQuota().Wait() = %v", err)
+	}
+
+	l, err := client.GetQuotaRoot("INBOX").Wait()
+	if err != nil {
+		t.Fatalf("GetQuotaRoot().Wait() = %v", err)
+	}
+	want := imap.QuotaResourceData{Usage: 1, Limit: 1}
+	if len(l) != 1 || l[0].Root != "" || l[0].Resources[imap.QuotaResourceMessage] != want {
+		t.Errorf("GetQuotaRoot().Wait() = %v, want MESSAGE %v", l, want)
+	}
+
+	s := "Subject: Over quota\r\n\r\nHi"
+	appendCmd := client.Append("INBOX", int64(len(s)), nil)
+	io.WriteString(appendCmd, s)
+	appendCmd.Close()
+	var imapErr *imap.Error
+	if _, err := appendCmd.Wait(); !errors.As(err, &imapErr) || imapErr.Code != imap.ResponseCodeOverQuota {
+		t.Errorf("AppendCommand.Wait() = %v, want OVERQUOTA", err)
+	}
+
+	// Moving messages within the quota root doesn't change the usage
+	if err := client.Create("Archive", nil).Wait(); err != nil {
+		t.Fatalf("Create().Wait() = %v", err)
+	}
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	}
+	if _, err := client.Move(imap.NumSetNum(1), "Archive").Wait(); err != nil {
+		t.Errorf("Move().Wait() = %v", err)
+	}
+	l, err = client.GetQuotaRoot("Archive").Wait()
+	if err != nil {
+		t.Fatalf("GetQuotaRoot().Wait() = %v", err)
+	}
+	if len(l) != 1 || l[0].Resources[imap.QuotaResourceMessage] != want {
+		t.Errorf("GetQuotaRoot().Wait() = %v, want MESSAGE %v", l, want)
+	}
+
+	// Shared mailboxes are charged to the quota of their owner
+	l, err = client.GetQuotaRoot("Other Users/" + testOtherUsername + "/Team").Wait()
+	if err != nil {
+		t.Fatalf("GetQuotaRoot().Wait() = %v", err)
+	} else if len(l) != 0 {
+		t.Errorf("GetQuotaRoot().Wait() = %v, want no quota root", l)
+	}
+}
+
+func TestACL(t *testing.T) {
+	server, addr := newTestServer(t)
+	defer server.Close()
+
+	login := func(username string) *imapclient.Client {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("net.Dial() = %v", err)
+		}
+		client := imapclient.New(conn, nil)
+		if err := client.Login(username, testPassword).Wait(); err != nil {
+			t.Fatalf("Login().Wait() = %v", err)
+		}
+		return client
+	}
+
+	client := login(testUsername)
+	defer client.Close()
+
+	if err := client.SetACL("INBOX", imap.RightsIdentifierAnyone, imap.RightModificationReplace, imap.RightSet("lr")).Wait(); err != nil {
+		t.Fatalf("SetACL().Wait() = %v", err)
+	}
+	if err := client.SetACL("INBOX", imap.RightsIdentifierAnyone, imap.RightModificationAdd, imap.RightSet("s")).Wait(); err != nil {
+		t.Fatalf("SetACL().Wait() = %v", err)
+	}
+
+	data, err := client.GetACL("INBOX").Wait()
+	if err != nil {
+		t.Fatalf("GetACL().Wait() = %v", err)
+	}
+	if rights := data.Rights[imap.RightsIdentifierAnyone]; rights.String() != "lrs" {
+		t.Errorf("GetACL().Wait() returned rights %q for anyone, want %q", rights, "lrs")
+	}
+	if rights := data.Rights[testUsername]; rights.String() != imap.RightSetAll.String() {
+		t.Errorf("GetACL().Wait() returned rights %q for owner, want %q", rights, imap.RightSetAll)
+	}
+
+	// Obsolete RFC 2086 rights are mapped to their RFC 4314 equivalents
+	if err := client.SetACL("INBOX", imap.RightsIdentifierAnyone, imap.RightModificationReplace, imap.RightSet("lrcd")).Wait(); err != nil {
+		t.Fatalf("SetACL().Wait() = %v", err)
+	}
+	data, err = client.GetACL("INBOX").Wait()
+	if err != nil {
+		t.Fatalf("GetACL().Wait() = %v", err)
+	} else if rights := data.Rights[imap.RightsIdentifierAnyone]; rights.String() != "lrkxte" {
+		t.Errorf("GetACL().Wait() returned rights %q for anyone, want %q", rights, "lrkxte")
+	}
+
+	if err := client.DeleteACL("INBOX", imap.RightsIdentifierAnyone).Wait(); err != nil {
+		t.Fatalf("DeleteACL().Wait() = %v", err)
+	}
+
+	const sharedMailbox = "Other Users/" + testOtherUsername + "/Team"
+
+	myRights, err := client.MyRights(sharedMailbox).Wait()
+	if err != nil {
+		t.Fatalf("MyRights().Wait() = %v", err)
+	} else if myRights.Rights.String() != "lr" {
+		t.Errorf("MyRights().Wait() = %q, want %q", myRights.Rights, "lr")
+	}
+
+	mailboxes, err := client.List("", "*", &imap.ListOptions{ReturnMyRights: true}).Collect()
+	if err != nil {
+		t.Fatalf("List().Collect() = %v", err)
+	}
+	found := false
+	for _, data := range mailboxes {
+		if data.Mailbox != sharedMailbox {
+			continue
+		}
+		found = true
+		if data.MyRights == nil || data.MyRights.Rights.String() != "lr" {
+			t.Errorf("List() returned rights %v for %v, want %q", data.MyRights, sharedMailbox, "lr")
+		}
+	}
+	if !found {
+		t.Errorf("List() = %v, want shared mailbox %v", mailboxes, sharedMailbox)
+	}
+
+	// Add a message to the shared mailbox as its owner
+	otherClient := login(testOtherUsername)
+	defer otherClient.Close()
+	appendCmd := otherClient.Append("Team", int64(len(simpleRawMessage)), nil)
+	if _, err := io.WriteString(appendCmd, simpleRawMessage); err != nil {
+		t.Fatalf("AppendCommand.Write() = %v", err)
+	} else if err := appendCmd.Close(); err != nil {
+		t.Fatalf("AppendCommand.Close() = %v", err)
+	} else if _, err := appendCmd.Wait(); err != nil {
+		t.Fatalf("AppendCommand.Wait() = %v", err)
+	}
+
+	// Without write rights, the mailbox is read-only
+	selectData, err := client.Select(sharedMailbox, nil).Wait()
+	if err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	} else if !selectData.ReadOnly {
+		t.Errorf("Select().Wait() = %+v, want read-only", selectData)
+	}
+
+	// Without the "s" right, fetching the body doesn't set \Seen
+	fetchOptions := &imap.FetchOptions{
+		BodySection: []*imap.FetchItemBodySection{{}},
+	}
+	if _, err := client.Fetch(imap.NumSetNum(1), fetchOptions).Collect(); err != nil {
+		t.Fatalf("Fetch().Collect() = %v", err)
+	}
+	msgs, err := client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{Flags: true}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch().Collect() = %v", err)
+	} else if len(msgs) != 1 || containsFlag(msgs[0].Flags, imap.FlagSeen) {
+		t.Errorf("Fetch().Collect() = %v, want a message without %v", msgs, imap.FlagSeen)
+	}
+
+	var imapErr *imap.Error
+	for _, storeFlags := range []imap.StoreFlags{
+		{Op: imap.StoreFlagsAdd, Flags: []imap.Flag{imap.FlagFlagged}},
+		{Op: imap.StoreFlagsDel, Flags: []imap.Flag{imap.FlagSeen}},
+		{Op: imap.StoreFlagsDel, Flags: []imap.Flag{imap.FlagDeleted}},
+		{Op: imap.StoreFlagsSet, Flags: nil},
+		{Op: imap.StoreFlagsSet, Flags: []imap.Flag{imap.FlagSeen}},
+	} {
+		if err := client.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); !errors.As(err, &imapErr) || imapErr.Code != imap.ResponseCodeNoPerm {
+			t.Errorf("Store(%v) = %v, want NOPERM", storeFlags, err)
+		}
+	}
+	if err := client.SetACL(sharedMailbox, testUsername, imap.RightModificationAdd, imap.RightSet("w")).Wait(); !errors.As(err, &imapErr) || imapErr.Code != imap.ResponseCodeNoPerm {
+		t.Errorf("SetACL().Wait() = %v, want NOPERM", err)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateAuthenticated)
+	defer client.Close()
+	defer server.Close()
+
+	if err := client.Compress(); err != nil {
+		t.Fatalf("Compress() = %v", err)
+	}
+
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	}
+
+	fetchOptions := &imap.FetchOptions{
+		BodySection: []*imap.FetchItemBodySection{{}},
+	}
+	msgs, err := client.Fetch(imap.NumSetNum(1), fetchOptions).Collect()
+	if err != nil {
+		t.Fatalf("Fetch().Collect() = %v", err)
+	} else if len(msgs) != 1 || len(msgs[0].BodySection) != 1 {
+		t.Fatalf("Fetch().Collect() = %v, want one message with one body section", msgs)
+	}
+	want := strings.ReplaceAll(simpleRawMessage, "\n", "\r\n")
+	for _, body := range msgs[0].BodySection {
+		if string(body) != want {
+			t.Errorf("body = %q, want %q", body, want)
+		}
+	}
+
+	var imapErr *imap.Error
+	if err := client.Compress(); !errors.As(err, &imapErr) || imapErr.Code != imap.ResponseCodeCompressionActive {
+		t.Errorf("Compress() = %v, want COMPRESSIONACTIVE", err)
+	}
+}
+
+func TestID(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateNotAuthenticated)
+	defer client.Close()
+	defer server.Close()
+
+	data, err := client.ID(&imap.IDData{imap.IDName: "go-imap-test"}).Wait()
+	if err != nil {
+		t.Fatalf("ID().Wait() = %v", err)
+	} else if data == nil || (*data)[imap.IDName] != "imapmemserver" {
+		t.Errorf("ID().Wait() = %v, want name imapmemserver", data)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	statusCh := make(chan *imap.StatusData, 16)
+	listCh := make(chan *imap.ListData, 16)
+	options := &imapclient.Options{
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Status: func(data *imap.StatusData) {
+				statusCh <- data
+			},
+			List: func(data *imap.ListData) {
+				listCh <- data
+			},
+		},
+	}
+	client, server := newClientServerPairWithOptions(t, imap.ConnStateAuthenticated, options)
+	defer client.Close()
+	defer server.Close()
+
+	err := client.Notify(&imap.NotifyOptions{
+		Items: []imap.NotifyItem{{
+			MailboxSpec: imap.NotifyMailboxSpecPersonal,
+			Events: []imap.NotifyEvent{
+				imap.NotifyEventMessageNew,
+				imap.NotifyEventMessageExpunge,
+				imap.NotifyEventMailboxName,
+			},
+		}},
+	}).Wait()
+	if err != nil {
+		t.Fatalf("Notify().Wait() = %v", err)
+	}
+
+	appendTestMessage(t, client, "Subject: Hello\r\n\r\nHi")
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop().Wait() = %v", err)
+	}
+	select {
+	case data := <-statusCh:
+		if data.Mailbox != "INBOX" || data.NumMessages == nil || *data.NumMessages != 2 {
+			t.Errorf("unilateral STATUS = %v, want INBOX with 2 messages", data)
+		}
+	default:
+		t.Errorf("no unilateral STATUS response received")
+	}
+
+	if err := client.Create("Archive", nil).Wait(); err != nil {
+		t.Fatalf("Create().Wait() = %v", err)
+	}
+	select {
+	case data := <-listCh:
+		if data.Mailbox != "Archive" {
+			t.Errorf("unilateral LIST = %v, want Archive", data)
+		}
+	default:
+		t.Errorf("no unilateral LIST response received")
+	}
+
+	err = client.Notify(&imap.NotifyOptions{
+		Items: []imap.NotifyItem{{
+			MailboxSpec: imap.NotifyMailboxSpecPersonal,
+			Events:      []imap.NotifyEvent{"XBogusEvent"},
+		}},
+	}).Wait()
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) || imapErr.Code != imap.ResponseCodeBadEvent {
+		t.Errorf("Notify().Wait() = %v, want BADEVENT", err)
+	}
+
+	if err := client.Notify(nil).Wait(); err != nil {
+		t.Fatalf("Notify(nil).Wait() = %v", err)
+	}
+
+	// IDLE without a selected mailbox nor NOTIFY doesn't report anything, but
+	// must last until DONE
+	idleCmd, err := client.Idle()
+	if err != nil {
+		t.Fatalf("Idle() = %v", err)
+	}
+	if err := idleCmd.Close(); err != nil {
+		t.Errorf("IdleCommand.Close() = %v", err)
+	}
+	if err := idleCmd.Wait(); err != nil {
+		t.Errorf("IdleCommand.Wait() = %v", err)
+	}
+}
+
+func TestNotify_selected(t *testing.T) {
+	server, addr := newTestServer(t)
+	defer server.Close()
+
+	dial := func(options *imapclient.Options) *imapclient.Client {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("net.Dial() = %v", err)
+		}
+		client := imapclient.New(conn, options)
+		if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+			t.Fatalf("Login().Wait() = %v", err)
+		}
+		if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+			t.Fatalf("Select().Wait() = %v", err)
+		}
+		return client
+	}
+
+	fetchCh := make(chan *imapclient.FetchMessageData, 16)
+	client := dial(&imapclient.Options{
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Fetch: func(msg *imapclient.FetchMessageData) {
+				msg.Collect()
+				fetchCh <- msg
+			},
+		},
+	})
+	defer client.Close()
+	otherClient := dial(nil)
+	defer otherClient.Close()
+
+	// FlagChange isn't requested for the selected mailbox
+	err := client.Notify(&imap.NotifyOptions{
+		Items: []imap.NotifyItem{{
+			MailboxSpec: imap.NotifyMailboxSpecSelected,
+			Events: []imap.NotifyEvent{
+				imap.NotifyEventMessageNew,
+				imap.NotifyEventMessageExpunge,
+			},
+		}},
+	}).Wait()
+	if err != nil {
+		t.Fatalf("Notify().Wait() = %v", err)
+	}
+
+	storeFlags := imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagFlagged},
+	}
+	if err := otherClient.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store().Close() = %v", err)
+	}
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop().Wait() = %v", err)
+	}
+	select {
+	case msg := <-fetchCh:
+		t.Errorf("got unilateral FETCH for message %v, want none", msg.SeqNum)
+	default:
+	}
+
+	if err := client.Notify(nil).Wait(); err != nil {
+		t.Fatalf("Notify(nil).Wait() = %v", err)
+	}
+	storeFlags.Op = imap.StoreFlagsDel
+	if err := otherClient.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store().Close() = %v", err)
+	}
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop().Wait() = %v", err)
+	}
+	select {
+	case <-fetchCh:
+	case <-time.After(5 * time.Second):
+		t.Errorf("no unilateral FETCH received after NOTIFY NONE")
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	var (
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+	)
+	interceptor := imapserver.InterceptorFuncs{
+		InterceptFunc: func(conn *imapserver.Conn, cmd *imapserver.CommandInfo) error {
+			if cmd.Name == "FETCH" {
+				started <- struct{}{}
+				<-release
+			}
+			return nil
+		},
+	}
+
+	server, addr := newTestServerWithOptions(t, func(options *imapserver.Options) {
+		options.Interceptors = []imapserver.Interceptor{interceptor}
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(conn, nil)
+	defer client.Close()
+
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login().Wait() = %v", err)
+	}
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select().Wait() = %v", err)
+	}
+
+	// A FETCH with only peek items doesn't block the following commands
+	fetchCmd := client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{
+		BodySection: []*imap.FetchItemBodySection{{Peek: true}},
+	})
+	<-started
+	if data, err := client.Status("INBOX", &imap.StatusOptions{NumMessages: true}).Wait(); err != nil {
+		t.Errorf("Status().Wait() = %v", err)
+	} else if data.NumMessages == nil || *data.NumMessages != 1 {
+		t.Errorf("Status().Wait() = %v, want 1 message", data)
+	}
+	if err := client.Noop().Wait(); err != nil {
+		t.Errorf("Noop().Wait() = %v", err)
+	}
+	release <- struct{}{}
+	if msgs, err := fetchCmd.Collect(); err != nil {
+		t.Errorf("Fetch().Collect() = %v", err)
+	} else if len(msgs) != 1 {
+		t.Errorf("Fetch().Collect() returned %v messages, want 1", len(msgs))
+	}
+
+	// A FETCH setting \Seen is executed alone
+	fetchCmd = client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{
+		BodySection: []*imap.FetchItemBodySection{{}},
+	})
+	<-started
+	noopDone := make(chan error, 1)
+	go func() {
+		noopDone <- client.Noop().Wait()
+	}()
+	select {
+	case err := <-noopDone:
+		t.Errorf("Noop() completed before non-peek Fetch(): %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	if _, err := fetchCmd.Collect(); err != nil {
+		t.Errorf("Fetch().Collect() = %v", err)
+	}
+	if err := <-noopDone; err != nil {
+		t.Errorf("Noop().Wait() = %v", err)
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	client, server := newClientServerPair(t, imap.ConnStateSelected)
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The command may or may not have completed by the time the context is
+	// checked
+	fetchCmd := client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{
+		BodySection: []*imap.FetchItemBodySection{{Peek: true}},
+	})
+	if _, err := fetchCmd.CollectContext(ctx); err != nil && err != context.Canceled {
+		t.Errorf("Fetch().CollectContext() = %v", err)
+	}
+
+	// Responses to the abandoned command must not leak into the next ones
+	data, err := client.Status("INBOX", &imap.StatusOptions{NumMessages: true}).WaitContext(context.Background())
+	if err != nil {
+		t.Fatalf("Status().WaitContext() = %v", err)
+	} else if data.NumMessages == nil || *data.NumMessages != 1 {
+		t.Errorf("Status().WaitContext() = %v, want 1 message", data)
+	}
+
+	if _, err := client.Status("INBOX", &imap.StatusOptions{NumUnseen: true}).WaitContext(ctx); err != nil && err != context.Canceled {
+		t.Errorf("Status().WaitContext() = %v", err)
+	}
+	if err := client.Noop().WaitContext(context.Background()); err != nil {
+		t.Errorf("Noop().WaitContext() = %v", err)
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	server, addr := newTestServer(t)
+	defer server.Close()
+
+	states := make(chan imapclient.ReconnectState, 16)
+	rc := imapclient.NewReconnectingClient(&imapclient.ReconnectOptions{
+		Dial: func(ctx context.Context) (*imapclient.Client, error) {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return imapclient.New(conn, nil), nil
+		},
+		Auth: func(c *imapclient.Client) error {
+			return c.Login(testUsername, testPassword).Wait()
+		},
+		MinBackoff: 10 * time.Millisecond,
+		StateChange: func(state imapclient.ReconnectState, err error) {
+			if err != nil && state == imapclient.ReconnectStateConnected {
+				t.Errorf("StateChange(%v, %v)", state, err)
+			}
+			states <- state
+		},
+	})
+	defer rc.Close()
+
+	waitState := func(want imapclient.ReconnectState) {
+		t.Helper()
+		for {
+			select {
+			case state := <-states:
+				if state == want {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for state %v", want)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := rc.Select(ctx, "INBOX", nil); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+	waitState(imapclient.ReconnectStateConnected)
+
+	// Simulate a connection loss
+	client, err := rc.Client(ctx)
+	if err != nil {
+		t.Fatalf("Client() = %v", err)
+	}
+	client.Close()
+	waitState(imapclient.ReconnectStateDisconnected)
+	waitState(imapclient.ReconnectStateConnected)
+
+	client, err = rc.Client(ctx)
+	if err != nil {
+		t.Fatalf("Client() = %v", err)
+	}
+	if mbox := client.Mailbox(); mbox == nil || mbox.Name != "INBOX" {
+		t.Errorf("Mailbox() = %v, want INBOX", mbox)
+	}
+	if err := client.Noop().Wait(); err != nil {
+		t.Errorf("Noop().Wait() = %v", err)
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	waitState(imapclient.ReconnectStateClosed)
+	if _, err := rc.Client(ctx); err != net.ErrClosed {
+		t.Errorf("Client() after Close() = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestReconnectingClient_closeBeforeGreeting(t *testing.T) {
+	// The server accepts connections but never sends a greeting
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	dialed := make(chan struct{})
+	rc := imapclient.NewReconnectingClient(&imapclient.ReconnectOptions{
+		Dial: func(ctx context.Context) (*imapclient.Client, error) {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", ln.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			close(dialed)
+			return imapclient.New(conn, nil), nil
+		},
+	})
+
+	select {
+	case <-dialed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for Dial()")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rc.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close() blocked while waiting for the greeting")
+	}
+}
+
+func TestPool(t *testing.T) {
+	server, addr := newTestServer(t)
+	defer server.Close()
+
+	pool := imapclient.NewPool(&imapclient.PoolOptions{
+		Dial: func(ctx context.Context) (*imapclient.Client, error) {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return imapclient.New(conn, nil), nil
+		},
+		Auth: func(c *imapclient.Client, account string) error {
+			return c.Login(account, testPassword).Wait()
+		},
+		MaxConns: 1,
+	})
+	defer pool.Close()
+
+	ctx := context.Background()
+	pc, err := pool.Get(ctx, testUsername, "INBOX", true)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if mbox := pc.Mailbox(); mbox == nil || mbox.Name != "INBOX" {
+		t.Errorf("Mailbox() = %v, want INBOX", mbox)
+	}
+	client := pc.Client
+
+	// The pool is full
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(timeoutCtx, testUsername, "INBOX", true); err != context.DeadlineExceeded {
+		t.Errorf("Get() with full pool = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	pc.Release()
+
+	pc, err = pool.Get(ctx, testUsername, "INBOX", true)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if pc.Client != client {
+		t.Errorf("Get() didn't re-use the idle connection")
+	}
+	if err := pc.Noop().Wait(); err != nil {
+		t.Errorf("Noop().Wait() = %v", err)
+	}
+	pc.Release()
+
+	// The idle connection is evicted for another account
+	pc, err = pool.Get(ctx, testOtherUsername, "", false)
+	if err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if pc.Client == client {
+		t.Errorf("Get() returned a connection for another account")
+	}
+	pc.Release()
+}
+
+func TestServerShutdown(t *testing.T) {
+	server, addr := newTestServer(t)
+	defer server.Close()
+
+	// A raw connection waiting for a command
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("failed to read greeting: %v", err)
+	}
+
+	// A client in the middle of an IDLE command
+	clientConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(clientConn, nil)
+	defer client.Close()
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+	idleCmd, err := client.Idle()
+	if err != nil {
+		t.Fatalf("Idle() = %v", err)
+	}
+	defer idleCmd.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx, &imapserver.ShutdownOptions{
+		Code: imap.ResponseCodeUnavailable,
+		Text: "Maintenance",
+	})
+	if err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read BYE: %v", err)
+	} else if want := "* BYE [UNAVAILABLE] Maintenance\r\n"; line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+	if _, err := br.ReadString('\n'); err != io.EOF {
+		t.Errorf("connection not closed after BYE: %v", err)
+	}
+
+	select {
+	case <-client.Closed():
+	case <-time.After(5 * time.Second):
+		t.Errorf("client not disconnected after Shutdown()")
+	}
+
+	if err := server.Shutdown(ctx, nil); err == nil {
+		t.Errorf("second Shutdown() succeeded")
+	}
+}
+
+// closeTrackingListener records connections which are written to after
+// being closed.
+type closeTrackingListener struct {
+	net.Listener
+
+	mutex sync.Mutex
+	conns []*closeTrackingConn
+}
+
+func (ln *closeTrackingListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &closeTrackingConn{Conn: conn}
+	ln.mutex.Lock()
+	ln.conns = append(ln.conns, c)
+	ln.mutex.Unlock()
+	return c, nil
+}
+
+func (ln *closeTrackingListener) writtenAfterClose() bool {
+	ln.mutex.Lock()
+	defer ln.mutex.Unlock()
+	for _, c := range ln.conns {
+		c.mutex.Lock()
+		written := c.writtenAfterClose
+		c.mutex.Unlock()
+		if written {
+			return true
+		}
+	}
+	return false
+}
+
+type closeTrackingConn struct {
+	net.Conn
+
+	mutex             sync.Mutex
+	closed            bool
+	writtenAfterClose bool
+}
+
+func (c *closeTrackingConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.writtenAfterClose = true
+	}
+	c.mutex.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *closeTrackingConn) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	c.mutex.Unlock()
+	return c.Conn.Close()
+}
+
+func TestServerShutdown_idle(t *testing.T) {
+	memServer := imapmemserver.New()
+	user := imapmemserver.NewUser(testUsername, testPassword)
+	user.Create("INBOX", nil)
+	memServer.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return memServer.NewSession(), nil, nil
+		},
+		InsecureAuth: true,
+	})
+	defer server.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	trackingLn := &closeTrackingListener{Listener: ln}
+	go server.Serve(trackingLn)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(conn, nil)
+	defer client.Close()
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	idleCmd, err := client.Idle()
+	if err != nil {
+		t.Fatalf("Idle() = %v", err)
+	}
+	defer idleCmd.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx, nil); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	// The IDLE command must not be completed after BYE
+	if trackingLn.writtenAfterClose() {
+		t.Errorf("server wrote to the connection after BYE")
+	}
+}
+
+func TestServerShutdown_inFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	interceptor := imapserver.InterceptorFuncs{
+		InterceptFunc: func(conn *imapserver.Conn, cmd *imapserver.CommandInfo) error {
+			if cmd.Name == "NOOP" {
+				close(started)
+				<-release
+			}
+			return nil
+		},
+	}
+	server, addr := newTestServerWithOptions(t, func(options *imapserver.Options) {
+		options.Interceptors = []imapserver.Interceptor{interceptor}
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	readTagged := func(tag string) string {
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			if strings.HasPrefix(line, tag+" ") {
+				return line
+			}
+		}
+	}
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("failed to read greeting: %v", err)
+	}
+	fmt.Fprintf(conn, "A1 LOGIN %v %v\r\n", testUsername, testPassword)
+	if line := readTagged("A1"); !strings.HasPrefix(line, "A1 OK") {
+		t.Fatalf("LOGIN failed: %q", line)
+	}
+
+	io.WriteString(conn, "A2 NOOP\r\n")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(ctx, nil)
+	}()
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown() returned before in-flight command completed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	// The in-flight command completes before the BYE response
+	if line := readTagged("A2"); !strings.HasPrefix(line, "A2 OK") {
+		t.Errorf("NOOP failed: %q", line)
+	}
+	if line, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("failed to read BYE: %v", err)
+	} else if !strings.HasPrefix(line, "* BYE ") {
+		t.Errorf("got %q, want BYE", line)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	type doneCall struct {
+		name string
+		args []interface{}
+		resp imap.StatusResponseType
+	}
+
+	var (
+		mutex sync.Mutex
+		calls []doneCall
+	)
+	interceptor := imapserver.InterceptorFuncs{
+		InterceptFunc: func(conn *imapserver.Conn, cmd *imapserver.CommandInfo) error {
+			if cmd.Name == "DELETE" && cmd.Args[0] == "INBOX" {
+				return &imap.Error{
+					Type: imap.StatusResponseTypeNo,
+					Code: imap.ResponseCodeNoPerm,
+					Text: "INBOX cannot be deleted",
+				}
+			}
+			return nil
+		},
+		DoneFunc: func(conn *imapserver.Conn, cmd *imapserver.CommandInfo, resp *imap.StatusResponse, dur time.Duration) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			calls = append(calls, doneCall{cmd.Name, cmd.Args, resp.Type})
+		},
+	}
+
+	server, addr := newTestServerWithOptions(t, func(options *imapserver.Options) {
+		options.Interceptors = []imapserver.Interceptor{interceptor}
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(conn, nil)
+	defer client.Close()
+
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+
+	err = client.Delete("INBOX").Wait()
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) || imapErr.Code != imap.ResponseCodeNoPerm {
+		t.Errorf("Delete() = %v, want NOPERM error", err)
+	}
+	if _, err := client.Status("INBOX", &imap.StatusOptions{NumMessages: true}).Wait(); err != nil {
+		t.Errorf("Status() = %v", err)
+	}
+
+	if err := client.Logout().Wait(); err != nil {
+		t.Fatalf("Logout() = %v", err)
+	}
+	// The server closes the connection once interceptors have been notified
+	select {
+	case <-client.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("client not disconnected after Logout()")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	want := []struct {
+		name    string
+		mailbox string
+		resp    imap.StatusResponseType
+	}{
+		{"LOGIN", "", imap.StatusResponseTypeOK},
+		{"SELECT", "INBOX", imap.StatusResponseTypeOK},
+		{"DELETE", "INBOX", imap.StatusResponseTypeNo},
+		{"STATUS", "INBOX", imap.StatusResponseTypeOK},
+		{"LOGOUT", "", imap.StatusResponseTypeOK},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("got %v commands, want %v: %v", len(calls), len(want), calls)
+	}
+	for i, w := range want {
+		call := calls[i]
+		if call.name != w.name || call.resp != w.resp {
+			t.Errorf("command #%v: got %v %v, want %v %v", i, call.name, call.resp, w.name, w.resp)
+		}
+		if w.mailbox != "" && (len(call.args) == 0 || call.args[0] != w.mailbox) {
+			t.Errorf("command #%v: got args %v, want mailbox %q", i, call.args, w.mailbox)
+		}
+	}
+}
+
+func TestCustomCommand(t *testing.T) {
+	server, addr := newTestServerWithOptions(t, func(options *imapserver.Options) {
+		options.Commands = map[string]*imapserver.CommandHandler{
+			"XECHO": {
+				Handle: func(conn *imapserver.Conn, session imapserver.Session, dec *imapwire.Decoder, w *imapserver.ResponseWriter) error {
+					var (
+						s string
+						n uint32
+					)
+					if !dec.ExpectSP() || !dec.ExpectAString(&s) || !dec.ExpectSP() || !dec.ExpectNumber(&n) || !dec.ExpectCRLF() {
+						return dec.Err()
+					}
+					return w.WriteUntagged(func(enc *imapwire.Encoder) {
+						enc.Atom("XECHO").SP().String(s).SP().Number(n)
+					})
+				},
+			},
+		}
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("failed to read greeting: %v", err)
+	}
+
+	exchange := func(cmd string, want ...string) {
+		t.Helper()
+		if _, err := io.WriteString(conn, cmd+"\r\n"); err != nil {
+			t.Fatalf("failed to write command: %v", err)
+		}
+		for _, w := range want {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			if !strings.HasPrefix(line, w) {
+				t.Errorf("got %q, want prefix %q", line, w)
+			}
+		}
+	}
+
+	exchange("a1 XECHO hello 42", "a1 BAD")
+	exchange(fmt.Sprintf("a2 LOGIN %v %v", testUsername, testPassword), "a2 OK")
+	exchange("a3 XECHO \"hello world\" 42", "* XECHO \"hello world\" 42\r\n", "a3 OK")
+	exchange("a4 XECHO hello", "a4 BAD")
+	exchange("a5 XUNKNOWN", "a5 BAD")
+}
+
+func TestExecute(t *testing.T) {
+	server, addr := newTestServerWithOptions(t, func(options *imapserver.Options) {
+		options.Commands = map[string]*imapserver.CommandHandler{
+			"XECHO": {
+				Handle: func(conn *imapserver.Conn, session imapserver.Session, dec *imapwire.Decoder, w *imapserver.ResponseWriter) error {
+					var (
+						s, mailbox string
+						n          uint32
+						flags      []string
+					)
+					if !dec.ExpectSP() || !dec.ExpectString(&s) || !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectSP() || !dec.ExpectNumber(&n) || !dec.ExpectSP() {
+						return dec.Err()
+					}
+					err := dec.ExpectList(func() error {
+						var flag string
+						if !dec.ExpectAtom(&flag) {
+							return dec.Err()
+						}
+						flags = append(flags, flag)
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+					if !dec.ExpectCRLF() {
+						return dec.Err()
+					}
+					return w.WriteUntagged(func(enc *imapwire.Encoder) {
+						enc.Number(n).SP().Atom("XECHO").SP().String(s).SP().Mailbox(mailbox).SP().List(len(flags), func(i int) {
+							enc.Atom(flags[i])
+						}).SP().NIL()
+					})
+				},
+			},
+		}
+	})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(conn, nil)
+	defer client.Close()
+
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+
+	resps, err := client.Execute("XECHO", "hello\r\nworld", imapclient.RawMailbox("Entwürfe"), uint32(42), []interface{}{imapclient.RawAtom("a"), imapclient.RawAtom("b")}).Wait()
+	if err != nil {
+		t.Fatalf("Execute().Wait() = %v", err)
+	}
+	want := []imapclient.RawResponse{{
+		Type: "XECHO",
+		Num:  42,
+		Fields: []imapclient.RawValue{
+			{Type: imapclient.RawValueString, Str: "hello\r\nworld"},
+			{Type: imapclient.RawValueString, Str: "Entw&APw-rfe"},
+			{Type: imapclient.RawValueList, List: []imapclient.RawValue{
+				{Type: imapclient.RawValueAtom, Str: "a"},
+				{Type: imapclient.RawValueAtom, Str: "b"},
+			}},
+			{Type: imapclient.RawValueNIL},
+		},
+	}}
+	if !reflect.DeepEqual(resps, want) {
+		t.Errorf("Execute().Wait() = %#v, want %#v", resps, want)
+	}
+
+	_, err = client.Execute("XECHO", "missing arguments").Wait()
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) || imapErr.Type != imap.StatusResponseTypeBad {
+		t.Errorf("Execute().Wait() = %v, want BAD error", err)
+	}
+}
+
+func TestUnknownResponseHandler(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	respCh := make(chan *imapclient.RawResponse, 3)
+	client := imapclient.New(clientConn, &imapclient.Options{
+		UnknownResponseHandler: func(resp *imapclient.RawResponse) {
+			respCh <- resp
+		},
+	})
+	defer client.Close()
+
+	go func() {
+		br := bufio.NewReader(serverConn)
+		io.WriteString(serverConn, "* OK [CAPABILITY IMAP4rev1] Hello\r\n")
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, _, _ := strings.Cut(line, " ")
+		io.WriteString(serverConn, "* 3 XPING (\\Seen \\*) {5}\r\nhello\r\n")
+		io.WriteString(serverConn, "* XFOO 日本\r\n")
+		io.WriteString(serverConn, "* XBAR [CODE (a b)] some text\r\n")
+		io.WriteString(serverConn, tag+" OK NOOP completed\r\n")
+	}()
+
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop() = %v", err)
+	}
+
+	want := []*imapclient.RawResponse{
+		{
+			Type: "XPING",
+			Num:  3,
+			Fields: []imapclient.RawValue{
+				{Type: imapclient.RawValueList, List: []imapclient.RawValue{
+					{Type: imapclient.RawValueAtom, Str: "\\Seen"},
+					{Type: imapclient.RawValueAtom, Str: "\\*"},
+				}},
+				{Type: imapclient.RawValueString, Str: "hello"},
+			},
+		},
+		{
+			Type: "XFOO",
+			Fields: []imapclient.RawValue{
+				{Type: imapclient.RawValueAtom, Str: "日本"},
+			},
+		},
+		{
+			Type: "XBAR",
+			Fields: []imapclient.RawValue{
+				{Type: imapclient.RawValueAtom, Str: "[CODE"},
+				{Type: imapclient.RawValueList, List: []imapclient.RawValue{
+					{Type: imapclient.RawValueAtom, Str: "a"},
+					{Type: imapclient.RawValueAtom, Str: "b"},
+				}},
+				{Type: imapclient.RawValueText, Str: "] some text"},
+			},
+		},
+	}
+	for _, w := range want {
+		if resp := <-respCh; !reflect.DeepEqual(resp, w) {
+			t.Errorf("got %#v, want %#v", resp, w)
+		}
+	}
+}
+
+func TestGmail(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	client := imapclient.New(clientConn, nil)
+	defer client.Close()
+
+	cmdCh := make(chan string, 3)
+	go func() {
+		defer close(cmdCh)
+		br := bufio.NewReader(serverConn)
+		io.WriteString(serverConn, "* OK [CAPABILITY IMAP4rev1 X-GM-EXT-1] Hello\r\n")
+		for _, resp := range []string{
+			"* 1 FETCH (UID 42 X-GM-MSGID 1278455344230334865 X-GM-THRID 1266894439832287888 X-GM-LABELS (\\Inbox \"\\\\Important\" \"Muy Importante\" Entw&APw-rfe))\r\n",
+			"* SEARCH 42\r\n",
+			"* 1 FETCH (UID 42 X-GM-LABELS (\\Inbox \"\\\\Important\" Work))\r\n",
+		} {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmdCh <- line
+			tag, _, _ := strings.Cut(line, " ")
+			io.WriteString(serverConn, resp)
+			io.WriteString(serverConn, tag+" OK Success\r\n")
+		}
+	}()
+
+	uidSet := imap.NumSetNum(42)
+	msgs, err := client.UIDFetch(uidSet, &imap.FetchOptions{
+		GmailMsgID:    true,
+		GmailThreadID: true,
+		GmailLabels:   true,
+	}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	if cmd := <-cmdCh; !strings.Contains(cmd, "X-GM-MSGID") || !strings.Contains(cmd, "X-GM-THRID") || !strings.Contains(cmd, "X-GM-LABELS") {
+		t.Errorf("FETCH command %q doesn't request Gmail items", cmd)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("len(msgs) = %v, want 1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.GmailMsgID != 1278455344230334865 {
+		t.Errorf("GmailMsgID = %v, want 1278455344230334865", msg.GmailMsgID)
+	}
+	if msg.GmailThreadID != 1266894439832287888 {
+		t.Errorf("GmailThreadID = %v, want 1266894439832287888", msg.GmailThreadID)
+	}
+	wantLabels := []string{"\\Inbox", "\\Important", "Muy Importante", "Entwürfe"}
+	if !reflect.DeepEqual(msg.GmailLabels, wantLabels) {
+		t.Errorf("GmailLabels = %q, want %q", msg.GmailLabels, wantLabels)
+	}
+
+	data, err := client.UIDSearch(&imap.SearchCriteria{
+		GmailRaw: []string{"has:attachment in:unread"},
+	}, nil).Wait()
+	if err != nil {
+		t.Fatalf("UIDSearch() = %v", err)
+	}
+	if cmd, want := <-cmdCh, `UID SEARCH (X-GM-RAW "has:attachment in:unread")`; !strings.Contains(cmd, want) {
+		t.Errorf("SEARCH command = %q, want %q", cmd, want)
+	}
+	if nums := data.AllNums(); !reflect.DeepEqual(nums, []uint32{42}) {
+		t.Errorf("AllNums() = %v, want [42]", nums)
+	}
+
+	msgs, err = client.UIDStoreGmailLabels(uidSet, &imap.StoreGmailLabels{
+		Op:     imap.StoreFlagsAdd,
+		Labels: []string{"\\Important", "Work"},
+	}).Collect()
+	if err != nil {
+		t.Fatalf("UIDStoreGmailLabels() = %v", err)
+	}
+	if cmd, want := <-cmdCh, `UID STORE 42 +X-GM-LABELS ("\\Important" "Work")`; !strings.Contains(cmd, want) {
+		t.Errorf("STORE command = %q, want %q", cmd, want)
+	}
+	wantLabels = []string{"\\Inbox", "\\Important", "Work"}
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0].GmailLabels, wantLabels) {
+		t.Errorf("got %v, want labels %q", msgs, wantLabels)
+	}
+}
@@ -1,8 +1,10 @@
 package imapclient
 
 import (
+	"context"
+
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Client) copy(uid bool, seqSet imap.NumSet, mailbox string) *CopyCommand {
@@ -35,6 +37,15 @@ func (cmd *CopyCommand) Wait() (*imap.CopyData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *CopyCommand) WaitContext(ctx context.Context) (*imap.CopyData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 func readRespCodeCopy(dec *imapwire.Decoder) (uidValidity uint32, srcUIDs, dstUIDs imap.NumSet, err error) {
 	if !dec.ExpectNumber(&uidValidity) || !dec.ExpectSP() || !dec.ExpectNumSet(&srcUIDs) || !dec.ExpectSP() || !dec.ExpectNumSet(&dstUIDs) {
 		return 0, imap.NumSet{}, imap.NumSet{}, dec.Err()
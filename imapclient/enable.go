@@ -1,6 +1,8 @@
 package imapclient
 
 import (
+	"context"
+
 	"github.com/emersion/go-imap/v2"
 )
 
@@ -38,6 +40,15 @@ func (cmd *EnableCommand) Wait() (*EnableData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *EnableCommand) WaitContext(ctx context.Context) (*EnableData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 // EnableData is the data returned by the ENABLE command.
 type EnableData struct {
 	// Capabilities that were successfully enabled
@@ -0,0 +1,303 @@
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// RawAtom is an atom argument for Client.Execute. It's written as-is.
+type RawAtom string
+
+// RawMailbox is a mailbox name argument for Client.Execute. It's encoded with
+// modified UTF-7 if necessary.
+type RawMailbox string
+
+// Execute sends an arbitrary command.
+//
+// This can be used to send commands which aren't natively supported by the
+// client, for instance vendor-specific extensions. The command name may
+// contain a "UID " prefix.
+//
+// Arguments are separated by spaces, and are encoded depending on their type:
+//
+//   - string: quoted string or literal
+//   - RawAtom: atom
+//   - RawMailbox: mailbox name
+//   - uint32, int, int64, uint64, imap.UID: number
+//   - imap.NumSet: sequence set
+//   - imap.Flag: flag
+//   - []interface{}: parenthesized list of arguments
+//   - RawValue: encoded according to its type
+//   - nil: NIL
+//
+// Execute panics if an argument has an unsupported type.
+//
+// While the command is in progress, untagged responses which aren't natively
+// supported by the client are collected by the returned command, see
+// ExecuteCommand.
+func (c *Client) Execute(name string, args ...interface{}) *ExecuteCommand {
+	for _, arg := range args {
+		if err := checkRawArg(arg); err != nil {
+			panic(err)
+		}
+	}
+
+	cmd := &ExecuteCommand{}
+	enc := c.beginCommand(name, cmd)
+	for _, arg := range args {
+		enc.SP()
+		writeRawArg(enc.Encoder, arg)
+	}
+	enc.end()
+	return cmd
+}
+
+func checkRawArg(arg interface{}) error {
+	switch arg := arg.(type) {
+	case nil, string, RawAtom, RawMailbox, uint32, int64, uint64, imap.UID, imap.NumSet, imap.Flag, RawValue:
+		return nil
+	case int:
+		if arg < 0 {
+			return fmt.Errorf("imapclient: negative number argument %v", arg)
+		}
+		return nil
+	case []interface{}:
+		for _, item := range arg {
+			if err := checkRawArg(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("imapclient: unsupported argument type %T", arg)
+	}
+}
+
+func writeRawArg(enc *imapwire.Encoder, arg interface{}) {
+	switch arg := arg.(type) {
+	case nil:
+		enc.NIL()
+	case string:
+		enc.String(arg)
+	case RawAtom:
+		enc.Atom(string(arg))
+	case RawMailbox:
+		enc.Mailbox(string(arg))
+	case uint32:
+		enc.Number(arg)
+	case int:
+		enc.Number64(int64(arg))
+	case int64:
+		enc.Number64(arg)
+	case uint64:
+		enc.ModSeq(arg)
+	case imap.UID:
+		enc.UID(arg)
+	case imap.NumSet:
+		enc.NumSet(arg)
+	case imap.Flag:
+		enc.Flag(arg)
+	case []interface{}:
+		enc.List(len(arg), func(i int) {
+			writeRawArg(enc, arg[i])
+		})
+	case RawValue:
+		writeRawValue(enc, arg)
+	default:
+		panic(fmt.Errorf("imapclient: unsupported argument type %T", arg))
+	}
+}
+
+func writeRawValue(enc *imapwire.Encoder, v RawValue) {
+	switch v.Type {
+	case RawValueAtom:
+		enc.Atom(v.Str)
+	case RawValueString:
+		enc.String(v.Str)
+	case RawValueNIL:
+		enc.NIL()
+	case RawValueList:
+		enc.List(len(v.List), func(i int) {
+			writeRawValue(enc, v.List[i])
+		})
+	case RawValueText:
+		enc.Text(v.Str)
+	default:
+		panic(fmt.Errorf("imapclient: unknown raw value type %v", v.Type))
+	}
+}
+
+// ExecuteCommand is an arbitrary command sent with Client.Execute.
+type ExecuteCommand struct {
+	cmd
+	data []RawResponse
+}
+
+// Wait blocks until the command has completed.
+//
+// The untagged responses not natively supported by the client and received
+// while the command was in progress are returned.
+func (cmd *ExecuteCommand) Wait() ([]RawResponse, error) {
+	err := cmd.cmd.Wait()
+	return cmd.data, err
+}
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *ExecuteCommand) WaitContext(ctx context.Context) ([]RawResponse, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
+// RawValueType is the type of a RawValue.
+type RawValueType int
+
+const (
+	// An atom, including numbers and flags.
+	RawValueAtom RawValueType = 1 + iota
+	// A quoted string or a literal.
+	RawValueString
+	// NIL.
+	RawValueNIL
+	// A parenthesized list.
+	RawValueList
+	// Text which doesn't follow the IMAP syntax, up to the end of the line.
+	RawValueText
+)
+
+// RawValue is a generic IMAP value.
+type RawValue struct {
+	Type RawValueType
+	// Contents of an atom, a string or text.
+	Str string
+	// Items of a list.
+	List []RawValue
+}
+
+// Number returns the numeric value of an atom. false is returned if the value
+// isn't a number.
+func (v RawValue) Number() (uint32, bool) {
+	if v.Type != RawValueAtom {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v.Str, 10, 32)
+	return uint32(n), err == nil
+}
+
+// RawResponse is a generic untagged response.
+type RawResponse struct {
+	// Response type, in uppercase. For instance, "XLIST".
+	Type string
+	// Number preceding the response type, for instance for message data. Zero
+	// if the response isn't prefixed with a number.
+	Num uint32
+	// Values following the response type.
+	Fields []RawValue
+}
+
+func (c *Client) handleUnknown(num uint32, typ string) error {
+	fields, err := readRawValues(c.dec)
+	if err != nil {
+		return fmt.Errorf("in %v response: %v", typ, err)
+	}
+	resp := &RawResponse{
+		Type:   strings.ToUpper(typ),
+		Num:    num,
+		Fields: fields,
+	}
+
+	if cmd := findPendingCmdByType[*ExecuteCommand](c); cmd != nil {
+		cmd.data = append(cmd.data, *resp)
+	} else if handler := c.options.UnknownResponseHandler; handler != nil {
+		handler(resp)
+	} else {
+		return fmt.Errorf("unsupported response type %q", typ)
+	}
+	return nil
+}
+
+// readRawValues reads the values of a generic response, up to the end of the
+// line.
+//
+// Responses which don't follow the usual IMAP syntax are accepted: the part of
+// the line which can't be parsed is returned as a RawValueText value.
+func readRawValues(dec *imapwire.Decoder) ([]RawValue, error) {
+	var l []RawValue
+	for dec.SP() {
+		v, ok, err := readRawValue(dec)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			l = append(l, *v)
+		}
+		if !ok {
+			break
+		}
+	}
+
+	var text string
+	if dec.Text(&text) {
+		l = append(l, RawValue{Type: RawValueText, Str: text})
+	}
+	return l, nil
+}
+
+// readRawValue reads a single value. If the value is malformed, ok is false
+// and v contains the part of the value which could be parsed, if any.
+func readRawValue(dec *imapwire.Decoder) (v *RawValue, ok bool, err error) {
+	var s string
+	if dec.String(&s) {
+		return &RawValue{Type: RawValueString, Str: s}, true, nil
+	} else if err := dec.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if dec.Special('(') {
+		v := &RawValue{Type: RawValueList}
+		for i := 0; ; i++ {
+			if dec.Special(')') {
+				return v, true, nil
+			}
+			if i > 0 && !dec.SP() {
+				return v, false, dec.Err()
+			}
+			item, ok, err := readRawValue(dec)
+			if err != nil {
+				return nil, false, err
+			}
+			if item != nil {
+				v.List = append(v.List, *item)
+			}
+			if !ok {
+				return v, false, nil
+			}
+		}
+	}
+
+	if !dec.Func(&s, isRawAtomChar) {
+		return nil, false, dec.Err()
+	}
+	if s == "NIL" {
+		return &RawValue{Type: RawValueNIL}, true, nil
+	}
+	return &RawValue{Type: RawValueAtom, Str: s}, true, nil
+}
+
+// isRawAtomChar is a liberal version of imapwire.IsAtomChar, which also
+// accepts flags, wildcards, response codes and 8-bit characters.
+func isRawAtomChar(ch byte) bool {
+	switch ch {
+	case '(', ')', '{', ' ', '"':
+		return false
+	default:
+		return ch >= 0x20 && ch != 0x7f
+	}
+}
@@ -1,6 +1,9 @@
 package imapclient
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/emersion/go-imap/v2"
 )
 
@@ -40,13 +43,79 @@ func (c *Client) handleExpunge(seqNum uint32) error {
 	return nil
 }
 
+func (c *Client) handleVanished() error {
+	var data VanishedData
+	if c.dec.Special('(') {
+		var name string
+		if !c.dec.ExpectAtom(&name) || !c.dec.ExpectSpecial(')') || !c.dec.ExpectSP() {
+			return c.dec.Err()
+		}
+		if name != "EARLIER" {
+			return fmt.Errorf("in vanished: unexpected tag %q", name)
+		}
+		data.Earlier = true
+	}
+	if !c.dec.ExpectNumSet(&data.UIDs) {
+		return c.dec.Err()
+	}
+
+	if !data.Earlier {
+		// Note: a dynamic number set would be a server bug
+		uids, _ := data.UIDs.Nums()
+		c.mutex.Lock()
+		if c.state == imap.ConnStateSelected {
+			c.mailbox = c.mailbox.copy()
+			if n := uint32(len(uids)); c.mailbox.NumMessages > n {
+				c.mailbox.NumMessages -= n
+			} else {
+				c.mailbox.NumMessages = 0
+			}
+		}
+		c.mutex.Unlock()
+	}
+
+	var cmd command
+	if data.Earlier {
+		cmd = c.findPendingCmdFunc(func(anyCmd command) bool {
+			cmd, ok := anyCmd.(*FetchCommand)
+			return ok && cmd.vanished
+		})
+	} else if expungeCmd := findPendingCmdByType[*ExpungeCommand](c); expungeCmd != nil {
+		cmd = expungeCmd
+	}
+	switch cmd := cmd.(type) {
+	case *FetchCommand:
+		cmd.vanishedUIDs.AddSet(data.UIDs)
+	case *ExpungeCommand:
+		cmd.vanishedUIDs.AddSet(data.UIDs)
+	default:
+		if handler := c.options.unilateralDataHandler().Vanished; handler != nil {
+			handler(&data)
+		}
+	}
+
+	return nil
+}
+
+// VanishedData is the data contained in a VANISHED response.
+//
+// This requires QRESYNC.
+type VanishedData struct {
+	// Earlier is true if the messages were expunged before the command which
+	// triggered the response. In this case, the number of messages in the
+	// mailbox is left unchanged.
+	Earlier bool
+	UIDs    imap.NumSet
+}
+
 // ExpungeCommand is an EXPUNGE command.
 //
 // The caller must fully consume the ExpungeCommand. A simple way to do so is
 // to defer a call to FetchCommand.Close.
 type ExpungeCommand struct {
 	cmd
-	seqNums chan uint32
+	seqNums      chan uint32
+	vanishedUIDs imap.NumSet
 }
 
 // Next advances to the next expunged message sequence number.
@@ -82,3 +151,20 @@ func (cmd *ExpungeCommand) Collect() ([]uint32, error) {
 	}
 	return l, cmd.Close()
 }
+
+// CollectContext is like Collect, but stops waiting when ctx is done.
+//
+// If ctx is done first, ctx.Err() is returned and the remaining sequence
+// numbers are discarded in the background. See Command.WaitContext.
+func (cmd *ExpungeCommand) CollectContext(ctx context.Context) ([]uint32, error) {
+	return waitContextFunc(ctx, cmd.Collect)
+}
+
+// Vanished returns the UIDs of the expunged messages, as reported by VANISHED
+// responses.
+//
+// When QRESYNC is enabled, servers send VANISHED responses instead of EXPUNGE
+// responses. Vanished must be called after Close or Collect.
+func (cmd *ExpungeCommand) Vanished() imap.NumSet {
+	return cmd.vanishedUIDs
+}
@@ -1,6 +1,7 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/mail"
@@ -8,8 +9,8 @@ import (
 	"time"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 func (c *Client) fetch(uid bool, seqSet imap.NumSet, options *imap.FetchOptions) *FetchCommand {
@@ -18,15 +19,20 @@ func (c *Client) fetch(uid bool, seqSet imap.NumSet, options *imap.FetchOptions)
 	}
 
 	cmd := &FetchCommand{
-		uid:    uid,
-		seqSet: seqSet,
-		msgs:   make(chan *FetchMessageData, 128),
+		uid:      uid,
+		seqSet:   seqSet,
+		vanished: options.Vanished,
+		msgs:     make(chan *FetchMessageData, 128),
 	}
 	enc := c.beginCommand(uidCmdName("FETCH", uid), cmd)
 	enc.SP().NumSet(seqSet).SP()
 	writeFetchItems(enc.Encoder, uid, options)
 	if options.ChangedSince != 0 {
-		enc.SP().Special('(').Atom("CHANGEDSINCE").SP().ModSeq(options.ChangedSince).Special(')')
+		enc.SP().Special('(').Atom("CHANGEDSINCE").SP().ModSeq(options.ChangedSince)
+		if options.Vanished {
+			enc.SP().Atom("VANISHED")
+		}
+		enc.Special(')')
 	}
 	enc.end()
 	return cmd
@@ -66,6 +72,9 @@ func writeFetchItems(enc *imapwire.Encoder, uid bool, options *imap.FetchOptions
 		"INTERNALDATE":  options.InternalDate,
 		"RFC822.SIZE":   options.RFC822Size,
 		"MODSEQ":        options.ModSeq,
+		"X-GM-MSGID":    options.GmailMsgID,
+		"X-GM-THRID":    options.GmailThreadID,
+		"X-GM-LABELS":   options.GmailLabels,
 	}
 	for k, req := range m {
 		if req {
@@ -163,6 +172,9 @@ type FetchCommand struct {
 	seqSet     imap.NumSet
 	recvNumSet imap.NumSet
 
+	vanished     bool
+	vanishedUIDs imap.NumSet
+
 	msgs chan *FetchMessageData
 	prev *FetchMessageData
 }
@@ -217,6 +229,23 @@ func (cmd *FetchCommand) Collect() ([]*FetchMessageBuffer, error) {
 	return l, cmd.Close()
 }
 
+// CollectContext is like Collect, but stops waiting when ctx is done.
+//
+// If ctx is done first, ctx.Err() is returned and the remaining messages are
+// discarded in the background. See Command.WaitContext.
+func (cmd *FetchCommand) CollectContext(ctx context.Context) ([]*FetchMessageBuffer, error) {
+	return waitContextFunc(ctx, cmd.Collect)
+}
+
+// Vanished returns the UIDs of the messages which have been expunged since
+// FetchOptions.ChangedSince, as reported by VANISHED (EARLIER) responses.
+//
+// This requires QRESYNC and FetchOptions.Vanished. Vanished must be called
+// after Close or Collect.
+func (cmd *FetchCommand) Vanished() imap.NumSet {
+	return cmd.vanishedUIDs
+}
+
 // FetchMessageData contains a message's FETCH data.
 type FetchMessageData struct {
 	SeqNum uint32
@@ -282,6 +311,11 @@ var (
 	_ FetchItemData = FetchItemDataRFC822Size{}
 	_ FetchItemData = FetchItemDataUID{}
 	_ FetchItemData = FetchItemDataBodyStructure{}
+	_ FetchItemData = FetchItemDataBinarySectionSize{}
+	_ FetchItemData = FetchItemDataModSeq{}
+	_ FetchItemData = FetchItemDataGmailMsgID{}
+	_ FetchItemData = FetchItemDataGmailThreadID{}
+	_ FetchItemData = FetchItemDataGmailLabels{}
 )
 
 type discarder interface {
@@ -378,6 +412,36 @@ type FetchItemDataModSeq struct {
 
 func (FetchItemDataModSeq) fetchItemData() {}
 
+// FetchItemDataGmailMsgID holds data returned by FETCH X-GM-MSGID.
+//
+// This requires the X-GM-EXT-1 extension.
+type FetchItemDataGmailMsgID struct {
+	MsgID uint64
+}
+
+func (FetchItemDataGmailMsgID) fetchItemData() {}
+
+// FetchItemDataGmailThreadID holds data returned by FETCH X-GM-THRID.
+//
+// This requires the X-GM-EXT-1 extension.
+type FetchItemDataGmailThreadID struct {
+	ThreadID uint64
+}
+
+func (FetchItemDataGmailThreadID) fetchItemData() {}
+
+// FetchItemDataGmailLabels holds data returned by FETCH X-GM-LABELS.
+//
+// System labels start with a backslash, for instance `\Inbox` or
+// `\Important`. Other labels are user-defined.
+//
+// This requires the X-GM-EXT-1 extension.
+type FetchItemDataGmailLabels struct {
+	Labels []string
+}
+
+func (FetchItemDataGmailLabels) fetchItemData() {}
+
 // FetchMessageBuffer is a buffer for the data returned by FetchMessageData.
 //
 // The SeqNum field is always populated. All remaining fields are optional.
@@ -392,7 +456,10 @@ type FetchMessageBuffer struct {
 	BodySection       map[*imap.FetchItemBodySection][]byte
 	BinarySection     map[*imap.FetchItemBinarySection][]byte
 	BinarySectionSize []FetchItemDataBinarySectionSize
-	ModSeq            uint64 // requires CONDSTORE
+	ModSeq            uint64   // requires CONDSTORE
+	GmailMsgID        uint64   // requires X-GM-EXT-1
+	GmailThreadID     uint64   // requires X-GM-EXT-1
+	GmailLabels       []string // requires X-GM-EXT-1
 }
 
 func (buf *FetchMessageBuffer) populateItemData(item FetchItemData) error {
@@ -431,6 +498,12 @@ func (buf *FetchMessageBuffer) populateItemData(item FetchItemData) error {
 		buf.BinarySectionSize = append(buf.BinarySectionSize, item)
 	case FetchItemDataModSeq:
 		buf.ModSeq = item.ModSeq
+	case FetchItemDataGmailMsgID:
+		buf.GmailMsgID = item.MsgID
+	case FetchItemDataGmailThreadID:
+		buf.GmailThreadID = item.ThreadID
+	case FetchItemDataGmailLabels:
+		buf.GmailLabels = item.Labels
 	default:
 		panic(fmt.Errorf("unsupported fetch item data %T", item))
 	}
@@ -493,8 +566,8 @@ func (c *Client) handleFetch(seqNum uint32) error {
 	numAtts := 0
 	return dec.ExpectList(func() error {
 		var attName string
-		if !dec.Expect(dec.Func(&attName, isMsgAttNameChar), "msg-att name") {
-			return dec.Err()
+		if !dec.Func(&attName, isMsgAttNameChar) {
+			return internal.NewExpectError("msg-att name")
 		}
 		attName = strings.ToUpper(attName)
 
@@ -607,8 +680,8 @@ func (c *Client) handleFetch(seqNum uint32) error {
 				}
 				break
 			}
-			if !dec.Expect(attName == "BODY", "'['") {
-				return dec.Err()
+			if attName != "BODY" {
+				return internal.NewExpectError("'['")
 			}
 			fallthrough
 		case "BODYSTRUCTURE":
@@ -646,6 +719,25 @@ func (c *Client) handleFetch(seqNum uint32) error {
 				return dec.Err()
 			}
 			item = FetchItemDataModSeq{ModSeq: modSeq}
+		case "X-GM-MSGID", "X-GM-THRID":
+			var id uint64
+			if !dec.ExpectSP() || !dec.ExpectModSeq(&id) {
+				return dec.Err()
+			}
+			if attName == "X-GM-MSGID" {
+				item = FetchItemDataGmailMsgID{MsgID: id}
+			} else {
+				item = FetchItemDataGmailThreadID{ThreadID: id}
+			}
+		case "X-GM-LABELS":
+			if !dec.ExpectSP() {
+				return dec.Err()
+			}
+			labels, err := readGmailLabels(dec)
+			if err != nil {
+				return err
+			}
+			item = FetchItemDataGmailLabels{Labels: labels}
 		default:
 			return fmt.Errorf("unsupported msg-att name: %q", attName)
 		}
@@ -1125,6 +1217,26 @@ func readHeaderList(dec *imapwire.Decoder) ([]string, error) {
 	return l, err
 }
 
+// readGmailLabels reads a list of Gmail labels. System labels may be sent
+// either as flags or as quoted strings, e.g. \Inbox or "\\Important".
+func readGmailLabels(dec *imapwire.Decoder) ([]string, error) {
+	var l []string
+	err := dec.ExpectList(func() error {
+		var label string
+		if dec.Special('\\') {
+			if !dec.ExpectAtom(&label) {
+				return fmt.Errorf("in system label: %w", dec.Err())
+			}
+			label = "\\" + label
+		} else if !dec.ExpectMailbox(&label) {
+			return fmt.Errorf("in label: %w", dec.Err())
+		}
+		l = append(l, label)
+		return nil
+	})
+	return l, err
+}
+
 func readSectionPart(dec *imapwire.Decoder) (part []int, dot bool) {
 	for {
 		dot = len(part) > 0
@@ -0,0 +1,57 @@
+package imapclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal"
+)
+
+// ID sends an ID command.
+//
+// The ID data is sent to the server, and the server's ID data is returned.
+// A nil pointer sends NIL.
+//
+// This command requires support for the ID extension.
+func (c *Client) ID(idData *imap.IDData) *IDCommand {
+	cmd := &IDCommand{}
+	enc := c.beginCommand("ID", cmd)
+	enc.SP()
+	internal.WriteIDData(enc.Encoder, idData)
+	enc.end()
+	return cmd
+}
+
+func (c *Client) handleID() error {
+	data, err := internal.ExpectIDData(c.dec)
+	if err != nil {
+		return fmt.Errorf("in id-response: %v", err)
+	}
+	if cmd := findPendingCmdByType[*IDCommand](c); cmd != nil {
+		cmd.data = data
+	}
+	return nil
+}
+
+// IDCommand is an ID command.
+type IDCommand struct {
+	cmd
+	data *imap.IDData
+}
+
+// Wait blocks until the ID command has completed.
+//
+// A nil pointer is returned if the server didn't send any ID data.
+func (cmd *IDCommand) Wait() (*imap.IDData, error) {
+	return cmd.data, cmd.cmd.Wait()
+}
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *IDCommand) WaitContext(ctx context.Context) (*imap.IDData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
@@ -0,0 +1,452 @@
+// Package imapsync synchronizes a local copy of a mailbox with an IMAP server.
+//
+// The local copy is accessed via the Store interface. Synchronization is
+// incremental: only new messages, flag changes and expunged messages are
+// transferred. CONDSTORE (RFC 7162) and QRESYNC (RFC 7162) are used when
+// supported by the server. Otherwise, flags are compared and expunged messages
+// are detected with UID SEARCH.
+package imapsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// fetchBatchSize is the maximum number of messages fetched with a single
+// command.
+const fetchBatchSize = 64
+
+// MailboxState is the synchronization state of a mailbox.
+type MailboxState struct {
+	UIDValidity uint32
+	// UID of the next message to be synchronized
+	UIDNext imap.UID
+	// Zero if CONDSTORE isn't supported by the server
+	HighestModSeq uint64
+}
+
+// Message is a message stored locally.
+type Message struct {
+	UID          imap.UID
+	Flags        []imap.Flag
+	InternalDate time.Time
+	// Full message contents, as defined in RFC 5322
+	Body []byte
+}
+
+// Store is a local copy of a single mailbox.
+type Store interface {
+	// State returns the synchronization state of the mailbox. Nil is
+	// returned if the mailbox has never been synchronized.
+	State() (*MailboxState, error)
+	// SetState saves the synchronization state of the mailbox.
+	SetState(state *MailboxState) error
+	// Reset deletes all messages. It's called when the mailbox UIDVALIDITY
+	// changes.
+	Reset() error
+	// UIDs returns the UIDs of all messages stored locally.
+	UIDs() ([]imap.UID, error)
+	// PutMessage stores a new message.
+	PutMessage(msg *Message) error
+	// SetFlags replaces the flags of a message. Unknown UIDs are ignored.
+	SetFlags(uid imap.UID, flags []imap.Flag) error
+	// DeleteMessages deletes messages. Unknown UIDs are ignored.
+	DeleteMessages(uids []imap.UID) error
+}
+
+// LocalChanges contains changes made to a local mailbox copy.
+type LocalChanges struct {
+	// New flags for modified messages
+	Flags map[imap.UID][]imap.Flag
+	// Messages deleted locally
+	Deleted []imap.UID
+}
+
+// ChangeStore is a Store which records local changes. It's required for
+// two-way synchronization.
+type ChangeStore interface {
+	Store
+	// LocalChanges returns the changes made locally since the last
+	// synchronization.
+	LocalChanges() (*LocalChanges, error)
+	// ClearLocalChanges is called once changes have been applied to the
+	// server.
+	ClearLocalChanges(changes *LocalChanges) error
+}
+
+// Options contains options for Sync.
+type Options struct {
+	// Push local changes to the server before fetching remote changes. The
+	// store must implement ChangeStore.
+	//
+	// Local changes are applied unconditionally: they override concurrent
+	// changes made on the server. Messages deleted locally are marked as
+	// \Deleted, and are expunged if the server supports UIDPLUS.
+	TwoWay bool
+}
+
+// Sync synchronizes a mailbox.
+//
+// The client must be authenticated. The mailbox is left selected. If the
+// server supports QRESYNC, it's enabled.
+//
+// A nil options pointer is equivalent to a zero options value.
+func Sync(ctx context.Context, c *imapclient.Client, mailbox string, store Store, options *Options) error {
+	if options == nil {
+		options = new(Options)
+	}
+
+	var changeStore ChangeStore
+	if options.TwoWay {
+		var ok bool
+		changeStore, ok = store.(ChangeStore)
+		if !ok {
+			return fmt.Errorf("imapsync: two-way synchronization requires a ChangeStore")
+		}
+	}
+
+	caps := c.Caps()
+	condStore := caps.Has(imap.CapCondStore)
+	qresync := caps.Has(imap.CapQResync)
+
+	if qresync {
+		if _, err := c.Enable(imap.CapQResync).WaitContext(ctx); err != nil {
+			return fmt.Errorf("imapsync: failed to enable QRESYNC: %w", err)
+		}
+	}
+
+	selectData, err := c.Select(mailbox, &imap.SelectOptions{
+		ReadOnly:  !options.TwoWay,
+		CondStore: condStore && !qresync,
+	}).WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("imapsync: failed to select mailbox: %w", err)
+	}
+
+	state, err := store.State()
+	if err != nil {
+		return err
+	}
+	if state == nil || state.UIDValidity != selectData.UIDValidity {
+		if err := store.Reset(); err != nil {
+			return err
+		}
+		if changeStore != nil {
+			// Local changes refer to UIDs which are no longer valid
+			changes, err := changeStore.LocalChanges()
+			if err != nil {
+				return err
+			}
+			if err := changeStore.ClearLocalChanges(changes); err != nil {
+				return err
+			}
+		}
+		state = &MailboxState{UIDValidity: selectData.UIDValidity, UIDNext: 1}
+	}
+
+	s := &syncer{
+		ctx:        ctx,
+		client:     c,
+		store:      store,
+		state:      state,
+		selectData: selectData,
+		condStore:  condStore || qresync,
+		qresync:    qresync,
+	}
+
+	if changeStore != nil {
+		if err := s.push(changeStore); err != nil {
+			return err
+		}
+	}
+	if err := s.pull(); err != nil {
+		return err
+	}
+
+	return store.SetState(s.newState())
+}
+
+type syncer struct {
+	ctx        context.Context
+	client     *imapclient.Client
+	store      Store
+	state      *MailboxState
+	selectData *imap.SelectData
+	condStore  bool
+	qresync    bool
+
+	uidNext imap.UID
+}
+
+// push applies local changes to the server.
+func (s *syncer) push(changeStore ChangeStore) error {
+	changes, err := changeStore.LocalChanges()
+	if err != nil {
+		return err
+	}
+
+	// Messages with the same flags are updated with a single command
+	type flagsGroup struct {
+		flags []imap.Flag
+		uids  imap.NumSet
+	}
+	groups := make(map[string]*flagsGroup)
+	for uid, flags := range changes.Flags {
+		k := flagsKey(flags)
+		g, ok := groups[k]
+		if !ok {
+			g = &flagsGroup{flags: flags}
+			groups[k] = g
+		}
+		g.uids.AddNum(uint32(uid))
+	}
+	for _, g := range groups {
+		storeFlags := imap.StoreFlags{
+			Op:     imap.StoreFlagsSet,
+			Silent: true,
+			Flags:  g.flags,
+		}
+		if _, err := s.client.UIDStore(g.uids, &storeFlags, nil).CollectContext(s.ctx); err != nil {
+			return fmt.Errorf("imapsync: failed to push flags: %w", err)
+		}
+	}
+
+	if len(changes.Deleted) > 0 {
+		var uids imap.NumSet
+		for _, uid := range changes.Deleted {
+			uids.AddNum(uint32(uid))
+		}
+
+		storeFlags := imap.StoreFlags{
+			Op:     imap.StoreFlagsAdd,
+			Silent: true,
+			Flags:  []imap.Flag{imap.FlagDeleted},
+		}
+		if _, err := s.client.UIDStore(uids, &storeFlags, nil).CollectContext(s.ctx); err != nil {
+			return fmt.Errorf("imapsync: failed to push deletions: %w", err)
+		}
+		if s.client.Caps().Has(imap.CapUIDPlus) {
+			if _, err := s.client.UIDExpunge(uids).CollectContext(s.ctx); err != nil {
+				return fmt.Errorf("imapsync: failed to expunge: %w", err)
+			}
+		}
+	}
+
+	return changeStore.ClearLocalChanges(changes)
+}
+
+// pull applies remote changes to the store.
+func (s *syncer) pull() error {
+	s.uidNext = s.state.UIDNext
+
+	if s.state.UIDNext > 1 {
+		var err error
+		if s.condStore && s.state.HighestModSeq > 0 {
+			err = s.pullChangedSince()
+		} else {
+			err = s.pullAllFlags()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.pullNew()
+}
+
+// knownUIDs returns the UID range of messages which have been synchronized.
+func (s *syncer) knownUIDs() imap.NumSet {
+	return imap.NumSetRange(1, uint32(s.state.UIDNext-1))
+}
+
+// pullChangedSince fetches flags of messages modified since the last
+// synchronization with CONDSTORE, and expunged messages with QRESYNC.
+func (s *syncer) pullChangedSince() error {
+	if s.selectData.HighestModSeq != s.state.HighestModSeq {
+		cmd := s.client.UIDFetch(s.knownUIDs(), &imap.FetchOptions{
+			UID:          true,
+			Flags:        true,
+			ChangedSince: s.state.HighestModSeq,
+			Vanished:     s.qresync,
+		})
+		msgs, err := cmd.CollectContext(s.ctx)
+		if err != nil {
+			return fmt.Errorf("imapsync: failed to fetch changed flags: %w", err)
+		}
+		for _, msg := range msgs {
+			if err := s.store.SetFlags(msg.UID, msg.Flags); err != nil {
+				return err
+			}
+		}
+
+		if s.qresync {
+			// Note: a dynamic number set would be a server bug
+			vanished, _ := cmd.Vanished().Nums()
+			if err := s.store.DeleteMessages(toUIDs(vanished)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.qresync {
+		return nil
+	}
+
+	// Without QRESYNC, expunged messages need to be detected by comparing
+	// UIDs
+	searchData, err := s.client.UIDSearch(&imap.SearchCriteria{
+		UID: []imap.NumSet{s.knownUIDs()},
+	}, nil).WaitContext(s.ctx)
+	if err != nil {
+		return fmt.Errorf("imapsync: failed to search UIDs: %w", err)
+	}
+	remote := make(map[imap.UID]struct{})
+	for _, uid := range searchData.AllNums() {
+		remote[imap.UID(uid)] = struct{}{}
+	}
+	return s.deleteMissing(remote)
+}
+
+// pullAllFlags fetches the flags of all messages which have been synchronized,
+// and detects expunged messages.
+func (s *syncer) pullAllFlags() error {
+	msgs, err := s.client.UIDFetch(s.knownUIDs(), &imap.FetchOptions{
+		UID:   true,
+		Flags: true,
+	}).CollectContext(s.ctx)
+	if err != nil {
+		return fmt.Errorf("imapsync: failed to fetch flags: %w", err)
+	}
+
+	remote := make(map[imap.UID]struct{}, len(msgs))
+	for _, msg := range msgs {
+		remote[msg.UID] = struct{}{}
+		if err := s.store.SetFlags(msg.UID, msg.Flags); err != nil {
+			return err
+		}
+	}
+	return s.deleteMissing(remote)
+}
+
+// deleteMissing deletes local messages which are missing from remote.
+func (s *syncer) deleteMissing(remote map[imap.UID]struct{}) error {
+	local, err := s.store.UIDs()
+	if err != nil {
+		return err
+	}
+
+	var expunged []imap.UID
+	for _, uid := range local {
+		if _, ok := remote[uid]; !ok && uid < s.state.UIDNext {
+			expunged = append(expunged, uid)
+		}
+	}
+	if len(expunged) == 0 {
+		return nil
+	}
+	return s.store.DeleteMessages(expunged)
+}
+
+// pullNew fetches messages added since the last synchronization.
+func (s *syncer) pullNew() error {
+	if s.selectData.NumMessages == 0 {
+		return nil
+	}
+
+	searchData, err := s.client.UIDSearch(&imap.SearchCriteria{
+		UID: []imap.NumSet{{imap.NumRange{Start: uint32(s.state.UIDNext), Stop: 0}}},
+	}, nil).WaitContext(s.ctx)
+	if err != nil {
+		return fmt.Errorf("imapsync: failed to search new messages: %w", err)
+	}
+
+	// "n:*" always matches the last message, even if its UID is lower than n
+	var uids []uint32
+	for _, uid := range searchData.AllNums() {
+		if imap.UID(uid) >= s.state.UIDNext {
+			uids = append(uids, uid)
+		}
+	}
+
+	bodySection := &imap.FetchItemBodySection{Peek: true}
+	for len(uids) > 0 {
+		n := len(uids)
+		if n > fetchBatchSize {
+			n = fetchBatchSize
+		}
+		batch := uids[:n]
+		uids = uids[n:]
+
+		msgs, err := s.client.UIDFetch(imap.NumSetNum(batch...), &imap.FetchOptions{
+			UID:          true,
+			Flags:        true,
+			InternalDate: true,
+			BodySection:  []*imap.FetchItemBodySection{bodySection},
+		}).CollectContext(s.ctx)
+		if err != nil {
+			return fmt.Errorf("imapsync: failed to fetch new messages: %w", err)
+		}
+
+		for _, msg := range msgs {
+			var body []byte
+			for section, b := range msg.BodySection {
+				if section.Specifier == imap.PartSpecifierNone && len(section.Part) == 0 {
+					body = b
+				}
+			}
+
+			err := s.store.PutMessage(&Message{
+				UID:          msg.UID,
+				Flags:        msg.Flags,
+				InternalDate: msg.InternalDate,
+				Body:         body,
+			})
+			if err != nil {
+				return err
+			}
+
+			if msg.UID >= s.uidNext {
+				s.uidNext = msg.UID + 1
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *syncer) newState() *MailboxState {
+	uidNext := s.selectData.UIDNext
+	if uidNext < s.uidNext {
+		// Messages added after SELECT may have been fetched
+		uidNext = s.uidNext
+	}
+	return &MailboxState{
+		UIDValidity:   s.selectData.UIDValidity,
+		UIDNext:       uidNext,
+		HighestModSeq: s.selectData.HighestModSeq,
+	}
+}
+
+// flagsKey returns a string which is identical for equal flag sets.
+func flagsKey(flags []imap.Flag) string {
+	l := make([]string, len(flags))
+	for i, flag := range flags {
+		l[i] = strings.ToLower(string(flag))
+	}
+	sort.Strings(l)
+	return strings.Join(l, " ")
+}
+
+func toUIDs(nums []uint32) []imap.UID {
+	uids := make([]imap.UID, len(nums))
+	for i, num := range nums {
+		uids[i] = imap.UID(num)
+	}
+	return uids
+}
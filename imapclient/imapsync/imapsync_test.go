@@ -0,0 +1,430 @@
+package imapsync_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapclient/imapsync"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+const (
+	testUsername = "test-user"
+	testPassword = "test-password"
+)
+
+const testRawMessage = `From: contact@example.org
+Subject: Hello
+
+Hi there!`
+
+// memStore is an in-memory ChangeStore.
+type memStore struct {
+	state    *imapsync.MailboxState
+	messages map[imap.UID]*imapsync.Message
+	changes  imapsync.LocalChanges
+}
+
+func newMemStore() *memStore {
+	return &memStore{messages: make(map[imap.UID]*imapsync.Message)}
+}
+
+func (s *memStore) State() (*imapsync.MailboxState, error) {
+	return s.state, nil
+}
+
+func (s *memStore) SetState(state *imapsync.MailboxState) error {
+	s.state = state
+	return nil
+}
+
+func (s *memStore) Reset() error {
+	s.messages = make(map[imap.UID]*imapsync.Message)
+	return nil
+}
+
+func (s *memStore) UIDs() ([]imap.UID, error) {
+	var uids []imap.UID
+	for uid := range s.messages {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return uids[i] < uids[j]
+	})
+	return uids, nil
+}
+
+func (s *memStore) PutMessage(msg *imapsync.Message) error {
+	s.messages[msg.UID] = msg
+	return nil
+}
+
+func (s *memStore) SetFlags(uid imap.UID, flags []imap.Flag) error {
+	if msg, ok := s.messages[uid]; ok {
+		msg.Flags = flags
+	}
+	return nil
+}
+
+func (s *memStore) DeleteMessages(uids []imap.UID) error {
+	for _, uid := range uids {
+		delete(s.messages, uid)
+	}
+	return nil
+}
+
+func (s *memStore) LocalChanges() (*imapsync.LocalChanges, error) {
+	changes := s.changes
+	return &changes, nil
+}
+
+func (s *memStore) ClearLocalChanges(changes *imapsync.LocalChanges) error {
+	s.changes = imapsync.LocalChanges{}
+	return nil
+}
+
+func newTestServer(t *testing.T, caps imap.CapSet) (*imapserver.Server, string) {
+	memServer := imapmemserver.New()
+	user := imapmemserver.NewUser(testUsername, testPassword)
+	user.Create("INBOX", nil)
+	if _, err := user.Append("INBOX", strings.NewReader(testRawMessage), &imap.AppendOptions{}); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+	memServer.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return memServer.NewSession(), nil, nil
+		},
+		Caps:         caps,
+		InsecureAuth: true,
+	})
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	}()
+
+	return server, ln.Addr().String()
+}
+
+func newTestClient(t *testing.T, addr string) *imapclient.Client {
+	return newTestClientWithOptions(t, addr, nil)
+}
+
+func newTestClientWithOptions(t *testing.T, addr string, options *imapclient.Options) *imapclient.Client {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(conn, options)
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login().Wait() = %v", err)
+	}
+	return client
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func appendMessage(t *testing.T, client *imapclient.Client, mailbox string) {
+	t.Helper()
+	appendCmd := client.Append(mailbox, int64(len(testRawMessage)), nil)
+	appendCmd.Write([]byte(testRawMessage))
+	appendCmd.Close()
+	if _, err := appendCmd.Wait(); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+}
+
+func checkMessages(t *testing.T, store *memStore, want map[imap.UID][]imap.Flag) {
+	t.Helper()
+
+	got := make(map[imap.UID][]imap.Flag)
+	for uid, msg := range store.messages {
+		flags := append([]imap.Flag{}, msg.Flags...)
+		sort.Slice(flags, func(i, j int) bool {
+			return flags[i] < flags[j]
+		})
+		got[uid] = flags
+		if !strings.Contains(string(msg.Body), "Hi there!") {
+			t.Errorf("message %v has body %q", uid, msg.Body)
+		}
+	}
+	for uid, flags := range want {
+		if flags == nil {
+			want[uid] = []imap.Flag{}
+		}
+	}
+	for uid, flags := range got {
+		if len(flags) == 0 {
+			got[uid] = []imap.Flag{}
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
+
+func TestSync(t *testing.T) {
+	testCases := []struct {
+		name string
+		caps imap.CapSet
+	}{
+		{"IMAP4rev1", imap.CapSet{imap.CapIMAP4rev1: {}}},
+		{"CONDSTORE", imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapCondStore: {}}},
+		{"QRESYNC", imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapCondStore: {}, imap.CapQResync: {}, imap.CapUIDPlus: {}}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			testSync(t, tc.caps)
+		})
+	}
+}
+
+func testSync(t *testing.T, caps imap.CapSet) {
+	server, addr := newTestServer(t, caps)
+	defer server.Close()
+
+	client := newTestClient(t, addr)
+	defer client.Close()
+
+	other := newTestClient(t, addr)
+	defer other.Close()
+
+	ctx := context.Background()
+	store := newMemStore()
+
+	sync := func(options *imapsync.Options) {
+		t.Helper()
+		if err := imapsync.Sync(ctx, client, "INBOX", store, options); err != nil {
+			t.Fatalf("Sync() = %v", err)
+		}
+	}
+
+	// Initial synchronization
+	sync(nil)
+	checkMessages(t, store, map[imap.UID][]imap.Flag{1: nil})
+
+	// New message and flag change
+	appendMessage(t, other, "INBOX")
+	if _, err := other.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+	storeFlags := imap.StoreFlags{Op: imap.StoreFlagsAdd, Flags: []imap.Flag{imap.FlagFlagged}}
+	if err := other.UIDStore(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+
+	sync(nil)
+	checkMessages(t, store, map[imap.UID][]imap.Flag{
+		1: {imap.FlagFlagged},
+		2: nil,
+	})
+
+	// Expunged message
+	storeFlags = imap.StoreFlags{Op: imap.StoreFlagsAdd, Flags: []imap.Flag{imap.FlagDeleted}}
+	if err := other.UIDStore(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+	if err := other.Expunge().Close(); err != nil {
+		t.Fatalf("Expunge() = %v", err)
+	}
+
+	sync(nil)
+	checkMessages(t, store, map[imap.UID][]imap.Flag{2: nil})
+
+	// Local changes
+	store.messages[2].Flags = []imap.Flag{imap.FlagSeen}
+	store.changes.Flags = map[imap.UID][]imap.Flag{2: {imap.FlagSeen}}
+
+	sync(&imapsync.Options{TwoWay: true})
+	checkMessages(t, store, map[imap.UID][]imap.Flag{2: {imap.FlagSeen}})
+
+	msgs, err := other.UIDFetch(imap.NumSetNum(2), &imap.FetchOptions{Flags: true}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	} else if len(msgs) != 1 || !reflect.DeepEqual(msgs[0].Flags, []imap.Flag{imap.FlagSeen}) {
+		t.Errorf("Fetch() = %v, want \\Seen flag", msgs)
+	}
+
+	// No changes: the state is stable once our own changes have been fetched
+	sync(nil)
+	state := *store.state
+	sync(nil)
+	if *store.state != state {
+		t.Errorf("state = %v, want %v", store.state, state)
+	}
+	checkMessages(t, store, map[imap.UID][]imap.Flag{2: {imap.FlagSeen}})
+}
+
+func TestSync_twoWay(t *testing.T) {
+	testCases := []struct {
+		name    string
+		uidPlus bool
+	}{
+		{"IMAP4rev1", false},
+		{"UIDPLUS", true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			caps := imap.CapSet{imap.CapIMAP4rev1: {}}
+			if tc.uidPlus {
+				caps[imap.CapUIDPlus] = struct{}{}
+			}
+			testSyncTwoWay(t, caps, tc.uidPlus)
+		})
+	}
+}
+
+func testSyncTwoWay(t *testing.T, caps imap.CapSet, uidPlus bool) {
+	server, addr := newTestServer(t, caps)
+	defer server.Close()
+
+	var debug lockedBuffer
+	client := newTestClientWithOptions(t, addr, &imapclient.Options{DebugWriter: &debug})
+	defer client.Close()
+
+	other := newTestClient(t, addr)
+	defer other.Close()
+
+	appendMessage(t, other, "INBOX")
+	appendMessage(t, other, "INBOX")
+
+	ctx := context.Background()
+	store := newMemStore()
+	options := &imapsync.Options{TwoWay: true}
+	if err := imapsync.Sync(ctx, client, "INBOX", store, options); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	checkMessages(t, store, map[imap.UID][]imap.Flag{1: nil, 2: nil, 3: nil})
+
+	// Message 1 is deleted, messages 2 and 3 get the same flags
+	delete(store.messages, 1)
+	store.messages[2].Flags = []imap.Flag{imap.FlagSeen}
+	store.messages[3].Flags = []imap.Flag{imap.FlagSeen}
+	store.changes.Deleted = []imap.UID{1}
+	store.changes.Flags = map[imap.UID][]imap.Flag{
+		2: {imap.FlagSeen},
+		3: {imap.FlagSeen},
+	}
+
+	before := strings.Count(debug.String(), "UID STORE")
+	if err := imapsync.Sync(ctx, client, "INBOX", store, options); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	checkMessages(t, store, map[imap.UID][]imap.Flag{
+		2: {imap.FlagSeen},
+		3: {imap.FlagSeen},
+	})
+	if n := strings.Count(debug.String(), "UID STORE") - before; n != 2 {
+		t.Errorf("sent %v UID STORE commands, want 2", n)
+	}
+
+	if _, err := other.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+	msgs, err := other.UIDFetch(imap.NumSetRange(1, 3), &imap.FetchOptions{UID: true, Flags: true}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	got := make(map[imap.UID][]imap.Flag)
+	for _, msg := range msgs {
+		got[msg.UID] = msg.Flags
+	}
+	want := map[imap.UID][]imap.Flag{
+		2: {imap.FlagSeen},
+		3: {imap.FlagSeen},
+	}
+	if !uidPlus {
+		// Without UIDPLUS, deleted messages are only marked as such
+		want[1] = []imap.Flag{imap.FlagDeleted}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fetch() = %v, want %v", got, want)
+	}
+}
+
+func TestSync_uidValidity(t *testing.T) {
+	server, addr := newTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+	defer server.Close()
+
+	client := newTestClient(t, addr)
+	defer client.Close()
+
+	other := newTestClient(t, addr)
+	defer other.Close()
+
+	if err := other.Create("Archive", nil).Wait(); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	appendMessage(t, other, "Archive")
+	appendMessage(t, other, "Archive")
+
+	ctx := context.Background()
+	store := newMemStore()
+	options := &imapsync.Options{TwoWay: true}
+	if err := imapsync.Sync(ctx, client, "Archive", store, options); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	checkMessages(t, store, map[imap.UID][]imap.Flag{1: nil, 2: nil})
+	uidValidity := store.state.UIDValidity
+
+	// Re-creating the mailbox changes its UIDVALIDITY
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+	if err := other.Delete("Archive").Wait(); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if err := other.Create("Archive", nil).Wait(); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	appendMessage(t, other, "Archive")
+
+	// Local changes refer to the old mailbox and are dropped
+	store.messages[1].Flags = []imap.Flag{imap.FlagFlagged}
+	store.changes.Flags = map[imap.UID][]imap.Flag{1: {imap.FlagFlagged}}
+
+	if err := imapsync.Sync(ctx, client, "Archive", store, options); err != nil {
+		t.Fatalf("Sync() = %v", err)
+	}
+	checkMessages(t, store, map[imap.UID][]imap.Flag{1: nil})
+	if store.state.UIDValidity == uidValidity {
+		t.Errorf("UIDValidity = %v, want a new value", store.state.UIDValidity)
+	}
+	if store.changes.Flags != nil {
+		t.Errorf("local changes = %v, want none", store.changes)
+	}
+}
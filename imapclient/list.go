@@ -1,13 +1,14 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 func getSelectOpts(options *imap.ListOptions) []string {
@@ -49,6 +50,9 @@ func getReturnOpts(options *imap.ListOptions) []string {
 	if options.ReturnSpecialUse {
 		l = append(l, "SPECIAL-USE")
 	}
+	if options.ReturnMyRights {
+		l = append(l, "MYRIGHTS")
+	}
 	return l
 }
 
@@ -63,8 +67,9 @@ func getReturnOpts(options *imap.ListOptions) []string {
 // extension.
 func (c *Client) List(ref, pattern string, options *imap.ListOptions) *ListCommand {
 	cmd := &ListCommand{
-		mailboxes:    make(chan *imap.ListData, 64),
-		returnStatus: options != nil && options.ReturnStatus != nil,
+		mailboxes:      make(chan *imap.ListData, 64),
+		returnStatus:   options != nil && options.ReturnStatus != nil,
+		returnMyRights: options != nil && options.ReturnMyRights,
 	}
 	enc := c.beginCommand("LIST", cmd)
 	if selectOpts := getSelectOpts(options); len(selectOpts) > 0 {
@@ -107,7 +112,7 @@ func (c *Client) handleList() error {
 	})
 	switch cmd := cmd.(type) {
 	case *ListCommand:
-		if cmd.returnStatus {
+		if cmd.returnStatus || cmd.returnMyRights {
 			if cmd.pendingData != nil {
 				cmd.mailboxes <- cmd.pendingData
 			}
@@ -117,6 +122,10 @@ func (c *Client) handleList() error {
 		}
 	case *SelectCommand:
 		cmd.data.List = data
+	default:
+		if handler := c.options.unilateralDataHandler().List; handler != nil {
+			handler(data)
+		}
 	}
 
 	return nil
@@ -127,8 +136,22 @@ type ListCommand struct {
 	cmd
 	mailboxes chan *imap.ListData
 
-	returnStatus bool
-	pendingData  *imap.ListData
+	returnStatus   bool
+	returnMyRights bool
+	pendingData    *imap.ListData
+}
+
+// flushPendingData sends the pending mailbox once all of the data requested
+// with RETURN options has been received.
+func (cmd *ListCommand) flushPendingData() {
+	if cmd.returnStatus && cmd.pendingData.Status == nil {
+		return
+	}
+	if cmd.returnMyRights && cmd.pendingData.MyRights == nil {
+		return
+	}
+	cmd.mailboxes <- cmd.pendingData
+	cmd.pendingData = nil
 }
 
 // Next advances to the next mailbox.
@@ -165,6 +188,14 @@ func (cmd *ListCommand) Collect() ([]*imap.ListData, error) {
 	return l, cmd.Close()
 }
 
+// CollectContext is like Collect, but stops waiting when ctx is done.
+//
+// If ctx is done first, ctx.Err() is returned and the remaining mailboxes are
+// discarded in the background. See Command.WaitContext.
+func (cmd *ListCommand) CollectContext(ctx context.Context) ([]*imap.ListData, error) {
+	return waitContextFunc(ctx, cmd.Collect)
+}
+
 func readList(dec *imapwire.Decoder) (*imap.ListData, error) {
 	var data imap.ListData
 
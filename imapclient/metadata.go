@@ -1,39 +1,36 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
-type GetMetadataDepth int
+// GetMetadataDepth is an alias for imap.GetMetadataDepth.
+//
+// Deprecated: use imap.GetMetadataDepth instead.
+type GetMetadataDepth = imap.GetMetadataDepth
 
+// Deprecated: use the constants in the imap package instead.
 const (
-	GetMetadataDepthZero     GetMetadataDepth = 0
-	GetMetadataDepthOne      GetMetadataDepth = 1
-	GetMetadataDepthInfinity GetMetadataDepth = -1
+	GetMetadataDepthZero     = imap.GetMetadataDepthZero
+	GetMetadataDepthOne      = imap.GetMetadataDepthOne
+	GetMetadataDepthInfinity = imap.GetMetadataDepthInfinity
 )
 
-func (depth GetMetadataDepth) String() string {
-	switch depth {
-	case GetMetadataDepthZero:
-		return "0"
-	case GetMetadataDepthOne:
-		return "1"
-	case GetMetadataDepthInfinity:
-		return "infinity"
-	default:
-		panic(fmt.Errorf("imapclient: unknown GETMETADATA depth %d", depth))
-	}
-}
+// GetMetadataOptions is an alias for imap.GetMetadataOptions.
+//
+// Deprecated: use imap.GetMetadataOptions instead.
+type GetMetadataOptions = imap.GetMetadataOptions
 
-// GetMetadataOptions contains options for the GETMETADATA command.
-type GetMetadataOptions struct {
-	MaxSize *uint32
-	Depth   GetMetadataDepth
-}
+// GetMetadataData is an alias for imap.GetMetadataData.
+//
+// Deprecated: use imap.GetMetadataData instead.
+type GetMetadataData = imap.GetMetadataData
 
-func (options *GetMetadataOptions) names() []string {
+func getMetadataOptionNames(options *imap.GetMetadataOptions) []string {
 	if options == nil {
 		return nil
 	}
@@ -41,7 +38,7 @@ func (options *GetMetadataOptions) names() []string {
 	if options.MaxSize != nil {
 		l = append(l, "MAXSIZE")
 	}
-	if options.Depth != GetMetadataDepthZero {
+	if options.Depth != imap.GetMetadataDepthZero {
 		l = append(l, "DEPTH")
 	}
 	return l
@@ -50,11 +47,11 @@ func (options *GetMetadataOptions) names() []string {
 // GetMetadata sends a GETMETADATA command.
 //
 // This command requires support for the METADATA or METADATA-SERVER extension.
-func (c *Client) GetMetadata(mailbox string, entries []string, options *GetMetadataOptions) *GetMetadataCommand {
+func (c *Client) GetMetadata(mailbox string, entries []string, options *imap.GetMetadataOptions) *GetMetadataCommand {
 	cmd := &GetMetadataCommand{mailbox: mailbox}
 	enc := c.beginCommand("GETMETADATA", cmd)
 	enc.SP().Mailbox(mailbox)
-	if opts := options.names(); len(opts) > 0 {
+	if opts := getMetadataOptionNames(options); len(opts) > 0 {
 		enc.SP().List(len(opts), func(i int) {
 			opt := opts[i]
 			enc.Atom(opt).SP()
@@ -124,22 +121,24 @@ func (c *Client) handleMetadata() error {
 type GetMetadataCommand struct {
 	cmd
 	mailbox string
-	data    GetMetadataData
+	data    imap.GetMetadataData
 }
 
-func (cmd *GetMetadataCommand) Wait() (*GetMetadataData, error) {
+func (cmd *GetMetadataCommand) Wait() (*imap.GetMetadataData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
-// GetMetadataData is the data returned by the GETMETADATA command.
-type GetMetadataData struct {
-	Mailbox     string
-	EntryList   []string
-	EntryValues map[string]*[]byte
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *GetMetadataCommand) WaitContext(ctx context.Context) (*imap.GetMetadataData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
 }
 
-func readMetadataResp(dec *imapwire.Decoder) (*GetMetadataData, error) {
-	var data GetMetadataData
+func readMetadataResp(dec *imapwire.Decoder) (*imap.GetMetadataData, error) {
+	var data imap.GetMetadataData
 
 	if !dec.ExpectMailbox(&data.Mailbox) || !dec.ExpectSP() {
 		return nil, dec.Err()
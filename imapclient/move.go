@@ -1,6 +1,8 @@
 package imapclient
 
 import (
+	"context"
+
 	"github.com/emersion/go-imap/v2"
 )
 
@@ -75,6 +77,12 @@ func (cmd *MoveCommand) Wait() (*MoveData, error) {
 	return &cmd.data, nil
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *MoveCommand) WaitContext(ctx context.Context) (*MoveData, error) {
+	return waitContextFunc(ctx, cmd.Wait)
+}
+
 // MoveData contains the data returned by a MOVE command.
 type MoveData struct {
 	// requires UIDPLUS or IMAP4rev2
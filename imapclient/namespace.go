@@ -1,10 +1,11 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 // Namespace sends a NAMESPACE command.
@@ -37,6 +38,15 @@ func (cmd *NamespaceCommand) Wait() (*imap.NamespaceData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *NamespaceCommand) WaitContext(ctx context.Context) (*imap.NamespaceData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 func readNamespaceResponse(dec *imapwire.Decoder) (*imap.NamespaceData, error) {
 	var (
 		data imap.NamespaceData
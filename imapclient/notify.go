@@ -0,0 +1,48 @@
+package imapclient
+
+import (
+	"github.com/emersion/go-imap/v2"
+)
+
+// Notify sends a NOTIFY command.
+//
+// If options is nil, NOTIFY NONE is sent to disable notifications.
+//
+// Notifications for mailboxes other than the selected one are delivered as
+// STATUS and LIST responses via UnilateralDataHandler.
+//
+// This command requires support for the NOTIFY extension.
+func (c *Client) Notify(options *imap.NotifyOptions) *Command {
+	cmd := &Command{}
+	enc := c.beginCommand("NOTIFY", cmd)
+	if options == nil {
+		enc.SP().Atom("NONE")
+		enc.end()
+		return cmd
+	}
+
+	enc.SP().Atom("SET")
+	if options.Status {
+		enc.SP().Atom("STATUS")
+	}
+	for _, item := range options.Items {
+		enc.SP().Special('(').Atom(string(item.MailboxSpec))
+		switch item.MailboxSpec {
+		case imap.NotifyMailboxSpecSubtree, imap.NotifyMailboxSpecMailboxes:
+			enc.SP().List(len(item.Mailboxes), func(i int) {
+				enc.Mailbox(item.Mailboxes[i])
+			})
+		}
+		enc.SP()
+		if len(item.Events) == 0 {
+			enc.Atom("NONE")
+		} else {
+			enc.List(len(item.Events), func(i int) {
+				enc.Atom(string(item.Events[i]))
+			})
+		}
+		enc.Special(')')
+	}
+	enc.end()
+	return cmd
+}
@@ -0,0 +1,383 @@
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+const (
+	defaultPoolMaxIdlePerAccount   = 2
+	defaultPoolHealthCheckInterval = time.Minute
+)
+
+// PoolOptions contains options for Pool.
+type PoolOptions struct {
+	// Dial opens a new connection to the server. This field is required.
+	Dial func(ctx context.Context) (*Client, error)
+	// Auth authenticates a new connection for the specified account. This
+	// field is required.
+	Auth func(c *Client, account string) error
+
+	// Maximum number of connections to the server, across all accounts.
+	// Zero means no limit.
+	MaxConns int
+	// Maximum number of idle connections kept per account. Defaults to 2.
+	MaxIdlePerAccount int
+	// Idle connections are checked with a NOOP command at this interval.
+	// Connections failing the check are closed. Defaults to 1 minute.
+	HealthCheckInterval time.Duration
+}
+
+// Pool is a pool of IMAP connections to a single server.
+//
+// Connections are authenticated for an account and are re-used for later
+// requests on the same account. When possible, the pool hands out a
+// connection which already has the requested mailbox selected.
+//
+// A Pool can be safely used from multiple goroutines.
+type Pool struct {
+	options PoolOptions
+	stop    chan struct{}
+	done    chan struct{}
+
+	mutex    sync.Mutex
+	idle     []*poolConn
+	numConns int
+	wake     chan struct{} // closed when a connection is released
+	closed   bool
+}
+
+type poolConn struct {
+	client    *Client
+	account   string
+	mailbox   string
+	readOnly  bool
+	idleSince time.Time
+}
+
+// NewPool creates a new connection pool.
+//
+// This function doesn't perform I/O.
+func NewPool(options *PoolOptions) *Pool {
+	if options.Dial == nil || options.Auth == nil {
+		panic("imapclient: PoolOptions.Dial and PoolOptions.Auth are required")
+	}
+
+	p := &Pool{
+		options: *options,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		wake:    make(chan struct{}),
+	}
+	if p.options.MaxIdlePerAccount <= 0 {
+		p.options.MaxIdlePerAccount = defaultPoolMaxIdlePerAccount
+	}
+	if p.options.HealthCheckInterval <= 0 {
+		p.options.HealthCheckInterval = defaultPoolHealthCheckInterval
+	}
+	go p.healthCheck()
+	return p
+}
+
+// Get obtains a connection authenticated for account.
+//
+// If mailbox is non-empty, the returned connection has the mailbox selected.
+// If readOnly is true, the mailbox is opened with EXAMINE instead of SELECT.
+//
+// If the maximum number of connections is reached, Get evicts an idle
+// connection belonging to another account, or waits for a connection to be
+// released.
+//
+// The caller must call PoolClient.Release when done with the connection.
+func (p *Pool) Get(ctx context.Context, account, mailbox string, readOnly bool) (*PoolClient, error) {
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, net.ErrClosed
+		}
+
+		pc := p.takeIdleLocked(account, mailbox, readOnly)
+		var evicted *poolConn
+		dial := false
+		if pc == nil {
+			if p.options.MaxConns <= 0 || p.numConns < p.options.MaxConns {
+				p.numConns++
+				dial = true
+			} else if evicted = p.takeEvictableLocked(account); evicted != nil {
+				// Re-use the evicted connection's slot
+				dial = true
+			}
+		}
+		wake := p.wake
+		p.mutex.Unlock()
+
+		if evicted != nil {
+			evicted.client.Close()
+		}
+
+		if dial {
+			c, err := p.dial(ctx, account)
+			if err != nil {
+				p.removeConn()
+				return nil, err
+			}
+			pc = &poolConn{client: c, account: account}
+		}
+
+		if pc != nil {
+			if err := p.prepare(ctx, pc, mailbox, readOnly); err != nil {
+				if isClientClosed(pc.client) {
+					p.removeConn()
+					if !dial {
+						// Stale idle connection, try another one
+						continue
+					}
+				} else {
+					p.put(pc)
+				}
+				return nil, err
+			}
+			return &PoolClient{Client: pc.client, pool: p, conn: pc}, nil
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes all idle connections. Connections in use are closed when
+// released.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return net.ErrClosed
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.numConns -= len(idle)
+	p.wakeLocked()
+	p.mutex.Unlock()
+
+	close(p.stop)
+	<-p.done
+
+	for _, pc := range idle {
+		pc.client.Close()
+	}
+	return nil
+}
+
+// takeIdleLocked removes and returns an idle connection for account. A
+// connection which already has the mailbox selected in the right mode is
+// preferred.
+func (p *Pool) takeIdleLocked(account, mailbox string, readOnly bool) *poolConn {
+	p.removeClosedLocked()
+
+	best := -1
+	for i, pc := range p.idle {
+		if pc.account != account {
+			continue
+		}
+		if mailbox != "" && pc.mailbox == mailbox && pc.readOnly == readOnly {
+			best = i
+			break
+		}
+		if best < 0 {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return p.removeIdleLocked(best)
+}
+
+// takeEvictableLocked removes and returns the least recently used idle
+// connection not belonging to account.
+func (p *Pool) takeEvictableLocked(account string) *poolConn {
+	oldest := -1
+	for i, pc := range p.idle {
+		if pc.account != account && (oldest < 0 || pc.idleSince.Before(p.idle[oldest].idleSince)) {
+			oldest = i
+		}
+	}
+	if oldest < 0 {
+		return nil
+	}
+	return p.removeIdleLocked(oldest)
+}
+
+// removeClosedLocked drops idle connections closed by the server.
+func (p *Pool) removeClosedLocked() {
+	idle := p.idle[:0]
+	for _, pc := range p.idle {
+		if isClientClosed(pc.client) {
+			p.numConns--
+		} else {
+			idle = append(idle, pc)
+		}
+	}
+	p.idle = idle
+}
+
+func (p *Pool) removeIdleLocked(i int) *poolConn {
+	pc := p.idle[i]
+	p.idle = append(p.idle[:i], p.idle[i+1:]...)
+	return pc
+}
+
+func (p *Pool) wakeLocked() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// removeConn releases the slot of a connection which has been closed.
+func (p *Pool) removeConn() {
+	p.mutex.Lock()
+	p.numConns--
+	p.wakeLocked()
+	p.mutex.Unlock()
+}
+
+// put returns a connection to the idle list.
+func (p *Pool) put(pc *poolConn) {
+	p.mutex.Lock()
+	if p.closed || isClientClosed(pc.client) || p.numIdleLocked(pc.account) >= p.options.MaxIdlePerAccount {
+		p.numConns--
+		p.wakeLocked()
+		p.mutex.Unlock()
+		pc.client.Close()
+		return
+	}
+	pc.idleSince = time.Now()
+	p.idle = append(p.idle, pc)
+	p.wakeLocked()
+	p.mutex.Unlock()
+}
+
+func (p *Pool) numIdleLocked(account string) int {
+	n := 0
+	for _, pc := range p.idle {
+		if pc.account == account {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Pool) dial(ctx context.Context, account string) (*Client, error) {
+	c, err := p.options.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.WaitGreeting(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := p.options.Auth(c, account); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("imapclient: failed to authenticate: %w", err)
+	}
+	return c, nil
+}
+
+// prepare selects the requested mailbox on a connection, if necessary.
+func (p *Pool) prepare(ctx context.Context, pc *poolConn, mailbox string, readOnly bool) error {
+	if mailbox == "" || (pc.mailbox == mailbox && pc.readOnly == readOnly) {
+		return nil
+	}
+
+	options := &imap.SelectOptions{ReadOnly: readOnly}
+	if _, err := pc.client.Select(mailbox, options).WaitContext(ctx); err != nil {
+		pc.mailbox = ""
+		return err
+	}
+	pc.mailbox = mailbox
+	pc.readOnly = readOnly
+	return nil
+}
+
+func (p *Pool) healthCheck() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.options.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+
+		// Take the connections to check out of the idle list, so that they
+		// aren't handed out concurrently
+		deadline := time.Now().Add(-p.options.HealthCheckInterval)
+		var check []*poolConn
+		p.mutex.Lock()
+		for i := 0; i < len(p.idle); {
+			if p.idle[i].idleSince.After(deadline) {
+				i++
+				continue
+			}
+			check = append(check, p.removeIdleLocked(i))
+		}
+		p.mutex.Unlock()
+
+		for _, pc := range check {
+			ctx, cancel := context.WithTimeout(context.Background(), respReadTimeout)
+			err := pc.client.Noop().WaitContext(ctx)
+			cancel()
+			if err != nil {
+				pc.client.Close()
+			}
+			p.put(pc)
+		}
+	}
+}
+
+// PoolClient is a connection obtained from a Pool.
+type PoolClient struct {
+	*Client
+
+	pool *Pool
+	conn *poolConn
+}
+
+// Release returns the connection to the pool. The connection must not be used
+// afterwards.
+//
+// If the connection has been closed, it's discarded.
+func (pc *PoolClient) Release() {
+	if pc.pool == nil {
+		panic("imapclient: PoolClient already released")
+	}
+
+	// The caller may have selected another mailbox
+	if mbox := pc.Client.Mailbox(); mbox == nil || mbox.Name != pc.conn.mailbox {
+		pc.conn.mailbox = ""
+	}
+
+	pc.pool.put(pc.conn)
+	pc.pool = nil
+}
+
+func isClientClosed(c *Client) bool {
+	select {
+	case <-c.Closed():
+		return true
+	default:
+		return false
+	}
+}
@@ -1,10 +1,11 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 // GetQuota sends a GETQUOTA command.
@@ -100,57 +101,73 @@ func (c *Client) handleQuotaRoot() error {
 	return nil
 }
 
+// QuotaData is an alias for imap.QuotaData.
+//
+// Deprecated: use imap.QuotaData instead.
+type QuotaData = imap.QuotaData
+
+// QuotaResourceData is an alias for imap.QuotaResourceData.
+//
+// Deprecated: use imap.QuotaResourceData instead.
+type QuotaResourceData = imap.QuotaResourceData
+
 // GetQuotaCommand is a GETQUOTA command.
 type GetQuotaCommand struct {
 	cmd
 	root string
-	data *QuotaData
+	data *imap.QuotaData
 }
 
-func (cmd *GetQuotaCommand) Wait() (*QuotaData, error) {
+func (cmd *GetQuotaCommand) Wait() (*imap.QuotaData, error) {
 	if err := cmd.cmd.Wait(); err != nil {
 		return nil, err
 	}
 	return cmd.data, nil
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *GetQuotaCommand) WaitContext(ctx context.Context) (*imap.QuotaData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 // GetQuotaRootCommand is a GETQUOTAROOT command.
 type GetQuotaRootCommand struct {
 	cmd
 	mailbox string
 	roots   []string
-	data    []QuotaData
+	data    []imap.QuotaData
 }
 
-func (cmd *GetQuotaRootCommand) Wait() ([]QuotaData, error) {
+func (cmd *GetQuotaRootCommand) Wait() ([]imap.QuotaData, error) {
 	if err := cmd.cmd.Wait(); err != nil {
 		return nil, err
 	}
 	return cmd.data, nil
 }
 
-// QuotaData is the data returned by a QUOTA response.
-type QuotaData struct {
-	Root      string
-	Resources map[imap.QuotaResourceType]QuotaResourceData
-}
-
-// QuotaResourceData contains the usage and limit for a quota resource.
-type QuotaResourceData struct {
-	Usage int64
-	Limit int64
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *GetQuotaRootCommand) WaitContext(ctx context.Context) ([]imap.QuotaData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
 }
 
-func readQuotaResponse(dec *imapwire.Decoder) (*QuotaData, error) {
-	var data QuotaData
+func readQuotaResponse(dec *imapwire.Decoder) (*imap.QuotaData, error) {
+	var data imap.QuotaData
 	if !dec.ExpectAString(&data.Root) || !dec.ExpectSP() {
 		return nil, dec.Err()
 	}
-	data.Resources = make(map[imap.QuotaResourceType]QuotaResourceData)
+	data.Resources = make(map[imap.QuotaResourceType]imap.QuotaResourceData)
 	err := dec.ExpectList(func() error {
 		var (
 			name    string
-			resData QuotaResourceData
+			resData imap.QuotaResourceData
 		)
 		if !dec.ExpectAtom(&name) || !dec.ExpectSP() || !dec.ExpectNumber64(&resData.Usage) || !dec.ExpectSP() || !dec.ExpectNumber64(&resData.Limit) {
 			return fmt.Errorf("in quota-resource: %v", dec.Err())
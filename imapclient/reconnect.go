@@ -0,0 +1,386 @@
+package imapclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+const (
+	defaultMinReconnectBackoff = time.Second
+	defaultMaxReconnectBackoff = time.Minute
+)
+
+// ReconnectState is the connection state of a ReconnectingClient.
+type ReconnectState int
+
+const (
+	// ReconnectStateConnecting indicates that a connection is being
+	// established.
+	ReconnectStateConnecting ReconnectState = iota
+	// ReconnectStateConnected indicates that a connection has been
+	// established and the session has been restored.
+	ReconnectStateConnected
+	// ReconnectStateDisconnected indicates that the connection has been lost
+	// or couldn't be established. A new attempt will be made after a delay.
+	ReconnectStateDisconnected
+	// ReconnectStateClosed indicates that the ReconnectingClient has been
+	// closed.
+	ReconnectStateClosed
+)
+
+// String implements fmt.Stringer.
+func (state ReconnectState) String() string {
+	switch state {
+	case ReconnectStateConnecting:
+		return "connecting"
+	case ReconnectStateConnected:
+		return "connected"
+	case ReconnectStateDisconnected:
+		return "disconnected"
+	case ReconnectStateClosed:
+		return "closed"
+	default:
+		panic(fmt.Errorf("imapclient: unknown reconnect state %v", int(state)))
+	}
+}
+
+// UIDValidityError indicates that the UIDVALIDITY of the selected mailbox
+// changed across a reconnection. UIDs cached by the caller are no longer
+// valid.
+type UIDValidityError struct {
+	Mailbox          string
+	Previous, Actual uint32
+}
+
+// Error implements the error interface.
+func (err *UIDValidityError) Error() string {
+	return fmt.Sprintf("imapclient: UIDVALIDITY of mailbox %q changed from %v to %v", err.Mailbox, err.Previous, err.Actual)
+}
+
+// ReconnectOptions contains options for ReconnectingClient.
+type ReconnectOptions struct {
+	// Dial opens a new connection to the server. This field is required.
+	//
+	// The context is cancelled when the ReconnectingClient is closed.
+	Dial func(ctx context.Context) (*Client, error)
+	// Auth authenticates a new connection, e.g. by calling Client.Login or
+	// Client.Authenticate. It's only called if the connection isn't already
+	// authenticated after the greeting.
+	Auth func(c *Client) error
+
+	// Minimum and maximum delay between two connection attempts. The delay
+	// is doubled after each failed attempt. Defaults to 1 second and 1
+	// minute.
+	MinBackoff, MaxBackoff time.Duration
+
+	// StateChange is called when the connection state changes. err is the
+	// cause of the transition, if any.
+	//
+	// When the selected mailbox's UIDVALIDITY changes across a
+	// reconnection, StateChange is called with ReconnectStateConnected and
+	// a *UIDValidityError.
+	//
+	// StateChange is called from an internal goroutine and must not block.
+	StateChange func(state ReconnectState, err error)
+}
+
+// ReconnectingClient is an IMAP client which transparently reconnects when
+// the connection is lost.
+//
+// After reconnecting, the client authenticates, enables the capabilities
+// previously enabled via ReconnectingClient.Enable and selects the mailbox
+// previously selected via ReconnectingClient.Select.
+//
+// Commands are sent via the Client returned by ReconnectingClient.Client.
+// Commands in flight when the connection is lost fail: they are not retried,
+// because IMAP commands aren't idempotent in general.
+type ReconnectingClient struct {
+	options ReconnectOptions
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mutex         sync.Mutex
+	client        *Client
+	ready         chan struct{} // closed when client is set
+	enabled       imap.CapSet
+	mailbox       string
+	selectOptions *imap.SelectOptions
+	uidValidity   uint32
+}
+
+// NewReconnectingClient creates a new reconnecting IMAP client.
+//
+// The first connection attempt is started in the background.
+func NewReconnectingClient(options *ReconnectOptions) *ReconnectingClient {
+	if options.Dial == nil {
+		panic("imapclient: ReconnectOptions.Dial is nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := &ReconnectingClient{
+		options: *options,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+		enabled: make(imap.CapSet),
+	}
+	if rc.options.MinBackoff <= 0 {
+		rc.options.MinBackoff = defaultMinReconnectBackoff
+	}
+	if rc.options.MaxBackoff <= 0 {
+		rc.options.MaxBackoff = defaultMaxReconnectBackoff
+	}
+	go rc.run()
+	return rc
+}
+
+// Client returns the current connection, waiting for it to be established if
+// necessary.
+//
+// The connection may be lost at any time, in which case commands sent via the
+// returned Client fail. Callers should call Client again to obtain the new
+// connection.
+func (rc *ReconnectingClient) Client(ctx context.Context) (*Client, error) {
+	for {
+		rc.mutex.Lock()
+		c, ready := rc.client, rc.ready
+		rc.mutex.Unlock()
+
+		if rc.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		} else if c != nil {
+			return c, nil
+		}
+
+		select {
+		case <-ready:
+		case <-rc.ctx.Done():
+			return nil, net.ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Enable sends an ENABLE command and remembers the enabled capabilities, so
+// that they are enabled again after reconnecting.
+func (rc *ReconnectingClient) Enable(ctx context.Context, caps ...imap.Cap) (*EnableData, error) {
+	c, err := rc.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Enable(caps...).WaitContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mutex.Lock()
+	for name := range data.Caps {
+		rc.enabled[name] = struct{}{}
+	}
+	rc.mutex.Unlock()
+
+	return data, nil
+}
+
+// Select sends a SELECT or EXAMINE command and remembers the mailbox, so that
+// it's selected again after reconnecting.
+func (rc *ReconnectingClient) Select(ctx context.Context, mailbox string, options *imap.SelectOptions) (*imap.SelectData, error) {
+	c, err := rc.Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Select(mailbox, options).WaitContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mutex.Lock()
+	rc.mailbox = mailbox
+	rc.selectOptions = options
+	rc.uidValidity = data.UIDValidity
+	rc.mutex.Unlock()
+
+	return data, nil
+}
+
+// Unselect sends an UNSELECT command and forgets the selected mailbox.
+func (rc *ReconnectingClient) Unselect(ctx context.Context) error {
+	c, err := rc.Client(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.Unselect().WaitContext(ctx); err != nil {
+		return err
+	}
+
+	rc.mutex.Lock()
+	rc.mailbox = ""
+	rc.selectOptions = nil
+	rc.uidValidity = 0
+	rc.mutex.Unlock()
+
+	return nil
+}
+
+// Close closes the current connection and stops reconnecting.
+func (rc *ReconnectingClient) Close() error {
+	rc.cancel()
+	<-rc.done
+	return nil
+}
+
+func (rc *ReconnectingClient) setState(state ReconnectState, err error) {
+	if rc.options.StateChange != nil {
+		rc.options.StateChange(state, err)
+	}
+}
+
+func (rc *ReconnectingClient) run() {
+	defer close(rc.done)
+	defer rc.setState(ReconnectStateClosed, nil)
+
+	backoff := rc.options.MinBackoff
+	for {
+		rc.setState(ReconnectStateConnecting, nil)
+		c, uidValidityErr, err := rc.connect()
+		if rc.ctx.Err() != nil {
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		if err != nil {
+			rc.setState(ReconnectStateDisconnected, err)
+
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-rc.ctx.Done():
+				t.Stop()
+				return
+			}
+
+			backoff *= 2
+			if backoff > rc.options.MaxBackoff {
+				backoff = rc.options.MaxBackoff
+			}
+			continue
+		}
+		backoff = rc.options.MinBackoff
+
+		rc.mutex.Lock()
+		rc.client = c
+		close(rc.ready)
+		rc.mutex.Unlock()
+
+		if uidValidityErr != nil {
+			rc.setState(ReconnectStateConnected, uidValidityErr)
+		} else {
+			rc.setState(ReconnectStateConnected, nil)
+		}
+
+		select {
+		case <-c.Closed():
+		case <-rc.ctx.Done():
+			c.Close()
+			return
+		}
+
+		rc.mutex.Lock()
+		rc.client = nil
+		rc.ready = make(chan struct{})
+		rc.mutex.Unlock()
+
+		err = c.decErr
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		rc.setState(ReconnectStateDisconnected, err)
+	}
+}
+
+// connect dials a new connection and restores the session state.
+func (rc *ReconnectingClient) connect() (*Client, *UIDValidityError, error) {
+	c, err := rc.options.Dial(rc.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// WaitGreeting and Auth don't take a context: close the connection to
+	// unblock them when the reconnecting client is closed
+	restored := make(chan struct{})
+	defer close(restored)
+	go func() {
+		select {
+		case <-rc.ctx.Done():
+			c.Close()
+		case <-restored:
+		}
+	}()
+
+	uidValidityErr, err := rc.restore(c)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return c, uidValidityErr, nil
+}
+
+func (rc *ReconnectingClient) restore(c *Client) (*UIDValidityError, error) {
+	if err := c.WaitGreeting(); err != nil {
+		return nil, err
+	}
+
+	if c.State() == imap.ConnStateNotAuthenticated && rc.options.Auth != nil {
+		if err := rc.options.Auth(c); err != nil {
+			return nil, fmt.Errorf("imapclient: failed to authenticate: %w", err)
+		}
+	}
+
+	rc.mutex.Lock()
+	var enabled []imap.Cap
+	for name := range rc.enabled {
+		enabled = append(enabled, name)
+	}
+	mailbox, selectOptions, uidValidity := rc.mailbox, rc.selectOptions, rc.uidValidity
+	rc.mutex.Unlock()
+
+	if len(enabled) > 0 {
+		if _, err := c.Enable(enabled...).WaitContext(rc.ctx); err != nil {
+			return nil, fmt.Errorf("imapclient: failed to restore enabled capabilities: %w", err)
+		}
+	}
+
+	if mailbox == "" {
+		return nil, nil
+	}
+
+	data, err := c.Select(mailbox, selectOptions).WaitContext(rc.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("imapclient: failed to restore selected mailbox: %w", err)
+	}
+
+	rc.mutex.Lock()
+	if rc.mailbox == mailbox {
+		rc.uidValidity = data.UIDValidity
+	}
+	rc.mutex.Unlock()
+
+	if uidValidity != 0 && data.UIDValidity != uidValidity {
+		return &UIDValidityError{
+			Mailbox:  mailbox,
+			Previous: uidValidity,
+			Actual:   data.UIDValidity,
+		}, nil
+	}
+	return nil, nil
+}
@@ -1,14 +1,15 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 func returnSearchOptions(options *imap.SearchOptions) []string {
@@ -141,6 +142,15 @@ func (cmd *SearchCommand) Wait() (*imap.SearchData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *SearchCommand) WaitContext(ctx context.Context) (*imap.SearchData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 func writeSearchKey(enc *imapwire.Encoder, criteria *imap.SearchCriteria) {
 	enc.Special('(')
 
@@ -233,6 +243,10 @@ func writeSearchKey(enc *imapwire.Encoder, criteria *imap.SearchCriteria) {
 		}
 	}
 
+	for _, s := range criteria.GmailRaw {
+		encodeItem().Atom("X-GM-RAW").SP().String(s)
+	}
+
 	for _, not := range criteria.Not {
 		encodeItem().Atom("NOT").SP()
 		writeSearchKey(enc, &not)
@@ -347,6 +361,11 @@ func searchCriteriaIsASCII(criteria *imap.SearchCriteria) bool {
 			return false
 		}
 	}
+	for _, s := range criteria.GmailRaw {
+		if !isASCII(s) {
+			return false
+		}
+	}
 	for _, not := range criteria.Not {
 		if !searchCriteriaIsASCII(&not) {
 			return false
@@ -1,7 +1,10 @@
 package imapclient
 
 import (
+	"context"
+
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
 )
 
@@ -17,13 +20,35 @@ func (c *Client) Select(mailbox string, options *imap.SelectOptions) *SelectComm
 	cmd := &SelectCommand{mailbox: mailbox}
 	enc := c.beginCommand(cmdName, cmd)
 	enc.SP().Mailbox(mailbox)
-	if options != nil && options.CondStore {
-		enc.SP().Special('(').Atom("CONDSTORE").Special(')')
+	if options != nil && (options.CondStore || options.QResync != nil) {
+		enc.SP().Special('(')
+		if options.CondStore {
+			enc.Atom("CONDSTORE")
+		}
+		if qresync := options.QResync; qresync != nil {
+			if options.CondStore {
+				enc.SP()
+			}
+			writeSelectQResync(enc.Encoder, qresync)
+		}
+		enc.Special(')')
 	}
 	enc.end()
 	return cmd
 }
 
+func writeSelectQResync(enc *imapwire.Encoder, qresync *imap.SelectQResync) {
+	enc.Atom("QRESYNC").SP().Special('(')
+	enc.Number(qresync.UIDValidity).SP().ModSeq(qresync.ModSeq)
+	if len(qresync.KnownUIDs) > 0 {
+		enc.SP().NumSet(qresync.KnownUIDs)
+	}
+	if seqMatch := qresync.SeqMatch; seqMatch != nil {
+		enc.SP().Special('(').NumSet(seqMatch.SeqNums).SP().NumSet(seqMatch.UIDs).Special(')')
+	}
+	enc.Special(')')
+}
+
 // Unselect sends an UNSELECT command.
 //
 // This command requires support for IMAP4rev2 or the UNSELECT extension.
@@ -95,6 +120,15 @@ func (cmd *SelectCommand) Wait() (*imap.SelectData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *SelectCommand) WaitContext(ctx context.Context) (*imap.SelectData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 type unselectCommand struct {
 	cmd
 }
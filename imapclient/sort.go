@@ -1,30 +1,36 @@
 package imapclient
 
 import (
+	"context"
+
 	"github.com/emersion/go-imap/v2"
 )
 
-type SortKey string
+// SortKey is an alias for imap.SortKey.
+//
+// Deprecated: use imap.SortKey instead.
+type SortKey = imap.SortKey
 
+// Deprecated: use the constants in the imap package instead.
 const (
-	SortKeyArrival SortKey = "ARRIVAL"
-	SortKeyCc      SortKey = "CC"
-	SortKeyDate    SortKey = "DATE"
-	SortKeyFrom    SortKey = "FROM"
-	SortKeySize    SortKey = "SIZE"
-	SortKeySubject SortKey = "SUBJECT"
-	SortKeyTo      SortKey = "TO"
+	SortKeyArrival = imap.SortKeyArrival
+	SortKeyCc      = imap.SortKeyCc
+	SortKeyDate    = imap.SortKeyDate
+	SortKeyFrom    = imap.SortKeyFrom
+	SortKeySize    = imap.SortKeySize
+	SortKeySubject = imap.SortKeySubject
+	SortKeyTo      = imap.SortKeyTo
 )
 
-type SortCriterion struct {
-	Key     SortKey
-	Reverse bool
-}
+// SortCriterion is an alias for imap.SortCriterion.
+//
+// Deprecated: use imap.SortCriterion instead.
+type SortCriterion = imap.SortCriterion
 
 // SortOptions contains options for the SORT command.
 type SortOptions struct {
 	SearchCriteria *imap.SearchCriteria
-	SortCriteria   []SortCriterion
+	SortCriteria   []imap.SortCriterion
 }
 
 func (c *Client) sort(uid bool, options *SortOptions) *SortCommand {
@@ -81,3 +87,12 @@ func (cmd *SortCommand) Wait() ([]uint32, error) {
 	err := cmd.cmd.Wait()
 	return cmd.nums, err
 }
+
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *SortCommand) WaitContext(ctx context.Context) ([]uint32, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
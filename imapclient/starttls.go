@@ -50,6 +50,7 @@ func (c *Client) upgradeStartTLS(tlsConfig *tls.Config) {
 	}
 
 	tlsConn := tls.Client(cleartextConn, tlsConfig)
+	c.transport = tlsConn
 	rw := c.options.wrapReadWriter(tlsConn)
 
 	c.br.Reset(rw)
@@ -1,11 +1,12 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func statusItems(options *imap.StatusOptions) []string {
@@ -64,8 +65,11 @@ func (c *Client) handleStatus() error {
 		cmd.data = *data
 	case *ListCommand:
 		cmd.pendingData.Status = data
-		cmd.mailboxes <- cmd.pendingData
-		cmd.pendingData = nil
+		cmd.flushPendingData()
+	default:
+		if handler := c.options.unilateralDataHandler().Status; handler != nil {
+			handler(data)
+		}
 	}
 
 	return nil
@@ -82,6 +86,15 @@ func (cmd *StatusCommand) Wait() (*imap.StatusData, error) {
 	return &cmd.data, cmd.cmd.Wait()
 }
 
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *StatusCommand) WaitContext(ctx context.Context) (*imap.StatusData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
+}
+
 func readStatus(dec *imapwire.Decoder) (*imap.StatusData, error) {
 	var data imap.StatusData
 
@@ -4,16 +4,30 @@ import (
 	"fmt"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Client) store(uid bool, seqSet imap.NumSet, store *imap.StoreFlags, options *imap.StoreOptions) *FetchCommand {
-	cmd := &FetchCommand{msgs: make(chan *FetchMessageData, 128)}
+	cmd := &FetchCommand{
+		uid:    uid,
+		seqSet: seqSet,
+		msgs:   make(chan *FetchMessageData, 128),
+	}
 	enc := c.beginCommand(uidCmdName("STORE", uid), cmd)
 	enc.SP().NumSet(seqSet).SP()
 	if options != nil && options.UnchangedSince != 0 {
 		enc.Special('(').Atom("UNCHANGEDSINCE").SP().ModSeq(options.UnchangedSince).Special(')').SP()
 	}
-	switch store.Op {
+	writeStoreOp(enc.Encoder, store.Op, "FLAGS", store.Silent)
+	enc.SP().List(len(store.Flags), func(i int) {
+		enc.Flag(store.Flags[i])
+	})
+	enc.end()
+	return cmd
+}
+
+func writeStoreOp(enc *imapwire.Encoder, op imap.StoreFlagsOp, name string, silent bool) {
+	switch op {
 	case imap.StoreFlagsSet:
 		// nothing to do
 	case imap.StoreFlagsAdd:
@@ -21,17 +35,12 @@ func (c *Client) store(uid bool, seqSet imap.NumSet, store *imap.StoreFlags, opt
 	case imap.StoreFlagsDel:
 		enc.Special('-')
 	default:
-		panic(fmt.Errorf("imapclient: unknown store flags op: %v", store.Op))
+		panic(fmt.Errorf("imapclient: unknown store flags op: %v", op))
 	}
-	enc.Atom("FLAGS")
-	if store.Silent {
+	enc.Atom(name)
+	if silent {
 		enc.Atom(".SILENT")
 	}
-	enc.SP().List(len(store.Flags), func(i int) {
-		enc.Flag(store.Flags[i])
-	})
-	enc.end()
-	return cmd
 }
 
 // Store sends a STORE command.
@@ -49,3 +58,36 @@ func (c *Client) Store(seqSet imap.NumSet, store *imap.StoreFlags, options *imap
 func (c *Client) UIDStore(seqSet imap.NumSet, store *imap.StoreFlags, options *imap.StoreOptions) *FetchCommand {
 	return c.store(true, seqSet, store, options)
 }
+
+func (c *Client) storeGmailLabels(uid bool, seqSet imap.NumSet, store *imap.StoreGmailLabels) *FetchCommand {
+	cmd := &FetchCommand{
+		uid:    uid,
+		seqSet: seqSet,
+		msgs:   make(chan *FetchMessageData, 128),
+	}
+	enc := c.beginCommand(uidCmdName("STORE", uid), cmd)
+	enc.SP().NumSet(seqSet).SP()
+	writeStoreOp(enc.Encoder, store.Op, "X-GM-LABELS", store.Silent)
+	enc.SP().List(len(store.Labels), func(i int) {
+		enc.Mailbox(store.Labels[i])
+	})
+	enc.end()
+	return cmd
+}
+
+// StoreGmailLabels sends a STORE X-GM-LABELS command.
+//
+// Unless StoreGmailLabels.Silent is set, the server will return the updated
+// labels.
+//
+// This requires the X-GM-EXT-1 extension.
+func (c *Client) StoreGmailLabels(seqSet imap.NumSet, store *imap.StoreGmailLabels) *FetchCommand {
+	return c.storeGmailLabels(false, seqSet, store)
+}
+
+// UIDStoreGmailLabels sends a UID STORE X-GM-LABELS command.
+//
+// See StoreGmailLabels.
+func (c *Client) UIDStoreGmailLabels(seqSet imap.NumSet, store *imap.StoreGmailLabels) *FetchCommand {
+	return c.storeGmailLabels(true, seqSet, store)
+}
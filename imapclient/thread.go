@@ -1,10 +1,11 @@
 package imapclient
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 // ThreadOptions contains options for the THREAD command.
@@ -50,24 +51,33 @@ func (c *Client) handleThread() error {
 	return nil
 }
 
+// ThreadData is an alias for imap.ThreadData.
+//
+// Deprecated: use imap.ThreadData instead.
+type ThreadData = imap.ThreadData
+
 // ThreadCommand is a THREAD command.
 type ThreadCommand struct {
 	cmd
-	data []ThreadData
+	data []imap.ThreadData
 }
 
-func (cmd *ThreadCommand) Wait() ([]ThreadData, error) {
+func (cmd *ThreadCommand) Wait() ([]imap.ThreadData, error) {
 	err := cmd.cmd.Wait()
 	return cmd.data, err
 }
 
-type ThreadData struct {
-	Chain      []uint32
-	SubThreads []ThreadData
+// WaitContext is like Wait, but stops waiting when ctx is done. See
+// Command.WaitContext.
+func (cmd *ThreadCommand) WaitContext(ctx context.Context) ([]imap.ThreadData, error) {
+	if err := cmd.cmd.waitContext(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Wait()
 }
 
-func readThreadList(dec *imapwire.Decoder) (*ThreadData, error) {
-	var data ThreadData
+func readThreadList(dec *imapwire.Decoder) (*imap.ThreadData, error) {
+	var data imap.ThreadData
 	err := dec.ExpectList(func() error {
 		var num uint32
 		if len(data.SubThreads) == 0 && dec.Number(&num) {
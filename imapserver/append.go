@@ -5,8 +5,8 @@ import (
 	"io"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 // appendLimit is the maximum size of an APPEND payload.
@@ -65,7 +65,11 @@ func (c *Conn) handleAppend(tag string, dec *imapwire.Decoder) error {
 	c.setReadTimeout(literalReadTimeout)
 	defer c.setReadTimeout(cmdReadTimeout)
 
-	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+	err = c.checkState(imap.ConnStateAuthenticated)
+	if err == nil {
+		err = c.intercept(dec, mailbox, &options)
+	}
+	if err != nil {
 		io.Copy(io.Discard, lit)
 		dec.CRLF()
 		return err
@@ -7,8 +7,8 @@ import (
 	"github.com/emersion/go-sasl"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 func (c *Conn) handleAuthenticate(tag string, dec *imapwire.Decoder) error {
@@ -45,6 +45,9 @@ func (c *Conn) handleAuthenticate(tag string, dec *imapwire.Decoder) error {
 			Text: "TLS is required to authenticate",
 		}
 	}
+	if err := c.intercept(dec, mech, initialResp); err != nil {
+		return err
+	}
 
 	var saslServer sasl.Server
 	if authSess, ok := c.session.(SessionSASL); ok {
@@ -137,6 +140,9 @@ func (c *Conn) handleUnauthenticate(dec *imapwire.Decoder) error {
 	if !ok {
 		return newClientBugError("UNAUTHENTICATE is not supported")
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 	if err := session.Unauthenticate(); err != nil {
 		return err
 	}
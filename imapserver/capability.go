@@ -2,13 +2,16 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleCapability(dec *imapwire.Decoder) error {
 	if !dec.ExpectCRLF() {
 		return dec.Err()
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 
 	enc := newResponseEncoder(c)
 	defer enc.end()
@@ -43,6 +46,7 @@ func (c *Conn) availableCaps() []imap.Cap {
 			imap.CapLiteralMinus,
 		}...)
 	}
+	addAvailableCaps(&caps, available, []imap.Cap{imap.CapID})
 	if c.canStartTLS() {
 		caps = append(caps, imap.CapStartTLS)
 	}
@@ -76,10 +80,35 @@ func (c *Conn) availableCaps() []imap.Cap {
 			})
 		}
 		addAvailableCaps(&caps, available, []imap.Cap{
+			imap.CapCondStore,
+			imap.CapQResync,
+			imap.CapSort,
+			imap.CapESort,
+			imap.CapSortDisplay,
+			imap.CapMetadata,
+			imap.CapMetadataServer,
+			imap.CapACL,
+			imap.CapListMyRights,
+			imap.CapNotify,
 			imap.CapCreateSpecialUse,
+			imap.CapCompressDeflate,
 			imap.CapLiteralPlus,
 			imap.CapUnauthenticate,
 		})
+		if available.Has(imap.CapACL) {
+			// All of the RFC 4314 rights are supported by imap.RightSet
+			caps = append(caps, imap.Cap("RIGHTS=texk"))
+		}
+		for _, alg := range available.ThreadAlgorithms() {
+			caps = append(caps, imap.Cap("THREAD="+string(alg)))
+		}
+		if quotaSess, ok := c.session.(SessionQuota); ok && available.Has(imap.CapQuota) {
+			caps = append(caps, imap.CapQuota)
+			for _, typ := range quotaSess.QuotaResourceTypes() {
+				caps = append(caps, imap.Cap("QUOTA=RES-"+string(typ)))
+			}
+			addAvailableCaps(&caps, available, []imap.Cap{imap.CapQuotaSet})
+		}
 	}
 	return caps
 }
@@ -0,0 +1,72 @@
+package imapserver
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// CommandHandler handles a custom command.
+//
+// Custom commands can be used to implement extensions which aren't natively
+// supported by this package, for instance vendor-specific commands.
+type CommandHandler struct {
+	// States in which the command is valid. If empty, the command is valid in
+	// the authenticated and selected states.
+	States []imap.ConnState
+	// Handle executes the command.
+	//
+	// dec is positioned right after the command name: Handle must decode the
+	// command arguments including the final CRLF, for instance by calling
+	// dec.ExpectCRLF. Literals are accepted transparently, up to 4096 bytes.
+	// Untagged responses can be written with w.
+	//
+	// If Handle returns nil, a tagged OK status response is sent to the
+	// client. Otherwise, the error is sent to the client in a tagged status
+	// response. Errors should be of type *imap.Error: other errors are logged
+	// and sent to the client as an internal server error.
+	Handle func(conn *Conn, session Session, dec *imapwire.Decoder, w *ResponseWriter) error
+}
+
+func (h *CommandHandler) checkState(state imap.ConnState) error {
+	states := h.States
+	if len(states) == 0 {
+		states = []imap.ConnState{imap.ConnStateAuthenticated, imap.ConnStateSelected}
+	}
+	for _, s := range states {
+		if s == state {
+			return nil
+		}
+	}
+	return newClientBugError(fmt.Sprintf("This command is not valid in the %s state", state))
+}
+
+func (c *Conn) handleCustom(dec *imapwire.Decoder, h *CommandHandler) error {
+	if err := h.checkState(c.state); err != nil {
+		return err
+	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
+	w := &ResponseWriter{conn: c}
+	return h.Handle(c, c.session, dec, w)
+}
+
+// ResponseWriter writes untagged responses for a custom command.
+type ResponseWriter struct {
+	conn *Conn
+}
+
+// WriteUntagged writes an untagged response.
+//
+// f is called with an encoder to write the response contents, for instance
+// the response name and its arguments. The leading "* " and the trailing
+// CRLF are written by WriteUntagged.
+func (w *ResponseWriter) WriteUntagged(f func(enc *imapwire.Encoder)) error {
+	enc := newResponseEncoder(w.conn)
+	defer enc.end()
+	enc.Atom("*").SP()
+	f(enc.Encoder)
+	return enc.CRLF()
+}
@@ -0,0 +1,72 @@
+package imapserver
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+	"github.com/emersion/go-imap/v2/internal"
+)
+
+func (c *Conn) handleCompress(tag string, dec *imapwire.Decoder) error {
+	var mechanism string
+	if !dec.ExpectSP() || !dec.ExpectAtom(&mechanism) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(mechanism, "DEFLATE") || !c.server.options.caps().Has(imap.CapCompressDeflate) {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeBad,
+			Text: "Unsupported compression mechanism",
+		}
+	}
+	if c.compressed {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeCompressionActive,
+			Text: "Compression is already active",
+		}
+	}
+	if err := c.intercept(dec, mechanism); err != nil {
+		return err
+	}
+
+	// Do not allow to write uncompressed data past this point: keep
+	// c.encMutex locked until the end
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	err := writeStatusResp(enc.Encoder, tag, &imap.StatusResponse{
+		Type: imap.StatusResponseTypeOK,
+		Text: "DEFLATE active",
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drain buffered data from our bufio.Reader: the client may have sent
+	// compressed data right after receiving our response
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, c.br, int64(c.br.Buffered())); err != nil {
+		panic(err) // unreachable
+	}
+
+	conn := c.NetConn()
+	var r io.Reader = conn
+	if buf.Len() > 0 {
+		r = io.MultiReader(&buf, conn)
+	}
+
+	rw := c.server.options.wrapReadWriter(internal.NewDeflateReadWriter(r, conn))
+	c.br.Reset(rw)
+	c.bw.Reset(rw)
+	c.compressed = true
+
+	return nil
+}
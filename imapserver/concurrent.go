@@ -0,0 +1,207 @@
+package imapserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// maxBufferedCommandSize is the maximum size of a command executed
+// concurrently, excluding literals.
+const maxBufferedCommandSize = 64 * 1024
+
+// canExecConcurrently checks whether a command can be executed while other
+// commands are in-flight.
+//
+// Only commands which don't change the connection state, don't stream
+// literals and don't conflict with each other (see RFC 9051 section 5.5) are
+// eligible. FETCH commands need to be checked further with isPeekFetch.
+func (c *Conn) canExecConcurrently(name string) bool {
+	switch c.state {
+	case imap.ConnStateAuthenticated, imap.ConnStateSelected:
+		// ok
+	default:
+		return false
+	}
+
+	session, ok := c.session.(SessionConcurrent)
+	if !ok || !session.ConcurrentSafe() {
+		return false
+	}
+
+	switch name {
+	case "NOOP", "CHECK", "CAPABILITY", "STATUS", "LIST", "LSUB", "NAMESPACE", "FETCH", "UID FETCH", "GETMETADATA", "GETQUOTA", "GETQUOTAROOT", "GETACL", "LISTRIGHTS", "MYRIGHTS":
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferCommand reads the rest of a command, including literals, in memory.
+//
+// If the command is rejected, a tagged response is written and a nil buffer is
+// returned.
+func (c *Conn) bufferCommand(tag string) ([]byte, error) {
+	var (
+		buf       bytes.Buffer
+		rejectErr error
+	)
+	for {
+		line, truncated, err := c.readLine(maxBufferedCommandSize - buf.Len())
+		if err != nil {
+			return nil, err
+		}
+		if truncated && rejectErr == nil {
+			rejectErr = &imap.Error{
+				Type: imap.StatusResponseTypeBad,
+				Code: imap.ResponseCodeTooBig,
+				Text: "Command too long",
+			}
+		}
+		if rejectErr == nil {
+			buf.Write(line)
+		}
+
+		size, nonSync, ok := parseLiteralSuffix(line)
+		if !ok {
+			break
+		}
+		if rejectErr == nil {
+			rejectErr = c.checkBufferedLiteral(size, nonSync)
+		}
+		if rejectErr != nil {
+			if !nonSync {
+				// The client won't send the literal nor the rest of the
+				// command
+				break
+			}
+			if _, err := io.CopyN(io.Discard, c.br, size); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		c.setReadTimeout(literalReadTimeout)
+		_, err = io.CopyN(&buf, c.br, size)
+		c.setReadTimeout(cmdReadTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rejectErr != nil {
+		var imapErr *imap.Error
+		if !errors.As(rejectErr, &imapErr) {
+			return nil, rejectErr
+		}
+		return nil, c.writeStatusResp(tag, (*imap.StatusResponse)(imapErr))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func newBufferedDecoder(b []byte) *imapwire.Decoder {
+	br := bufio.NewReader(bytes.NewReader(b))
+	return imapwire.NewDecoder(br, imapwire.ConnSideServer)
+}
+
+// isPeekFetch checks whether the arguments of a buffered FETCH command leave
+// the message flags untouched.
+//
+// Non-peek BODY[] and BINARY[] items implicitly set the \Seen flag, which
+// conflicts with other commands.
+func isPeekFetch(b []byte) bool {
+	_, options, _, err := readFetchArgs(newBufferedDecoder(b))
+	if err != nil {
+		return false
+	}
+	for _, bs := range options.BodySection {
+		if !bs.Peek {
+			return false
+		}
+	}
+	for _, bs := range options.BinarySection {
+		if !bs.Peek {
+			return false
+		}
+	}
+	return true
+}
+
+// readLine reads a line, including the trailing CRLF.
+//
+// If the line is longer than max, it's consumed entirely but only its last
+// chunk is returned.
+func (c *Conn) readLine(max int) (line []byte, truncated bool, err error) {
+	for {
+		b, err := c.br.ReadSlice('\n')
+		if truncated || len(line)+len(b) > max {
+			truncated = true
+			line = append(line[:0], b...)
+		} else {
+			line = append(line, b...)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, truncated, err
+		}
+	}
+}
+
+// parseLiteralSuffix checks whether a line ends with a literal prefix.
+//
+// Quoted strings and atoms can't contain "{" and CRLF, so a line ending with
+// "{<size>}" always announces a literal.
+func parseLiteralSuffix(line []byte) (size int64, nonSync, ok bool) {
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if !bytes.HasSuffix(line, []byte("}")) {
+		return 0, false, false
+	}
+	i := bytes.LastIndexByte(line, '{')
+	if i < 0 {
+		return 0, false, false
+	}
+	s := string(line[i+1 : len(line)-1])
+	if strings.HasSuffix(s, "+") {
+		nonSync = true
+		s = strings.TrimSuffix(s, "+")
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return 0, false, false
+	}
+	return size, nonSync, true
+}
+
+// execConcurrently executes a fully buffered command in a separate goroutine.
+func (c *Conn) execConcurrently(tag, name string, numKind NumKind, dec *imapwire.Decoder) {
+	c.cmds.Add(1)
+	c.numConcurrentCmds.Add(1)
+	go func() {
+		// Runs last, once the command is no longer in-flight
+		defer c.maybeShutdown()
+		defer c.cmds.Done()
+		defer c.numConcurrentCmds.Add(-1)
+		defer func() {
+			if v := recover(); v != nil {
+				c.server.logger().Printf("panic handling command: %v\n%s", v, debug.Stack())
+				c.NetConn().Close()
+			}
+		}()
+
+		if err := c.execCommand(tag, name, numKind, dec); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.server.logger().Printf("failed to execute command: %v", err)
+			}
+			// Unblock the command reader
+			c.NetConn().Close()
+		}
+	}()
+}
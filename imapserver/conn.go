@@ -10,10 +10,11 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 const (
@@ -38,12 +39,23 @@ type Conn struct {
 	bw       *bufio.Writer
 	encMutex sync.Mutex
 
-	mutex   sync.Mutex
-	conn    net.Conn
-	enabled imap.CapSet
+	mutex    sync.Mutex
+	conn     net.Conn
+	enabled  imap.CapSet
+	clientID *imap.IDData
 
-	state   imap.ConnState
-	session Session
+	state      imap.ConnState
+	session    Session
+	compressed bool
+
+	waiting bool                 // waiting for the next command or DONE
+	bye     *imap.StatusResponse // set when the server is shutting down
+	byeSent bool
+
+	intercepted map[*imapwire.Decoder]*CommandInfo // in-flight commands
+
+	cmds              sync.WaitGroup // in-flight concurrent commands
+	numConcurrentCmds atomic.Int32
 }
 
 func newConn(c net.Conn, server *Server) *Conn {
@@ -94,6 +106,7 @@ func (c *Conn) serve() {
 
 	c.server.mutex.Lock()
 	c.server.conns[c] = struct{}{}
+	bye := c.server.bye
 	c.server.mutex.Unlock()
 	defer func() {
 		c.server.mutex.Lock()
@@ -101,6 +114,14 @@ func (c *Conn) serve() {
 		c.server.mutex.Unlock()
 	}()
 
+	if bye != nil {
+		// The server started shutting down while accepting this connection
+		if err := c.writeStatusResp("", bye); err != nil {
+			c.server.logger().Printf("failed to write BYE: %v", err)
+		}
+		return
+	}
+
 	var (
 		greetingData *GreetingData
 		err          error
@@ -144,6 +165,24 @@ func (c *Conn) serve() {
 	if _, ok := c.session.(SessionUnauthenticate); !ok && caps.Has(imap.CapUnauthenticate) {
 		panic("imapserver: server advertises UNAUTHENTICATE but session doesn't support it")
 	}
+	if _, ok := c.session.(SessionSort); !ok && (caps.Has(imap.CapSort) || caps.Has(imap.CapESort)) {
+		panic("imapserver: server advertises SORT but session doesn't support it")
+	}
+	if _, ok := c.session.(SessionThread); !ok && len(caps.ThreadAlgorithms()) > 0 {
+		panic("imapserver: server advertises THREAD but session doesn't support it")
+	}
+	if _, ok := c.session.(SessionMetadata); !ok && (caps.Has(imap.CapMetadata) || caps.Has(imap.CapMetadataServer)) {
+		panic("imapserver: server advertises METADATA but session doesn't support it")
+	}
+	if _, ok := c.session.(SessionQuota); !ok && caps.Has(imap.CapQuota) {
+		panic("imapserver: server advertises QUOTA but session doesn't support it")
+	}
+	if _, ok := c.session.(SessionACL); !ok && caps.Has(imap.CapACL) {
+		panic("imapserver: server advertises ACL but session doesn't support it")
+	}
+	if _, ok := c.session.(SessionNotify); !ok && caps.Has(imap.CapNotify) {
+		panic("imapserver: server advertises NOTIFY but session doesn't support it")
+	}
 
 	c.state = imap.ConnStateNotAuthenticated
 	statusType := imap.StatusResponseTypeOK
@@ -169,7 +208,17 @@ func (c *Conn) serve() {
 		dec := imapwire.NewDecoder(c.br, imapwire.ConnSideServer)
 		dec.CheckBufferedLiteralFunc = c.checkBufferedLiteral
 
-		if c.state == imap.ConnStateLogout || dec.EOF() {
+		if c.state == imap.ConnStateLogout {
+			break
+		}
+		if !c.setWaiting(true) {
+			c.cmds.Wait()
+			c.writeShutdownBye()
+			break
+		}
+		eof := dec.EOF()
+		c.setWaiting(false)
+		if eof {
 			break
 		}
 
@@ -181,6 +230,8 @@ func (c *Conn) serve() {
 			break
 		}
 	}
+
+	c.cmds.Wait()
 }
 
 func (c *Conn) readCommand(dec *imapwire.Decoder) error {
@@ -200,7 +251,34 @@ func (c *Conn) readCommand(dec *imapwire.Decoder) error {
 		name = "UID " + strings.ToUpper(subName)
 	}
 
-	// TODO: handle multiple commands concurrently
+	if c.canExecConcurrently(name) {
+		buf, err := c.bufferCommand(tag)
+		if err != nil || buf == nil {
+			return err
+		}
+		if (name == "FETCH" || name == "UID FETCH") && !isPeekFetch(buf) {
+			c.cmds.Wait()
+			return c.execCommand(tag, name, numKind, newBufferedDecoder(buf))
+		}
+		c.execConcurrently(tag, name, numKind, newBufferedDecoder(buf))
+		return nil
+	}
+
+	// Other commands may change the connection state or generate responses
+	// which would be ambiguous for in-flight commands: wait for them to
+	// complete
+	c.cmds.Wait()
+
+	return c.execCommand(tag, name, numKind, dec)
+}
+
+func (c *Conn) execCommand(tag, name string, numKind NumKind, dec *imapwire.Decoder) error {
+	var resp *imap.StatusResponse
+	cmd := c.beginCommand(tag, name, dec)
+	defer func() {
+		c.endCommand(cmd, dec, resp)
+	}()
+
 	sendOK := true
 	var err error
 	switch name {
@@ -213,6 +291,13 @@ func (c *Conn) readCommand(dec *imapwire.Decoder) error {
 	case "STARTTLS":
 		err = c.handleStartTLS(tag, dec)
 		sendOK = false
+	case "COMPRESS":
+		err = c.handleCompress(tag, dec)
+		sendOK = false
+	case "ID":
+		err = c.handleID(dec)
+	case "NOTIFY":
+		err = c.handleNotify(dec)
 	case "AUTHENTICATE":
 		err = c.handleAuthenticate(tag, dec)
 		sendOK = false
@@ -258,7 +343,8 @@ func (c *Conn) readCommand(dec *imapwire.Decoder) error {
 	case "UID EXPUNGE":
 		err = c.handleUIDExpunge(dec)
 	case "STORE", "UID STORE":
-		err = c.handleStore(dec, numKind)
+		err = c.handleStore(tag, dec, numKind)
+		sendOK = false
 	case "COPY", "UID COPY":
 		err = c.handleCopy(tag, dec, numKind)
 		sendOK = false
@@ -266,7 +352,37 @@ func (c *Conn) readCommand(dec *imapwire.Decoder) error {
 		err = c.handleMove(dec, numKind)
 	case "SEARCH", "UID SEARCH":
 		err = c.handleSearch(tag, dec, numKind)
+	case "SORT", "UID SORT":
+		err = c.handleSort(tag, dec, numKind)
+	case "THREAD", "UID THREAD":
+		err = c.handleThread(dec, numKind)
+	case "GETMETADATA":
+		err = c.handleGetMetadata(tag, dec)
+		sendOK = false
+	case "SETMETADATA":
+		err = c.handleSetMetadata(tag, dec)
+		sendOK = false
+	case "GETQUOTA":
+		err = c.handleGetQuota(dec)
+	case "GETQUOTAROOT":
+		err = c.handleGetQuotaRoot(dec)
+	case "SETQUOTA":
+		err = c.handleSetQuota(dec)
+	case "SETACL":
+		err = c.handleSetACL(dec)
+	case "DELETEACL":
+		err = c.handleDeleteACL(dec)
+	case "GETACL":
+		err = c.handleGetACL(dec)
+	case "LISTRIGHTS":
+		err = c.handleListRights(dec)
+	case "MYRIGHTS":
+		err = c.handleMyRights(dec)
 	default:
+		if h := c.server.options.Commands[name]; h != nil {
+			err = c.handleCustom(dec, h)
+			break
+		}
 		if c.state == imap.ConnStateNotAuthenticated {
 			// Don't allow a single unknown command before authentication to
 			// mitigate cross-protocol attacks:
@@ -280,10 +396,14 @@ func (c *Conn) readCommand(dec *imapwire.Decoder) error {
 		}
 	}
 
+	if errors.Is(err, net.ErrClosed) {
+		// Nothing can be written after BYE
+		return err
+	}
+
 	dec.DiscardLine()
 
 	var (
-		resp    *imap.StatusResponse
 		imapErr *imap.Error
 		decErr  *imapwire.DecoderExpectError
 	)
@@ -299,31 +419,41 @@ func (c *Conn) readCommand(dec *imapwire.Decoder) error {
 		c.server.logger().Printf("handling %v command: %v", name, err)
 		resp = internalServerErrorResp
 	} else {
+		okResp := &imap.StatusResponse{
+			Type: imap.StatusResponseTypeOK,
+			Text: fmt.Sprintf("%v completed", name),
+		}
 		if !sendOK {
+			// The handler has already written the status response
+			resp = okResp
 			return nil
 		}
 		if err := c.poll(name); err != nil {
 			return err
 		}
-		resp = &imap.StatusResponse{
-			Type: imap.StatusResponseTypeOK,
-			Text: fmt.Sprintf("%v completed", name),
-		}
+		resp = okResp
+	}
+	if err := c.writeStatusResp(tag, resp); err != nil {
+		resp = nil
+		return err
 	}
-	return c.writeStatusResp(tag, resp)
+	return nil
 }
 
 func (c *Conn) handleNoop(dec *imapwire.Decoder) error {
 	if !dec.ExpectCRLF() {
 		return dec.Err()
 	}
-	return nil
+	return c.intercept(dec)
 }
 
 func (c *Conn) handleLogout(dec *imapwire.Decoder) error {
 	if !dec.ExpectCRLF() {
 		return dec.Err()
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 
 	c.state = imap.ConnStateLogout
 
@@ -341,6 +471,9 @@ func (c *Conn) handleDelete(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, name); err != nil {
+		return err
+	}
 	return c.session.Delete(name)
 }
 
@@ -352,6 +485,9 @@ func (c *Conn) handleRename(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, oldName, newName); err != nil {
+		return err
+	}
 	return c.session.Rename(oldName, newName)
 }
 
@@ -363,6 +499,9 @@ func (c *Conn) handleSubscribe(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, name); err != nil {
+		return err
+	}
 	return c.session.Subscribe(name)
 }
 
@@ -374,6 +513,9 @@ func (c *Conn) handleUnsubscribe(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, name); err != nil {
+		return err
+	}
 	return c.session.Unsubscribe(name)
 }
 
@@ -430,6 +572,68 @@ func (c *Conn) writeCapabilityStatus(tag string, typ imap.StatusResponseType, te
 	return writeCapabilityStatus(enc.Encoder, tag, typ, c.availableCaps(), text)
 }
 
+// setWaiting marks the connection as waiting for client input, or not. When
+// marking the connection as waiting, false is returned if the server is
+// shutting down.
+func (c *Conn) setWaiting(waiting bool) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if waiting && c.bye != nil {
+		return false
+	}
+	c.waiting = waiting
+	return true
+}
+
+// shutdown notifies the connection that the server is shutting down. If the
+// connection is waiting for a command, it's closed immediately. Otherwise,
+// it's closed once in-flight commands complete.
+func (c *Conn) shutdown(bye *imap.StatusResponse) {
+	c.mutex.Lock()
+	c.bye = bye
+	c.mutex.Unlock()
+
+	c.maybeShutdown()
+}
+
+// maybeShutdown closes the connection if the server is shutting down and no
+// command is in-flight.
+func (c *Conn) maybeShutdown() {
+	c.mutex.Lock()
+	ok := c.bye != nil && c.waiting && c.numConcurrentCmds.Load() == 0
+	c.mutex.Unlock()
+
+	if ok {
+		c.writeShutdownBye()
+	}
+}
+
+// writeShutdownBye writes the BYE response sent when the server is shutting
+// down and closes the connection.
+func (c *Conn) writeShutdownBye() {
+	c.mutex.Lock()
+	bye := c.bye
+	ok := bye != nil && !c.byeSent
+	c.byeSent = true
+	c.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := c.writeStatusResp("", bye); err != nil && !errors.Is(err, net.ErrClosed) {
+		c.server.logger().Printf("failed to write BYE: %v", err)
+	}
+	c.NetConn().Close()
+}
+
+// shuttingDown returns true if the connection has been closed because the
+// server is shutting down.
+func (c *Conn) shuttingDown() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.byeSent
+}
+
 func (c *Conn) checkState(state imap.ConnState) error {
 	if state == imap.ConnStateAuthenticated && c.state == imap.ConnStateSelected {
 		return nil
@@ -466,7 +670,11 @@ func (c *Conn) poll(cmd string) error {
 
 	allowExpunge := true
 	switch cmd {
-	case "FETCH", "STORE", "SEARCH":
+	case "FETCH", "STORE", "SEARCH", "SORT", "THREAD":
+		allowExpunge = false
+	}
+	if c.numConcurrentCmds.Load() > 1 {
+		// Other commands are in-flight and may use sequence numbers
 		allowExpunge = false
 	}
 
@@ -577,6 +785,17 @@ func (w *UpdateWriter) WriteExpunge(seqNum uint32) error {
 	return w.conn.writeExpunge(seqNum)
 }
 
+// WriteExpungeUID writes an EXPUNGE response, or a VANISHED response if the
+// client has enabled QRESYNC.
+//
+// Servers supporting QRESYNC must use this method instead of WriteExpunge.
+func (w *UpdateWriter) WriteExpungeUID(seqNum uint32, uid imap.UID) error {
+	if !w.allowExpunge {
+		return fmt.Errorf("imapserver: EXPUNGE updates are not allowed in this context")
+	}
+	return w.conn.writeExpungeUID(seqNum, uid)
+}
+
 // WriteNumMessages writes an EXISTS response.
 func (w *UpdateWriter) WriteNumMessages(n uint32) error {
 	return w.conn.writeExists(n)
@@ -589,11 +808,48 @@ func (w *UpdateWriter) WriteMailboxFlags(flags []imap.Flag) error {
 
 // WriteMessageFlags writes a FETCH response with FLAGS.
 func (w *UpdateWriter) WriteMessageFlags(seqNum uint32, uid imap.UID, flags []imap.Flag) error {
+	return w.WriteMessageFlagsModSeq(seqNum, uid, flags, 0)
+}
+
+// WriteMessageFlagsModSeq writes a FETCH response with FLAGS and MODSEQ.
+//
+// The modification sequence is omitted if zero or if CONDSTORE isn't enabled
+// for the connection.
+func (w *UpdateWriter) WriteMessageFlagsModSeq(seqNum uint32, uid imap.UID, flags []imap.Flag, modSeq uint64) error {
 	fetchWriter := &FetchWriter{conn: w.conn}
 	respWriter := fetchWriter.CreateMessage(seqNum)
 	if uid != 0 {
 		respWriter.WriteUID(uid)
 	}
 	respWriter.WriteFlags(flags)
+	if modSeq != 0 {
+		respWriter.WriteModSeq(modSeq)
+	}
 	return respWriter.Close()
 }
+
+// WriteMailboxStatus writes a STATUS response for a mailbox other than the
+// selected one.
+//
+// Only the items set in data are written.
+func (w *UpdateWriter) WriteMailboxStatus(data *imap.StatusData) error {
+	options := imap.StatusOptions{
+		NumMessages:    data.NumMessages != nil,
+		UIDNext:        data.UIDNext != 0,
+		UIDValidity:    data.UIDValidity != 0,
+		NumUnseen:      data.NumUnseen != nil,
+		NumDeleted:     data.NumDeleted != nil,
+		Size:           data.Size != nil,
+		DeletedStorage: data.DeletedStorage != nil,
+		HighestModSeq:  data.HighestModSeq != 0,
+	}
+	return w.conn.writeStatus(data, &options, false)
+}
+
+// WriteMailboxList writes a LIST response for a mailbox.
+//
+// This can be used to report created, deleted, renamed and (un)subscribed
+// mailboxes.
+func (w *UpdateWriter) WriteMailboxList(data *imap.ListData) error {
+	return w.conn.writeList(data)
+}
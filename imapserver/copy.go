@@ -2,7 +2,7 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleCopy(tag string, dec *imapwire.Decoder, numKind NumKind) error {
@@ -13,6 +13,9 @@ func (c *Conn) handleCopy(tag string, dec *imapwire.Decoder, numKind NumKind) er
 	if err := c.checkState(imap.ConnStateSelected); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, numKind, seqSet, dest); err != nil {
+		return err
+	}
 	data, err := c.session.Copy(numKind, seqSet, dest)
 	if err != nil {
 		return err
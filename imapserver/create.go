@@ -4,8 +4,8 @@ import (
 	"strings"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 func (c *Conn) handleCreate(dec *imapwire.Decoder) error {
@@ -41,5 +41,8 @@ func (c *Conn) handleCreate(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, name, &options); err != nil {
+		return err
+	}
 	return c.session.Create(name, &options)
 }
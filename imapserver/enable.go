@@ -2,7 +2,7 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleEnable(dec *imapwire.Decoder) error {
@@ -21,18 +21,29 @@ func (c *Conn) handleEnable(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, requested); err != nil {
+		return err
+	}
 
 	var enabled []imap.Cap
 	for _, req := range requested {
 		switch req {
 		case imap.CapIMAP4rev2:
 			enabled = append(enabled, req)
+		case imap.CapCondStore, imap.CapQResync:
+			if c.server.options.caps().Has(req) {
+				enabled = append(enabled, req)
+			}
 		}
 	}
 
 	c.mutex.Lock()
 	for _, e := range enabled {
 		c.enabled[e] = struct{}{}
+		if e == imap.CapQResync {
+			// QRESYNC implies CONDSTORE, see RFC 7162 section 3.2.3
+			c.enabled[imap.CapCondStore] = struct{}{}
+		}
 	}
 	c.mutex.Unlock()
 
@@ -44,3 +55,19 @@ func (c *Conn) handleEnable(dec *imapwire.Decoder) error {
 	}
 	return enc.CRLF()
 }
+
+// enableCondStore marks CONDSTORE as enabled for the connection.
+//
+// This is called when the client issues a CONDSTORE enabling command, see
+// RFC 7162 section 3.1.
+func (c *Conn) enableCondStore() {
+	c.mutex.Lock()
+	c.enabled[imap.CapCondStore] = struct{}{}
+	c.mutex.Unlock()
+}
+
+func (c *Conn) isEnabled(cap imap.Cap) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.enabled.Has(cap)
+}
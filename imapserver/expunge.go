@@ -2,14 +2,14 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleExpunge(dec *imapwire.Decoder) error {
 	if !dec.ExpectCRLF() {
 		return dec.Err()
 	}
-	return c.expunge(nil)
+	return c.expunge(dec, nil)
 }
 
 func (c *Conn) handleUIDExpunge(dec *imapwire.Decoder) error {
@@ -17,13 +17,16 @@ func (c *Conn) handleUIDExpunge(dec *imapwire.Decoder) error {
 	if !dec.ExpectSP() || !dec.ExpectNumSet(&seqSet) || !dec.ExpectCRLF() {
 		return dec.Err()
 	}
-	return c.expunge(&seqSet)
+	return c.expunge(dec, &seqSet)
 }
 
-func (c *Conn) expunge(uids *imap.NumSet) error {
+func (c *Conn) expunge(dec *imapwire.Decoder, uids *imap.NumSet) error {
 	if err := c.checkState(imap.ConnStateSelected); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, uids); err != nil {
+		return err
+	}
 	w := &ExpungeWriter{conn: c}
 	return c.session.Expunge(w, uids)
 }
@@ -35,6 +38,26 @@ func (c *Conn) writeExpunge(seqNum uint32) error {
 	return enc.CRLF()
 }
 
+func (c *Conn) writeVanished(uids imap.NumSet, earlier bool) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("VANISHED").SP()
+	if earlier {
+		enc.Special('(').Atom("EARLIER").Special(')').SP()
+	}
+	enc.NumSet(uids)
+	return enc.CRLF()
+}
+
+// writeExpungeUID writes an EXPUNGE response, or a VANISHED response if the
+// client has enabled QRESYNC.
+func (c *Conn) writeExpungeUID(seqNum uint32, uid imap.UID) error {
+	if c.isEnabled(imap.CapQResync) {
+		return c.writeVanished(imap.NumSetNum(uint32(uid)), false)
+	}
+	return c.writeExpunge(seqNum)
+}
+
 // ExpungeWriter writes EXPUNGE updates.
 type ExpungeWriter struct {
 	conn *Conn
@@ -48,3 +71,16 @@ func (w *ExpungeWriter) WriteExpunge(seqNum uint32) error {
 	}
 	return w.conn.writeExpunge(seqNum)
 }
+
+// WriteExpungeUID notifies the client that the message with the provided
+// sequence number and UID has been deleted.
+//
+// If the client has enabled QRESYNC, a VANISHED response is written instead of
+// an EXPUNGE response. Servers supporting QRESYNC must use this method instead
+// of WriteExpunge.
+func (w *ExpungeWriter) WriteExpungeUID(seqNum uint32, uid imap.UID) error {
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.writeExpungeUID(seqNum, uid)
+}
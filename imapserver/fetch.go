@@ -9,8 +9,8 @@ import (
 	"time"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 const envelopeDateLayout = "Mon, 02 Jan 2006 15:04:05 -0700"
@@ -24,9 +24,48 @@ type fetchWriterOptions struct {
 }
 
 func (c *Conn) handleFetch(dec *imapwire.Decoder, numKind NumKind) error {
+	seqSet, options, writerOptions, err := readFetchArgs(dec)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+
+	if numKind == NumKindUID {
+		options.UID = true
+	}
+	if options.ChangedSince != 0 {
+		// CHANGEDSINCE implies MODSEQ, see RFC 7162 section 3.1.4.1
+		options.ModSeq = true
+	}
+	if options.Vanished {
+		if numKind != NumKindUID || options.ChangedSince == 0 {
+			return newClientBugError("VANISHED requires UID FETCH and CHANGEDSINCE")
+		} else if !c.isEnabled(imap.CapQResync) {
+			return newClientBugError("VANISHED requires QRESYNC to be enabled")
+		}
+	}
+	if options.ModSeq {
+		c.enableCondStore()
+	}
+	if err := c.intercept(dec, numKind, seqSet, options); err != nil {
+		return err
+	}
+
+	w := &FetchWriter{conn: c, options: *writerOptions}
+	if err := c.session.Fetch(w, numKind, seqSet, options); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFetchArgs reads the arguments of a FETCH command, up to the final CRLF.
+func readFetchArgs(dec *imapwire.Decoder) (imap.NumSet, *imap.FetchOptions, *fetchWriterOptions, error) {
 	var seqSet imap.NumSet
 	if !dec.ExpectSP() || !dec.ExpectNumSet(&seqSet) || !dec.ExpectSP() {
-		return dec.Err()
+		return nil, nil, nil, dec.Err()
 	}
 
 	var options imap.FetchOptions
@@ -43,12 +82,12 @@ func (c *Conn) handleFetch(dec *imapwire.Decoder, numKind NumKind) error {
 		return handleFetchAtt(dec, name, &options, &writerOptions)
 	})
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	if !isList {
 		name, err := readFetchAttName(dec)
 		if err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 
 		// Handle macros
@@ -70,28 +109,25 @@ func (c *Conn) handleFetch(dec *imapwire.Decoder, numKind NumKind) error {
 			handleFetchBodyStructure(&options, &writerOptions, false)
 		default:
 			if err := handleFetchAtt(dec, name, &options, &writerOptions); err != nil {
-				return err
+				return nil, nil, nil, err
 			}
 		}
 	}
 
-	if !dec.ExpectCRLF() {
-		return dec.Err()
-	}
-
-	if err := c.checkState(imap.ConnStateSelected); err != nil {
-		return err
+	if dec.SP() {
+		err := dec.ExpectList(func() error {
+			return readFetchModifier(dec, &options)
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
 
-	if numKind == NumKindUID {
-		options.UID = true
+	if !dec.ExpectCRLF() {
+		return nil, nil, nil, dec.Err()
 	}
 
-	w := &FetchWriter{conn: c, options: writerOptions}
-	if err := c.session.Fetch(w, numKind, seqSet, &options); err != nil {
-		return err
-	}
-	return nil
+	return seqSet, &options, &writerOptions, nil
 }
 
 func handleFetchAtt(dec *imapwire.Decoder, attName string, options *imap.FetchOptions, writerOptions *fetchWriterOptions) error {
@@ -108,6 +144,8 @@ func handleFetchAtt(dec *imapwire.Decoder, attName string, options *imap.FetchOp
 		options.RFC822Size = true
 	case "UID":
 		options.UID = true
+	case "MODSEQ":
+		options.ModSeq = true
 	case "RFC822": // equivalent to BODY[]
 		bs := &imap.FetchItemBodySection{}
 		writerOptions.obsolete[bs] = attName
@@ -182,6 +220,24 @@ func handleFetchAtt(dec *imapwire.Decoder, attName string, options *imap.FetchOp
 	return nil
 }
 
+func readFetchModifier(dec *imapwire.Decoder, options *imap.FetchOptions) error {
+	var name string
+	if !dec.ExpectAtom(&name) {
+		return dec.Err()
+	}
+	switch strings.ToUpper(name) {
+	case "CHANGEDSINCE":
+		if !dec.ExpectSP() || !dec.ExpectModSeq(&options.ChangedSince) {
+			return dec.Err()
+		}
+	case "VANISHED":
+		options.Vanished = true
+	default:
+		return newClientBugError("Unknown FETCH modifier")
+	}
+	return nil
+}
+
 func handleFetchBodyStructure(options *imap.FetchOptions, writerOptions *fetchWriterOptions, extended bool) {
 	if options.BodyStructure == nil || extended {
 		options.BodyStructure = &imap.FetchItemBodyStructure{Extended: extended}
@@ -195,8 +251,8 @@ func handleFetchBodyStructure(options *imap.FetchOptions, writerOptions *fetchWr
 
 func readFetchAttName(dec *imapwire.Decoder) (string, error) {
 	var attName string
-	if !dec.Expect(dec.Func(&attName, isMsgAttNameChar), "msg-att name") {
-		return "", dec.Err()
+	if !dec.Func(&attName, isMsgAttNameChar) {
+		return "", internal.NewExpectError("msg-att name")
 	}
 	return strings.ToUpper(attName), nil
 }
@@ -333,6 +389,14 @@ func (cmd *FetchWriter) CreateMessage(seqNum uint32) *FetchResponseWriter {
 	return &FetchResponseWriter{enc: enc, options: cmd.options}
 }
 
+// WriteVanished writes a VANISHED (EARLIER) response for messages which have
+// been expunged since FetchOptions.ChangedSince.
+//
+// This must only be used when FetchOptions.Vanished is set.
+func (cmd *FetchWriter) WriteVanished(uids imap.NumSet) error {
+	return cmd.conn.writeVanished(uids, true)
+}
+
 // FetchResponseWriter writes a single FETCH response for a message.
 type FetchResponseWriter struct {
 	enc     *responseEncoder
@@ -362,6 +426,19 @@ func (w *FetchResponseWriter) WriteFlags(flags []imap.Flag) {
 	})
 }
 
+// WriteModSeq writes the message's modification sequence.
+//
+// The modification sequence is only written if CONDSTORE is enabled for the
+// connection. This allows sessions to unconditionally include it in FETCH
+// responses resulting from a STORE command.
+func (w *FetchResponseWriter) WriteModSeq(modSeq uint64) {
+	if !w.enc.conn.isEnabled(imap.CapCondStore) {
+		return
+	}
+	w.writeItemSep()
+	w.enc.Atom("MODSEQ").SP().Special('(').ModSeq(modSeq).Special(')')
+}
+
 // WriteRFC822Size writes the message's full size.
 func (w *FetchResponseWriter) WriteRFC822Size(size int64) {
 	w.writeItemSep()
@@ -0,0 +1,48 @@
+package imapserver
+
+import (
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+	"github.com/emersion/go-imap/v2/internal"
+)
+
+func (c *Conn) handleID(dec *imapwire.Decoder) error {
+	if !dec.ExpectSP() {
+		return dec.Err()
+	}
+	clientID, err := internal.ExpectIDData(dec)
+	if err != nil {
+		return err
+	}
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+	if err := c.intercept(dec, clientID); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.clientID = clientID
+	c.mutex.Unlock()
+
+	var serverID *imap.IDData
+	if session, ok := c.session.(SessionID); ok {
+		serverID = session.ID(clientID)
+	}
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom("*").SP().Atom("ID").SP()
+	internal.WriteIDData(enc.Encoder, serverID)
+	return enc.CRLF()
+}
+
+// ClientID returns the ID data sent by the client via the ID command.
+//
+// Nil is returned if the client hasn't sent any ID data.
+func (c *Conn) ClientID() *imap.IDData {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.clientID
+}
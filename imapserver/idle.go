@@ -3,10 +3,11 @@ package imapserver
 import (
 	"fmt"
 	"io"
+	"net"
 	"runtime/debug"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleIdle(dec *imapwire.Decoder) error {
@@ -17,6 +18,9 @@ func (c *Conn) handleIdle(dec *imapwire.Decoder) error {
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 
 	if err := c.writeContReq("idling"); err != nil {
 		return err
@@ -36,9 +40,18 @@ func (c *Conn) handleIdle(dec *imapwire.Decoder) error {
 	}()
 
 	c.setReadTimeout(idleReadTimeout)
+	if !c.setWaiting(true) {
+		c.writeShutdownBye()
+	}
 	line, isPrefix, err := c.br.ReadLine()
+	c.setWaiting(false)
 	close(stop)
-	if err == io.EOF {
+	if c.shuttingDown() {
+		// Interrupted by Server.Shutdown: the connection has been closed
+		// after writing BYE
+		<-done
+		return net.ErrClosed
+	} else if err == io.EOF {
 		return nil
 	} else if err != nil {
 		return err
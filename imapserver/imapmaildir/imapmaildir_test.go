@@ -0,0 +1,305 @@
+package imapmaildir_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmaildir"
+)
+
+const (
+	testUsername = "test-user"
+	testPassword = "test-password"
+)
+
+const testRawMessage = "From: contact@example.org\r\n" +
+	"Subject: Hello\r\n" +
+	"\r\n" +
+	"Hi there!\r\n"
+
+func deliver(t *testing.T, root, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(root, "new", name), []byte(testRawMessage), 0600); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+}
+
+func newTestClient(t *testing.T, root string) (*imapclient.Client, *imapserver.Server) {
+	maildirServer := imapmaildir.New()
+	maildirServer.AddUser(imapmaildir.NewUser(testUsername, testPassword, root))
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return maildirServer.NewSession(), nil, nil
+		},
+		Caps: imap.CapSet{
+			imap.CapIMAP4rev1: {},
+			imap.CapIMAP4rev2: {},
+		},
+		InsecureAuth: true,
+	})
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	go server.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial() = %v", err)
+	}
+	client := imapclient.New(conn, nil)
+	if err := client.Login(testUsername, testPassword).Wait(); err != nil {
+		t.Fatalf("Login().Wait() = %v", err)
+	}
+	return client, server
+}
+
+func TestServer(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatalf("MkdirAll() = %v", err)
+		}
+	}
+	deliver(t, root, "1000.M1P1.example.org")
+
+	client, server := newTestClient(t, root)
+	defer server.Close()
+	defer client.Close()
+
+	if err := client.Create("Archive/2024", nil).Wait(); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".Archive.2024", "cur")); err != nil {
+		t.Errorf("Create() didn't create the Maildir folder: %v", err)
+	}
+
+	mailboxes, err := client.List("", "*", nil).Collect()
+	if err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	var names []string
+	for _, data := range mailboxes {
+		names = append(names, data.Mailbox)
+	}
+	if want := []string{"Archive/2024", "INBOX"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+
+	selectData, err := client.Select("INBOX", nil).Wait()
+	if err != nil {
+		t.Fatalf("Select() = %v", err)
+	} else if selectData.NumMessages != 1 {
+		t.Fatalf("Select().NumMessages = %v, want 1", selectData.NumMessages)
+	}
+
+	msgs, err := client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{
+		UID:      true,
+		Envelope: true,
+		Flags:    true,
+	}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	} else if len(msgs) != 1 {
+		t.Fatalf("Fetch() returned %v messages, want 1", len(msgs))
+	}
+	if msgs[0].UID != 1 || msgs[0].Envelope == nil || msgs[0].Envelope.Subject != "Hello" {
+		t.Errorf("Fetch() = UID %v, envelope %v", msgs[0].UID, msgs[0].Envelope)
+	}
+
+	storeFlags := imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Flags:  []imap.Flag{imap.FlagSeen, "$Important"},
+		Silent: true,
+	}
+	if err := client.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "cur"))
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	} else if len(entries) != 1 || entries[0].Name() != "1000.M1P1.example.org:2,Sa" {
+		t.Errorf("cur directory contains %v, want a file with info Sa", entries)
+	}
+
+	// External delivery
+	deliver(t, root, "2000.M1P1.example.org")
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop() = %v", err)
+	}
+	if n := client.Mailbox().NumMessages; n != 2 {
+		t.Errorf("NumMessages after delivery = %v, want 2", n)
+	}
+
+	msgs, err = client.Fetch(imap.NumSetNum(1, 2), &imap.FetchOptions{UID: true, Flags: true}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	var flags [][]imap.Flag
+	var uids []imap.UID
+	for _, msg := range msgs {
+		uids = append(uids, msg.UID)
+		flags = append(flags, msg.Flags)
+	}
+	if want := []imap.UID{1, 2}; !reflect.DeepEqual(uids, want) {
+		t.Errorf("Fetch() UIDs = %v, want %v", uids, want)
+	}
+	if want := [][]imap.Flag{{imap.FlagSeen, "$Important"}, nil}; !reflect.DeepEqual(flags, want) {
+		t.Errorf("Fetch() flags = %v, want %v", flags, want)
+	}
+
+	searchData, err := client.UIDSearch(&imap.SearchCriteria{
+		NotFlag: []imap.Flag{imap.FlagSeen},
+		Header:  []imap.SearchCriteriaHeaderField{{Key: "Subject", Value: "hello"}},
+	}, nil).Wait()
+	if err != nil {
+		t.Fatalf("Search() = %v", err)
+	} else if searchData.All.String() != "2" {
+		t.Errorf("Search() = %v, want 2", searchData.All)
+	}
+
+	storeFlags = imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+		Silent: true,
+	}
+	if err := client.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+	expunged, err := client.Expunge().Collect()
+	if err != nil {
+		t.Fatalf("Expunge() = %v", err)
+	} else if !reflect.DeepEqual(expunged, []uint32{1}) {
+		t.Errorf("Expunge() = %v, want [1]", expunged)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cur", "1000.M1P1.example.org:2,STa")); !os.IsNotExist(err) {
+		t.Errorf("expunged message file still exists: %v", err)
+	}
+
+	// UIDs are persisted across restarts
+	client.Close()
+	server.Close()
+	client, server = newTestClient(t, root)
+	defer server.Close()
+	defer client.Close()
+
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+	msgs, err = client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{UID: true}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	} else if len(msgs) != 1 || msgs[0].UID != 2 {
+		t.Errorf("Fetch() after restart = %v, want UID 2", msgs)
+	}
+}
+
+func TestRenameSelected(t *testing.T) {
+	root := t.TempDir()
+	client, server := newTestClient(t, root)
+	defer server.Close()
+	defer client.Close()
+
+	if err := client.Create("Archive", nil).Wait(); err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	appendCmd := client.Append("Archive", int64(len(testRawMessage)), nil)
+	appendCmd.Write([]byte(testRawMessage))
+	appendCmd.Close()
+	if _, err := appendCmd.Wait(); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+	if _, err := client.Select("Archive", nil).Wait(); err != nil {
+		t.Fatalf("Select() = %v", err)
+	}
+
+	// The old mailbox is gone: its messages are expunged from the session
+	if err := client.Rename("Archive", "Old").Wait(); err != nil {
+		t.Fatalf("Rename() = %v", err)
+	}
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop() after Rename() = %v", err)
+	}
+	if n := client.Mailbox().NumMessages; n != 0 {
+		t.Errorf("NumMessages after Rename() = %v, want 0", n)
+	}
+
+	selectData, err := client.Select("Old", nil).Wait()
+	if err != nil {
+		t.Fatalf("Select() = %v", err)
+	} else if selectData.NumMessages != 1 {
+		t.Errorf("Select().NumMessages = %v, want 1", selectData.NumMessages)
+	}
+
+	if err := client.Delete("Old").Wait(); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if err := client.Noop().Wait(); err != nil {
+		t.Fatalf("Noop() after Delete() = %v", err)
+	}
+}
+
+func TestExamine(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatalf("MkdirAll() = %v", err)
+		}
+	}
+	deliver(t, root, "1000.M1P1.example.org")
+
+	client, server := newTestClient(t, root)
+	defer server.Close()
+	defer client.Close()
+
+	selectData, err := client.Select("INBOX", &imap.SelectOptions{ReadOnly: true}).Wait()
+	if err != nil {
+		t.Fatalf("Select() = %v", err)
+	} else if !selectData.ReadOnly {
+		t.Errorf("Select().ReadOnly = false, want true")
+	}
+
+	// Fetching the body doesn't set \Seen
+	_, err = client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{
+		BodySection: []*imap.FetchItemBodySection{{}},
+	}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	}
+	msgs, err := client.Fetch(imap.NumSetNum(1), &imap.FetchOptions{Flags: true}).Collect()
+	if err != nil {
+		t.Fatalf("Fetch() = %v", err)
+	} else if len(msgs) != 1 || len(msgs[0].Flags) != 0 {
+		t.Errorf("Fetch() = %v, want a message without flags", msgs)
+	}
+
+	storeFlags := imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+		Silent: true,
+	}
+	if err := client.Store(imap.NumSetNum(1), &storeFlags, nil).Close(); err == nil {
+		t.Errorf("Store() succeeded in a read-only mailbox")
+	}
+	if _, err := client.Expunge().Collect(); err == nil {
+		t.Errorf("Expunge() succeeded in a read-only mailbox")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "cur"))
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "1000.M1P1.example.org" && entry.Name() != "1000.M1P1.example.org:2," {
+			t.Errorf("cur directory contains %v, want a file without info", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,780 @@
+package imapmaildir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+// Mailbox is a Maildir folder.
+//
+// The same mailbox is shared between all connections of a user. The Maildir
+// is re-scanned on demand to pick up changes made by other processes, for
+// instance messages delivered by an MDA.
+//
+// Scanning lists the whole cur directory, so its cost grows with the number of
+// messages. To keep polling cheap, the Maildir is only re-scanned when the
+// modification time of the new or cur directory has changed.
+type Mailbox struct {
+	tracker *imapserver.MailboxTracker
+	dir     string
+
+	mutex       sync.Mutex
+	loaded      bool
+	gone        bool // deleted or renamed
+	stamp       dirStamp
+	uidValidity uint32
+	uidNext     imap.UID
+	l           []*message
+	keywords    []imap.Flag
+}
+
+// dirStamp holds the modification times of the new and cur directories, as
+// observed at a given time.
+type dirStamp struct {
+	new, cur time.Time
+	at       time.Time
+}
+
+// stampPrecision is the maximum granularity of directory modification times.
+// Modifications made within the same granule may leave the modification time
+// unchanged, so a stamp is only trusted if it was observed long enough after
+// the last modification.
+const stampPrecision = time.Second
+
+func (mbox *Mailbox) readStamp() (dirStamp, error) {
+	now := time.Now()
+	newInfo, err := os.Stat(filepath.Join(mbox.dir, "new"))
+	if err != nil {
+		return dirStamp{}, err
+	}
+	curInfo, err := os.Stat(mbox.curDir())
+	if err != nil {
+		return dirStamp{}, err
+	}
+	return dirStamp{new: newInfo.ModTime(), cur: curInfo.ModTime(), at: now}, nil
+}
+
+// unchangedLocked returns true if the Maildir is known to be unmodified since
+// the last scan.
+func (mbox *Mailbox) unchangedLocked(stamp dirStamp) bool {
+	prev := mbox.stamp
+	if !mbox.loaded || !stamp.new.Equal(prev.new) || !stamp.cur.Equal(prev.cur) {
+		return false
+	}
+	latest := prev.new
+	if prev.cur.After(latest) {
+		latest = prev.cur
+	}
+	return prev.at.Sub(latest) > stampPrecision
+}
+
+// invalidate marks the mailbox as deleted or renamed. All of its messages are
+// expunged from the views of the mailbox.
+func (mbox *Mailbox) invalidate() {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	if mbox.gone {
+		return
+	}
+	mbox.gone = true
+	for i := len(mbox.l) - 1; i >= 0; i-- {
+		mbox.tracker.QueueExpungeUID(uint32(i)+1, mbox.l[i].uid)
+	}
+	mbox.l = nil
+}
+
+func newMailbox(dir string) *Mailbox {
+	return &Mailbox{
+		tracker: imapserver.NewMailboxTracker(0),
+		dir:     dir,
+	}
+}
+
+func (mbox *Mailbox) curDir() string {
+	return filepath.Join(mbox.dir, "cur")
+}
+
+func (mbox *Mailbox) filePath(msg *message) string {
+	return filepath.Join(mbox.curDir(), msg.filename())
+}
+
+// scan updates the mailbox state from the Maildir.
+func (mbox *Mailbox) scan() error {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+	return mbox.scanLocked()
+}
+
+// scanLocked synchronizes the in-memory message list with the Maildir and
+// queues the resulting updates.
+//
+// Messages in the new directory are moved to the cur directory. Messages
+// missing from the UID list are assigned a new UID.
+//
+// Nothing is done if the Maildir hasn't changed since the last scan, or if the
+// mailbox has been deleted or renamed.
+func (mbox *Mailbox) scanLocked() error {
+	if mbox.gone {
+		return nil
+	}
+
+	// The stamp is read before the directories are listed, so that changes
+	// made during the scan trigger another scan
+	stamp, err := mbox.readStamp()
+	if err != nil {
+		return err
+	} else if mbox.unchangedLocked(stamp) {
+		return nil
+	}
+
+	if err := mbox.rescanLocked(); err != nil {
+		return err
+	}
+	mbox.stamp = stamp
+	return nil
+}
+
+// rescanLocked unconditionally scans the Maildir. See scanLocked.
+func (mbox *Mailbox) rescanLocked() error {
+	uidList, err := readUIDList(mbox.dir)
+	if err != nil {
+		return err
+	}
+	keywords, err := readKeywords(mbox.dir)
+	if err != nil {
+		return err
+	}
+	mbox.keywords = keywords
+
+	if err := mbox.moveNewLocked(); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(mbox.curDir())
+	if err != nil {
+		return err
+	}
+	infos := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		key, info := splitFilename(entry.Name())
+		infos[key] = info
+	}
+
+	dirty := !mbox.loaded || uidList.uidNext != mbox.uidNext
+	if !mbox.loaded || uidList.uidValidity != mbox.uidValidity {
+		// The UID list has been re-created: start over
+		for i := len(mbox.l) - 1; i >= 0; i-- {
+			mbox.tracker.QueueExpungeUID(uint32(i)+1, mbox.l[i].uid)
+		}
+		mbox.l = nil
+		mbox.uidValidity = uidList.uidValidity
+		mbox.loaded = true
+	}
+	mbox.uidNext = uidList.uidNext
+
+	// Flag changes are queued before expunges, since they refer to sequence
+	// numbers before the expunges
+	known := make(map[string]struct{}, len(mbox.l))
+	for i, msg := range mbox.l {
+		known[msg.key] = struct{}{}
+		info, ok := infos[msg.key]
+		if !ok || info == msg.info {
+			continue
+		}
+		msg.info = info
+		mbox.tracker.QueueMessageFlags(uint32(i)+1, msg.uid, msg.flags(mbox.keywords), nil)
+	}
+
+	// Iterate in reverse order, to keep sequence numbers consistent
+	var filtered []*message
+	for i := len(mbox.l) - 1; i >= 0; i-- {
+		msg := mbox.l[i]
+		if _, ok := infos[msg.key]; ok {
+			filtered = append(filtered, msg)
+			continue
+		}
+		mbox.tracker.QueueExpungeUID(uint32(i)+1, msg.uid)
+		dirty = true
+	}
+	for i := 0; i < len(filtered)/2; i++ {
+		j := len(filtered) - i - 1
+		filtered[i], filtered[j] = filtered[j], filtered[i]
+	}
+	mbox.l = filtered
+
+	var added []*message
+	for key, info := range infos {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		msg := &message{key: key, info: info, uid: uidList.uids[key]}
+		fi, err := os.Stat(mbox.filePath(msg))
+		if os.IsNotExist(err) {
+			continue // concurrently removed
+		} else if err != nil {
+			return err
+		}
+		msg.t = fi.ModTime()
+		msg.size = fi.Size()
+		added = append(added, msg)
+	}
+	if len(added) == 0 {
+		if dirty {
+			return mbox.writeUIDListLocked()
+		}
+		return nil
+	}
+
+	// Messages with a known UID come first, then new messages in delivery
+	// order
+	sort.Slice(added, func(i, j int) bool {
+		a, b := added[i], added[j]
+		if (a.uid != 0) != (b.uid != 0) {
+			return a.uid != 0
+		} else if a.uid != b.uid {
+			return a.uid < b.uid
+		} else if !a.t.Equal(b.t) {
+			return a.t.Before(b.t)
+		}
+		return a.key < b.key
+	})
+	var lastUID imap.UID
+	if len(mbox.l) > 0 {
+		lastUID = mbox.l[len(mbox.l)-1].uid
+	}
+	for _, msg := range added {
+		if msg.uid <= lastUID {
+			msg.uid = mbox.uidNext
+			mbox.uidNext++
+		} else if msg.uid >= mbox.uidNext {
+			mbox.uidNext = msg.uid + 1
+		}
+		lastUID = msg.uid
+		mbox.l = append(mbox.l, msg)
+	}
+	mbox.tracker.QueueNumMessages(uint32(len(mbox.l)))
+
+	return mbox.writeUIDListLocked()
+}
+
+// moveNewLocked moves messages from the new directory to the cur directory.
+func (mbox *Mailbox) moveNewLocked() error {
+	entries, err := os.ReadDir(filepath.Join(mbox.dir, "new"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		key, _ := splitFilename(name)
+		src := filepath.Join(mbox.dir, "new", name)
+		dst := filepath.Join(mbox.curDir(), key+infoSep)
+		if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mbox *Mailbox) writeUIDListLocked() error {
+	return writeUIDList(mbox.dir, mbox.uidValidity, mbox.uidNext, mbox.l)
+}
+
+func (mbox *Mailbox) statusData(options *imap.StatusOptions) (*imap.StatusData, error) {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	if err := mbox.scanLocked(); err != nil {
+		return nil, err
+	}
+
+	data := imap.StatusData{}
+	if options.NumMessages {
+		num := uint32(len(mbox.l))
+		data.NumMessages = &num
+	}
+	if options.UIDNext {
+		data.UIDNext = mbox.uidNext
+	}
+	if options.UIDValidity {
+		data.UIDValidity = mbox.uidValidity
+	}
+	if options.NumUnseen {
+		num := uint32(len(mbox.l)) - mbox.countByInfoLetterLocked('S')
+		data.NumUnseen = &num
+	}
+	if options.NumDeleted {
+		num := mbox.countByInfoLetterLocked('T')
+		data.NumDeleted = &num
+	}
+	if options.Size {
+		var size int64
+		for _, msg := range mbox.l {
+			size += msg.size
+		}
+		data.Size = &size
+	}
+	return &data, nil
+}
+
+func (mbox *Mailbox) countByInfoLetterLocked(letter byte) uint32 {
+	var n uint32
+	for _, msg := range mbox.l {
+		if hasInfoLetter(msg.info, letter) {
+			n++
+		}
+	}
+	return n
+}
+
+func (mbox *Mailbox) selectDataLocked() *imap.SelectData {
+	flags := mbox.flagsLocked()
+
+	permanentFlags := make([]imap.Flag, len(flags))
+	copy(permanentFlags, flags)
+	if len(mbox.keywords) < maxKeywords {
+		permanentFlags = append(permanentFlags, imap.FlagWildcard)
+	}
+
+	return &imap.SelectData{
+		Flags:          flags,
+		PermanentFlags: permanentFlags,
+		NumMessages:    uint32(len(mbox.l)),
+		UIDNext:        mbox.uidNext,
+		UIDValidity:    mbox.uidValidity,
+	}
+}
+
+func (mbox *Mailbox) flagsLocked() []imap.Flag {
+	var l []imap.Flag
+	for _, sf := range systemFlags {
+		l = append(l, sf.flag)
+	}
+	for _, keyword := range mbox.keywords {
+		if keyword != "" {
+			l = append(l, keyword)
+		}
+	}
+	return l
+}
+
+// appendBytes delivers a new message to the mailbox.
+func (mbox *Mailbox) appendBytes(buf []byte, options *imap.AppendOptions) (*imap.AppendData, error) {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	if err := mbox.scanLocked(); err != nil {
+		return nil, err
+	}
+
+	info, err := mbox.infoLocked("", options.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	t := options.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	msg := &message{
+		uid:  mbox.uidNext,
+		key:  newUniqueName(),
+		info: info,
+		t:    t,
+		size: int64(len(buf)),
+	}
+
+	tmpPath := filepath.Join(mbox.dir, "tmp", msg.key)
+	if err := os.WriteFile(tmpPath, buf, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(tmpPath, t, t); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, mbox.filePath(msg)); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	mbox.uidNext++
+	mbox.l = append(mbox.l, msg)
+	if err := mbox.writeUIDListLocked(); err != nil {
+		return nil, err
+	}
+	mbox.tracker.QueueNumMessages(uint32(len(mbox.l)))
+
+	return &imap.AppendData{
+		UIDValidity: mbox.uidValidity,
+		UID:         msg.uid,
+	}, nil
+}
+
+// infoLocked builds the info of a message with the specified flags. Flags
+// which can't be represented as an info letter are kept from prev.
+//
+// New keywords are added to the mailbox keyword list.
+func (mbox *Mailbox) infoLocked(prev string, flags []imap.Flag) (string, error) {
+	var letters []byte
+	for i := 0; i < len(prev); i++ {
+		// Preserve letters we don't know about
+		c := prev[i]
+		if c >= 'a' && c <= 'z' {
+			continue
+		}
+		known := false
+		for _, sf := range systemFlags {
+			if sf.letter == c {
+				known = true
+				break
+			}
+		}
+		if !known {
+			letters = append(letters, c)
+		}
+	}
+
+	keywordsChanged := false
+	for _, flag := range flags {
+		var letter byte
+		if l, ok := systemFlagLetter(flag); ok {
+			letter = l
+		} else if strings.HasPrefix(string(flag), `\`) {
+			continue // unsupported system flag, e.g. \Recent
+		} else {
+			index := -1
+			for i, keyword := range mbox.keywords {
+				if strings.EqualFold(string(keyword), string(flag)) {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				if len(mbox.keywords) >= maxKeywords {
+					return "", &imap.Error{
+						Type: imap.StatusResponseTypeNo,
+						Code: imap.ResponseCodeLimit,
+						Text: "Too many keywords",
+					}
+				}
+				index = len(mbox.keywords)
+				mbox.keywords = append(mbox.keywords, flag)
+				keywordsChanged = true
+			}
+			letter = byte('a' + index)
+		}
+		if !hasInfoLetter(string(letters), letter) {
+			letters = append(letters, letter)
+		}
+	}
+
+	if keywordsChanged {
+		if err := writeKeywords(mbox.dir, mbox.keywords); err != nil {
+			return "", err
+		}
+	}
+
+	return sortInfo(letters), nil
+}
+
+// setInfoLocked renames a message file to update its info.
+func (mbox *Mailbox) setInfoLocked(msg *message, info string) error {
+	if info == msg.info {
+		return nil
+	}
+	oldPath := mbox.filePath(msg)
+	newPath := filepath.Join(mbox.curDir(), msg.key+infoSep+info)
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("imapmaildir: failed to update message flags: %w", err)
+	}
+	msg.info = info
+	return nil
+}
+
+func (mbox *Mailbox) expunge(uids *imap.NumSet) error {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	for _, msg := range mbox.l {
+		if uids != nil && !uids.Contains(uint32(msg.uid)) {
+			continue
+		}
+		if !hasInfoLetter(msg.info, 'T') {
+			continue
+		}
+		if err := os.Remove(mbox.filePath(msg)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Picks up the removed files and queues the expunge updates
+	return mbox.scanLocked()
+}
+
+// NewView creates a new view into this mailbox.
+//
+// Callers must call MailboxView.Close once they are done with the mailbox view.
+func (mbox *Mailbox) NewView() *MailboxView {
+	return &MailboxView{
+		Mailbox: mbox,
+		tracker: mbox.tracker.NewSession(),
+	}
+}
+
+// A MailboxView is a view into a mailbox.
+//
+// Each view has its own queue of pending unilateral updates.
+//
+// Once the mailbox view is no longer used, Close must be called.
+type MailboxView struct {
+	*Mailbox
+	tracker  *imapserver.SessionTracker
+	readOnly bool
+}
+
+// Close releases the resources allocated for the mailbox view.
+func (mbox *MailboxView) Close() {
+	mbox.tracker.Close()
+}
+
+func (mbox *MailboxView) Fetch(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, options *imap.FetchOptions) error {
+	markSeen := false
+	if !mbox.readOnly {
+		for _, bs := range options.BodySection {
+			if !bs.Peek {
+				markSeen = true
+				break
+			}
+		}
+	}
+
+	var err error
+	mbox.forEach(numKind, seqSet, func(seqNum uint32, msg *message) {
+		if err != nil {
+			return
+		}
+
+		if markSeen && !hasInfoLetter(msg.info, 'S') {
+			info := sortInfo(append([]byte(msg.info), 'S'))
+			if err = mbox.setInfoLocked(msg, info); err != nil {
+				return
+			}
+			mbox.Mailbox.tracker.QueueMessageFlags(seqNum, msg.uid, msg.flags(mbox.keywords), nil)
+		}
+
+		respWriter := w.CreateMessage(mbox.tracker.EncodeSeqNum(seqNum))
+		err = mbox.fetchMessageLocked(respWriter, msg, options)
+	})
+	return err
+}
+
+func (mbox *MailboxView) Search(numKind imapserver.NumKind, criteria *imap.SearchCriteria, options *imap.SearchOptions) (*imap.SearchData, error) {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	for _, seqSet := range criteria.SeqNum {
+		mbox.staticNumSet(seqSet, imapserver.NumKindSeq)
+	}
+	for _, seqSet := range criteria.UID {
+		mbox.staticNumSet(seqSet, imapserver.NumKindUID)
+	}
+
+	data := imap.SearchData{
+		UID: numKind == imapserver.NumKindUID,
+	}
+	for i, msg := range mbox.l {
+		seqNum := mbox.tracker.EncodeSeqNum(uint32(i) + 1)
+
+		ok, err := mbox.searchMessageLocked(msg, seqNum, criteria)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		var num uint32
+		switch numKind {
+		case imapserver.NumKindSeq:
+			num = seqNum
+		case imapserver.NumKindUID:
+			num = uint32(msg.uid)
+		}
+		if num == 0 {
+			continue
+		}
+
+		data.All.AddNum(num)
+		if data.Min == 0 || num < data.Min {
+			data.Min = num
+		}
+		if data.Max == 0 || num > data.Max {
+			data.Max = num
+		}
+		data.Count++
+	}
+
+	return &data, nil
+}
+
+func (mbox *MailboxView) Store(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, flags *imap.StoreFlags, options *imap.StoreOptions) error {
+	if mbox.readOnly {
+		return errReadOnly
+	}
+
+	var err error
+	mbox.forEach(numKind, seqSet, func(seqNum uint32, msg *message) {
+		if err != nil {
+			return
+		}
+
+		var newFlags []imap.Flag
+		switch flags.Op {
+		case imap.StoreFlagsSet:
+			newFlags = flags.Flags
+		case imap.StoreFlagsAdd:
+			newFlags = append(msg.flags(mbox.keywords), flags.Flags...)
+		case imap.StoreFlagsDel:
+			for _, flag := range msg.flags(mbox.keywords) {
+				if !containsFlag(flags.Flags, flag) {
+					newFlags = append(newFlags, flag)
+				}
+			}
+		default:
+			panic(fmt.Errorf("unknown STORE flag operation: %v", flags.Op))
+		}
+
+		var info string
+		info, err = mbox.infoLocked(msg.info, newFlags)
+		if err != nil || info == msg.info {
+			return
+		}
+		if err = mbox.setInfoLocked(msg, info); err != nil {
+			return
+		}
+		mbox.Mailbox.tracker.QueueMessageFlags(seqNum, msg.uid, msg.flags(mbox.keywords), mbox.tracker)
+	})
+	if err != nil {
+		return err
+	}
+
+	if !flags.Silent {
+		return mbox.Fetch(w, numKind, seqSet, &imap.FetchOptions{Flags: true})
+	}
+	return nil
+}
+
+func (mbox *MailboxView) Poll(w *imapserver.UpdateWriter, allowExpunge bool) error {
+	if err := mbox.scan(); err != nil {
+		return err
+	}
+	return mbox.tracker.Poll(w, allowExpunge)
+}
+
+func (mbox *MailboxView) Idle(w *imapserver.UpdateWriter, stop <-chan struct{}) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	// Periodically re-scan the Maildir to pick up external changes. The
+	// tracker delivers the resulting updates.
+	go func() {
+		ticker := time.NewTicker(idleScanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mbox.scan()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return mbox.tracker.Idle(w, stop)
+}
+
+// messages returns the messages matching a sequence set.
+func (mbox *MailboxView) messages(numKind imapserver.NumKind, seqSet imap.NumSet) []*message {
+	var l []*message
+	mbox.forEach(numKind, seqSet, func(seqNum uint32, msg *message) {
+		l = append(l, msg)
+	})
+	return l
+}
+
+func (mbox *MailboxView) forEach(numKind imapserver.NumKind, seqSet imap.NumSet, f func(seqNum uint32, msg *message)) {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	mbox.staticNumSet(seqSet, numKind)
+
+	for i, msg := range mbox.l {
+		seqNum := uint32(i) + 1
+
+		var num uint32
+		switch numKind {
+		case imapserver.NumKindSeq:
+			num = mbox.tracker.EncodeSeqNum(seqNum)
+		case imapserver.NumKindUID:
+			num = uint32(msg.uid)
+		}
+		if num == 0 || !seqSet.Contains(num) {
+			continue
+		}
+
+		f(seqNum, msg)
+	}
+}
+
+// staticNumSet converts a dynamic sequence set into a static one.
+//
+// This is necessary to properly handle the special symbol "*", which
+// represents the maximum sequence number or UID in the mailbox.
+func (mbox *MailboxView) staticNumSet(seqSet imap.NumSet, numKind imapserver.NumKind) {
+	var max uint32
+	switch numKind {
+	case imapserver.NumKindSeq:
+		max = uint32(len(mbox.l))
+	case imapserver.NumKindUID:
+		max = uint32(mbox.uidNext) - 1
+	}
+
+	for i := range seqSet {
+		seq := &seqSet[i]
+		dyn := false
+		if seq.Start == 0 {
+			seq.Start = max
+			dyn = true
+		}
+		if seq.Stop == 0 {
+			seq.Stop = max
+			dyn = true
+		}
+		if dyn && seq.Start > seq.Stop {
+			seq.Start, seq.Stop = seq.Stop, seq.Start
+		}
+	}
+}
+
+func containsFlag(flags []imap.Flag, flag imap.Flag) bool {
+	for _, f := range flags {
+		if strings.EqualFold(string(f), string(flag)) {
+			return true
+		}
+	}
+	return false
+}
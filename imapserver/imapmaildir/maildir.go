@@ -0,0 +1,243 @@
+package imapmaildir
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+const (
+	uidListName       = "imapmaildir-uidlist"
+	keywordsName      = "imapmaildir-keywords"
+	subscriptionsName = "subscriptions"
+
+	// infoSep separates the unique name of a message from its info, which
+	// contains the message flags
+	infoSep = ":2,"
+
+	// maxKeywords is the maximum number of keywords per mailbox: keywords are
+	// stored as the lowercase letters "a" to "z" in the message info
+	maxKeywords = 26
+)
+
+// systemFlags maps Maildir info letters to IMAP flags. Letters are sorted in
+// ASCII order, as required in the message info.
+var systemFlags = []struct {
+	letter byte
+	flag   imap.Flag
+}{
+	{'D', imap.FlagDraft},
+	{'F', imap.FlagFlagged},
+	{'P', imap.FlagForwarded},
+	{'R', imap.FlagAnswered},
+	{'S', imap.FlagSeen},
+	{'T', imap.FlagDeleted},
+}
+
+func systemFlagLetter(flag imap.Flag) (byte, bool) {
+	for _, sf := range systemFlags {
+		if strings.EqualFold(string(sf.flag), string(flag)) {
+			return sf.letter, true
+		}
+	}
+	return 0, false
+}
+
+// splitFilename splits a file name in the cur directory into the message
+// unique name and its info.
+func splitFilename(name string) (key, info string) {
+	if i := strings.Index(name, infoSep); i >= 0 {
+		return name[:i], name[i+len(infoSep):]
+	}
+	return name, ""
+}
+
+func hasInfoLetter(info string, letter byte) bool {
+	return strings.IndexByte(info, letter) >= 0
+}
+
+// sortInfo sorts the letters of a message info.
+func sortInfo(letters []byte) string {
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i] < letters[j]
+	})
+	return string(letters)
+}
+
+var uniqueCounter uint64
+
+// newUniqueName generates a unique name for a new message, following the
+// Maildir conventions.
+func newUniqueName() string {
+	hostname, _ := os.Hostname()
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+	now := time.Now()
+	return fmt.Sprintf("%v.M%vP%vQ%v.%v", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&uniqueCounter, 1), hostname)
+}
+
+// uidList is the content of the file which maps message unique names to UIDs.
+//
+// The first line contains the format version, UIDVALIDITY and UIDNEXT. Each
+// following line contains a UID and a unique name.
+type uidList struct {
+	uidValidity uint32
+	uidNext     imap.UID
+	uids        map[string]imap.UID
+}
+
+func readUIDList(dir string) (*uidList, error) {
+	l := &uidList{uids: make(map[string]imap.UID)}
+
+	f, err := os.Open(filepath.Join(dir, uidListName))
+	if os.IsNotExist(err) {
+		l.uidValidity = uint32(time.Now().Unix())
+		l.uidNext = 1
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("imapmaildir: empty UID list")
+	}
+	var version int
+	var uidNext uint32
+	if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d", &version, &l.uidValidity, &uidNext); err != nil {
+		return nil, fmt.Errorf("imapmaildir: malformed UID list header: %v", err)
+	} else if version != 1 {
+		return nil, fmt.Errorf("imapmaildir: unsupported UID list version %v", version)
+	}
+	l.uidNext = imap.UID(uidNext)
+
+	for scanner.Scan() {
+		uidStr, key, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		uid, err := strconv.ParseUint(uidStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("imapmaildir: malformed UID list entry: %v", err)
+		}
+		l.uids[key] = imap.UID(uid)
+	}
+	return l, scanner.Err()
+}
+
+func writeUIDList(dir string, uidValidity uint32, uidNext imap.UID, msgs []*message) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "1 %v %v\n", uidValidity, uidNext)
+	for _, msg := range msgs {
+		fmt.Fprintf(&sb, "%v %v\n", msg.uid, msg.key)
+	}
+	return writeFileAtomic(filepath.Join(dir, uidListName), []byte(sb.String()))
+}
+
+// readKeywords reads the list of keywords of a mailbox. Each line contains an
+// index and a keyword.
+func readKeywords(dir string) ([]imap.Flag, error) {
+	b, err := os.ReadFile(filepath.Join(dir, keywordsName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keywords []imap.Flag
+	for _, line := range strings.Split(string(b), "\n") {
+		indexStr, keyword, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(indexStr)
+		if err != nil || index < 0 || index >= maxKeywords {
+			return nil, fmt.Errorf("imapmaildir: malformed keywords entry %q", line)
+		}
+		for len(keywords) <= index {
+			keywords = append(keywords, "")
+		}
+		keywords[index] = imap.Flag(keyword)
+	}
+	return keywords, nil
+}
+
+func writeKeywords(dir string, keywords []imap.Flag) error {
+	var sb strings.Builder
+	for i, keyword := range keywords {
+		fmt.Fprintf(&sb, "%v %v\n", i, keyword)
+	}
+	return writeFileAtomic(filepath.Join(dir, keywordsName), []byte(sb.String()))
+}
+
+func readSubscriptions(root string) (map[string]struct{}, error) {
+	b, err := os.ReadFile(filepath.Join(root, subscriptionsName))
+	if os.IsNotExist(err) {
+		return make(map[string]struct{}), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make(map[string]struct{})
+	for _, name := range strings.Split(string(b), "\n") {
+		if name != "" {
+			subscriptions[name] = struct{}{}
+		}
+	}
+	return subscriptions, nil
+}
+
+func writeSubscriptions(root string, subscriptions map[string]struct{}) error {
+	names := make([]string, 0, len(subscriptions))
+	for name := range subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteString("\n")
+	}
+	return writeFileAtomic(filepath.Join(root, subscriptionsName), []byte(sb.String()))
+}
+
+func writeFileAtomic(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// createMaildir creates the cur, new and tmp directories of a Maildir.
+func createMaildir(dir string) error {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isMaildir(dir string) bool {
+	fi, err := os.Stat(filepath.Join(dir, "cur"))
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,144 @@
+package imapmaildir
+
+import (
+	"os"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/internal/imapmsg"
+)
+
+// message is a message stored in a Maildir.
+//
+// The message contents are read from disk on demand.
+type message struct {
+	// immutable
+	uid  imap.UID
+	key  string // Maildir unique name
+	t    time.Time
+	size int64
+
+	// mutable, protected by Mailbox.mutex
+	info string
+}
+
+func (msg *message) filename() string {
+	return msg.key + infoSep + msg.info
+}
+
+// flags returns the IMAP flags of the message. Keywords are looked up in the
+// mailbox keyword list.
+func (msg *message) flags(keywords []imap.Flag) []imap.Flag {
+	var flags []imap.Flag
+	for i := 0; i < len(msg.info); i++ {
+		c := msg.info[i]
+		if c >= 'a' && c <= 'z' {
+			if index := int(c - 'a'); index < len(keywords) && keywords[index] != "" {
+				flags = append(flags, keywords[index])
+			}
+			continue
+		}
+		for _, sf := range systemFlags {
+			if sf.letter == c {
+				flags = append(flags, sf.flag)
+				break
+			}
+		}
+	}
+	return flags
+}
+
+func (mbox *Mailbox) readMessageLocked(msg *message) ([]byte, error) {
+	return os.ReadFile(mbox.filePath(msg))
+}
+
+func (mbox *Mailbox) fetchMessageLocked(w *imapserver.FetchResponseWriter, msg *message, options *imap.FetchOptions) error {
+	w.WriteUID(msg.uid)
+
+	if options.Flags {
+		w.WriteFlags(msg.flags(mbox.keywords))
+	}
+
+	if options.Envelope || options.BodyStructure != nil || len(options.BodySection) > 0 {
+		buf, err := mbox.readMessageLocked(msg)
+		if err != nil {
+			return err
+		}
+		if err := imapmsg.Fetch(w, buf, msg.t, options); err != nil {
+			return err
+		}
+	} else {
+		if options.InternalDate {
+			w.WriteInternalDate(msg.t)
+		}
+		if options.RFC822Size {
+			w.WriteRFC822Size(msg.size)
+		}
+	}
+
+	return w.Close()
+}
+
+func (mbox *Mailbox) searchMessageLocked(msg *message, seqNum uint32, criteria *imap.SearchCriteria) (bool, error) {
+	for _, seqSet := range criteria.SeqNum {
+		if seqNum == 0 || !seqSet.Contains(seqNum) {
+			return false, nil
+		}
+	}
+	for _, seqSet := range criteria.UID {
+		if !seqSet.Contains(uint32(msg.uid)) {
+			return false, nil
+		}
+	}
+	flags := msg.flags(mbox.keywords)
+	for _, flag := range criteria.Flag {
+		if !containsFlag(flags, flag) {
+			return false, nil
+		}
+	}
+	for _, flag := range criteria.NotFlag {
+		if containsFlag(flags, flag) {
+			return false, nil
+		}
+	}
+
+	if needsContent(criteria) {
+		buf, err := mbox.readMessageLocked(msg)
+		if err != nil {
+			return false, err
+		}
+		if !imapmsg.Match(buf, msg.t, criteria) {
+			return false, nil
+		}
+	}
+
+	for _, not := range criteria.Not {
+		ok, err := mbox.searchMessageLocked(msg, seqNum, &not)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+	for _, or := range criteria.Or {
+		ok, err := mbox.searchMessageLocked(msg, seqNum, &or[0])
+		if err != nil {
+			return false, err
+		} else if !ok {
+			ok, err = mbox.searchMessageLocked(msg, seqNum, &or[1])
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// needsContent checks whether the criteria fields matched by imapmsg.Match
+// are set.
+func needsContent(criteria *imap.SearchCriteria) bool {
+	return !criteria.Since.IsZero() || !criteria.Before.IsZero() ||
+		!criteria.SentSince.IsZero() || !criteria.SentBefore.IsZero() ||
+		len(criteria.Header) > 0 || len(criteria.Body) > 0 || len(criteria.Text) > 0 ||
+		criteria.Larger != 0 || criteria.Smaller != 0
+}
@@ -0,0 +1,82 @@
+// Package imapmaildir implements an IMAP server backed by Maildir++
+// directories.
+//
+// Each mailbox is a Maildir folder. UIDs are kept stable across restarts in
+// an "imapmaildir-uidlist" file stored in each folder. IMAP system flags are
+// mapped to the standard Maildir info flags, and keywords are mapped to the
+// lowercase info letters listed in an "imapmaildir-keywords" file. Messages
+// delivered by other processes are picked up when the client polls for
+// updates.
+package imapmaildir
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+// idleScanInterval is the interval at which the Maildir is re-scanned while
+// a client is idling.
+const idleScanInterval = 5 * time.Second
+
+// Server is a server instance.
+//
+// A server contains a list of users.
+type Server struct {
+	mutex sync.Mutex
+	users map[string]*User
+}
+
+// New creates a new server.
+func New() *Server {
+	return &Server{
+		users: make(map[string]*User),
+	}
+}
+
+// NewSession creates a new IMAP session.
+func (s *Server) NewSession() imapserver.Session {
+	return &serverSession{server: s}
+}
+
+func (s *Server) user(username string) *User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.users[username]
+}
+
+// AddUser adds a user to the server.
+func (s *Server) AddUser(user *User) {
+	s.mutex.Lock()
+	s.users[user.username] = user
+	s.mutex.Unlock()
+}
+
+type serverSession struct {
+	*UserSession // may be nil
+
+	server *Server // immutable
+}
+
+var (
+	_ imapserver.Session   = (*serverSession)(nil)
+	_ imapserver.SessionID = (*serverSession)(nil)
+)
+
+func (sess *serverSession) Login(username, password string) error {
+	u := sess.server.user(username)
+	if u == nil {
+		return imapserver.ErrAuthFailed
+	}
+	if err := u.Login(username, password); err != nil {
+		return err
+	}
+	sess.UserSession = NewUserSession(u)
+	return nil
+}
+
+func (sess *serverSession) ID(clientID *imap.IDData) *imap.IDData {
+	return &imap.IDData{imap.IDName: "imapmaildir"}
+}
@@ -0,0 +1,195 @@
+package imapmaildir
+
+import (
+	"os"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+type (
+	user    = User
+	mailbox = MailboxView
+)
+
+// UserSession represents a session tied to a specific user.
+//
+// UserSession implements imapserver.Session. Typically, a UserSession pointer
+// is embedded into a larger struct which overrides Login.
+type UserSession struct {
+	*user    // immutable
+	*mailbox // may be nil
+}
+
+var _ imapserver.SessionIMAP4rev2 = (*UserSession)(nil)
+
+// NewUserSession creates a new user session.
+func NewUserSession(user *User) *UserSession {
+	return &UserSession{user: user}
+}
+
+func (sess *UserSession) Close() error {
+	if sess != nil && sess.mailbox != nil {
+		sess.mailbox.Close()
+	}
+	return nil
+}
+
+func (sess *UserSession) Select(name string, options *imap.SelectOptions) (*imap.SelectData, error) {
+	mbox, err := sess.user.mailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+	if err := mbox.scanLocked(); err != nil {
+		return nil, err
+	}
+	if sess.mailbox != nil {
+		sess.mailbox.Close()
+	}
+	sess.mailbox = mbox.NewView()
+	sess.mailbox.readOnly = options.ReadOnly
+	return mbox.selectDataLocked(), nil
+}
+
+func (sess *UserSession) Unselect() error {
+	sess.mailbox.Close()
+	sess.mailbox = nil
+	return nil
+}
+
+func (sess *UserSession) Copy(numKind imapserver.NumKind, seqSet imap.NumSet, destName string) (*imap.CopyData, error) {
+	dest, err := sess.user.mailbox(destName)
+	if err != nil {
+		return nil, errTryCreate
+	} else if dest == sess.mailbox.Mailbox {
+		return nil, errSameMailbox
+	}
+
+	msgs := sess.mailbox.messages(numKind, seqSet)
+	uidValidity, destUIDs, err := sess.copyMessages(dest, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceUIDs imap.NumSet
+	for _, msg := range msgs {
+		sourceUIDs.AddNum(uint32(msg.uid))
+	}
+
+	return &imap.CopyData{
+		UIDValidity: uidValidity,
+		SourceUIDs:  sourceUIDs,
+		DestUIDs:    destUIDs,
+	}, nil
+}
+
+// copyMessages appends copies of messages from the selected mailbox to dest.
+// It returns the UIDVALIDITY of dest and the UIDs of the copies.
+func (sess *UserSession) copyMessages(dest *Mailbox, msgs []*message) (uint32, imap.NumSet, error) {
+	var (
+		uidValidity uint32
+		destUIDs    imap.NumSet
+	)
+	for _, msg := range msgs {
+		sess.mailbox.mutex.Lock()
+		buf, err := sess.mailbox.readMessageLocked(msg)
+		flags := msg.flags(sess.mailbox.keywords)
+		sess.mailbox.mutex.Unlock()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		data, err := dest.appendBytes(buf, &imap.AppendOptions{
+			Time:  msg.t,
+			Flags: flags,
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+		uidValidity = data.UIDValidity
+		destUIDs.AddNum(uint32(data.UID))
+	}
+	return uidValidity, destUIDs, nil
+}
+
+func (sess *UserSession) Move(w *imapserver.MoveWriter, numKind imapserver.NumKind, seqSet imap.NumSet, destName string) error {
+	if sess.mailbox.readOnly {
+		return errReadOnly
+	}
+
+	dest, err := sess.user.mailbox(destName)
+	if err != nil {
+		return errTryCreate
+	} else if dest == sess.mailbox.Mailbox {
+		return errSameMailbox
+	}
+
+	msgs := sess.mailbox.messages(numKind, seqSet)
+	uidValidity, destUIDs, err := sess.copyMessages(dest, msgs)
+	if err != nil {
+		return err
+	}
+
+	var sourceUIDs imap.NumSet
+	for _, msg := range msgs {
+		sourceUIDs.AddNum(uint32(msg.uid))
+	}
+	err = w.WriteCopyData(&imap.CopyData{
+		UIDValidity: uidValidity,
+		SourceUIDs:  sourceUIDs,
+		DestUIDs:    destUIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	mbox := sess.mailbox
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	for _, msg := range msgs {
+		if err := os.Remove(mbox.filePath(msg)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// The expunge updates are queued by the scan, and are written to the
+	// client when the server polls for updates after the command
+	return mbox.scanLocked()
+}
+
+func (sess *UserSession) Expunge(w *imapserver.ExpungeWriter, uids *imap.NumSet) error {
+	if sess.mailbox.readOnly {
+		return errReadOnly
+	}
+	return sess.mailbox.expunge(uids)
+}
+
+func (sess *UserSession) Poll(w *imapserver.UpdateWriter, allowExpunge bool) error {
+	if sess.mailbox == nil {
+		return nil
+	}
+	return sess.mailbox.Poll(w, allowExpunge)
+}
+
+func (sess *UserSession) Idle(w *imapserver.UpdateWriter, stop <-chan struct{}) error {
+	if sess.mailbox == nil {
+		// Nothing to watch
+		<-stop
+		return nil
+	}
+	return sess.mailbox.Idle(w, stop)
+}
+
+var (
+	errSameMailbox = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Text: "Source and destination mailboxes are identical",
+	}
+	errReadOnly = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Text: "Mailbox is read-only",
+	}
+)
@@ -0,0 +1,380 @@
+package imapmaildir
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+const mailboxDelim rune = '/'
+
+// User is a user whose mailboxes are stored in a Maildir++ directory.
+//
+// INBOX is stored in the root directory. Other mailboxes are stored in
+// sub-directories whose name is the mailbox name prefixed with a dot, with
+// the hierarchy delimiter replaced with a dot. For instance, the mailbox
+// "Archive/2024" is stored in the ".Archive.2024" directory.
+type User struct {
+	username, password string
+	root               string
+
+	mutex     sync.Mutex
+	mailboxes map[string]*Mailbox // indexed by directory
+}
+
+// NewUser creates a new user whose mailboxes are stored in the Maildir++
+// directory root.
+func NewUser(username, password, root string) *User {
+	return &User{
+		username:  username,
+		password:  password,
+		root:      root,
+		mailboxes: make(map[string]*Mailbox),
+	}
+}
+
+func (u *User) Login(username, password string) error {
+	if username != u.username {
+		return imapserver.ErrAuthFailed
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(u.password)) != 1 {
+		return imapserver.ErrAuthFailed
+	}
+	return nil
+}
+
+// canonicalName returns the canonical form of a mailbox name: INBOX is
+// case-insensitive.
+func canonicalName(name string) string {
+	if strings.EqualFold(name, "INBOX") {
+		return "INBOX"
+	}
+	return name
+}
+
+// mailboxDir returns the directory of a mailbox.
+func (u *User) mailboxDir(name string) (string, error) {
+	name = canonicalName(name)
+	if name == "INBOX" {
+		return u.root, nil
+	}
+
+	for _, elem := range strings.Split(name, string(mailboxDelim)) {
+		if elem == "" || strings.ContainsAny(elem, ".\x00") {
+			return "", &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Code: imap.ResponseCodeCannot,
+				Text: "Invalid mailbox name",
+			}
+		}
+	}
+	return filepath.Join(u.root, "."+strings.ReplaceAll(name, string(mailboxDelim), ".")), nil
+}
+
+func (u *User) mailbox(name string) (*Mailbox, error) {
+	dir, err := u.mailboxDir(name)
+	if err != nil {
+		return nil, errNoSuchMailbox
+	}
+
+	if dir == u.root {
+		// INBOX always exists
+		if err := createMaildir(dir); err != nil {
+			return nil, err
+		}
+	} else if !isMaildir(dir) {
+		return nil, errNoSuchMailbox
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	mbox := u.mailboxes[dir]
+	if mbox == nil {
+		mbox = newMailbox(dir)
+		u.mailboxes[dir] = mbox
+	}
+	return mbox, nil
+}
+
+// mailboxNames returns the names of all mailboxes, sorted.
+func (u *User) mailboxNames() ([]string, error) {
+	entries, err := os.ReadDir(u.root)
+	if os.IsNotExist(err) {
+		return []string{"INBOX"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := []string{"INBOX"}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || len(name) < 2 || name[0] != '.' || name == ".." {
+			continue
+		}
+		if !isMaildir(filepath.Join(u.root, name)) {
+			continue
+		}
+		names = append(names, strings.ReplaceAll(name[1:], ".", string(mailboxDelim)))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (u *User) Status(name string, options *imap.StatusOptions) (*imap.StatusData, error) {
+	mbox, err := u.mailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := mbox.statusData(options)
+	if err != nil {
+		return nil, err
+	}
+	data.Mailbox = name
+	return data, nil
+}
+
+func (u *User) List(w *imapserver.ListWriter, ref string, patterns []string, options *imap.ListOptions) error {
+	if len(patterns) == 0 {
+		return w.WriteList(&imap.ListData{
+			Attrs: []imap.MailboxAttr{imap.MailboxAttrNoSelect},
+			Delim: mailboxDelim,
+		})
+	}
+
+	names, err := u.mailboxNames()
+	if err != nil {
+		return err
+	}
+	u.mutex.Lock()
+	subscriptions, err := readSubscriptions(u.root)
+	u.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		match := false
+		for _, pattern := range patterns {
+			if imapserver.MatchList(name, mailboxDelim, ref, pattern) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		_, subscribed := subscriptions[name]
+		if options.SelectSubscribed && !subscribed {
+			continue
+		}
+
+		data := imap.ListData{
+			Mailbox: name,
+			Delim:   mailboxDelim,
+		}
+		if subscribed {
+			data.Attrs = append(data.Attrs, imap.MailboxAttrSubscribed)
+		}
+		if options.ReturnStatus != nil {
+			mbox, err := u.mailbox(name)
+			if err != nil {
+				return err
+			}
+			data.Status, err = mbox.statusData(options.ReturnStatus)
+			if err != nil {
+				return err
+			}
+			data.Status.Mailbox = name
+		}
+		if err := w.WriteList(&data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *User) Append(mailbox string, r imap.LiteralReader, options *imap.AppendOptions) (*imap.AppendData, error) {
+	mbox, err := u.mailbox(mailbox)
+	if err != nil {
+		return nil, errTryCreate
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return mbox.appendBytes(buf.Bytes(), options)
+}
+
+func (u *User) Create(name string, options *imap.CreateOptions) error {
+	name = strings.TrimRight(name, string(mailboxDelim))
+
+	dir, err := u.mailboxDir(name)
+	if err != nil {
+		return err
+	}
+	if isMaildir(dir) {
+		return errAlreadyExists
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return createMaildir(dir)
+}
+
+func (u *User) Delete(name string) error {
+	if canonicalName(name) == "INBOX" {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeCannot,
+			Text: "INBOX cannot be deleted",
+		}
+	}
+
+	dir, err := u.mailboxDir(name)
+	if err != nil || !isMaildir(dir) {
+		return errNoSuchMailbox
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	u.forgetMailboxLocked(dir)
+	return nil
+}
+
+// Rename renames a mailbox and its children.
+func (u *User) Rename(oldName, newName string) error {
+	newName = strings.TrimRight(newName, string(mailboxDelim))
+	if canonicalName(oldName) == "INBOX" || canonicalName(newName) == "INBOX" {
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeCannot,
+			Text: "INBOX cannot be renamed",
+		}
+	}
+
+	oldDir, err := u.mailboxDir(oldName)
+	if err != nil || !isMaildir(oldDir) {
+		return errNoSuchMailbox
+	}
+	newDir, err := u.mailboxDir(newName)
+	if err != nil {
+		return err
+	}
+	if isMaildir(newDir) {
+		return errAlreadyExists
+	}
+
+	names, err := u.mailboxNames()
+	if err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return err
+	}
+	u.forgetMailboxLocked(oldDir)
+
+	prefix := oldName + string(mailboxDelim)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		childOldDir, _ := u.mailboxDir(name)
+		childNewDir, err := u.mailboxDir(newName + string(mailboxDelim) + strings.TrimPrefix(name, prefix))
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(childOldDir, childNewDir); err != nil {
+			return err
+		}
+		u.forgetMailboxLocked(childOldDir)
+	}
+
+	return nil
+}
+
+// forgetMailboxLocked drops a mailbox which has been deleted or renamed.
+//
+// Sessions which have selected the mailbox keep a reference to it: the
+// mailbox is invalidated so that these sessions see all of its messages as
+// expunged, instead of failing to access the old directory.
+func (u *User) forgetMailboxLocked(dir string) {
+	if mbox := u.mailboxes[dir]; mbox != nil {
+		mbox.invalidate()
+	}
+	delete(u.mailboxes, dir)
+}
+
+func (u *User) Subscribe(name string) error {
+	if _, err := u.mailbox(name); err != nil {
+		return err
+	}
+	return u.setSubscribed(canonicalName(name), true)
+}
+
+func (u *User) Unsubscribe(name string) error {
+	return u.setSubscribed(canonicalName(name), false)
+}
+
+func (u *User) setSubscribed(name string, subscribed bool) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	subscriptions, err := readSubscriptions(u.root)
+	if err != nil {
+		return err
+	}
+	if _, ok := subscriptions[name]; ok == subscribed {
+		return nil
+	}
+	if subscribed {
+		subscriptions[name] = struct{}{}
+	} else {
+		delete(subscriptions, name)
+	}
+	if err := os.MkdirAll(u.root, 0700); err != nil {
+		return err
+	}
+	return writeSubscriptions(u.root, subscriptions)
+}
+
+func (u *User) Namespace() (*imap.NamespaceData, error) {
+	return &imap.NamespaceData{
+		Personal: []imap.NamespaceDescriptor{{Delim: mailboxDelim}},
+	}, nil
+}
+
+var (
+	errNoSuchMailbox = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeNonExistent,
+		Text: "No such mailbox",
+	}
+	errTryCreate = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeTryCreate,
+		Text: "No such mailbox",
+	}
+	errAlreadyExists = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeAlreadyExists,
+		Text: "Mailbox already exists",
+	}
+)
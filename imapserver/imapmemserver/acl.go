@@ -0,0 +1,269 @@
+package imapmemserver
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// sharedPrefix is the prefix of the namespace containing mailboxes shared by
+// other users. Shared mailboxes are named "Other Users/<owner>/<mailbox>".
+const sharedPrefix = "Other Users/"
+
+func isSharedMailbox(name string) bool {
+	return strings.HasPrefix(name, sharedPrefix)
+}
+
+// listShared returns the LIST data for the mailboxes shared with the user by
+// other users.
+func (u *User) listShared(match func(name string) bool, options *imap.ListOptions) []imap.ListData {
+	if u.server == nil {
+		return nil
+	}
+
+	var l []imap.ListData
+	for _, other := range u.server.userList() {
+		if other == u {
+			continue
+		}
+		for name, mbox := range other.mailboxList() {
+			name = sharedPrefix + other.username + string(mailboxDelim) + name
+			if !match(name) {
+				continue
+			}
+
+			rights := mbox.rights(u.username)
+			if !rights.Contains(imap.RightLookup) {
+				continue
+			}
+
+			data := mbox.list(options)
+			if data == nil {
+				continue
+			}
+			data.Mailbox = name
+			if data.Status != nil {
+				if rights.Contains(imap.RightRead) {
+					data.Status.Mailbox = name
+				} else {
+					data.Status = nil
+				}
+			}
+			if options.ReturnMyRights {
+				data.MyRights = &imap.MyRightsData{Mailbox: name, Rights: rights}
+			}
+			l = append(l, *data)
+		}
+	}
+	return l
+}
+
+// rights returns the rights a user has on the mailbox.
+//
+// The owner of the mailbox always has all rights.
+func (mbox *Mailbox) rights(username string) imap.RightSet {
+	if mbox.owner == "" || mbox.owner == username {
+		return imap.RightSetAll
+	}
+
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+	rights := mbox.acl[imap.RightsIdentifierAnyone]
+	return rights.Add(mbox.acl[imap.RightsIdentifier(username)])
+}
+
+// sharedMailbox looks up a mailbox in the shared namespace.
+func (u *User) sharedMailbox(name string) (*Mailbox, error) {
+	owner, name, _ := strings.Cut(strings.TrimPrefix(name, sharedPrefix), string(mailboxDelim))
+	var other *User
+	if u.server != nil && owner != u.username {
+		other = u.server.user(owner)
+	}
+	if other == nil {
+		return nil, errNoSuchMailbox
+	}
+
+	mbox, err := other.mailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	// Don't disclose the existence of mailboxes the user has no rights on
+	if len(mbox.rights(u.username)) == 0 {
+		return nil, errNoSuchMailbox
+	}
+	return mbox, nil
+}
+
+// mailboxWithRights looks up a mailbox and checks that the user has the
+// specified rights on it.
+func (u *User) mailboxWithRights(name string, rights imap.RightSet) (*Mailbox, error) {
+	mbox, err := u.mailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRights(mbox.rights(u.username), rights); err != nil {
+		return nil, err
+	}
+	return mbox, nil
+}
+
+// writeRights are the rights allowing a user to modify the contents of a
+// mailbox. Mailboxes are selected in read-only mode if the user has none of
+// them.
+var writeRights = imap.RightSet{imap.RightSeen, imap.RightWrite, imap.RightInsert, imap.RightDeleteMessages, imap.RightExpunge}
+
+func hasWriteRights(rights imap.RightSet) bool {
+	for _, right := range writeRights {
+		if rights.Contains(right) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandObsoleteRights replaces the obsolete RFC 2086 rights "c" and "d" with
+// their RFC 4314 equivalents, see RFC 4314 section 2.1.1.
+func expandObsoleteRights(rights imap.RightSet) imap.RightSet {
+	var expanded imap.RightSet
+	for _, right := range rights {
+		switch right {
+		case 'c':
+			expanded = expanded.Add(imap.RightSet{imap.RightCreateMailbox})
+		case 'd':
+			expanded = expanded.Add(imap.RightSet{imap.RightDeleteMailbox, imap.RightDeleteMessages, imap.RightExpunge})
+		default:
+			expanded = expanded.Add(imap.RightSet{right})
+		}
+	}
+	return expanded
+}
+
+func checkRights(have, want imap.RightSet) error {
+	for _, right := range want {
+		if !have.Contains(right) {
+			return &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Code: imap.ResponseCodeNoPerm,
+				Text: "Permission denied",
+			}
+		}
+	}
+	return nil
+}
+
+func (u *User) SetACL(mailbox string, ri imap.RightsIdentifier, rm imap.RightModification, rights imap.RightSet) error {
+	rights = expandObsoleteRights(rights)
+	for _, right := range rights {
+		if !imap.RightSetAll.Contains(right) {
+			return &imap.Error{
+				Type: imap.StatusResponseTypeBad,
+				Text: "Unsupported right: " + string(right),
+			}
+		}
+	}
+
+	mbox, err := u.mailboxWithRights(mailbox, imap.RightSet{imap.RightAdminister})
+	if err != nil {
+		return err
+	}
+	if ri == imap.RightsIdentifier(mbox.owner) {
+		return errOwnerRights
+	}
+
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	switch rm {
+	case imap.RightModificationAdd:
+		rights = mbox.acl[ri].Add(rights)
+	case imap.RightModificationRemove:
+		rights = mbox.acl[ri].Remove(rights)
+	default:
+		rights = imap.RightSet(nil).Add(rights)
+	}
+	if len(rights) == 0 {
+		delete(mbox.acl, ri)
+	} else {
+		mbox.acl[ri] = rights
+	}
+	return nil
+}
+
+func (u *User) DeleteACL(mailbox string, ri imap.RightsIdentifier) error {
+	mbox, err := u.mailboxWithRights(mailbox, imap.RightSet{imap.RightAdminister})
+	if err != nil {
+		return err
+	}
+	if ri == imap.RightsIdentifier(mbox.owner) {
+		return errOwnerRights
+	}
+
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+	delete(mbox.acl, ri)
+	return nil
+}
+
+func (u *User) GetACL(mailbox string) (*imap.GetACLData, error) {
+	mbox, err := u.mailboxWithRights(mailbox, imap.RightSet{imap.RightAdminister})
+	if err != nil {
+		return nil, err
+	}
+
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	data := imap.GetACLData{
+		Mailbox: mailbox,
+		Rights:  make(map[imap.RightsIdentifier]imap.RightSet, len(mbox.acl)+1),
+	}
+	for ri, rights := range mbox.acl {
+		data.Rights[ri] = rights
+	}
+	if mbox.owner != "" {
+		data.Rights[imap.RightsIdentifier(mbox.owner)] = imap.RightSetAll
+	}
+	return &data, nil
+}
+
+func (u *User) ListRights(mailbox string, ri imap.RightsIdentifier) (*imap.ListRightsData, error) {
+	mbox, err := u.mailboxWithRights(mailbox, imap.RightSet{imap.RightAdminister})
+	if err != nil {
+		return nil, err
+	}
+
+	data := imap.ListRightsData{
+		Mailbox:    mailbox,
+		Identifier: ri,
+	}
+	if ri == imap.RightsIdentifier(mbox.owner) {
+		data.RequiredRights = imap.RightSetAll
+	} else {
+		for _, right := range imap.RightSetAll {
+			data.OptionalRights = append(data.OptionalRights, imap.RightSet{right})
+		}
+	}
+	return &data, nil
+}
+
+func (u *User) MyRights(mailbox string) (*imap.MyRightsData, error) {
+	mbox, err := u.mailbox(mailbox)
+	if err != nil {
+		return nil, err
+	}
+	return &imap.MyRightsData{
+		Mailbox: mailbox,
+		Rights:  mbox.rights(u.username),
+	}, nil
+}
+
+var errSharedNamespace = &imap.Error{
+	Type: imap.StatusResponseTypeNo,
+	Code: imap.ResponseCodeNoPerm,
+	Text: "Operation not permitted in the shared namespace",
+}
+
+var errOwnerRights = &imap.Error{
+	Type: imap.StatusResponseTypeNo,
+	Text: "The rights of the mailbox owner cannot be changed",
+}
@@ -17,12 +17,31 @@ import (
 type Mailbox struct {
 	tracker     *imapserver.MailboxTracker
 	uidValidity uint32
+	quota       *quota // may be nil
+	owner       string // empty if the mailbox isn't owned by a user
 
 	mutex      sync.Mutex
 	name       string
 	subscribed bool
 	l          []*message
 	uidNext    imap.UID
+	modSeq     uint64             // highest mod-sequence
+	expunged   []expungedMessages // oldest first
+	metadata   map[string][]byte
+	acl        map[imap.RightsIdentifier]imap.RightSet
+	watchers   map[*notifier]struct{}
+}
+
+// maxExpungedHistory is the maximum number of expunge operations remembered
+// individually for QRESYNC. Older operations are merged together: clients may
+// then receive VANISHED responses for messages expunged before the
+// mod-sequence they've asked about, which RFC 7162 allows.
+const maxExpungedHistory = 64
+
+// expungedMessages is a set of messages expunged at the same mod-sequence.
+type expungedMessages struct {
+	uids   imap.NumSet
+	modSeq uint64
 }
 
 // NewMailbox creates a new mailbox.
@@ -32,6 +51,10 @@ func NewMailbox(name string, uidValidity uint32) *Mailbox {
 		uidValidity: uidValidity,
 		name:        name,
 		uidNext:     1,
+		modSeq:      1,
+		metadata:    make(map[string][]byte),
+		acl:         make(map[imap.RightsIdentifier]imap.RightSet),
+		watchers:    make(map[*notifier]struct{}),
 	}
 }
 
@@ -87,6 +110,9 @@ func (mbox *Mailbox) statusDataLocked(options *imap.StatusOptions) *imap.StatusD
 		size := mbox.sizeLocked()
 		data.Size = &size
 	}
+	if options.HighestModSeq {
+		data.HighestModSeq = mbox.modSeq
+	}
 	return &data
 }
 
@@ -113,17 +139,47 @@ func (mbox *Mailbox) appendLiteral(r imap.LiteralReader, options *imap.AppendOpt
 	if _, err := buf.ReadFrom(r); err != nil {
 		return nil, err
 	}
-	return mbox.appendBytes(buf.Bytes(), options), nil
+	return mbox.appendBytes(buf.Bytes(), options)
 }
 
-func (mbox *Mailbox) copyMsg(msg *message) *imap.AppendData {
-	return mbox.appendBytes(msg.buf, &imap.AppendOptions{
-		Time:  msg.t,
-		Flags: msg.flagList(),
-	})
+// copyMsgs appends copies of messages to the mailbox. If the quota doesn't
+// allow for all of the messages, none are copied.
+//
+// If moveFrom is non-nil, the caller will expunge the messages from moveFrom
+// afterwards. When both mailboxes share the same quota, the usage won't grow,
+// so limits aren't checked.
+func (mbox *Mailbox) copyMsgs(msgs []*message, moveFrom *Mailbox) ([]imap.UID, error) {
+	var size int64
+	for _, msg := range msgs {
+		size += int64(len(msg.buf))
+	}
+	if moveFrom != nil && moveFrom.quota == mbox.quota {
+		mbox.quota.add(int64(len(msgs)), size)
+	} else if err := mbox.quota.reserve(int64(len(msgs)), size); err != nil {
+		return nil, err
+	}
+
+	uids := make([]imap.UID, len(msgs))
+	for i, msg := range msgs {
+		data := mbox.appendReserved(msg.buf, &imap.AppendOptions{
+			Time:  msg.t,
+			Flags: msg.flagList(),
+		})
+		uids[i] = data.UID
+	}
+	return uids, nil
+}
+
+func (mbox *Mailbox) appendBytes(buf []byte, options *imap.AppendOptions) (*imap.AppendData, error) {
+	if err := mbox.quota.reserve(1, int64(len(buf))); err != nil {
+		return nil, err
+	}
+	return mbox.appendReserved(buf, options), nil
 }
 
-func (mbox *Mailbox) appendBytes(buf []byte, options *imap.AppendOptions) *imap.AppendData {
+// appendReserved appends a message whose size has already been accounted for
+// in the quota.
+func (mbox *Mailbox) appendReserved(buf []byte, options *imap.AppendOptions) *imap.AppendData {
 	msg := &message{
 		flags: make(map[imap.Flag]struct{}),
 		buf:   buf,
@@ -144,9 +200,11 @@ func (mbox *Mailbox) appendBytes(buf []byte, options *imap.AppendOptions) *imap.
 
 	msg.uid = mbox.uidNext
 	mbox.uidNext++
+	msg.modSeq = mbox.nextModSeqLocked()
 
 	mbox.l = append(mbox.l, msg)
 	mbox.tracker.QueueNumMessages(uint32(len(mbox.l)))
+	mbox.notifyLocked(imap.NotifyEventMessageNew)
 
 	return &imap.AppendData{
 		UIDValidity: mbox.uidValidity,
@@ -154,6 +212,12 @@ func (mbox *Mailbox) appendBytes(buf []byte, options *imap.AppendOptions) *imap.
 	}
 }
 
+// nextModSeqLocked allocates a new mod-sequence.
+func (mbox *Mailbox) nextModSeqLocked() uint64 {
+	mbox.modSeq++
+	return mbox.modSeq
+}
+
 func (mbox *Mailbox) rename(newName string) {
 	mbox.mutex.Lock()
 	mbox.name = newName
@@ -180,6 +244,7 @@ func (mbox *Mailbox) selectDataLocked() *imap.SelectData {
 		NumMessages:    uint32(len(mbox.l)),
 		UIDNext:        mbox.uidNext,
 		UIDValidity:    mbox.uidValidity,
+		HighestModSeq:  mbox.modSeq,
 	}
 }
 
@@ -227,17 +292,30 @@ func (mbox *Mailbox) Expunge(w *imapserver.ExpungeWriter, uids *imap.NumSet) err
 	return nil
 }
 
-func (mbox *Mailbox) expungeLocked(expunged map[*message]struct{}) (seqNums []uint32) {
+func (mbox *Mailbox) expungeLocked(expunged map[*message]struct{}) (seqNums []uint32, uids []imap.UID) {
 	// TODO: optimize
 
+	if len(expunged) == 0 {
+		return nil, nil
+	}
+	modSeq := mbox.nextModSeqLocked()
+
 	// Iterate in reverse order, to keep sequence numbers consistent
-	var filtered []*message
+	var (
+		filtered    []*message
+		size        int64
+		expungedSet imap.NumSet
+	)
 	for i := len(mbox.l) - 1; i >= 0; i-- {
 		msg := mbox.l[i]
 		if _, ok := expunged[msg]; ok {
+			size += int64(len(msg.buf))
 			seqNum := uint32(i) + 1
 			seqNums = append(seqNums, seqNum)
-			mbox.tracker.QueueExpunge(seqNum)
+			uids = append(uids, msg.uid)
+			mbox.tracker.QueueExpungeUID(seqNum, msg.uid)
+			mbox.notifyLocked(imap.NotifyEventMessageExpunge)
+			expungedSet.AddNum(uint32(msg.uid))
 		} else {
 			filtered = append(filtered, msg)
 		}
@@ -250,8 +328,24 @@ func (mbox *Mailbox) expungeLocked(expunged map[*message]struct{}) (seqNums []ui
 	}
 
 	mbox.l = filtered
+	mbox.quota.release(int64(len(seqNums)), size)
+	mbox.addExpungedLocked(expungedMessages{uids: expungedSet, modSeq: modSeq})
+
+	return seqNums, uids
+}
 
-	return seqNums
+func (mbox *Mailbox) addExpungedLocked(expunged expungedMessages) {
+	mbox.expunged = append(mbox.expunged, expunged)
+	if len(mbox.expunged) <= maxExpungedHistory {
+		return
+	}
+
+	// Merge the two oldest entries, keeping the most recent mod-sequence
+	merged := expungedMessages{modSeq: mbox.expunged[1].modSeq}
+	merged.uids.AddSet(mbox.expunged[0].uids)
+	merged.uids.AddSet(mbox.expunged[1].uids)
+	mbox.expunged[1] = merged
+	mbox.expunged = append(mbox.expunged[:0], mbox.expunged[1:]...)
 }
 
 // NewView creates a new view into this mailbox.
@@ -274,7 +368,8 @@ func (mbox *Mailbox) NewView() *MailboxView {
 // selected state.
 type MailboxView struct {
 	*Mailbox
-	tracker *imapserver.SessionTracker
+	tracker  *imapserver.SessionTracker
+	readOnly bool
 }
 
 // Close releases the resources allocated for the mailbox view.
@@ -283,11 +378,24 @@ func (mbox *MailboxView) Close() {
 }
 
 func (mbox *MailboxView) Fetch(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, options *imap.FetchOptions) error {
+	return mbox.fetch(w, numKind, seqSet, options, true)
+}
+
+// fetch is like Fetch, but only sets the \Seen flag if allowSeen is true.
+func (mbox *MailboxView) fetch(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, options *imap.FetchOptions, allowSeen bool) error {
 	markSeen := false
-	for _, bs := range options.BodySection {
-		if !bs.Peek {
-			markSeen = true
-			break
+	if allowSeen && !mbox.readOnly {
+		for _, bs := range options.BodySection {
+			if !bs.Peek {
+				markSeen = true
+				break
+			}
+		}
+	}
+
+	if options.Vanished {
+		if err := mbox.writeVanished(w, seqSet, options.ChangedSince); err != nil {
+			return err
 		}
 	}
 
@@ -297,9 +405,15 @@ func (mbox *MailboxView) Fetch(w *imapserver.FetchWriter, numKind imapserver.Num
 			return
 		}
 
-		if markSeen {
+		if options.ChangedSince != 0 && msg.modSeq <= options.ChangedSince {
+			return
+		}
+
+		if _, ok := msg.flags[canonicalFlag(imap.FlagSeen)]; markSeen && !ok {
 			msg.flags[canonicalFlag(imap.FlagSeen)] = struct{}{}
-			mbox.Mailbox.tracker.QueueMessageFlags(seqNum, msg.uid, msg.flagList(), nil)
+			msg.modSeq = mbox.nextModSeqLocked()
+			mbox.Mailbox.tracker.QueueMessageFlagsModSeq(seqNum, msg.uid, msg.flagList(), msg.modSeq, nil)
+			mbox.notifyLocked(imap.NotifyEventFlagChange)
 		}
 
 		respWriter := w.CreateMessage(mbox.tracker.EncodeSeqNum(seqNum))
@@ -308,10 +422,63 @@ func (mbox *MailboxView) Fetch(w *imapserver.FetchWriter, numKind imapserver.Num
 	return err
 }
 
+func (mbox *MailboxView) writeVanished(w *imapserver.FetchWriter, uidSet imap.NumSet, changedSince uint64) error {
+	var uids imap.NumSet
+	mbox.mutex.Lock()
+	mbox.staticNumSet(uidSet, imapserver.NumKindUID)
+	for _, expunged := range mbox.expunged {
+		if expunged.modSeq <= changedSince {
+			continue
+		}
+		for _, r := range expunged.uids {
+			for _, q := range uidSet {
+				start, stop := r.Start, r.Stop
+				if q.Start > start {
+					start = q.Start
+				}
+				if q.Stop < stop {
+					stop = q.Stop
+				}
+				if start <= stop {
+					uids.AddRange(start, stop)
+				}
+			}
+		}
+	}
+	mbox.mutex.Unlock()
+
+	if len(uids) == 0 {
+		return nil
+	}
+	return w.WriteVanished(uids)
+}
+
 func (mbox *MailboxView) Search(numKind imapserver.NumKind, criteria *imap.SearchCriteria, options *imap.SearchOptions) (*imap.SearchData, error) {
 	mbox.mutex.Lock()
 	defer mbox.mutex.Unlock()
 
+	data := imap.SearchData{
+		UID: numKind == imapserver.NumKindUID,
+	}
+
+	mbox.searchLocked(numKind, criteria, func(num uint32, msg *message) {
+		data.All.AddNum(num)
+		if criteria.ModSeq != nil && msg.modSeq > data.ModSeq {
+			data.ModSeq = msg.modSeq
+		}
+		if data.Min == 0 || num < data.Min {
+			data.Min = num
+		}
+		if data.Max == 0 || num > data.Max {
+			data.Max = num
+		}
+		data.Count++
+	})
+
+	return &data, nil
+}
+
+func (mbox *MailboxView) searchLocked(numKind imapserver.NumKind, criteria *imap.SearchCriteria, f func(num uint32, msg *message)) {
 	for _, seqSet := range criteria.SeqNum {
 		mbox.staticNumSet(seqSet, imapserver.NumKindSeq)
 	}
@@ -319,10 +486,6 @@ func (mbox *MailboxView) Search(numKind imapserver.NumKind, criteria *imap.Searc
 		mbox.staticNumSet(seqSet, imapserver.NumKindUID)
 	}
 
-	data := imap.SearchData{
-		UID: numKind == imapserver.NumKindUID,
-	}
-
 	for i, msg := range mbox.l {
 		seqNum := mbox.tracker.EncodeSeqNum(uint32(i) + 1)
 
@@ -340,26 +503,38 @@ func (mbox *MailboxView) Search(numKind imapserver.NumKind, criteria *imap.Searc
 		if num == 0 {
 			continue
 		}
-		data.All.AddNum(num)
-		if data.Min == 0 || num < data.Min {
-			data.Min = num
-		}
-		if data.Max == 0 || num > data.Max {
-			data.Max = num
-		}
-		data.Count++
+		f(num, msg)
 	}
-
-	return &data, nil
 }
 
 func (mbox *MailboxView) Store(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, flags *imap.StoreFlags, options *imap.StoreOptions) error {
+	var modified imap.NumSet
 	mbox.forEach(numKind, seqSet, func(seqNum uint32, msg *message) {
-		msg.store(flags)
-		mbox.Mailbox.tracker.QueueMessageFlags(seqNum, msg.uid, msg.flagList(), mbox.tracker)
+		if options.UnchangedSince != 0 && msg.modSeq > options.UnchangedSince {
+			switch numKind {
+			case imapserver.NumKindSeq:
+				modified.AddNum(mbox.tracker.EncodeSeqNum(seqNum))
+			case imapserver.NumKindUID:
+				modified.AddNum(uint32(msg.uid))
+			}
+			return
+		}
+		if !msg.store(flags) {
+			return
+		}
+		msg.modSeq = mbox.nextModSeqLocked()
+		mbox.Mailbox.tracker.QueueMessageFlagsModSeq(seqNum, msg.uid, msg.flagList(), msg.modSeq, mbox.tracker)
+		mbox.notifyLocked(imap.NotifyEventFlagChange)
 	})
 	if !flags.Silent {
-		return mbox.Fetch(w, numKind, seqSet, &imap.FetchOptions{Flags: true})
+		// MODSEQ is only written if CONDSTORE is enabled
+		err := mbox.Fetch(w, numKind, seqSet, &imap.FetchOptions{Flags: true, ModSeq: true})
+		if err != nil {
+			return err
+		}
+	}
+	if len(modified) > 0 {
+		return &imapserver.ModifiedError{NumSet: modified}
 	}
 	return nil
 }
@@ -0,0 +1,182 @@
+package imapmemserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/internal/imapmsg"
+)
+
+// mboxFromQuoteRegexp matches lines which need to be quoted in an mbox file,
+// following the mboxrd convention.
+var mboxFromQuoteRegexp = regexp.MustCompile(`^>*From `)
+
+// mboxDateLayouts are the date formats accepted in From_ lines.
+var mboxDateLayouts = []string{
+	time.ANSIC,
+	"Mon Jan _2 15:04:05 2006 -0700",
+	"Mon Jan _2 15:04:05 MST 2006",
+	"Mon Jan _2 15:04 2006",
+}
+
+// ImportMbox appends the messages of an mbox file to the mailbox.
+//
+// The file is parsed as described in RFC 4155, with the mboxrd quoting
+// convention: one level of ">" is removed from lines starting with ">From ".
+// The date in the From_ line is used as the message internal date. Line
+// endings are converted to CRLF.
+//
+// The number of imported messages is returned, even if an error occurs.
+func (mbox *Mailbox) ImportMbox(r io.Reader) (int, error) {
+	n := 0
+	err := readMbox(r, func(buf []byte, t time.Time) error {
+		if _, err := mbox.appendBytes(buf, &imap.AppendOptions{Time: t}); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// ExportMbox writes the messages of the mailbox in the mbox format.
+//
+// See ImportMbox for details about the format.
+func (mbox *Mailbox) ExportMbox(w io.Writer) error {
+	mbox.mutex.Lock()
+	msgs := make([]*message, len(mbox.l))
+	copy(msgs, mbox.l)
+	mbox.mutex.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, msg := range msgs {
+		if err := writeMboxMessage(bw, msg.buf, msg.t); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportMbox appends the messages of an mbox file to a mailbox.
+//
+// See Mailbox.ImportMbox.
+func (u *User) ImportMbox(mailbox string, r io.Reader) (int, error) {
+	mbox, err := u.mailbox(mailbox)
+	if err != nil {
+		return 0, err
+	}
+	return mbox.ImportMbox(r)
+}
+
+// ExportMbox writes the messages of a mailbox in the mbox format.
+//
+// See Mailbox.ExportMbox.
+func (u *User) ExportMbox(mailbox string, w io.Writer) error {
+	mbox, err := u.mailbox(mailbox)
+	if err != nil {
+		return err
+	}
+	return mbox.ExportMbox(w)
+}
+
+func readMbox(r io.Reader, f func(buf []byte, t time.Time) error) error {
+	br := bufio.NewReader(r)
+
+	var (
+		buf     bytes.Buffer
+		t       time.Time
+		started bool
+	)
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		// The empty line preceding a From_ line is part of the separator
+		b := bytes.TrimSuffix(buf.Bytes(), []byte("\r\n"))
+		msg := make([]byte, len(b))
+		copy(msg, b)
+		buf.Reset()
+		return f(msg, t)
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		} else if err == io.EOF && line == "" {
+			break
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "From ") {
+			if err := flush(); err != nil {
+				return err
+			}
+			t = parseMboxFromLine(line)
+			started = true
+		} else if !started {
+			if line == "" {
+				continue
+			}
+			return fmt.Errorf("imapmemserver: invalid mbox file: expected From_ line, got %q", line)
+		} else {
+			if mboxFromQuoteRegexp.MatchString(line) {
+				line = line[1:]
+			}
+			buf.WriteString(line)
+			buf.WriteString("\r\n")
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return flush()
+}
+
+// parseMboxFromLine extracts the date of a From_ line. The zero time is
+// returned if the date cannot be parsed.
+func parseMboxFromLine(line string) time.Time {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+	s := strings.Join(fields[2:], " ")
+	for _, layout := range mboxDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func writeMboxMessage(w *bufio.Writer, buf []byte, t time.Time) error {
+	sender := "MAILER-DAEMON"
+	if envelope := imapmsg.ReadEnvelope(buf); envelope != nil && len(envelope.From) > 0 {
+		if addr := envelope.From[0].Addr(); addr != "" && !strings.ContainsAny(addr, " \t") {
+			sender = addr
+		}
+	}
+	if _, err := fmt.Fprintf(w, "From %v %v\n", sender, t.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+
+	s := strings.ReplaceAll(string(buf), "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	for _, line := range strings.Split(s, "\n") {
+		if mboxFromQuoteRegexp.MatchString(line) {
+			w.WriteString(">")
+		}
+		w.WriteString(line)
+		w.WriteString("\n")
+	}
+	_, err := w.WriteString("\n")
+	return err
+}
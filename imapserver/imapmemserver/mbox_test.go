@@ -0,0 +1,57 @@
+package imapmemserver_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+const testMbox = `From alice@example.org Mon Nov 24 18:22:48 1986
+From: alice@example.org
+Subject: First
+
+Hello Bob!
+>From the start, this line was quoted.
+>>From here, too.
+
+From bob@example.org Tue Nov 25 08:00:00 1986
+From: bob@example.org
+Subject: Second
+
+Hi Alice!
+
+`
+
+func TestMbox(t *testing.T) {
+	user := imapmemserver.NewUser("user", "password")
+	user.Create("INBOX", nil)
+
+	n, err := user.ImportMbox("INBOX", strings.NewReader(testMbox))
+	if err != nil {
+		t.Fatalf("ImportMbox() = %v", err)
+	} else if n != 2 {
+		t.Errorf("ImportMbox() = %v, want 2", n)
+	}
+
+	data, err := user.Status("INBOX", &imap.StatusOptions{NumMessages: true})
+	if err != nil {
+		t.Fatalf("Status() = %v", err)
+	} else if *data.NumMessages != 2 {
+		t.Errorf("Status().NumMessages = %v, want 2", *data.NumMessages)
+	}
+
+	var buf bytes.Buffer
+	if err := user.ExportMbox("INBOX", &buf); err != nil {
+		t.Fatalf("ExportMbox() = %v", err)
+	}
+	if buf.String() != testMbox {
+		t.Errorf("ExportMbox() = \n%v\nwant:\n%v", buf.String(), testMbox)
+	}
+
+	if _, err := user.ImportMbox("INBOX", strings.NewReader("Subject: Not an mbox\n")); err == nil {
+		t.Errorf("ImportMbox() with invalid file succeeded")
+	}
+}
@@ -0,0 +1,86 @@
+package imapmemserver
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+// maxMetadataSize is the maximum size of a metadata entry value.
+const maxMetadataSize = 64 * 1024
+
+func (u *User) GetMetadata(mailbox string, entries []string, options *imap.GetMetadataOptions) (*imap.GetMetadataData, error) {
+	data := imap.GetMetadataData{
+		Mailbox:     mailbox,
+		EntryValues: make(map[string]*[]byte),
+	}
+	err := u.withMetadata(mailbox, func(metadata map[string][]byte) {
+		for _, entry := range entries {
+			entry = strings.ToLower(entry)
+			for name, value := range metadata {
+				if matchMetadataEntry(name, entry, options.Depth) {
+					b := append([]byte(nil), value...)
+					data.EntryValues[name] = &b
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (u *User) SetMetadata(mailbox string, entries map[string]*[]byte) error {
+	for _, value := range entries {
+		if value != nil && len(*value) > maxMetadataSize {
+			return &imapserver.MetadataError{
+				Code:    imap.ResponseCodeMaxSize,
+				MaxSize: maxMetadataSize,
+				Text:    "Metadata entry value too large",
+			}
+		}
+	}
+
+	return u.withMetadata(mailbox, func(metadata map[string][]byte) {
+		for name, value := range entries {
+			name = strings.ToLower(name)
+			if value == nil {
+				delete(metadata, name)
+			} else {
+				metadata[name] = append([]byte(nil), *value...)
+			}
+		}
+	})
+}
+
+// withMetadata calls f with the metadata entries of a mailbox, or with the
+// server entries if the mailbox name is empty.
+func (u *User) withMetadata(mailbox string, f func(metadata map[string][]byte)) error {
+	if mailbox == "" {
+		u.mutex.Lock()
+		defer u.mutex.Unlock()
+		f(u.metadata)
+		return nil
+	}
+
+	mbox, err := u.mailbox(mailbox)
+	if err != nil {
+		return err
+	}
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+	f(mbox.metadata)
+	return nil
+}
+
+func matchMetadataEntry(name, entry string, depth imap.GetMetadataDepth) bool {
+	if name == entry {
+		return true
+	}
+	if depth == imap.GetMetadataDepthZero || !strings.HasPrefix(name, entry+"/") {
+		return false
+	}
+	return depth == imap.GetMetadataDepthInfinity || !strings.Contains(name[len(entry)+1:], "/")
+}
@@ -0,0 +1,344 @@
+package imapmemserver
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+// notifier keeps track of the events a session has requested via NOTIFY.
+type notifier struct {
+	user    *User               // immutable
+	options *imap.NotifyOptions // immutable
+	wake    chan struct{}       // immutable
+
+	mutex   sync.Mutex
+	watched map[*Mailbox]*notifyWatch
+	pending map[*Mailbox]struct{} // mailboxes with pending message events
+	lists   []imap.ListData       // pending mailbox events
+	refresh bool                  // the set of watched mailboxes is stale
+}
+
+type notifyWatch struct {
+	name   string
+	events []imap.NotifyEvent
+}
+
+func newNotifier(u *User, options *imap.NotifyOptions) *notifier {
+	n := &notifier{
+		user:    u,
+		options: options,
+		wake:    make(chan struct{}, 1),
+		pending: make(map[*Mailbox]struct{}),
+	}
+	u.mutex.Lock()
+	u.notifiers[n] = struct{}{}
+	u.mutex.Unlock()
+	n.refreshWatched()
+	return n
+}
+
+// close unregisters the notifier.
+func (n *notifier) close() {
+	n.user.mutex.Lock()
+	delete(n.user.notifiers, n)
+	n.user.mutex.Unlock()
+
+	n.mutex.Lock()
+	watched := n.watched
+	n.watched = nil
+	n.mutex.Unlock()
+
+	for mbox := range watched {
+		mbox.removeWatcher(n)
+	}
+}
+
+// match returns the first event group matching a mailbox other than the
+// selected one.
+func (n *notifier) match(name string, subscribed bool) *imap.NotifyItem {
+	for i := range n.options.Items {
+		item := &n.options.Items[i]
+		var ok bool
+		switch item.MailboxSpec {
+		case imap.NotifyMailboxSpecInboxes:
+			ok = name == "INBOX"
+		case imap.NotifyMailboxSpecPersonal:
+			ok = !isSharedMailbox(name)
+		case imap.NotifyMailboxSpecSubscribed:
+			ok = subscribed
+		case imap.NotifyMailboxSpecSubtree:
+			for _, mailbox := range item.Mailboxes {
+				if name == mailbox || strings.HasPrefix(name, mailbox+string(mailboxDelim)) {
+					ok = true
+					break
+				}
+			}
+		case imap.NotifyMailboxSpecMailboxes:
+			for _, mailbox := range item.Mailboxes {
+				if name == mailbox {
+					ok = true
+					break
+				}
+			}
+		}
+		if ok {
+			return item
+		}
+	}
+	return nil
+}
+
+// refreshWatched updates the set of mailboxes watched for message events.
+func (n *notifier) refreshWatched() {
+	mailboxes := n.user.mailboxList()
+	for _, item := range n.options.Items {
+		if item.MailboxSpec != imap.NotifyMailboxSpecMailboxes && item.MailboxSpec != imap.NotifyMailboxSpecSubtree {
+			continue
+		}
+		for _, name := range item.Mailboxes {
+			if !isSharedMailbox(name) {
+				continue
+			}
+			if mbox, err := n.user.mailbox(name); err == nil && mbox.rights(n.user.username).Contains(imap.RightRead) {
+				mailboxes[name] = mbox
+			}
+		}
+	}
+
+	watched := make(map[*Mailbox]*notifyWatch)
+	for name, mbox := range mailboxes {
+		mbox.mutex.Lock()
+		subscribed := mbox.subscribed
+		mbox.mutex.Unlock()
+
+		item := n.match(name, subscribed)
+		if item == nil || !hasMessageEvent(item.Events) {
+			continue
+		}
+		watched[mbox] = &notifyWatch{name: name, events: item.Events}
+	}
+
+	n.mutex.Lock()
+	prev := n.watched
+	n.watched = watched
+	n.refresh = false
+	n.mutex.Unlock()
+
+	for mbox := range prev {
+		if _, ok := watched[mbox]; !ok {
+			mbox.removeWatcher(n)
+		}
+	}
+	for mbox := range watched {
+		if _, ok := prev[mbox]; !ok {
+			mbox.addWatcher(n)
+		}
+	}
+}
+
+func hasMessageEvent(events []imap.NotifyEvent) bool {
+	for _, event := range events {
+		switch event {
+		case imap.NotifyEventMessageNew, imap.NotifyEventMessageExpunge, imap.NotifyEventFlagChange:
+			return true
+		}
+	}
+	return false
+}
+
+func hasEvent(events []imap.NotifyEvent, event imap.NotifyEvent) bool {
+	for _, ev := range events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *notifier) wakeUp() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+		// a wake-up is already pending
+	}
+}
+
+// queueMessageEvent is called with the mailbox locked.
+func (n *notifier) queueMessageEvent(mbox *Mailbox, event imap.NotifyEvent) {
+	n.mutex.Lock()
+	watch := n.watched[mbox]
+	ok := watch != nil && hasEvent(watch.events, event)
+	if ok {
+		n.pending[mbox] = struct{}{}
+	}
+	n.mutex.Unlock()
+
+	if ok {
+		n.wakeUp()
+	}
+}
+
+// queueMailboxEvent is called with the user locked.
+func (n *notifier) queueMailboxEvent(event imap.NotifyEvent, data *imap.ListData) {
+	subscribed := false
+	for _, attr := range data.Attrs {
+		if attr == imap.MailboxAttrSubscribed {
+			subscribed = true
+		}
+	}
+	item := n.match(data.Mailbox, subscribed)
+	if item == nil && data.OldName != "" {
+		item = n.match(data.OldName, subscribed)
+	}
+
+	n.mutex.Lock()
+	if event == imap.NotifyEventMailboxName {
+		n.refresh = true
+	}
+	ok := item != nil && hasEvent(item.Events, event)
+	if ok {
+		n.lists = append(n.lists, *data)
+	}
+	n.mutex.Unlock()
+
+	if ok {
+		n.wakeUp()
+	}
+}
+
+// poll writes pending events. Message events for the selected mailbox are
+// skipped: these are reported by the mailbox tracker.
+func (n *notifier) poll(w *imapserver.UpdateWriter, selected *Mailbox) error {
+	n.mutex.Lock()
+	refresh := n.refresh
+	lists := n.lists
+	n.lists = nil
+	n.mutex.Unlock()
+
+	for i := range lists {
+		if err := w.WriteMailboxList(&lists[i]); err != nil {
+			return err
+		}
+	}
+
+	if refresh {
+		n.refreshWatched()
+	}
+
+	n.mutex.Lock()
+	pending := make(map[*Mailbox]string, len(n.pending))
+	for mbox := range n.pending {
+		if watch := n.watched[mbox]; watch != nil {
+			pending[mbox] = watch.name
+		}
+	}
+	n.pending = make(map[*Mailbox]struct{})
+	n.mutex.Unlock()
+
+	for mbox, name := range pending {
+		if mbox == selected {
+			continue
+		}
+		if err := writeNotifyStatus(w, mbox, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idle writes pending events until stop is closed.
+func (n *notifier) idle(w *imapserver.UpdateWriter, stop <-chan struct{}, selected *Mailbox) error {
+	for {
+		select {
+		case <-n.wake:
+			if err := n.poll(w, selected); err != nil {
+				return err
+			}
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// writeStatus writes a STATUS response for each watched mailbox.
+func (n *notifier) writeStatus(w *imapserver.UpdateWriter, selected *Mailbox) error {
+	n.mutex.Lock()
+	watched := make(map[*Mailbox]string, len(n.watched))
+	for mbox, watch := range n.watched {
+		watched[mbox] = watch.name
+	}
+	n.mutex.Unlock()
+
+	for mbox, name := range watched {
+		if mbox == selected {
+			continue
+		}
+		if err := writeNotifyStatus(w, mbox, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeNotifyStatus(w *imapserver.UpdateWriter, mbox *Mailbox, name string) error {
+	data := mbox.StatusData(&imap.StatusOptions{
+		NumMessages: true,
+		UIDNext:     true,
+		UIDValidity: true,
+		NumUnseen:   true,
+	})
+	data.Mailbox = name
+	return w.WriteMailboxStatus(data)
+}
+
+func (mbox *Mailbox) addWatcher(n *notifier) {
+	mbox.mutex.Lock()
+	mbox.watchers[n] = struct{}{}
+	mbox.mutex.Unlock()
+}
+
+func (mbox *Mailbox) removeWatcher(n *notifier) {
+	mbox.mutex.Lock()
+	delete(mbox.watchers, n)
+	mbox.mutex.Unlock()
+}
+
+func (mbox *Mailbox) notifyLocked(event imap.NotifyEvent) {
+	for n := range mbox.watchers {
+		n.queueMessageEvent(mbox, event)
+	}
+}
+
+// notifyMailboxLocked queues a mailbox event for all sessions of the user
+// which have enabled NOTIFY.
+func (u *User) notifyMailboxLocked(event imap.NotifyEvent, data *imap.ListData) {
+	for n := range u.notifiers {
+		n.queueMailboxEvent(event, data)
+	}
+}
+
+var supportedNotifyEvents = []imap.NotifyEvent{
+	imap.NotifyEventMessageNew,
+	imap.NotifyEventMessageExpunge,
+	imap.NotifyEventFlagChange,
+	imap.NotifyEventMailboxName,
+	imap.NotifyEventSubscriptionChange,
+}
+
+func checkNotifyOptions(options *imap.NotifyOptions) error {
+	for _, item := range options.Items {
+		for _, event := range item.Events {
+			if !hasEvent(supportedNotifyEvents, event) {
+				return &imap.Error{
+					Type: imap.StatusResponseTypeNo,
+					Code: imap.ResponseCodeBadEvent,
+					Text: "Unsupported event: " + string(event),
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package imapmemserver
+
+import (
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// quota tracks the resource usage of a user against its limits.
+//
+// The STORAGE resource is expressed in units of 1024 octets.
+type quota struct {
+	mutex    sync.Mutex
+	limits   map[imap.QuotaResourceType]int64
+	messages int64
+	size     int64
+}
+
+func newQuota() *quota {
+	return &quota{limits: make(map[imap.QuotaResourceType]int64)}
+}
+
+// reserve accounts for new messages, failing if that would exceed a limit.
+func (q *quota) reserve(messages, size int64) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if limit, ok := q.limits[imap.QuotaResourceMessage]; ok && q.messages+messages > limit {
+		return errOverQuota
+	}
+	if limit, ok := q.limits[imap.QuotaResourceStorage]; ok && storageUnits(q.size+size) > limit {
+		return errOverQuota
+	}
+
+	q.messages += messages
+	q.size += size
+	return nil
+}
+
+// add accounts for new messages, regardless of limits.
+func (q *quota) add(messages, size int64) {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	q.messages += messages
+	q.size += size
+	q.mutex.Unlock()
+}
+
+// release accounts for removed messages.
+func (q *quota) release(messages, size int64) {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	q.messages -= messages
+	q.size -= size
+	q.mutex.Unlock()
+}
+
+func (q *quota) data() *imap.QuotaData {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	data := imap.QuotaData{
+		Resources: make(map[imap.QuotaResourceType]imap.QuotaResourceData),
+	}
+	for typ, limit := range q.limits {
+		var usage int64
+		switch typ {
+		case imap.QuotaResourceMessage:
+			usage = q.messages
+		case imap.QuotaResourceStorage:
+			usage = storageUnits(q.size)
+		}
+		data.Resources[typ] = imap.QuotaResourceData{Usage: usage, Limit: limit}
+	}
+	return &data
+}
+
+func (q *quota) setLimits(limits map[imap.QuotaResourceType]int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.limits = make(map[imap.QuotaResourceType]int64)
+	for typ, limit := range limits {
+		q.limits[typ] = limit
+	}
+}
+
+func storageUnits(size int64) int64 {
+	return (size + 1023) / 1024
+}
+
+var errOverQuota = &imap.Error{
+	Type: imap.StatusResponseTypeNo,
+	Code: imap.ResponseCodeOverQuota,
+	Text: "Quota exceeded",
+}
+
+// QuotaResourceTypes returns the supported quota resource types.
+func (u *User) QuotaResourceTypes() []imap.QuotaResourceType {
+	return []imap.QuotaResourceType{imap.QuotaResourceStorage, imap.QuotaResourceMessage}
+}
+
+// GetQuota returns the usage and limits of a quota root.
+//
+// Each user has a single quota root, named with the empty string.
+func (u *User) GetQuota(root string) (*imap.QuotaData, error) {
+	if root != "" {
+		return nil, errNoSuchQuotaRoot
+	}
+	return u.quota.data(), nil
+}
+
+// GetQuotaRoot returns the quota roots of a mailbox.
+//
+// Mailboxes shared by other users have no quota root.
+func (u *User) GetQuotaRoot(mailbox string) ([]imap.QuotaData, error) {
+	mbox, err := u.mailboxWithRights(mailbox, imap.RightSet{imap.RightRead})
+	if err != nil {
+		return nil, err
+	}
+	// Shared mailboxes are charged to the quota of their owner, which isn't
+	// a quota root of this user
+	if mbox.quota != u.quota {
+		return nil, nil
+	}
+	return []imap.QuotaData{*u.quota.data()}, nil
+}
+
+// SetQuota sets the limits of a quota root. Previous limits are discarded.
+func (u *User) SetQuota(root string, limits map[imap.QuotaResourceType]int64) error {
+	if root != "" {
+		return errNoSuchQuotaRoot
+	}
+	for typ := range limits {
+		switch typ {
+		case imap.QuotaResourceStorage, imap.QuotaResourceMessage:
+			// ok
+		default:
+			return &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Text: "Unsupported quota resource type",
+			}
+		}
+	}
+	u.quota.setLimits(limits)
+	return nil
+}
+
+var errNoSuchQuotaRoot = &imap.Error{
+	Type: imap.StatusResponseTypeNo,
+	Code: imap.ResponseCodeNonExistent,
+	Text: "No such quota root",
+}
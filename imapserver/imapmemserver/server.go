@@ -4,6 +4,7 @@ package imapmemserver
 import (
 	"sync"
 
+	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapserver"
 )
 
@@ -33,20 +34,40 @@ func (s *Server) user(username string) *User {
 	return s.users[username]
 }
 
+// User returns the user with the specified username, or nil if there is no
+// such user.
+func (s *Server) User(username string) *User {
+	return s.user(username)
+}
+
 // AddUser adds a user to the server.
 func (s *Server) AddUser(user *User) {
+	user.server = s
 	s.mutex.Lock()
 	s.users[user.username] = user
 	s.mutex.Unlock()
 }
 
+func (s *Server) userList() []*User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		l = append(l, u)
+	}
+	return l
+}
+
 type serverSession struct {
 	*UserSession // may be nil
 
 	server *Server // immutable
 }
 
-var _ imapserver.Session = (*serverSession)(nil)
+var (
+	_ imapserver.Session   = (*serverSession)(nil)
+	_ imapserver.SessionID = (*serverSession)(nil)
+)
 
 func (sess *serverSession) Login(username, password string) error {
 	u := sess.server.user(username)
@@ -59,3 +80,7 @@ func (sess *serverSession) Login(username, password string) error {
 	sess.UserSession = NewUserSession(u)
 	return nil
 }
+
+func (sess *serverSession) ID(clientID *imap.IDData) *imap.IDData {
+	return &imap.IDData{imap.IDName: "imapmemserver"}
+}
@@ -17,9 +17,20 @@ type (
 type UserSession struct {
 	*user    // immutable
 	*mailbox // may be nil
+
+	notifier *notifier // may be nil
 }
 
-var _ imapserver.SessionIMAP4rev2 = (*UserSession)(nil)
+var (
+	_ imapserver.SessionIMAP4rev2  = (*UserSession)(nil)
+	_ imapserver.SessionSort       = (*UserSession)(nil)
+	_ imapserver.SessionThread     = (*UserSession)(nil)
+	_ imapserver.SessionMetadata   = (*UserSession)(nil)
+	_ imapserver.SessionQuota      = (*UserSession)(nil)
+	_ imapserver.SessionACL        = (*UserSession)(nil)
+	_ imapserver.SessionNotify     = (*UserSession)(nil)
+	_ imapserver.SessionConcurrent = (*UserSession)(nil)
+)
 
 // NewUserSession creates a new user session.
 func NewUserSession(user *User) *UserSession {
@@ -30,18 +41,34 @@ func (sess *UserSession) Close() error {
 	if sess != nil && sess.mailbox != nil {
 		sess.mailbox.Close()
 	}
+	if sess != nil && sess.notifier != nil {
+		sess.notifier.close()
+	}
 	return nil
 }
 
+// ConcurrentSafe implements imapserver.SessionConcurrent.
+func (sess *UserSession) ConcurrentSafe() bool {
+	return true
+}
+
 func (sess *UserSession) Select(name string, options *imap.SelectOptions) (*imap.SelectData, error) {
-	mbox, err := sess.user.mailbox(name)
+	mbox, err := sess.user.mailboxWithRights(name, imap.RightSet{imap.RightRead})
 	if err != nil {
 		return nil, err
 	}
+	readOnly := options.ReadOnly || !hasWriteRights(mbox.rights(sess.username))
+
 	mbox.mutex.Lock()
 	defer mbox.mutex.Unlock()
 	sess.mailbox = mbox.NewView()
-	return mbox.selectDataLocked(), nil
+	sess.mailbox.readOnly = readOnly
+	if sess.notifier != nil {
+		sess.mailbox.tracker.SetNotify(sess.notifier.options)
+	}
+	data := mbox.selectDataLocked()
+	data.ReadOnly = readOnly
+	return data, nil
 }
 
 func (sess *UserSession) Unselect() error {
@@ -53,25 +80,33 @@ func (sess *UserSession) Unselect() error {
 func (sess *UserSession) Copy(numKind imapserver.NumKind, seqSet imap.NumSet, destName string) (*imap.CopyData, error) {
 	dest, err := sess.user.mailbox(destName)
 	if err != nil {
-		return nil, &imap.Error{
-			Type: imap.StatusResponseTypeNo,
-			Code: imap.ResponseCodeTryCreate,
-			Text: "No such mailbox",
-		}
+		return nil, errTryCreate
 	} else if sess.mailbox != nil && dest == sess.mailbox.Mailbox {
 		return nil, &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: "Source and destination mailboxes are identical",
 		}
 	}
+	if err := checkRights(dest.rights(sess.username), imap.RightSet{imap.RightInsert}); err != nil {
+		return nil, err
+	}
 
-	var sourceUIDs, destUIDs imap.NumSet
+	var msgs []*message
 	sess.mailbox.forEach(numKind, seqSet, func(seqNum uint32, msg *message) {
-		appendData := dest.copyMsg(msg)
-		sourceUIDs.AddNum(uint32(msg.uid))
-		destUIDs.AddNum(uint32(appendData.UID))
+		msgs = append(msgs, msg)
 	})
 
+	uids, err := dest.copyMsgs(msgs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceUIDs, destUIDs imap.NumSet
+	for i, msg := range msgs {
+		sourceUIDs.AddNum(uint32(msg.uid))
+		destUIDs.AddNum(uint32(uids[i]))
+	}
+
 	return &imap.CopyData{
 		UIDValidity: dest.uidValidity,
 		SourceUIDs:  sourceUIDs,
@@ -82,30 +117,41 @@ func (sess *UserSession) Copy(numKind imapserver.NumKind, seqSet imap.NumSet, de
 func (sess *UserSession) Move(w *imapserver.MoveWriter, numKind imapserver.NumKind, seqSet imap.NumSet, destName string) error {
 	dest, err := sess.user.mailbox(destName)
 	if err != nil {
-		return &imap.Error{
-			Type: imap.StatusResponseTypeNo,
-			Code: imap.ResponseCodeTryCreate,
-			Text: "No such mailbox",
-		}
+		return errTryCreate
 	} else if sess.mailbox != nil && dest == sess.mailbox.Mailbox {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
 			Text: "Source and destination mailboxes are identical",
 		}
 	}
+	if err := checkRights(dest.rights(sess.username), imap.RightSet{imap.RightInsert}); err != nil {
+		return err
+	}
+	if err := sess.checkRights(imap.RightSet{imap.RightDeleteMessages, imap.RightExpunge}); err != nil {
+		return err
+	}
 
 	sess.mailbox.mutex.Lock()
 	defer sess.mailbox.mutex.Unlock()
 
+	var msgs []*message
+	sess.mailbox.forEachLocked(numKind, seqSet, func(seqNum uint32, msg *message) {
+		msgs = append(msgs, msg)
+	})
+
+	uids, err := dest.copyMsgs(msgs, sess.mailbox.Mailbox)
+	if err != nil {
+		return err
+	}
+
 	var sourceUIDs, destUIDs imap.NumSet
 	expunged := make(map[*message]struct{})
-	sess.mailbox.forEachLocked(numKind, seqSet, func(seqNum uint32, msg *message) {
-		appendData := dest.copyMsg(msg)
+	for i, msg := range msgs {
 		sourceUIDs.AddNum(uint32(msg.uid))
-		destUIDs.AddNum(uint32(appendData.UID))
+		destUIDs.AddNum(uint32(uids[i]))
 		expunged[msg] = struct{}{}
-	})
-	seqNums := sess.mailbox.expungeLocked(expunged)
+	}
+	seqNums, uids := sess.mailbox.expungeLocked(expunged)
 
 	err = w.WriteCopyData(&imap.CopyData{
 		UIDValidity: dest.uidValidity,
@@ -116,8 +162,8 @@ func (sess *UserSession) Move(w *imapserver.MoveWriter, numKind imapserver.NumKi
 		return err
 	}
 
-	for _, seqNum := range seqNums {
-		if err := w.WriteExpunge(sess.mailbox.tracker.EncodeSeqNum(seqNum)); err != nil {
+	for i, seqNum := range seqNums {
+		if err := w.WriteExpungeUID(sess.mailbox.tracker.EncodeSeqNum(seqNum), uids[i]); err != nil {
 			return err
 		}
 	}
@@ -125,7 +171,78 @@ func (sess *UserSession) Move(w *imapserver.MoveWriter, numKind imapserver.NumKi
 	return nil
 }
 
+func (sess *UserSession) Fetch(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, options *imap.FetchOptions) error {
+	// Without the "s" right, fetching a message body doesn't set \Seen
+	allowSeen := sess.mailbox.rights(sess.username).Contains(imap.RightSeen)
+	return sess.mailbox.fetch(w, numKind, seqSet, options, allowSeen)
+}
+
+func (sess *UserSession) Store(w *imapserver.FetchWriter, numKind imapserver.NumKind, seqSet imap.NumSet, flags *imap.StoreFlags, options *imap.StoreOptions) error {
+	var rights imap.RightSet
+	if flags.Op == imap.StoreFlagsSet {
+		// Replacing the flags may remove any flag
+		rights = imap.RightSet{imap.RightSeen, imap.RightDeleteMessages, imap.RightWrite}
+	}
+	for _, flag := range flags.Flags {
+		switch canonicalFlag(flag) {
+		case canonicalFlag(imap.FlagSeen):
+			rights = rights.Add(imap.RightSet{imap.RightSeen})
+		case canonicalFlag(imap.FlagDeleted):
+			rights = rights.Add(imap.RightSet{imap.RightDeleteMessages})
+		default:
+			rights = rights.Add(imap.RightSet{imap.RightWrite})
+		}
+	}
+	if err := sess.checkRights(rights); err != nil {
+		return err
+	}
+	return sess.mailbox.Store(w, numKind, seqSet, flags, options)
+}
+
+func (sess *UserSession) Expunge(w *imapserver.ExpungeWriter, uids *imap.NumSet) error {
+	if err := sess.checkRights(imap.RightSet{imap.RightExpunge}); err != nil {
+		return err
+	}
+	return sess.mailbox.Expunge(w, uids)
+}
+
+// checkRights checks that the user has the specified rights on the selected
+// mailbox.
+func (sess *UserSession) checkRights(rights imap.RightSet) error {
+	return checkRights(sess.mailbox.rights(sess.username), rights)
+}
+
+func (sess *UserSession) Notify(w *imapserver.UpdateWriter, options *imap.NotifyOptions) error {
+	if options != nil {
+		if err := checkNotifyOptions(options); err != nil {
+			return err
+		}
+	}
+
+	if sess.notifier != nil {
+		sess.notifier.close()
+		sess.notifier = nil
+	}
+	if sess.mailbox != nil {
+		sess.mailbox.tracker.SetNotify(options)
+	}
+	if options == nil {
+		return nil
+	}
+
+	sess.notifier = newNotifier(sess.user, options)
+	if options.Status {
+		return sess.notifier.writeStatus(w, sess.selected())
+	}
+	return nil
+}
+
 func (sess *UserSession) Poll(w *imapserver.UpdateWriter, allowExpunge bool) error {
+	if sess.notifier != nil {
+		if err := sess.notifier.poll(w, sess.selected()); err != nil {
+			return err
+		}
+	}
 	if sess.mailbox == nil {
 		return nil
 	}
@@ -133,8 +250,32 @@ func (sess *UserSession) Poll(w *imapserver.UpdateWriter, allowExpunge bool) err
 }
 
 func (sess *UserSession) Idle(w *imapserver.UpdateWriter, stop <-chan struct{}) error {
+	if sess.notifier == nil {
+		if sess.mailbox == nil {
+			// Nothing to watch
+			<-stop
+			return nil
+		}
+		return sess.mailbox.Idle(w, stop)
+	} else if sess.mailbox == nil {
+		return sess.notifier.idle(w, stop, nil)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.mailbox.Idle(w, stop)
+	}()
+	err := sess.notifier.idle(w, stop, sess.mailbox.Mailbox)
+	if mboxErr := <-done; err == nil {
+		err = mboxErr
+	}
+	return err
+}
+
+// selected returns the selected mailbox, or nil if none is selected.
+func (sess *UserSession) selected() *Mailbox {
 	if sess.mailbox == nil {
-		return nil // TODO
+		return nil
 	}
-	return sess.mailbox.Idle(w, stop)
+	return sess.mailbox.Mailbox
 }
@@ -0,0 +1,279 @@
+package imapmemserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// snapshotVersion is the version of the snapshot format.
+const snapshotVersion = 1
+
+type snapshotServer struct {
+	Version int            `json:"version"`
+	Users   []snapshotUser `json:"users"`
+}
+
+type snapshotUser struct {
+	Username        string                           `json:"username"`
+	Password        string                           `json:"password"`
+	PrevUIDValidity uint32                           `json:"prev_uid_validity"`
+	Metadata        map[string][]byte                `json:"metadata,omitempty"`
+	QuotaLimits     map[imap.QuotaResourceType]int64 `json:"quota_limits,omitempty"`
+	Mailboxes       []snapshotMailbox                `json:"mailboxes"`
+}
+
+type snapshotMailbox struct {
+	Name        string                           `json:"name"`
+	UIDValidity uint32                           `json:"uid_validity"`
+	UIDNext     imap.UID                         `json:"uid_next"`
+	ModSeq      uint64                           `json:"mod_seq"`
+	Subscribed  bool                             `json:"subscribed,omitempty"`
+	Metadata    map[string][]byte                `json:"metadata,omitempty"`
+	ACL         map[imap.RightsIdentifier]string `json:"acl,omitempty"`
+	Messages    []snapshotMessage                `json:"messages"`
+	Expunged    []snapshotExpunged               `json:"expunged,omitempty"`
+}
+
+type snapshotMessage struct {
+	UID          imap.UID    `json:"uid"`
+	ModSeq       uint64      `json:"mod_seq"`
+	InternalDate time.Time   `json:"internal_date"`
+	Flags        []imap.Flag `json:"flags,omitempty"`
+	Body         []byte      `json:"body"`
+}
+
+type snapshotExpunged struct {
+	UIDs   string `json:"uids"`
+	ModSeq uint64 `json:"mod_seq"`
+}
+
+// Snapshot writes the state of the server to w.
+//
+// The snapshot contains users, mailboxes, messages and their metadata. It can
+// be loaded back with Restore.
+func (s *Server) Snapshot(w io.Writer) error {
+	users := s.userList()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].username < users[j].username
+	})
+
+	data := snapshotServer{Version: snapshotVersion}
+	for _, u := range users {
+		data.Users = append(data.Users, u.snapshot())
+	}
+
+	return json.NewEncoder(w).Encode(&data)
+}
+
+// Restore loads the state of the server from r, previously written by
+// Snapshot.
+//
+// Users present in the snapshot replace existing users with the same name.
+// Restore should be called before the server starts accepting connections.
+func (s *Server) Restore(r io.Reader) error {
+	var data snapshotServer
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("imapmemserver: failed to decode snapshot: %w", err)
+	}
+	if data.Version != snapshotVersion {
+		return fmt.Errorf("imapmemserver: unsupported snapshot version %v", data.Version)
+	}
+
+	users := make([]*User, 0, len(data.Users))
+	for i := range data.Users {
+		u, err := restoreUser(&data.Users[i])
+		if err != nil {
+			return fmt.Errorf("imapmemserver: failed to restore user %q: %w", data.Users[i].Username, err)
+		}
+		users = append(users, u)
+	}
+	for _, u := range users {
+		s.AddUser(u)
+	}
+	return nil
+}
+
+// SaveFile writes a snapshot of the server state to a file.
+//
+// The file is replaced atomically.
+func (s *Server) SaveFile(name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := s.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// LoadFile restores the server state from a file written by SaveFile.
+func (s *Server) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.Restore(f)
+}
+
+func (u *User) snapshot() snapshotUser {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	data := snapshotUser{
+		Username:        u.username,
+		Password:        u.password,
+		PrevUIDValidity: u.prevUidValidity,
+		Metadata:        copyMetadata(u.metadata),
+	}
+
+	u.quota.mutex.Lock()
+	if len(u.quota.limits) > 0 {
+		data.QuotaLimits = make(map[imap.QuotaResourceType]int64, len(u.quota.limits))
+		for typ, limit := range u.quota.limits {
+			data.QuotaLimits[typ] = limit
+		}
+	}
+	u.quota.mutex.Unlock()
+
+	names := make([]string, 0, len(u.mailboxes))
+	for name := range u.mailboxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data.Mailboxes = append(data.Mailboxes, u.mailboxes[name].snapshot())
+	}
+	return data
+}
+
+func (mbox *Mailbox) snapshot() snapshotMailbox {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	data := snapshotMailbox{
+		Name:        mbox.name,
+		UIDValidity: mbox.uidValidity,
+		UIDNext:     mbox.uidNext,
+		ModSeq:      mbox.modSeq,
+		Subscribed:  mbox.subscribed,
+		Metadata:    copyMetadata(mbox.metadata),
+		Messages:    make([]snapshotMessage, 0, len(mbox.l)),
+	}
+	if len(mbox.acl) > 0 {
+		data.ACL = make(map[imap.RightsIdentifier]string, len(mbox.acl))
+		for identifier, rights := range mbox.acl {
+			data.ACL[identifier] = rights.String()
+		}
+	}
+	for _, msg := range mbox.l {
+		flags := msg.flagList()
+		sort.Slice(flags, func(i, j int) bool {
+			return flags[i] < flags[j]
+		})
+		data.Messages = append(data.Messages, snapshotMessage{
+			UID:          msg.uid,
+			ModSeq:       msg.modSeq,
+			InternalDate: msg.t,
+			Flags:        flags,
+			Body:         msg.buf,
+		})
+	}
+	for _, expunged := range mbox.expunged {
+		data.Expunged = append(data.Expunged, snapshotExpunged{
+			UIDs:   expunged.uids.String(),
+			ModSeq: expunged.modSeq,
+		})
+	}
+	return data
+}
+
+func restoreUser(data *snapshotUser) (*User, error) {
+	u := NewUser(data.Username, data.Password)
+	u.prevUidValidity = data.PrevUIDValidity
+	for k, v := range data.Metadata {
+		u.metadata[k] = v
+	}
+	for typ, limit := range data.QuotaLimits {
+		u.quota.limits[typ] = limit
+	}
+
+	for i := range data.Mailboxes {
+		mbox, err := restoreMailbox(&data.Mailboxes[i])
+		if err != nil {
+			return nil, err
+		}
+		mbox.quota = u.quota
+		mbox.owner = u.username
+		u.mailboxes[mbox.name] = mbox
+
+		u.quota.messages += int64(len(mbox.l))
+		u.quota.size += mbox.sizeLocked()
+	}
+	return u, nil
+}
+
+func restoreMailbox(data *snapshotMailbox) (*Mailbox, error) {
+	mbox := NewMailbox(data.Name, data.UIDValidity)
+	mbox.tracker = imapserver.NewMailboxTracker(uint32(len(data.Messages)))
+	mbox.uidNext = data.UIDNext
+	mbox.modSeq = data.ModSeq
+	mbox.subscribed = data.Subscribed
+	for k, v := range data.Metadata {
+		mbox.metadata[k] = v
+	}
+	for identifier, rights := range data.ACL {
+		mbox.acl[identifier] = imap.RightSet(rights)
+	}
+
+	for _, msgData := range data.Messages {
+		msg := &message{
+			uid:    msgData.UID,
+			buf:    msgData.Body,
+			t:      msgData.InternalDate,
+			flags:  make(map[imap.Flag]struct{}),
+			modSeq: msgData.ModSeq,
+		}
+		for _, flag := range msgData.Flags {
+			msg.flags[canonicalFlag(flag)] = struct{}{}
+		}
+		mbox.l = append(mbox.l, msg)
+	}
+	for _, expunged := range data.Expunged {
+		uids, err := imapwire.ParseNumSet(expunged.UIDs)
+		if err != nil {
+			return nil, fmt.Errorf("in mailbox %q: invalid expunged UIDs: %v", data.Name, err)
+		}
+		mbox.addExpungedLocked(expungedMessages{uids: uids, modSeq: expunged.ModSeq})
+	}
+	return mbox, nil
+}
+
+func copyMetadata(m map[string][]byte) map[string][]byte {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string][]byte, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,134 @@
+package imapmemserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+func TestSnapshot(t *testing.T) {
+	s := imapmemserver.New()
+	user := imapmemserver.NewUser("user", "password")
+	user.Create("INBOX", nil)
+	user.Create("Archive", nil)
+	user.Subscribe("Archive")
+	if _, err := user.Append("INBOX", strings.NewReader("Subject: Hello\r\n\r\nHi!"), &imap.AppendOptions{
+		Flags: []imap.Flag{imap.FlagSeen},
+	}); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+	s.AddUser(user)
+
+	var buf bytes.Buffer
+	if err := s.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot() = %v", err)
+	}
+	snapshot := buf.String()
+
+	name := filepath.Join(t.TempDir(), "state.json")
+	if err := s.SaveFile(name); err != nil {
+		t.Fatalf("SaveFile() = %v", err)
+	}
+
+	restored := imapmemserver.New()
+	if err := restored.LoadFile(name); err != nil {
+		t.Fatalf("LoadFile() = %v", err)
+	}
+
+	buf.Reset()
+	if err := restored.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot() = %v", err)
+	}
+	if buf.String() != snapshot {
+		t.Errorf("Snapshot() after restore = %v, want %v", buf.String(), snapshot)
+	}
+
+	sess := restored.NewSession()
+	if err := sess.Login("user", "password"); err != nil {
+		t.Fatalf("Login() = %v", err)
+	}
+	data, err := sess.Status("INBOX", &imap.StatusOptions{NumMessages: true, UIDNext: true})
+	if err != nil {
+		t.Fatalf("Status() = %v", err)
+	}
+	if *data.NumMessages != 1 || data.UIDNext != 2 {
+		t.Errorf("Status() = %v, want 1 message and UIDNEXT 2", data)
+	}
+}
+
+func TestSnapshot_expungedHistory(t *testing.T) {
+	type expunged struct {
+		UIDs   string `json:"uids"`
+		ModSeq uint64 `json:"mod_seq"`
+	}
+	type snapshot struct {
+		Users []struct {
+			Mailboxes []struct {
+				Expunged []expunged `json:"expunged"`
+			} `json:"mailboxes"`
+		} `json:"users"`
+	}
+
+	// One expunge operation per message
+	const n = 200
+	var history []string
+	for i := 1; i <= n; i++ {
+		history = append(history, fmt.Sprintf(`{"uids": "%v", "mod_seq": %v}`, i, i+1))
+	}
+	in := fmt.Sprintf(`{
+		"version": 1,
+		"users": [{
+			"username": "user",
+			"password": "password",
+			"mailboxes": [{
+				"name": "INBOX",
+				"uid_validity": 1,
+				"uid_next": %v,
+				"mod_seq": %v,
+				"messages": [],
+				"expunged": [%v]
+			}]
+		}]
+	}`, n+1, n+1, strings.Join(history, ","))
+
+	s := imapmemserver.New()
+	if err := s.Restore(strings.NewReader(in)); err != nil {
+		t.Fatalf("Restore() = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot() = %v", err)
+	}
+	var out snapshot
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+
+	l := out.Users[0].Mailboxes[0].Expunged
+	if len(l) >= n {
+		t.Fatalf("got %v expunged entries, want history to be compacted", len(l))
+	}
+	// Old entries are merged, recent entries are kept as-is
+	if l[len(l)-1].UIDs != fmt.Sprint(n) || l[len(l)-1].ModSeq != n+1 {
+		t.Errorf("last expunged entry = %+v, want UID %v at mod-sequence %v", l[len(l)-1], n, n+1)
+	}
+	var all imap.NumSet
+	for _, e := range l {
+		uids, err := imapwire.ParseNumSet(e.UIDs)
+		if err != nil {
+			t.Fatalf("ParseNumSet(%q) = %v", e.UIDs, err)
+		}
+		all.AddSet(uids)
+	}
+	if want := fmt.Sprintf("1:%v", n); all.String() != want {
+		t.Errorf("expunged UIDs = %v, want %v", all, want)
+	}
+}
@@ -0,0 +1,131 @@
+package imapmemserver
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+)
+
+func (mbox *MailboxView) Sort(numKind imapserver.NumKind, criteria *imap.SearchCriteria, sortCriteria []imap.SortCriterion) ([]uint32, error) {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	var l []sortMessage
+	mbox.searchLocked(numKind, criteria, func(num uint32, msg *message) {
+		envelope := msg.envelope()
+		if envelope == nil {
+			envelope = new(imap.Envelope)
+		}
+		l = append(l, sortMessage{num: num, msg: msg, envelope: envelope})
+	})
+
+	// Messages are already ordered by sequence number, which is the final
+	// tie-breaker (RFC 5256 section 3)
+	sort.SliceStable(l, func(i, j int) bool {
+		for _, criterion := range sortCriteria {
+			cmp := compareSortMessages(&l[i], &l[j], criterion.Key)
+			if criterion.Reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+
+	nums := make([]uint32, len(l))
+	for i, sm := range l {
+		nums[i] = sm.num
+	}
+	return nums, nil
+}
+
+type sortMessage struct {
+	num      uint32
+	msg      *message
+	envelope *imap.Envelope
+}
+
+func compareSortMessages(a, b *sortMessage, key imap.SortKey) int {
+	switch key {
+	case imap.SortKeyArrival:
+		return compareTime(a.msg.t, b.msg.t)
+	case imap.SortKeyDate:
+		return compareTime(sentDate(a), sentDate(b))
+	case imap.SortKeySize:
+		return compareInt(len(a.msg.buf), len(b.msg.buf))
+	case imap.SortKeySubject:
+		return strings.Compare(sortSubject(a.envelope), sortSubject(b.envelope))
+	case imap.SortKeyFrom:
+		return strings.Compare(sortAddrMailbox(a.envelope.From), sortAddrMailbox(b.envelope.From))
+	case imap.SortKeyTo:
+		return strings.Compare(sortAddrMailbox(a.envelope.To), sortAddrMailbox(b.envelope.To))
+	case imap.SortKeyCc:
+		return strings.Compare(sortAddrMailbox(a.envelope.Cc), sortAddrMailbox(b.envelope.Cc))
+	case imap.SortKeyDisplayFrom:
+		return strings.Compare(sortDisplayName(a.envelope.From), sortDisplayName(b.envelope.From))
+	case imap.SortKeyDisplayTo:
+		return strings.Compare(sortDisplayName(a.envelope.To), sortDisplayName(b.envelope.To))
+	default:
+		return 0
+	}
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sentDate returns the message's sent date, falling back to the internal date
+// if the Date header field is missing or invalid.
+func sentDate(sm *sortMessage) time.Time {
+	if !sm.envelope.Date.IsZero() {
+		return sm.envelope.Date
+	}
+	return sm.msg.t
+}
+
+func sortSubject(envelope *imap.Envelope) string {
+	return strings.ToLower(imap.BaseSubject(envelope.Subject))
+}
+
+// sortAddrMailbox returns the local-part of the first address.
+func sortAddrMailbox(addrs []imap.Address) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	return strings.ToLower(addrs[0].Mailbox)
+}
+
+// sortDisplayName returns the display name of the first address, falling back
+// to the address itself, see RFC 5957 section 3.
+func sortDisplayName(addrs []imap.Address) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	if addrs[0].Name != "" {
+		return strings.ToLower(addrs[0].Name)
+	}
+	return strings.ToLower(addrs[0].Addr())
+}
@@ -0,0 +1,30 @@
+package imapmemserver
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/internal/imapmsg"
+	"github.com/emersion/go-message/textproto"
+)
+
+func (mbox *MailboxView) Thread(numKind imapserver.NumKind, algorithm imap.ThreadAlgorithm, criteria *imap.SearchCriteria) ([]imap.ThreadData, error) {
+	mbox.mutex.Lock()
+	defer mbox.mutex.Unlock()
+
+	var threader imap.Threader
+	mbox.searchLocked(numKind, criteria, func(num uint32, msg *message) {
+		br := bufio.NewReader(bytes.NewReader(msg.buf))
+		header, _ := textproto.ReadHeader(br)
+		threader.Add(&imap.ThreadMessage{
+			Num:          num,
+			Envelope:     imapmsg.Envelope(header),
+			References:   header.Get("References"),
+			InternalDate: msg.t,
+		})
+	})
+
+	return threader.Thread(algorithm)
+}
@@ -18,6 +18,10 @@ type User struct {
 	mutex           sync.Mutex
 	mailboxes       map[string]*Mailbox
 	prevUidValidity uint32
+	metadata        map[string][]byte // server entries
+	quota           *quota            // immutable
+	server          *Server           // set by Server.AddUser
+	notifiers       map[*notifier]struct{}
 }
 
 func NewUser(username, password string) *User {
@@ -25,6 +29,9 @@ func NewUser(username, password string) *User {
 		username:  username,
 		password:  password,
 		mailboxes: make(map[string]*Mailbox),
+		metadata:  make(map[string][]byte),
+		quota:     newQuota(),
+		notifiers: make(map[*notifier]struct{}),
 	}
 }
 
@@ -41,33 +48,32 @@ func (u *User) Login(username, password string) error {
 func (u *User) mailboxLocked(name string) (*Mailbox, error) {
 	mbox := u.mailboxes[name]
 	if mbox == nil {
-		return nil, &imap.Error{
-			Type: imap.StatusResponseTypeNo,
-			Code: imap.ResponseCodeNonExistent,
-			Text: "No such mailbox",
-		}
+		return nil, errNoSuchMailbox
 	}
 	return mbox, nil
 }
 
 func (u *User) mailbox(name string) (*Mailbox, error) {
+	if isSharedMailbox(name) {
+		return u.sharedMailbox(name)
+	}
+
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 	return u.mailboxLocked(name)
 }
 
 func (u *User) Status(name string, options *imap.StatusOptions) (*imap.StatusData, error) {
-	mbox, err := u.mailbox(name)
+	mbox, err := u.mailboxWithRights(name, imap.RightSet{imap.RightRead})
 	if err != nil {
 		return nil, err
 	}
-	return mbox.StatusData(options), nil
+	data := mbox.StatusData(options)
+	data.Mailbox = name
+	return data, nil
 }
 
 func (u *User) List(w *imapserver.ListWriter, ref string, patterns []string, options *imap.ListOptions) error {
-	u.mutex.Lock()
-	defer u.mutex.Unlock()
-
 	// TODO: fail if ref doesn't exist
 
 	if len(patterns) == 0 {
@@ -77,24 +83,34 @@ func (u *User) List(w *imapserver.ListWriter, ref string, patterns []string, opt
 		})
 	}
 
-	var l []imap.ListData
-	for name, mbox := range u.mailboxes {
-		match := false
+	match := func(name string) bool {
 		for _, pattern := range patterns {
-			match = imapserver.MatchList(name, mailboxDelim, ref, pattern)
-			if match {
-				break
+			if imapserver.MatchList(name, mailboxDelim, ref, pattern) {
+				return true
 			}
 		}
-		if !match {
+		return false
+	}
+
+	var l []imap.ListData
+	u.mutex.Lock()
+	for name, mbox := range u.mailboxes {
+		if !match(name) {
 			continue
 		}
 
 		data := mbox.list(options)
-		if data != nil {
-			l = append(l, *data)
+		if data == nil {
+			continue
 		}
+		if options.ReturnMyRights {
+			data.MyRights = &imap.MyRightsData{Mailbox: name, Rights: imap.RightSetAll}
+		}
+		l = append(l, *data)
 	}
+	u.mutex.Unlock()
+
+	l = append(l, u.listShared(match, options)...)
 
 	sort.Slice(l, func(i, j int) bool {
 		return l[i].Mailbox < l[j].Mailbox
@@ -112,11 +128,10 @@ func (u *User) List(w *imapserver.ListWriter, ref string, patterns []string, opt
 func (u *User) Append(mailbox string, r imap.LiteralReader, options *imap.AppendOptions) (*imap.AppendData, error) {
 	mbox, err := u.mailbox(mailbox)
 	if err != nil {
-		return nil, &imap.Error{
-			Type: imap.StatusResponseTypeNo,
-			Code: imap.ResponseCodeTryCreate,
-			Text: "No such mailbox",
-		}
+		return nil, errTryCreate
+	}
+	if err := checkRights(mbox.rights(u.username), imap.RightSet{imap.RightInsert}); err != nil {
+		return nil, err
 	}
 	return mbox.appendLiteral(r, options)
 }
@@ -127,6 +142,9 @@ func (u *User) Create(name string, options *imap.CreateOptions) error {
 
 	name = strings.TrimRight(name, string(mailboxDelim))
 
+	if isSharedMailbox(name) {
+		return errSharedNamespace
+	}
 	if u.mailboxes[name] != nil {
 		return &imap.Error{
 			Type: imap.StatusResponseTypeNo,
@@ -138,7 +156,15 @@ func (u *User) Create(name string, options *imap.CreateOptions) error {
 	// UIDVALIDITY must change if a mailbox is deleted and re-created with the
 	// same name.
 	u.prevUidValidity++
-	u.mailboxes[name] = NewMailbox(name, u.prevUidValidity)
+	mbox := NewMailbox(name, u.prevUidValidity)
+	mbox.quota = u.quota
+	mbox.owner = u.username
+	u.mailboxes[name] = mbox
+
+	u.notifyMailboxLocked(imap.NotifyEventMailboxName, &imap.ListData{
+		Mailbox: name,
+		Delim:   mailboxDelim,
+	})
 	return nil
 }
 
@@ -146,11 +172,22 @@ func (u *User) Delete(name string) error {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 
-	if _, err := u.mailboxLocked(name); err != nil {
+	mbox, err := u.mailboxLocked(name)
+	if err != nil {
 		return err
 	}
 
+	mbox.mutex.Lock()
+	mbox.quota.release(int64(len(mbox.l)), mbox.sizeLocked())
+	mbox.mutex.Unlock()
+
 	delete(u.mailboxes, name)
+
+	u.notifyMailboxLocked(imap.NotifyEventMailboxName, &imap.ListData{
+		Attrs:   []imap.MailboxAttr{imap.MailboxAttrNonExistent},
+		Mailbox: name,
+		Delim:   mailboxDelim,
+	})
 	return nil
 }
 
@@ -159,6 +196,9 @@ func (u *User) Rename(oldName, newName string) error {
 	defer u.mutex.Unlock()
 
 	newName = strings.TrimRight(newName, string(mailboxDelim))
+	if isSharedMailbox(newName) {
+		return errSharedNamespace
+	}
 
 	mbox, err := u.mailboxLocked(oldName)
 	if err != nil {
@@ -176,29 +216,82 @@ func (u *User) Rename(oldName, newName string) error {
 	mbox.rename(newName)
 	u.mailboxes[newName] = mbox
 	delete(u.mailboxes, oldName)
+
+	u.notifyMailboxLocked(imap.NotifyEventMailboxName, &imap.ListData{
+		Mailbox: newName,
+		Delim:   mailboxDelim,
+		OldName: oldName,
+	})
 	return nil
 }
 
 func (u *User) Subscribe(name string) error {
+	if isSharedMailbox(name) {
+		return errSharedNamespace
+	}
 	mbox, err := u.mailbox(name)
 	if err != nil {
 		return err
 	}
 	mbox.SetSubscribed(true)
+	u.notifySubscription(name, true)
 	return nil
 }
 
 func (u *User) Unsubscribe(name string) error {
+	if isSharedMailbox(name) {
+		return errSharedNamespace
+	}
 	mbox, err := u.mailbox(name)
 	if err != nil {
 		return err
 	}
 	mbox.SetSubscribed(false)
+	u.notifySubscription(name, false)
 	return nil
 }
 
+func (u *User) notifySubscription(name string, subscribed bool) {
+	data := imap.ListData{
+		Mailbox: name,
+		Delim:   mailboxDelim,
+	}
+	if subscribed {
+		data.Attrs = []imap.MailboxAttr{imap.MailboxAttrSubscribed}
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.notifyMailboxLocked(imap.NotifyEventSubscriptionChange, &data)
+}
+
 func (u *User) Namespace() (*imap.NamespaceData, error) {
 	return &imap.NamespaceData{
 		Personal: []imap.NamespaceDescriptor{{Delim: mailboxDelim}},
+		Other:    []imap.NamespaceDescriptor{{Prefix: sharedPrefix, Delim: mailboxDelim}},
 	}, nil
 }
+
+// mailboxList returns a snapshot of the user's mailboxes.
+func (u *User) mailboxList() map[string]*Mailbox {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	l := make(map[string]*Mailbox, len(u.mailboxes))
+	for name, mbox := range u.mailboxes {
+		l[name] = mbox
+	}
+	return l
+}
+
+var (
+	errNoSuchMailbox = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeNonExistent,
+		Text: "No such mailbox",
+	}
+	errTryCreate = &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeTryCreate,
+		Text: "No such mailbox",
+	}
+)
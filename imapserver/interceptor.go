@@ -0,0 +1,141 @@
+package imapserver
+
+import (
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// CommandInfo describes a command executed by a connection.
+type CommandInfo struct {
+	// Command tag.
+	Tag string
+	// Command name, in uppercase. UID commands are prefixed with "UID ", for
+	// instance "UID FETCH".
+	Name string
+	// Connection state when the command was received.
+	State imap.ConnState
+	// Parsed command arguments, in the same order as the parameters of the
+	// corresponding Session method. For instance, SELECT has a mailbox name
+	// and an *imap.SelectOptions, and UID FETCH has a NumKind, an
+	// imap.NumSet and an *imap.FetchOptions.
+	//
+	// Args is nil until the arguments have been parsed, for commands without
+	// arguments and for custom commands (see Options.Commands). Args may
+	// contain credentials, for instance the LOGIN password.
+	Args []interface{}
+	// Time at which the command started.
+	Start time.Time
+}
+
+// Interceptor intercepts commands executed by connections.
+//
+// Interceptors are useful to implement cross-cutting concerns such as audit
+// logging, metrics, authorization checks or rate limiting.
+type Interceptor interface {
+	// Intercept is called once the command arguments have been parsed and the
+	// connection state has been checked, before the command is executed.
+	//
+	// If a non-nil error is returned, the command is aborted and the error is
+	// sent to the client. Errors should be of type *imap.Error: other errors
+	// are logged and sent to the client as an internal server error.
+	Intercept(conn *Conn, cmd *CommandInfo) error
+	// Done is called once a command has completed, including commands which
+	// have been aborted by an interceptor and commands whose arguments could
+	// not be parsed.
+	//
+	// resp is the tagged status response sent to the client. For successful
+	// commands returning additional data in the tagged status response (for
+	// instance APPENDUID), the data is omitted. resp is nil if the
+	// connection failed.
+	Done(conn *Conn, cmd *CommandInfo, resp *imap.StatusResponse, dur time.Duration)
+}
+
+// InterceptorFuncs is an Interceptor implemented by functions. Nil functions
+// are ignored.
+type InterceptorFuncs struct {
+	InterceptFunc func(conn *Conn, cmd *CommandInfo) error
+	DoneFunc      func(conn *Conn, cmd *CommandInfo, resp *imap.StatusResponse, dur time.Duration)
+}
+
+var _ Interceptor = InterceptorFuncs{}
+
+// Intercept implements Interceptor.
+func (funcs InterceptorFuncs) Intercept(conn *Conn, cmd *CommandInfo) error {
+	if funcs.InterceptFunc == nil {
+		return nil
+	}
+	return funcs.InterceptFunc(conn, cmd)
+}
+
+// Done implements Interceptor.
+func (funcs InterceptorFuncs) Done(conn *Conn, cmd *CommandInfo, resp *imap.StatusResponse, dur time.Duration) {
+	if funcs.DoneFunc != nil {
+		funcs.DoneFunc(conn, cmd, resp, dur)
+	}
+}
+
+// beginCommand starts tracking a command for interceptors. Nil is returned if
+// no interceptor is configured.
+func (c *Conn) beginCommand(tag, name string, dec *imapwire.Decoder) *CommandInfo {
+	if len(c.server.options.Interceptors) == 0 {
+		return nil
+	}
+
+	cmd := &CommandInfo{
+		Tag:   tag,
+		Name:  name,
+		State: c.state,
+		Start: time.Now(),
+	}
+
+	c.mutex.Lock()
+	if c.intercepted == nil {
+		c.intercepted = make(map[*imapwire.Decoder]*CommandInfo)
+	}
+	c.intercepted[dec] = cmd
+	c.mutex.Unlock()
+
+	return cmd
+}
+
+// endCommand notifies interceptors that a command has completed. Interceptors
+// are called in reverse order.
+func (c *Conn) endCommand(cmd *CommandInfo, dec *imapwire.Decoder, resp *imap.StatusResponse) {
+	if cmd == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	delete(c.intercepted, dec)
+	c.mutex.Unlock()
+
+	dur := time.Since(cmd.Start)
+	interceptors := c.server.options.Interceptors
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptors[i].Done(c, cmd, resp, dur)
+	}
+}
+
+// intercept runs the interceptors for the command being decoded by dec, with
+// the parsed command arguments. The first error returned by an interceptor
+// aborts the command.
+//
+// Handlers must call intercept before executing the command.
+func (c *Conn) intercept(dec *imapwire.Decoder, args ...interface{}) error {
+	c.mutex.Lock()
+	cmd := c.intercepted[dec]
+	c.mutex.Unlock()
+	if cmd == nil {
+		return nil
+	}
+
+	cmd.Args = args
+	for _, interceptor := range c.server.options.Interceptors {
+		if err := interceptor.Intercept(c, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,7 +5,8 @@ import (
 	"strings"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
+	"github.com/emersion/go-imap/v2/internal"
 	"github.com/emersion/go-imap/v2/internal/utf7"
 )
 
@@ -19,6 +20,10 @@ func (c *Conn) handleList(dec *imapwire.Decoder) error {
 		return err
 	}
 
+	if err := c.intercept(dec, ref, pattern, options); err != nil {
+		return err
+	}
+
 	w := &ListWriter{
 		conn:         c,
 		options:      options,
@@ -45,6 +50,9 @@ func (c *Conn) handleLSub(dec *imapwire.Decoder) error {
 	}
 
 	options := &imap.ListOptions{SelectSubscribed: true}
+	if err := c.intercept(dec, ref, []string{pattern}, options); err != nil {
+		return err
+	}
 	w := &ListWriter{
 		conn: c,
 		lsub: true,
@@ -175,7 +183,11 @@ func readListCmd(dec *imapwire.Decoder) (ref string, patterns []string, options
 
 	if dec.SP() { // list-return-opts
 		var atom string
-		if !dec.ExpectAtom(&atom) || !dec.Expect(strings.EqualFold(atom, "RETURN"), "RETURN") || !dec.ExpectSP() {
+		if !dec.ExpectAtom(&atom) {
+			return "", nil, nil, false, dec.Err()
+		} else if !strings.EqualFold(atom, "RETURN") {
+			return "", nil, nil, false, internal.NewExpectError("RETURN")
+		} else if !dec.ExpectSP() {
 			return "", nil, nil, false, dec.Err()
 		}
 
@@ -201,8 +213,8 @@ func readListCmd(dec *imapwire.Decoder) (ref string, patterns []string, options
 func readListMailbox(dec *imapwire.Decoder) (string, error) {
 	var mailbox string
 	if !dec.String(&mailbox) {
-		if !dec.Expect(dec.Func(&mailbox, isListChar), "list-char") {
-			return "", dec.Err()
+		if !dec.Func(&mailbox, isListChar) {
+			return "", internal.NewExpectError("list-char")
 		}
 	}
 	return utf7.Encoding.NewDecoder().String(mailbox)
@@ -230,6 +242,8 @@ func readReturnOption(dec *imapwire.Decoder, options *imap.ListOptions, recent *
 		options.ReturnSubscribed = true
 	case "CHILDREN":
 		options.ReturnChildren = true
+	case "MYRIGHTS":
+		options.ReturnMyRights = true
 	case "STATUS":
 		if !dec.ExpectSP() {
 			return dec.Err()
@@ -270,6 +284,11 @@ func (w *ListWriter) WriteList(data *imap.ListData) error {
 			return err
 		}
 	}
+	if w.options.ReturnMyRights && data.MyRights != nil {
+		if err := w.conn.writeMyRights(data.MyRights); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
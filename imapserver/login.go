@@ -2,7 +2,7 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleLogin(tag string, dec *imapwire.Decoder) error {
@@ -20,6 +20,9 @@ func (c *Conn) handleLogin(tag string, dec *imapwire.Decoder) error {
 			Text: "TLS is required to authenticate",
 		}
 	}
+	if err := c.intercept(dec, username, password); err != nil {
+		return err
+	}
 	if err := c.session.Login(username, password); err != nil {
 		return err
 	}
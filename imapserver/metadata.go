@@ -0,0 +1,337 @@
+package imapserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+	"github.com/emersion/go-imap/v2/internal"
+)
+
+// MetadataError is returned by SessionMetadata.SetMetadata when entries
+// cannot be set. It's sent to the client with a METADATA response code.
+type MetadataError struct {
+	// One of imap.ResponseCodeMaxSize, imap.ResponseCodeTooMany or
+	// imap.ResponseCodeNoPrivate
+	Code imap.ResponseCode
+	// Maximum size of an entry value, for imap.ResponseCodeMaxSize
+	MaxSize uint32
+	Text    string
+}
+
+// Error implements the error interface.
+func (err *MetadataError) Error() string {
+	return fmt.Sprintf("imapserver: SETMETADATA failed (%v): %v", err.Code, err.Text)
+}
+
+func (c *Conn) handleGetMetadata(tag string, dec *imapwire.Decoder) error {
+	if !dec.ExpectSP() {
+		return dec.Err()
+	}
+
+	// RFC 5464 places the options before the mailbox name in the formal
+	// syntax, but after it in the examples: accept both
+	var options imap.GetMetadataOptions
+	l, isList, err := readAStringList(dec)
+	if err != nil {
+		return err
+	} else if isList {
+		if err := readGetMetadataOptions(l, &options); err != nil {
+			return err
+		}
+		if !dec.ExpectSP() {
+			return dec.Err()
+		}
+	}
+
+	var mailbox string
+	if !dec.ExpectMailbox(&mailbox) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+
+	entries, isList, err := readAStringList(dec)
+	if err != nil {
+		return err
+	}
+	if isList && len(entries) > 0 && isGetMetadataOption(entries[0]) {
+		if err := readGetMetadataOptions(entries, &options); err != nil {
+			return err
+		}
+		if !dec.ExpectSP() {
+			return dec.Err()
+		}
+		entries, isList, err = readAStringList(dec)
+		if err != nil {
+			return err
+		}
+	}
+	if !isList {
+		var entry string
+		if !dec.ExpectAString(&entry) {
+			return dec.Err()
+		}
+		entries = []string{entry}
+	}
+
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	for _, entry := range entries {
+		if err := checkMetadataEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	session, err := c.metadataSession(mailbox)
+	if err != nil {
+		return err
+	}
+	if err := c.intercept(dec, mailbox, entries, &options); err != nil {
+		return err
+	}
+
+	data, err := session.GetMetadata(mailbox, entries, &options)
+	if err != nil {
+		return err
+	}
+
+	longEntries, err := c.writeMetadata(data, options.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	if err := c.poll("GETMETADATA"); err != nil {
+		return err
+	}
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom(tag).SP().Atom("OK").SP()
+	if longEntries > 0 {
+		enc.Special('[').Atom("METADATA").SP().Atom(string(imap.ResponseCodeLongEntries)).SP().Number(longEntries).Special(']').SP()
+	}
+	enc.Text("GETMETADATA completed")
+	return enc.CRLF()
+}
+
+func (c *Conn) handleSetMetadata(tag string, dec *imapwire.Decoder) error {
+	var mailbox string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+
+	entries := make(map[string]*[]byte)
+	err := dec.ExpectList(func() error {
+		var entry string
+		if !dec.ExpectAString(&entry) || !dec.ExpectSP() {
+			return dec.Err()
+		}
+		if err := checkMetadataEntry(entry); err != nil {
+			return err
+		}
+
+		value, err := readMetadataValue(dec)
+		if err != nil {
+			return err
+		}
+		entries[entry] = value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.metadataSession(mailbox)
+	if err != nil {
+		return err
+	}
+	if err := c.intercept(dec, mailbox, entries); err != nil {
+		return err
+	}
+
+	var metadataErr *MetadataError
+	err = session.SetMetadata(mailbox, entries)
+	if errors.As(err, &metadataErr) {
+		return c.writeMetadataError(tag, metadataErr)
+	} else if err != nil {
+		return err
+	}
+
+	if err := c.poll("SETMETADATA"); err != nil {
+		return err
+	}
+
+	return c.writeStatusResp(tag, &imap.StatusResponse{
+		Type: imap.StatusResponseTypeOK,
+		Text: "SETMETADATA completed",
+	})
+}
+
+func (c *Conn) metadataSession(mailbox string) (SessionMetadata, error) {
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return nil, err
+	}
+
+	session, ok := c.session.(SessionMetadata)
+	if !ok {
+		return nil, newClientBugError("METADATA is not supported")
+	}
+
+	caps := c.server.options.caps()
+	if mailbox == "" && !caps.Has(imap.CapMetadata) && !caps.Has(imap.CapMetadataServer) {
+		return nil, newClientBugError("Server metadata is not supported")
+	} else if mailbox != "" && !caps.Has(imap.CapMetadata) {
+		return nil, newClientBugError("Mailbox metadata is not supported")
+	}
+
+	return session, nil
+}
+
+// writeMetadata writes a METADATA response. Entries larger than maxSize are
+// omitted, and the size of the largest omitted entry is returned.
+func (c *Conn) writeMetadata(data *imap.GetMetadataData, maxSize *uint32) (longEntries uint32, err error) {
+	var names []string
+	for name, value := range data.EntryValues {
+		if value != nil && maxSize != nil && len(*value) > int(*maxSize) {
+			if size := uint32(len(*value)); size > longEntries {
+				longEntries = size
+			}
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return longEntries, nil
+	}
+	sort.Strings(names)
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom("*").SP().Atom("METADATA").SP().Mailbox(data.Mailbox).SP()
+	enc.List(len(names), func(i int) {
+		enc.String(names[i]).SP()
+		if value := data.EntryValues[names[i]]; value != nil {
+			enc.String(string(*value))
+		} else {
+			enc.NIL()
+		}
+	})
+	return longEntries, enc.CRLF()
+}
+
+func (c *Conn) writeMetadataError(tag string, err *MetadataError) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom(tag).SP().Atom("NO").SP()
+	enc.Special('[').Atom("METADATA").SP().Atom(string(err.Code))
+	if err.Code == imap.ResponseCodeMaxSize {
+		enc.SP().Number(err.MaxSize)
+	}
+	enc.Special(']').SP()
+
+	text := err.Text
+	if text == "" {
+		text = "SETMETADATA failed"
+	}
+	enc.Text(text)
+	return enc.CRLF()
+}
+
+func readAStringList(dec *imapwire.Decoder) (l []string, isList bool, err error) {
+	isList, err = dec.List(func() error {
+		var s string
+		if !dec.ExpectAString(&s) {
+			return dec.Err()
+		}
+		l = append(l, s)
+		return nil
+	})
+	return l, isList, err
+}
+
+func isGetMetadataOption(name string) bool {
+	switch strings.ToUpper(name) {
+	case "MAXSIZE", "DEPTH":
+		return true
+	default:
+		return false
+	}
+}
+
+func readGetMetadataOptions(l []string, options *imap.GetMetadataOptions) error {
+	if len(l)%2 != 0 {
+		return newClientBugError("Invalid GETMETADATA options")
+	}
+	for i := 0; i < len(l); i += 2 {
+		name, value := strings.ToUpper(l[i]), l[i+1]
+		switch name {
+		case "MAXSIZE":
+			maxSize, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return newClientBugError("Invalid GETMETADATA MAXSIZE option")
+			}
+			v := uint32(maxSize)
+			options.MaxSize = &v
+		case "DEPTH":
+			switch strings.ToLower(value) {
+			case "0":
+				options.Depth = imap.GetMetadataDepthZero
+			case "1":
+				options.Depth = imap.GetMetadataDepthOne
+			case "infinity":
+				options.Depth = imap.GetMetadataDepthInfinity
+			default:
+				return newClientBugError("Invalid GETMETADATA DEPTH option")
+			}
+		default:
+			return newClientBugError("Unknown GETMETADATA option")
+		}
+	}
+	return nil
+}
+
+func readMetadataValue(dec *imapwire.Decoder) (*[]byte, error) {
+	var s string
+	if dec.Special('~') { // literal8
+		if !dec.Literal(&s) {
+			if err := dec.Err(); err != nil {
+				return nil, err
+			}
+			return nil, internal.NewExpectError("literal8")
+		}
+	} else if dec.Atom(&s) {
+		if !strings.EqualFold(s, "NIL") {
+			return nil, newClientBugError("Expected metadata value")
+		}
+		return nil, nil
+	} else if !dec.ExpectString(&s) {
+		return nil, dec.Err()
+	}
+	b := []byte(s)
+	return &b, nil
+}
+
+// checkMetadataEntry checks that an entry name is valid, see RFC 5464
+// section 3.2.
+func checkMetadataEntry(name string) error {
+	lower := strings.ToLower(name)
+	valid := (lower == "/private" || lower == "/shared" || strings.HasPrefix(lower, "/private/") || strings.HasPrefix(lower, "/shared/")) &&
+		!strings.HasSuffix(name, "/") &&
+		!strings.Contains(name, "//") &&
+		!strings.ContainsAny(name, "*%")
+	if !valid {
+		return newClientBugError("Invalid metadata entry name")
+	}
+	return nil
+}
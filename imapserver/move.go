@@ -2,7 +2,7 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleMove(dec *imapwire.Decoder, numKind NumKind) error {
@@ -17,6 +17,9 @@ func (c *Conn) handleMove(dec *imapwire.Decoder, numKind NumKind) error {
 	if !ok {
 		return newClientBugError("MOVE is not supported")
 	}
+	if err := c.intercept(dec, numKind, seqSet, dest); err != nil {
+		return err
+	}
 	w := &MoveWriter{conn: c}
 	return session.Move(w, numKind, seqSet, dest)
 }
@@ -38,3 +41,11 @@ func (w *MoveWriter) WriteCopyData(data *imap.CopyData) error {
 func (w *MoveWriter) WriteExpunge(seqNum uint32) error {
 	return w.conn.writeExpunge(seqNum)
 }
+
+// WriteExpungeUID writes an EXPUNGE response for a MOVE command, or a VANISHED
+// response if the client has enabled QRESYNC.
+//
+// Servers supporting QRESYNC must use this method instead of WriteExpunge.
+func (w *MoveWriter) WriteExpungeUID(seqNum uint32, uid imap.UID) error {
+	return w.conn.writeExpungeUID(seqNum, uid)
+}
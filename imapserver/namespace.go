@@ -2,7 +2,7 @@ package imapserver
 
 import (
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleNamespace(dec *imapwire.Decoder) error {
@@ -18,6 +18,9 @@ func (c *Conn) handleNamespace(dec *imapwire.Decoder) error {
 	if !ok {
 		return newClientBugError("NAMESPACE is not supported")
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 
 	data, err := session.Namespace()
 	if err != nil {
@@ -0,0 +1,191 @@
+package imapserver
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+func (c *Conn) handleNotify(dec *imapwire.Decoder) error {
+	options, err := readNotify(dec)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return err
+	}
+
+	session, ok := c.session.(SessionNotify)
+	if !ok {
+		return newClientBugError("NOTIFY is not supported")
+	}
+	if err := c.intercept(dec, options); err != nil {
+		return err
+	}
+
+	w := &UpdateWriter{conn: c, allowExpunge: false}
+	return session.Notify(w, options)
+}
+
+func readNotify(dec *imapwire.Decoder) (*imap.NotifyOptions, error) {
+	var verb string
+	if !dec.ExpectSP() || !dec.ExpectAtom(&verb) {
+		return nil, dec.Err()
+	}
+	switch strings.ToUpper(verb) {
+	case "NONE":
+		if !dec.ExpectCRLF() {
+			return nil, dec.Err()
+		}
+		return nil, nil
+	case "SET":
+		// handled below
+	default:
+		return nil, newClientBugError("Unknown NOTIFY operation")
+	}
+
+	if !dec.ExpectSP() {
+		return nil, dec.Err()
+	}
+
+	var options imap.NotifyOptions
+	var atom string
+	if dec.Atom(&atom) {
+		if !strings.EqualFold(atom, "STATUS") {
+			return nil, newClientBugError("Expected STATUS or event group")
+		}
+		options.Status = true
+		if !dec.ExpectSP() {
+			return nil, dec.Err()
+		}
+	}
+
+	for {
+		if !dec.ExpectSpecial('(') {
+			return nil, dec.Err()
+		}
+		item, err := readNotifyItem(dec)
+		if err != nil {
+			return nil, err
+		}
+		if !dec.ExpectSpecial(')') {
+			return nil, dec.Err()
+		}
+		options.Items = append(options.Items, *item)
+
+		if !dec.SP() {
+			break
+		}
+	}
+
+	if !dec.ExpectCRLF() {
+		return nil, dec.Err()
+	}
+
+	return &options, nil
+}
+
+func readNotifyItem(dec *imapwire.Decoder) (*imap.NotifyItem, error) {
+	var spec string
+	if !dec.ExpectAtom(&spec) || !dec.ExpectSP() {
+		return nil, dec.Err()
+	}
+
+	item := imap.NotifyItem{MailboxSpec: imap.NotifyMailboxSpec(strings.ToLower(spec))}
+	switch item.MailboxSpec {
+	case imap.NotifyMailboxSpecSelected, imap.NotifyMailboxSpecSelectedDelayed, imap.NotifyMailboxSpecInboxes, imap.NotifyMailboxSpecPersonal, imap.NotifyMailboxSpecSubscribed:
+		// no arguments
+	case imap.NotifyMailboxSpecSubtree, imap.NotifyMailboxSpecMailboxes:
+		isList, err := dec.List(func() error {
+			var name string
+			if !dec.ExpectMailbox(&name) {
+				return dec.Err()
+			}
+			item.Mailboxes = append(item.Mailboxes, name)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		} else if !isList {
+			var name string
+			if !dec.ExpectMailbox(&name) {
+				return nil, dec.Err()
+			}
+			item.Mailboxes = append(item.Mailboxes, name)
+		}
+		if len(item.Mailboxes) == 0 || !dec.ExpectSP() {
+			return nil, dec.Err()
+		}
+	default:
+		return nil, newClientBugError("Unknown NOTIFY mailbox filter")
+	}
+
+	var none string
+	if dec.Atom(&none) {
+		if !strings.EqualFold(none, "NONE") {
+			return nil, newClientBugError("Expected NONE or list of events")
+		}
+		return &item, nil
+	}
+
+	err := dec.ExpectList(func() error {
+		var name string
+		if !dec.ExpectAtom(&name) {
+			return dec.Err()
+		}
+		event, ok := canonicalNotifyEvent(name)
+		if !ok {
+			return &imap.Error{
+				Type: imap.StatusResponseTypeNo,
+				Code: imap.ResponseCodeBadEvent,
+				Text: "Unknown event: " + name,
+			}
+		}
+		item.Events = append(item.Events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var hasNew, hasExpunge, hasFlags bool
+	for _, event := range item.Events {
+		switch event {
+		case imap.NotifyEventMessageNew:
+			hasNew = true
+		case imap.NotifyEventMessageExpunge:
+			hasExpunge = true
+		case imap.NotifyEventFlagChange, imap.NotifyEventAnnotationChange:
+			hasFlags = true
+		}
+	}
+	if hasNew != hasExpunge || (hasFlags && !hasNew) {
+		return nil, newClientBugError("MessageNew and MessageExpunge must be specified together, and are required by FlagChange and AnnotationChange")
+	}
+
+	return &item, nil
+}
+
+var notifyEvents = []imap.NotifyEvent{
+	imap.NotifyEventMessageNew,
+	imap.NotifyEventMessageExpunge,
+	imap.NotifyEventFlagChange,
+	imap.NotifyEventAnnotationChange,
+	imap.NotifyEventMailboxName,
+	imap.NotifyEventSubscriptionChange,
+	imap.NotifyEventMailboxMetadataChange,
+	imap.NotifyEventServerMetadataChange,
+}
+
+// canonicalNotifyEvent returns the event with the given case-insensitive
+// name. false is returned if the event isn't defined in RFC 5465.
+func canonicalNotifyEvent(name string) (imap.NotifyEvent, bool) {
+	for _, event := range notifyEvents {
+		if strings.EqualFold(name, string(event)) {
+			return event, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,156 @@
+package imapserver
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+func (c *Conn) handleGetQuota(dec *imapwire.Decoder) error {
+	var root string
+	if !dec.ExpectSP() || !dec.ExpectAString(&root) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.quotaSession()
+	if err != nil {
+		return err
+	}
+	if err := c.intercept(dec, root); err != nil {
+		return err
+	}
+
+	data, err := session.GetQuota(root)
+	if err != nil {
+		return err
+	}
+
+	return c.writeQuota(data)
+}
+
+func (c *Conn) handleGetQuotaRoot(dec *imapwire.Decoder) error {
+	var mailbox string
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.quotaSession()
+	if err != nil {
+		return err
+	}
+	if err := c.intercept(dec, mailbox); err != nil {
+		return err
+	}
+
+	l, err := session.GetQuotaRoot(mailbox)
+	if err != nil {
+		return err
+	}
+
+	roots := make([]string, len(l))
+	for i, data := range l {
+		roots[i] = data.Root
+	}
+	if err := c.writeQuotaRoot(mailbox, roots); err != nil {
+		return err
+	}
+
+	for i := range l {
+		if err := c.writeQuota(&l[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) handleSetQuota(dec *imapwire.Decoder) error {
+	var root string
+	if !dec.ExpectSP() || !dec.ExpectAString(&root) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+
+	limits := make(map[imap.QuotaResourceType]int64)
+	err := dec.ExpectList(func() error {
+		var (
+			name  string
+			limit int64
+		)
+		if !dec.ExpectAtom(&name) || !dec.ExpectSP() || !dec.ExpectNumber64(&limit) {
+			return dec.Err()
+		}
+		limits[imap.QuotaResourceType(strings.ToUpper(name))] = limit
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	session, err := c.quotaSession()
+	if err != nil {
+		return err
+	}
+	if !c.server.options.caps().Has(imap.CapQuotaSet) {
+		return newClientBugError("SETQUOTA is not supported")
+	}
+	if err := c.intercept(dec, root, limits); err != nil {
+		return err
+	}
+
+	if err := session.SetQuota(root, limits); err != nil {
+		return err
+	}
+
+	data, err := session.GetQuota(root)
+	if err != nil {
+		return err
+	}
+
+	return c.writeQuota(data)
+}
+
+func (c *Conn) quotaSession() (SessionQuota, error) {
+	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
+		return nil, err
+	}
+
+	session, ok := c.session.(SessionQuota)
+	if !ok {
+		return nil, newClientBugError("QUOTA is not supported")
+	}
+	return session, nil
+}
+
+func (c *Conn) writeQuota(data *imap.QuotaData) error {
+	var types []string
+	for typ := range data.Resources {
+		types = append(types, string(typ))
+	}
+	sort.Strings(types)
+
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom("*").SP().Atom("QUOTA").SP().String(data.Root).SP()
+	enc.List(len(types), func(i int) {
+		res := data.Resources[imap.QuotaResourceType(types[i])]
+		enc.Atom(types[i]).SP().Number64(res.Usage).SP().Number64(res.Limit)
+	})
+	return enc.CRLF()
+}
+
+func (c *Conn) writeQuotaRoot(mailbox string, roots []string) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom("*").SP().Atom("QUOTAROOT").SP().Mailbox(mailbox)
+	for _, root := range roots {
+		enc.SP().String(root)
+	}
+	return enc.CRLF()
+}
@@ -6,8 +6,8 @@ import (
 	"time"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
 func (c *Conn) handleSearch(tag string, dec *imapwire.Decoder, numKind NumKind) error {
@@ -35,50 +35,41 @@ func (c *Conn) handleSearch(tag string, dec *imapwire.Decoder, numKind NumKind)
 		if !dec.ExpectSP() || !dec.ExpectAString(&charset) || !dec.ExpectSP() {
 			return dec.Err()
 		}
-		switch strings.ToUpper(charset) {
-		case "US-ASCII", "UTF-8":
-			// nothing to do
-		default:
-			return &imap.Error{
-				Type: imap.StatusResponseTypeNo,
-				Code: imap.ResponseCodeBadCharset, // TODO: return list of supported charsets
-				Text: "Only US-ASCII and UTF-8 are supported SEARCH charsets",
-			}
+		if err := checkSearchCharset(charset); err != nil {
+			return err
 		}
 		atom = ""
 		maybeReadSearchKeyAtom(dec, &atom)
 	}
 
 	var criteria imap.SearchCriteria
-	for {
-		var err error
-		if atom != "" {
-			err = readSearchKeyWithAtom(&criteria, dec, atom)
-			atom = ""
-		} else {
-			err = readSearchKey(&criteria, dec)
-		}
-		if err != nil {
-			return fmt.Errorf("in search-key: %w", err)
-		}
-
-		if !dec.SP() {
-			break
-		}
+	if err := readSearchCriteria(&criteria, dec, atom); err != nil {
+		return err
 	}
 
 	if !dec.ExpectCRLF() {
 		return dec.Err()
 	}
 
+	if options.ReturnPartial != nil {
+		return newClientBugError("PARTIAL is not supported for SEARCH")
+	}
+
 	if err := c.checkState(imap.ConnStateSelected); err != nil {
 		return err
 	}
 
+	if criteria.ModSeq != nil {
+		c.enableCondStore()
+	}
+
 	// If no return option is specified, ALL is assumed
 	if !options.ReturnMin && !options.ReturnMax && !options.ReturnAll && !options.ReturnCount {
 		options.ReturnAll = true
 	}
+	if err := c.intercept(dec, numKind, &criteria, &options); err != nil {
+		return err
+	}
 
 	data, err := c.session.Search(numKind, &criteria, &options)
 	if err != nil {
@@ -88,7 +79,7 @@ func (c *Conn) handleSearch(tag string, dec *imapwire.Decoder, numKind NumKind)
 	if c.enabled.Has(imap.CapIMAP4rev2) || extended {
 		return c.writeESearch(tag, data, &options)
 	} else {
-		return c.writeSearch(data.All)
+		return c.writeSearch(data)
 	}
 }
 
@@ -115,14 +106,27 @@ func (c *Conn) writeESearch(tag string, data *imap.SearchData, options *imap.Sea
 	if options.ReturnCount {
 		enc.SP().Atom("COUNT").SP().Number(data.Count)
 	}
+	if data.ModSeq != 0 {
+		enc.SP().Atom("MODSEQ").SP().ModSeq(data.ModSeq)
+	}
+	if partial := data.Partial; options.ReturnPartial != nil && partial != nil {
+		enc.SP().Atom("PARTIAL").SP().Special('(')
+		enc.Number(partial.Range.Start).Special(':').Number(partial.Range.Stop).SP()
+		if len(partial.All) > 0 {
+			enc.NumSet(partial.All)
+		} else {
+			enc.NIL()
+		}
+		enc.Special(')')
+	}
 	return enc.CRLF()
 }
 
-func (c *Conn) writeSearch(seqSet imap.NumSet) error {
+func (c *Conn) writeSearch(data *imap.SearchData) error {
 	enc := newResponseEncoder(c)
 	defer enc.end()
 
-	nums, ok := seqSet.Nums()
+	nums, ok := data.All.Nums()
 	if !ok {
 		return fmt.Errorf("imapserver: failed to enumerate message numbers in SEARCH response")
 	}
@@ -131,6 +135,9 @@ func (c *Conn) writeSearch(seqSet imap.NumSet) error {
 	for _, num := range nums {
 		enc.SP().Number(num)
 	}
+	if data.ModSeq != 0 && len(nums) > 0 {
+		enc.SP().Special('(').Atom("MODSEQ").SP().ModSeq(data.ModSeq).Special(')')
+	}
 	return enc.CRLF()
 }
 
@@ -152,6 +159,17 @@ func readSearchReturnOpts(dec *imapwire.Decoder, options *imap.SearchOptions) er
 			options.ReturnAll = true
 		case "COUNT":
 			options.ReturnCount = true
+		case "PARTIAL":
+			var partial imap.SearchPartialRange
+			if !dec.ExpectSP() || !dec.ExpectNumber(&partial.Start) || !dec.ExpectSpecial(':') || !dec.ExpectNumber(&partial.Stop) {
+				return dec.Err()
+			}
+			if partial.Start == 0 || partial.Stop == 0 {
+				return newClientBugError("Invalid PARTIAL range")
+			} else if partial.Start > partial.Stop {
+				partial.Start, partial.Stop = partial.Stop, partial.Start
+			}
+			options.ReturnPartial = &partial
 		default:
 			return newClientBugError("unknown SEARCH RETURN option")
 		}
@@ -159,6 +177,40 @@ func readSearchReturnOpts(dec *imapwire.Decoder, options *imap.SearchOptions) er
 	})
 }
 
+func checkSearchCharset(charset string) error {
+	switch strings.ToUpper(charset) {
+	case "US-ASCII", "UTF-8":
+		return nil
+	default:
+		return &imap.Error{
+			Type: imap.StatusResponseTypeNo,
+			Code: imap.ResponseCodeBadCharset, // TODO: return list of supported charsets
+			Text: "Only US-ASCII and UTF-8 are supported SEARCH charsets",
+		}
+	}
+}
+
+// readSearchCriteria reads a list of search keys separated by spaces. If atom
+// is not empty, it's used as the first search key atom.
+func readSearchCriteria(criteria *imap.SearchCriteria, dec *imapwire.Decoder, atom string) error {
+	for {
+		var err error
+		if atom != "" {
+			err = readSearchKeyWithAtom(criteria, dec, atom)
+			atom = ""
+		} else {
+			err = readSearchKey(criteria, dec)
+		}
+		if err != nil {
+			return fmt.Errorf("in search-key: %w", err)
+		}
+
+		if !dec.SP() {
+			return nil
+		}
+	}
+}
+
 func maybeReadSearchKeyAtom(dec *imapwire.Decoder, ptr *string) bool {
 	return dec.Func(ptr, func(ch byte) bool {
 		return ch == '*' || imapwire.IsAtomChar(ch)
@@ -277,6 +329,12 @@ func readSearchKeyWithAtom(criteria *imap.SearchCriteria, dec *imapwire.Decoder,
 		case "SMALLER":
 			criteria.And(&imap.SearchCriteria{Smaller: n})
 		}
+	case "MODSEQ":
+		modSeq, err := readSearchKeyModSeq(dec)
+		if err != nil {
+			return err
+		}
+		criteria.And(&imap.SearchCriteria{ModSeq: modSeq})
 	case "NOT":
 		if !dec.ExpectSP() {
 			return dec.Err()
@@ -311,6 +369,31 @@ func readSearchKeyWithAtom(criteria *imap.SearchCriteria, dec *imapwire.Decoder,
 	return nil
 }
 
+func readSearchKeyModSeq(dec *imapwire.Decoder) (*imap.SearchCriteriaModSeq, error) {
+	if !dec.ExpectSP() {
+		return nil, dec.Err()
+	}
+
+	var modSeq imap.SearchCriteriaModSeq
+	if dec.Quoted(&modSeq.MetadataName) {
+		var typ string
+		if !dec.ExpectSP() || !dec.ExpectAtom(&typ) || !dec.ExpectSP() {
+			return nil, dec.Err()
+		}
+		switch typ := imap.SearchCriteriaMetadataType(strings.ToLower(typ)); typ {
+		case imap.SearchCriteriaMetadataAll, imap.SearchCriteriaMetadataPrivate, imap.SearchCriteriaMetadataShared:
+			modSeq.MetadataType = typ
+		default:
+			return nil, newClientBugError("Unknown MODSEQ entry type")
+		}
+	}
+
+	if !dec.ExpectModSeq(&modSeq.ModSeq) {
+		return nil, dec.Err()
+	}
+	return &modSeq, nil
+}
+
 func searchKeyFlag(key string) imap.Flag {
 	return imap.Flag("\\" + strings.Title(strings.ToLower(key)))
 }
@@ -2,20 +2,36 @@ package imapserver
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleSelect(tag string, dec *imapwire.Decoder, readOnly bool) error {
 	var mailbox string
-	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) || !dec.ExpectCRLF() {
+	if !dec.ExpectSP() || !dec.ExpectMailbox(&mailbox) {
+		return dec.Err()
+	}
+	options := imap.SelectOptions{ReadOnly: readOnly}
+	if dec.SP() {
+		err := dec.ExpectList(func() error {
+			return readSelectParam(dec, &options)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !dec.ExpectCRLF() {
 		return dec.Err()
 	}
 
 	if err := c.checkState(imap.ConnStateAuthenticated); err != nil {
 		return err
 	}
+	if err := c.intercept(dec, mailbox, &options); err != nil {
+		return err
+	}
 
 	if c.state == imap.ConnStateSelected {
 		if err := c.session.Unselect(); err != nil {
@@ -32,7 +48,13 @@ func (c *Conn) handleSelect(tag string, dec *imapwire.Decoder, readOnly bool) er
 		}
 	}
 
-	options := imap.SelectOptions{ReadOnly: readOnly}
+	if options.QResync != nil && !c.isEnabled(imap.CapQResync) {
+		return newClientBugError("QRESYNC must be enabled first")
+	}
+	if options.CondStore {
+		c.enableCondStore()
+	}
+
 	data, err := c.session.Select(mailbox, &options)
 	if err != nil {
 		return err
@@ -63,19 +85,33 @@ func (c *Conn) handleSelect(tag string, dec *imapwire.Decoder, readOnly bool) er
 			return err
 		}
 	}
+	if c.server.options.caps().Has(imap.CapCondStore) {
+		if err := c.writeHighestModSeq(data.HighestModSeq); err != nil {
+			return err
+		}
+	}
 
 	c.state = imap.ConnStateSelected
 	// TODO: forbid write commands in read-only mode
 
+	if qresync := options.QResync; qresync != nil && qresync.UIDValidity == data.UIDValidity && data.HighestModSeq != 0 {
+		if err := c.resync(qresync); err != nil {
+			return err
+		}
+	}
+
 	var (
 		cmdName string
 		code    imap.ResponseCode
 	)
 	if readOnly {
 		cmdName = "EXAMINE"
-		code = "READ-ONLY"
 	} else {
 		cmdName = "SELECT"
+	}
+	if readOnly || data.ReadOnly {
+		code = "READ-ONLY"
+	} else {
 		code = "READ-WRITE"
 	}
 	return c.writeStatusResp(tag, &imap.StatusResponse{
@@ -85,6 +121,75 @@ func (c *Conn) handleSelect(tag string, dec *imapwire.Decoder, readOnly bool) er
 	})
 }
 
+func readSelectParam(dec *imapwire.Decoder, options *imap.SelectOptions) error {
+	var name string
+	if !dec.ExpectAtom(&name) {
+		return dec.Err()
+	}
+	switch strings.ToUpper(name) {
+	case "CONDSTORE":
+		options.CondStore = true
+	case "QRESYNC":
+		var qresync imap.SelectQResync
+		if !dec.ExpectSP() || !dec.ExpectSpecial('(') {
+			return dec.Err()
+		}
+		if err := readSelectQResync(dec, &qresync); err != nil {
+			return err
+		}
+		if !dec.ExpectSpecial(')') {
+			return dec.Err()
+		}
+		options.QResync = &qresync
+	default:
+		return newClientBugError("Unknown SELECT parameter")
+	}
+	return nil
+}
+
+func readSelectQResync(dec *imapwire.Decoder, qresync *imap.SelectQResync) error {
+	if !dec.ExpectNumber(&qresync.UIDValidity) || !dec.ExpectSP() || !dec.ExpectModSeq(&qresync.ModSeq) {
+		return dec.Err()
+	}
+	if !dec.SP() {
+		return nil
+	}
+	if !dec.Special('(') {
+		if !dec.ExpectNumSet(&qresync.KnownUIDs) {
+			return dec.Err()
+		}
+		if !dec.SP() {
+			return nil
+		}
+		if !dec.ExpectSpecial('(') {
+			return dec.Err()
+		}
+	}
+	var seqMatch imap.SelectQResyncSeqMatch
+	if !dec.ExpectNumSet(&seqMatch.SeqNums) || !dec.ExpectSP() || !dec.ExpectNumSet(&seqMatch.UIDs) || !dec.ExpectSpecial(')') {
+		return dec.Err()
+	}
+	qresync.SeqMatch = &seqMatch
+	return nil
+}
+
+// resync sends the VANISHED (EARLIER) and FETCH responses for a SELECT command
+// with the QRESYNC parameter, see RFC 7162 section 3.2.5.
+func (c *Conn) resync(qresync *imap.SelectQResync) error {
+	uids := qresync.KnownUIDs
+	if len(uids) == 0 {
+		uids = imap.NumSet{imap.NumRange{Start: 1, Stop: 0}}
+	}
+	w := &FetchWriter{conn: c}
+	return c.session.Fetch(w, NumKindUID, uids, &imap.FetchOptions{
+		UID:          true,
+		Flags:        true,
+		ModSeq:       true,
+		ChangedSince: qresync.ModSeq,
+		Vanished:     true,
+	})
+}
+
 func (c *Conn) handleUnselect(dec *imapwire.Decoder, expunge bool) error {
 	if !dec.ExpectCRLF() {
 		return dec.Err()
@@ -93,6 +198,9 @@ func (c *Conn) handleUnselect(dec *imapwire.Decoder, expunge bool) error {
 	if err := c.checkState(imap.ConnStateSelected); err != nil {
 		return err
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 
 	if expunge {
 		w := &ExpungeWriter{}
@@ -158,3 +266,17 @@ func (c *Conn) writePermanentFlags(flags []imap.Flag) error {
 	enc.SP().Text("Permanent flags")
 	return enc.CRLF()
 }
+
+func (c *Conn) writeHighestModSeq(highestModSeq uint64) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+	enc.Atom("*").SP().Atom("OK").SP()
+	if highestModSeq == 0 {
+		enc.Special('[').Atom("NOMODSEQ").Special(']')
+		enc.SP().Text("Mod-sequences are not supported for this mailbox")
+	} else {
+		enc.Special('[').Atom("HIGHESTMODSEQ").SP().ModSeq(highestModSeq).Special(']')
+		enc.SP().Text("Highest mod-sequence")
+	}
+	return enc.CRLF()
+}
@@ -2,6 +2,7 @@
 package imapserver
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -53,6 +54,12 @@ type Options struct {
 	// Note, this may include sensitive information such as credentials used
 	// during authentication.
 	DebugWriter io.Writer
+	// Interceptors are called for each command, in order. See Interceptor.
+	Interceptors []Interceptor
+	// Commands contains handlers for custom commands, indexed by uppercase
+	// command name. UID commands are prefixed with "UID ", for instance
+	// "UID XFOO". Custom commands cannot override built-in commands.
+	Commands map[string]*CommandHandler
 }
 
 func (options *Options) wrapReadWriter(rw io.ReadWriter) io.ReadWriter {
@@ -80,11 +87,13 @@ type Server struct {
 	options Options
 
 	listenerWaitGroup sync.WaitGroup
+	connWaitGroup     sync.WaitGroup
 
 	mutex     sync.Mutex
 	listeners map[net.Listener]struct{}
 	conns     map[*Conn]struct{}
 	closed    bool
+	bye       *imap.StatusResponse // set by Shutdown
 }
 
 // New creates a new server.
@@ -149,7 +158,11 @@ func (s *Server) Serve(ln net.Listener) error {
 		}
 
 		delay = 0
-		go newConn(conn, s).serve()
+		s.connWaitGroup.Add(1)
+		go func() {
+			defer s.connWaitGroup.Done()
+			newConn(conn, s).serve()
+		}()
 	}
 }
 
@@ -190,13 +203,91 @@ func (s *Server) ListenAndServeTLS(addr string) error {
 //
 // Once Close has been called on a server, it may not be reused; future calls
 // to methods such as Serve will return an error.
+//
+// See Shutdown for a graceful alternative.
 func (s *Server) Close() error {
+	err := s.closeListeners(nil)
+	if err == errClosed {
+		return err
+	}
+
+	s.closeConns()
+	return err
+}
+
+// ShutdownOptions contains options for Server.Shutdown.
+type ShutdownOptions struct {
+	// Response code sent with the BYE response, for instance
+	// imap.ResponseCodeUnavailable or imap.ResponseCodeAlert. Optional.
+	Code imap.ResponseCode
+	// Human-readable text sent with the BYE response. Defaults to "Server
+	// shutting down".
+	Text string
+}
+
+// Shutdown gracefully shuts down the server.
+//
+// Shutdown first closes all active listeners. Then it sends an untagged BYE
+// response to connections waiting for a command and closes them. Connections
+// with in-flight commands are closed with a BYE response once their commands
+// complete. Sessions are closed as connections are closed.
+//
+// If ctx expires before all connections are closed, the remaining
+// connections are forcibly closed and the context's error is returned.
+// Otherwise, Shutdown returns any error returned from closing the server's
+// underlying listeners.
+//
+// Once Shutdown has been called on a server, it may not be reused; future
+// calls to methods such as Serve will return an error.
+func (s *Server) Shutdown(ctx context.Context, options *ShutdownOptions) error {
+	if options == nil {
+		options = new(ShutdownOptions)
+	}
+	bye := &imap.StatusResponse{
+		Type: imap.StatusResponseTypeBye,
+		Code: options.Code,
+		Text: options.Text,
+	}
+	if bye.Text == "" {
+		bye.Text = "Server shutting down"
+	}
+
+	err := s.closeListeners(bye)
+	if err == errClosed {
+		return err
+	}
+
+	s.mutex.Lock()
+	for c := range s.conns {
+		go c.shutdown(bye)
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.connWaitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// closeListeners marks the server as closed, closes all active listeners and
+// waits for Serve calls to return.
+func (s *Server) closeListeners(bye *imap.StatusResponse) error {
 	var err error
 
 	s.mutex.Lock()
 	ok := !s.closed
 	if ok {
 		s.closed = true
+		s.bye = bye
 		for l := range s.listeners {
 			if closeErr := l.Close(); closeErr != nil && err == nil {
 				err = closeErr
@@ -209,12 +300,13 @@ func (s *Server) Close() error {
 	}
 
 	s.listenerWaitGroup.Wait()
+	return err
+}
 
+func (s *Server) closeConns() {
 	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for c := range s.conns {
-		c.conn.Close()
+		c.NetConn().Close()
 	}
-	s.mutex.Unlock()
-
-	return err
 }
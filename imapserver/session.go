@@ -87,6 +87,97 @@ type SessionMove interface {
 	Move(w *MoveWriter, kind NumKind, seqSet imap.NumSet, dest string) error
 }
 
+// SessionSort is an IMAP session which supports SORT.
+type SessionSort interface {
+	Session
+
+	// Selected state
+	Sort(kind NumKind, criteria *imap.SearchCriteria, sortCriteria []imap.SortCriterion) ([]uint32, error)
+}
+
+// SessionThread is an IMAP session which supports THREAD.
+type SessionThread interface {
+	Session
+
+	// Selected state
+	Thread(kind NumKind, algorithm imap.ThreadAlgorithm, criteria *imap.SearchCriteria) ([]imap.ThreadData, error)
+}
+
+// SessionMetadata is an IMAP session which supports METADATA.
+//
+// The mailbox name is empty for server entries. Entries larger than
+// GetMetadataOptions.MaxSize are filtered out by the server.
+type SessionMetadata interface {
+	Session
+
+	// Authenticated state
+	GetMetadata(mailbox string, entries []string, options *imap.GetMetadataOptions) (*imap.GetMetadataData, error)
+	SetMetadata(mailbox string, entries map[string]*[]byte) error
+}
+
+// SessionQuota is an IMAP session which supports QUOTA.
+type SessionQuota interface {
+	Session
+
+	// QuotaResourceTypes returns the resource types supported by the
+	// session. They are advertised as QUOTA=RES-* capabilities.
+	QuotaResourceTypes() []imap.QuotaResourceType
+
+	// Authenticated state
+	GetQuota(root string) (*imap.QuotaData, error)
+	GetQuotaRoot(mailbox string) ([]imap.QuotaData, error)
+	SetQuota(root string, limits map[imap.QuotaResourceType]int64) error
+}
+
+// SessionID is an IMAP session which supports ID.
+type SessionID interface {
+	Session
+
+	// ID is called when the client sends its ID data. It returns the server's
+	// ID data, or nil.
+	//
+	// The client ID data may be nil.
+	ID(clientID *imap.IDData) *imap.IDData
+}
+
+// SessionNotify is an IMAP session which supports NOTIFY.
+//
+// Notify replaces the current notification settings. A nil options pointer
+// disables notifications. Once enabled, the session reports events for
+// mailboxes other than the selected one in Session.Poll and Session.Idle, via
+// UpdateWriter.WriteMailboxStatus and UpdateWriter.WriteMailboxList.
+type SessionNotify interface {
+	Session
+
+	// Authenticated state
+	Notify(w *UpdateWriter, options *imap.NotifyOptions) error
+}
+
+// SessionConcurrent is an IMAP session which is safe for concurrent use.
+//
+// If ConcurrentSafe returns true, the server may execute commands which don't
+// conflict with each other concurrently (e.g. a STATUS command while a FETCH
+// command is in progress), as allowed by RFC 9051 section 5.5. Commands which
+// change the connection state or the selected mailbox are always executed
+// once all previous commands have completed.
+type SessionConcurrent interface {
+	Session
+
+	ConcurrentSafe() bool
+}
+
+// SessionACL is an IMAP session which supports ACL.
+type SessionACL interface {
+	Session
+
+	// Authenticated state
+	SetACL(mailbox string, ri imap.RightsIdentifier, rm imap.RightModification, rights imap.RightSet) error
+	DeleteACL(mailbox string, ri imap.RightsIdentifier) error
+	GetACL(mailbox string) (*imap.GetACLData, error)
+	ListRights(mailbox string, ri imap.RightsIdentifier) (*imap.ListRightsData, error)
+	MyRights(mailbox string) (*imap.MyRightsData, error)
+}
+
 // SessionIMAP4rev2 is an IMAP session which supports IMAP4rev2.
 type SessionIMAP4rev2 interface {
 	Session
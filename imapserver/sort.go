@@ -0,0 +1,171 @@
+package imapserver
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+func (c *Conn) handleSort(tag string, dec *imapwire.Decoder, numKind NumKind) error {
+	if !dec.ExpectSP() {
+		return dec.Err()
+	}
+
+	var (
+		options  imap.SearchOptions
+		extended bool
+		atom     string
+	)
+	if maybeReadSearchKeyAtom(dec, &atom) {
+		if !strings.EqualFold(atom, "RETURN") {
+			return newClientBugError("Expected RETURN or sort criteria")
+		}
+		if err := readSearchReturnOpts(dec, &options); err != nil {
+			return err
+		}
+		if !dec.ExpectSP() {
+			return dec.Err()
+		}
+		extended = true
+	}
+
+	var sortCriteria []imap.SortCriterion
+	err := dec.ExpectList(func() error {
+		criterion, err := readSortCriterion(dec)
+		if err != nil {
+			return err
+		}
+		sortCriteria = append(sortCriteria, *criterion)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var charset string
+	if !dec.ExpectSP() || !dec.ExpectAString(&charset) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+	if err := checkSearchCharset(charset); err != nil {
+		return err
+	}
+
+	var criteria imap.SearchCriteria
+	if err := readSearchCriteria(&criteria, dec, ""); err != nil {
+		return err
+	}
+
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+
+	session, ok := c.session.(SessionSort)
+	if !ok {
+		return newClientBugError("SORT is not supported")
+	}
+
+	if extended && options.ReturnPartial == nil && !options.ReturnMin && !options.ReturnMax && !options.ReturnAll && !options.ReturnCount {
+		options.ReturnAll = true
+	}
+	if err := c.intercept(dec, numKind, &criteria, sortCriteria); err != nil {
+		return err
+	}
+
+	nums, err := session.Sort(numKind, &criteria, sortCriteria)
+	if err != nil {
+		return err
+	}
+
+	if extended {
+		return c.writeESearch(tag, newSortData(nums, numKind, &options), &options)
+	} else {
+		return c.writeSort(nums)
+	}
+}
+
+func readSortCriterion(dec *imapwire.Decoder) (*imap.SortCriterion, error) {
+	var criterion imap.SortCriterion
+
+	var name string
+	if !dec.ExpectAtom(&name) {
+		return nil, dec.Err()
+	}
+	name = strings.ToUpper(name)
+	if name == "REVERSE" {
+		criterion.Reverse = true
+		if !dec.ExpectSP() || !dec.ExpectAtom(&name) {
+			return nil, dec.Err()
+		}
+		name = strings.ToUpper(name)
+	}
+
+	switch key := imap.SortKey(name); key {
+	case imap.SortKeyArrival, imap.SortKeyCc, imap.SortKeyDate, imap.SortKeyFrom, imap.SortKeySize, imap.SortKeySubject, imap.SortKeyTo, imap.SortKeyDisplayFrom, imap.SortKeyDisplayTo:
+		criterion.Key = key
+	default:
+		return nil, newClientBugError("Unknown sort key")
+	}
+
+	return &criterion, nil
+}
+
+// newSortData builds ESORT result data from a sorted list of message numbers.
+func newSortData(nums []uint32, numKind NumKind, options *imap.SearchOptions) *imap.SearchData {
+	data := imap.SearchData{
+		UID:   numKind == NumKindUID,
+		Count: uint32(len(nums)),
+	}
+	if len(nums) > 0 {
+		// MIN and MAX refer to the lowest-sorted and highest-sorted messages
+		data.Min = nums[0]
+		data.Max = nums[len(nums)-1]
+	}
+	if options.ReturnAll {
+		data.All = sortedNumSet(nums)
+	}
+	if partial := options.ReturnPartial; partial != nil {
+		var l []uint32
+		if start := int(partial.Start) - 1; start < len(nums) {
+			stop := int(partial.Stop)
+			if stop > len(nums) {
+				stop = len(nums)
+			}
+			l = nums[start:stop]
+		}
+		data.Partial = &imap.SearchPartialData{
+			Range: *partial,
+			All:   sortedNumSet(l),
+		}
+	}
+	return &data
+}
+
+// sortedNumSet builds a NumSet which preserves the order of the provided
+// numbers.
+func sortedNumSet(nums []uint32) imap.NumSet {
+	var set imap.NumSet
+	for _, num := range nums {
+		if n := len(set); n > 0 && set[n-1].Stop+1 == num {
+			set[n-1].Stop = num
+		} else {
+			set = append(set, imap.NumRange{Start: num, Stop: num})
+		}
+	}
+	return set
+}
+
+func (c *Conn) writeSort(nums []uint32) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom("*").SP().Atom("SORT")
+	for _, num := range nums {
+		enc.SP().Number(num)
+	}
+	return enc.CRLF()
+}
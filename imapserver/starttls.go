@@ -7,7 +7,7 @@ import (
 	"net"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) canStartTLS() bool {
@@ -32,6 +32,9 @@ func (c *Conn) handleStartTLS(tag string, dec *imapwire.Decoder) error {
 			Text: "STARTTLS not available",
 		}
 	}
+	if err := c.intercept(dec); err != nil {
+		return err
+	}
 
 	// Do not allow to write cleartext data past this point: keep c.encMutex
 	// locked until the end
@@ -4,7 +4,7 @@ import (
 	"strings"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 func (c *Conn) handleStatus(dec *imapwire.Decoder) error {
@@ -36,6 +36,14 @@ func (c *Conn) handleStatus(dec *imapwire.Decoder) error {
 		return err
 	}
 
+	if err := c.intercept(dec, mailbox, &options); err != nil {
+		return err
+	}
+
+	if options.HighestModSeq {
+		c.enableCondStore()
+	}
+
 	data, err := c.session.Status(mailbox, &options)
 	if err != nil {
 		return err
@@ -79,6 +87,9 @@ func (c *Conn) writeStatus(data *imap.StatusData, options *imap.StatusOptions, r
 	if options.DeletedStorage {
 		listEnc.Item().Atom("DELETED-STORAGE").SP().Number64(*data.DeletedStorage)
 	}
+	if options.HighestModSeq {
+		listEnc.Item().Atom("HIGHESTMODSEQ").SP().ModSeq(data.HighestModSeq)
+	}
 	if recent {
 		listEnc.Item().Atom("RECENT").SP().Number(0)
 	}
@@ -109,6 +120,8 @@ func readStatusItem(dec *imapwire.Decoder, options *imap.StatusOptions) (isRecen
 		options.AppendLimit = true
 	case "DELETED-STORAGE":
 		options.DeletedStorage = true
+	case "HIGHESTMODSEQ":
+		options.HighestModSeq = true
 	case "RECENT":
 		isRecent = true
 	default:
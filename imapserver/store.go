@@ -1,23 +1,38 @@
 package imapserver
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
 	"github.com/emersion/go-imap/v2/internal"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
 )
 
-func (c *Conn) handleStore(dec *imapwire.Decoder, numKind NumKind) error {
+func (c *Conn) handleStore(tag string, dec *imapwire.Decoder, numKind NumKind) error {
 	var (
-		seqSet imap.NumSet
-		item   string
+		seqSet            imap.NumSet
+		item              string
+		options           imap.StoreOptions
+		hasUnchangedSince bool
 	)
-	if !dec.ExpectSP() || !dec.ExpectNumSet(&seqSet) || !dec.ExpectSP() || !dec.ExpectAtom(&item) || !dec.ExpectSP() {
+	if !dec.ExpectSP() || !dec.ExpectNumSet(&seqSet) || !dec.ExpectSP() {
 		return dec.Err()
 	}
-	var flags []imap.Flag
 	isList, err := dec.List(func() error {
+		return readStoreModifier(dec, &options, &hasUnchangedSince)
+	})
+	if err != nil {
+		return err
+	} else if isList && !dec.ExpectSP() {
+		return dec.Err()
+	}
+	if !dec.ExpectAtom(&item) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+	var flags []imap.Flag
+	isList, err = dec.List(func() error {
 		flag, err := internal.ExpectFlag(dec)
 		if err != nil {
 			return err
@@ -68,11 +83,86 @@ func (c *Conn) handleStore(dec *imapwire.Decoder, numKind NumKind) error {
 		return err
 	}
 
-	w := &FetchWriter{conn: c}
-	options := imap.StoreOptions{}
-	return c.session.Store(w, numKind, seqSet, &imap.StoreFlags{
+	storeFlags := &imap.StoreFlags{
 		Op:     op,
 		Silent: silent,
 		Flags:  flags,
-	}, &options)
+	}
+	if err := c.intercept(dec, numKind, seqSet, storeFlags, &options); err != nil {
+		return err
+	}
+
+	if hasUnchangedSince {
+		c.enableCondStore()
+	}
+
+	var modified imap.NumSet
+	if hasUnchangedSince && options.UnchangedSince == 0 {
+		// UNCHANGEDSINCE 0 always fails, see RFC 7162 section 3.1.3
+		modified = seqSet
+	} else {
+		w := &FetchWriter{conn: c}
+		err := c.session.Store(w, numKind, seqSet, storeFlags, &options)
+		var modErr *ModifiedError
+		if errors.As(err, &modErr) {
+			modified = modErr.NumSet
+		} else if err != nil {
+			return err
+		}
+	}
+
+	cmdName := "STORE"
+	if numKind == NumKindUID {
+		cmdName = "UID STORE"
+	}
+	if err := c.poll(cmdName); err != nil {
+		return err
+	}
+
+	return c.writeStoreOK(tag, modified)
+}
+
+func (c *Conn) writeStoreOK(tag string, modified imap.NumSet) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom(tag).SP().Atom("OK").SP()
+	if len(modified) > 0 {
+		enc.Special('[').Atom("MODIFIED").SP().NumSet(modified).Special(']').SP()
+		enc.Text("Conditional STORE failed")
+	} else {
+		enc.Text("STORE completed")
+	}
+	return enc.CRLF()
+}
+
+func readStoreModifier(dec *imapwire.Decoder, options *imap.StoreOptions, hasUnchangedSince *bool) error {
+	var name string
+	if !dec.ExpectAtom(&name) {
+		return dec.Err()
+	}
+	switch strings.ToUpper(name) {
+	case "UNCHANGEDSINCE":
+		if !dec.ExpectSP() || !dec.ExpectModSeq(&options.UnchangedSince) {
+			return dec.Err()
+		}
+		*hasUnchangedSince = true
+	default:
+		return newClientBugError("Unknown STORE modifier")
+	}
+	return nil
+}
+
+// ModifiedError is returned by Session.Store when the UNCHANGEDSINCE test
+// failed for some of the messages. The STORE command still completes
+// successfully, with a MODIFIED response code.
+type ModifiedError struct {
+	// Sequence numbers or UIDs (depending on the command) of the messages
+	// which have not been updated
+	NumSet imap.NumSet
+}
+
+// Error implements the error interface.
+func (err *ModifiedError) Error() string {
+	return fmt.Sprintf("imapserver: UNCHANGEDSINCE test failed for messages %v", err.NumSet)
 }
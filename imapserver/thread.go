@@ -0,0 +1,92 @@
+package imapserver
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+func (c *Conn) handleThread(dec *imapwire.Decoder, numKind NumKind) error {
+	var algorithm, charset string
+	if !dec.ExpectSP() || !dec.ExpectAtom(&algorithm) || !dec.ExpectSP() || !dec.ExpectAString(&charset) || !dec.ExpectSP() {
+		return dec.Err()
+	}
+	if err := checkSearchCharset(charset); err != nil {
+		return err
+	}
+
+	var criteria imap.SearchCriteria
+	if err := readSearchCriteria(&criteria, dec, ""); err != nil {
+		return err
+	}
+
+	if !dec.ExpectCRLF() {
+		return dec.Err()
+	}
+
+	if err := c.checkState(imap.ConnStateSelected); err != nil {
+		return err
+	}
+
+	session, ok := c.session.(SessionThread)
+	if !ok {
+		return newClientBugError("THREAD is not supported")
+	}
+
+	alg, ok := c.threadAlgorithm(algorithm)
+	if !ok {
+		return newClientBugError("Unsupported threading algorithm")
+	}
+	if err := c.intercept(dec, numKind, alg, &criteria); err != nil {
+		return err
+	}
+
+	threads, err := session.Thread(numKind, alg, &criteria)
+	if err != nil {
+		return err
+	}
+
+	return c.writeThread(threads)
+}
+
+// threadAlgorithm looks up a threading algorithm advertised by the server.
+func (c *Conn) threadAlgorithm(name string) (imap.ThreadAlgorithm, bool) {
+	for _, alg := range c.server.options.caps().ThreadAlgorithms() {
+		if strings.EqualFold(string(alg), name) {
+			return alg, true
+		}
+	}
+	return "", false
+}
+
+func (c *Conn) writeThread(threads []imap.ThreadData) error {
+	enc := newResponseEncoder(c)
+	defer enc.end()
+
+	enc.Atom("*").SP().Atom("THREAD")
+	if len(threads) > 0 {
+		enc.SP()
+	}
+	for i := range threads {
+		writeThreadList(enc.Encoder, &threads[i])
+	}
+	return enc.CRLF()
+}
+
+func writeThreadList(enc *imapwire.Encoder, data *imap.ThreadData) {
+	enc.Special('(')
+	for i, num := range data.Chain {
+		if i > 0 {
+			enc.SP()
+		}
+		enc.Number(num)
+	}
+	for i := range data.SubThreads {
+		if i == 0 && len(data.Chain) > 0 {
+			enc.SP()
+		}
+		writeThreadList(enc, &data.SubThreads[i])
+	}
+	enc.Special(')')
+}
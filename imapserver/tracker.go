@@ -72,6 +72,18 @@ func (t *MailboxTracker) QueueExpunge(seqNum uint32) {
 	t.queueUpdate(&trackerUpdate{expunge: seqNum}, nil)
 }
 
+// QueueExpungeUID queues a new EXPUNGE update for a message with a known UID.
+//
+// Clients which have enabled QRESYNC receive a VANISHED response instead of
+// an EXPUNGE response. Servers supporting QRESYNC must use this method instead
+// of QueueExpunge.
+func (t *MailboxTracker) QueueExpungeUID(seqNum uint32, uid imap.UID) {
+	if seqNum == 0 {
+		panic("imapserver: invalid expunge message sequence number")
+	}
+	t.queueUpdate(&trackerUpdate{expunge: seqNum, expungeUID: uid}, nil)
+}
+
 // QueueNumMessages queues a new EXISTS update.
 func (t *MailboxTracker) QueueNumMessages(n uint32) {
 	// TODO: merge consecutive NumMessages updates
@@ -90,15 +102,25 @@ func (t *MailboxTracker) QueueMailboxFlags(flags []imap.Flag) {
 //
 // If source is not nil, the update won't be dispatched to it.
 func (t *MailboxTracker) QueueMessageFlags(seqNum uint32, uid imap.UID, flags []imap.Flag, source *SessionTracker) {
+	t.QueueMessageFlagsModSeq(seqNum, uid, flags, 0, source)
+}
+
+// QueueMessageFlagsModSeq queues a new FETCH FLAGS update with the message's
+// new modification sequence.
+//
+// If source is not nil, the update won't be dispatched to it.
+func (t *MailboxTracker) QueueMessageFlagsModSeq(seqNum uint32, uid imap.UID, flags []imap.Flag, modSeq uint64, source *SessionTracker) {
 	t.queueUpdate(&trackerUpdate{fetch: &trackerUpdateFetch{
 		seqNum: seqNum,
 		uid:    uid,
 		flags:  flags,
+		modSeq: modSeq,
 	}}, source)
 }
 
 type trackerUpdate struct {
 	expunge      uint32
+	expungeUID   imap.UID
 	numMessages  uint32
 	mailboxFlags []imap.Flag
 	fetch        *trackerUpdateFetch
@@ -108,6 +130,7 @@ type trackerUpdateFetch struct {
 	seqNum uint32
 	uid    imap.UID
 	flags  []imap.Flag
+	modSeq uint64
 }
 
 // SessionTracker tracks the state of a mailbox for an IMAP client.
@@ -117,6 +140,49 @@ type SessionTracker struct {
 	mutex   sync.Mutex
 	queue   []trackerUpdate
 	updates chan<- struct{}
+	notify  bool
+	// Event group for the selected mailbox, nil if there is none
+	notifyItem *imap.NotifyItem
+}
+
+// SetNotify applies NOTIFY settings to the session. A nil options pointer
+// disables notifications.
+//
+// When notifications are enabled, the "selected" and "selected-delayed" event
+// groups control the updates sent by Idle: if no such group is specified, or
+// if it doesn't contain any event, Idle doesn't send any update. With
+// "selected-delayed", expunges are only sent by Poll. If the event group
+// doesn't contain FlagChange, flag updates are never sent.
+func (t *SessionTracker) SetNotify(options *imap.NotifyOptions) {
+	var item *imap.NotifyItem
+	if options != nil {
+		for i := range options.Items {
+			spec := options.Items[i].MailboxSpec
+			if spec == imap.NotifyMailboxSpecSelected || spec == imap.NotifyMailboxSpecSelectedDelayed {
+				item = &options.Items[i]
+				break
+			}
+		}
+	}
+
+	t.mutex.Lock()
+	t.notify = options != nil
+	t.notifyItem = item
+	t.mutex.Unlock()
+}
+
+// notifyEventLocked returns false if NOTIFY settings prevent an event from
+// being reported.
+func (t *SessionTracker) notifyEventLocked(event imap.NotifyEvent) bool {
+	if !t.notify || t.notifyItem == nil || len(t.notifyItem.Events) == 0 {
+		return true
+	}
+	for _, ev := range t.notifyItem.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
 }
 
 // Close unregisters the session.
@@ -148,6 +214,15 @@ func (t *SessionTracker) queueUpdate(update *trackerUpdate) {
 func (t *SessionTracker) Poll(w *UpdateWriter, allowExpunge bool) error {
 	var updates []trackerUpdate
 	t.mutex.Lock()
+	if !t.notifyEventLocked(imap.NotifyEventFlagChange) {
+		queue := t.queue[:0]
+		for _, update := range t.queue {
+			if update.fetch == nil {
+				queue = append(queue, update)
+			}
+		}
+		t.queue = queue
+	}
 	if allowExpunge {
 		updates = t.queue
 		t.queue = nil
@@ -171,6 +246,8 @@ func (t *SessionTracker) Poll(w *UpdateWriter, allowExpunge bool) error {
 	for _, update := range updates {
 		var err error
 		switch {
+		case update.expunge != 0 && update.expungeUID != 0:
+			err = w.WriteExpungeUID(update.expunge, update.expungeUID)
 		case update.expunge != 0:
 			err = w.WriteExpunge(update.expunge)
 		case update.numMessages != 0:
@@ -178,7 +255,7 @@ func (t *SessionTracker) Poll(w *UpdateWriter, allowExpunge bool) error {
 		case update.mailboxFlags != nil:
 			err = w.WriteMailboxFlags(update.mailboxFlags)
 		case update.fetch != nil:
-			err = w.WriteMessageFlags(update.fetch.seqNum, update.fetch.uid, update.fetch.flags)
+			err = w.WriteMessageFlagsModSeq(update.fetch.seqNum, update.fetch.uid, update.fetch.flags, update.fetch.modSeq)
 		default:
 			panic(fmt.Errorf("imapserver: unknown tracker update %#v", update))
 		}
@@ -201,6 +278,8 @@ func (t *SessionTracker) Idle(w *UpdateWriter, stop <-chan struct{}) error {
 	if ok {
 		t.updates = updates
 	}
+	silent := t.notify && (t.notifyItem == nil || len(t.notifyItem.Events) == 0)
+	allowExpunge := !t.notify || t.notifyItem == nil || t.notifyItem.MailboxSpec != imap.NotifyMailboxSpecSelectedDelayed
 	t.mutex.Unlock()
 	if !ok {
 		return fmt.Errorf("imapserver: only a single SessionTracker.Idle call is allowed at a time")
@@ -212,10 +291,15 @@ func (t *SessionTracker) Idle(w *UpdateWriter, stop <-chan struct{}) error {
 		t.mutex.Unlock()
 	}()
 
+	if silent {
+		<-stop
+		return nil
+	}
+
 	for {
 		select {
 		case <-updates:
-			if err := t.Poll(w, true); err != nil {
+			if err := t.Poll(w, allowExpunge); err != nil {
 				return err
 			}
 		case <-stop:
@@ -22,7 +22,10 @@ func IsAtomChar(ch byte) bool {
 	}
 }
 
-// DecoderExpectError is an error due to the Decoder.Expect family of methods.
+// DecoderExpectError is a syntax error.
+//
+// It's returned by the "Expect" family of Decoder methods. It can also be
+// returned by callers when the decoded data doesn't match the grammar.
 type DecoderExpectError struct {
 	Message string
 }
@@ -115,8 +118,8 @@ func (dec *Decoder) EOF() bool {
 	return false
 }
 
-// Expect sets the decoder error if ok is false.
-func (dec *Decoder) Expect(ok bool, name string) bool {
+// expect sets the decoder error if ok is false.
+func (dec *Decoder) expect(ok bool, name string) bool {
 	if !ok {
 		msg := fmt.Sprintf("expected %v", name)
 		if dec.r.Buffered() > 0 {
@@ -128,6 +131,8 @@ func (dec *Decoder) Expect(ok bool, name string) bool {
 	return true
 }
 
+// SP decodes a space. The space may be omitted before a parenthesized list,
+// to be liberal in what is accepted.
 func (dec *Decoder) SP() bool {
 	if dec.acceptByte(' ') {
 		return true
@@ -142,10 +147,12 @@ func (dec *Decoder) SP() bool {
 	return b == '('
 }
 
+// ExpectSP is like SP, but sets the decoder error on failure.
 func (dec *Decoder) ExpectSP() bool {
-	return dec.Expect(dec.SP(), "SP")
+	return dec.expect(dec.SP(), "SP")
 }
 
+// CRLF decodes the end of a line. A lone LF is accepted.
 func (dec *Decoder) CRLF() bool {
 	dec.acceptByte(' ')  // https://github.com/emersion/go-imap/issues/540
 	dec.acceptByte('\r') // be liberal in what we receive and accept lone LF
@@ -156,10 +163,12 @@ func (dec *Decoder) CRLF() bool {
 	return true
 }
 
+// ExpectCRLF is like CRLF, but sets the decoder error on failure.
 func (dec *Decoder) ExpectCRLF() bool {
-	return dec.Expect(dec.CRLF(), "CRLF")
+	return dec.expect(dec.CRLF(), "CRLF")
 }
 
+// Func decodes a non-empty sequence of bytes accepted by the valid function.
 func (dec *Decoder) Func(ptr *string, valid func(ch byte) bool) bool {
 	var sb strings.Builder
 	for {
@@ -182,27 +191,33 @@ func (dec *Decoder) Func(ptr *string, valid func(ch byte) bool) bool {
 	return true
 }
 
+// Atom decodes an atom.
 func (dec *Decoder) Atom(ptr *string) bool {
 	return dec.Func(ptr, IsAtomChar)
 }
 
+// ExpectAtom is like Atom, but sets the decoder error on failure.
 func (dec *Decoder) ExpectAtom(ptr *string) bool {
-	return dec.Expect(dec.Atom(ptr), "atom")
+	return dec.expect(dec.Atom(ptr), "atom")
 }
 
+// ExpectNIL decodes NIL, and sets the decoder error on failure.
 func (dec *Decoder) ExpectNIL() bool {
 	var s string
-	return dec.ExpectAtom(&s) && dec.Expect(s == "NIL", "NIL")
+	return dec.ExpectAtom(&s) && dec.expect(s == "NIL", "NIL")
 }
 
+// Special decodes a special character, such as '(' or ')'.
 func (dec *Decoder) Special(b byte) bool {
 	return dec.acceptByte(b)
 }
 
+// ExpectSpecial is like Special, but sets the decoder error on failure.
 func (dec *Decoder) ExpectSpecial(b byte) bool {
-	return dec.Expect(dec.Special(b), fmt.Sprintf("'%v'", string(b)))
+	return dec.expect(dec.Special(b), fmt.Sprintf("'%v'", string(b)))
 }
 
+// Text decodes human-readable text, up to the end of the line.
 func (dec *Decoder) Text(ptr *string) bool {
 	var sb strings.Builder
 	for {
@@ -222,22 +237,13 @@ func (dec *Decoder) Text(ptr *string) bool {
 	return true
 }
 
+// ExpectText is like Text, but sets the decoder error on failure.
 func (dec *Decoder) ExpectText(ptr *string) bool {
-	return dec.Expect(dec.Text(ptr), "text")
-}
-
-func (dec *Decoder) DiscardUntilByte(untilCh byte) {
-	for {
-		ch, ok := dec.readByte()
-		if !ok {
-			return
-		} else if ch == untilCh {
-			dec.mustUnreadByte()
-			return
-		}
-	}
+	return dec.expect(dec.Text(ptr), "text")
 }
 
+// DiscardLine discards data up to and including the end of the line. It does
+// nothing if the end of the line has just been decoded.
 func (dec *Decoder) DiscardLine() {
 	if dec.crlf {
 		return
@@ -247,6 +253,8 @@ func (dec *Decoder) DiscardLine() {
 	dec.CRLF()
 }
 
+// DiscardValue discards a single value: an atom, a string or a
+// parenthesized list.
 func (dec *Decoder) DiscardValue() bool {
 	var s string
 	if dec.String(&s) {
@@ -269,7 +277,7 @@ func (dec *Decoder) DiscardValue() bool {
 		return true
 	}
 
-	dec.Expect(false, "value")
+	dec.expect(false, "value")
 	return false
 }
 
@@ -291,6 +299,7 @@ func (dec *Decoder) numberStr() (s string, ok bool) {
 	return sb.String(), true
 }
 
+// Number decodes a 32-bit number.
 func (dec *Decoder) Number(ptr *uint32) bool {
 	s, ok := dec.numberStr()
 	if !ok {
@@ -304,10 +313,12 @@ func (dec *Decoder) Number(ptr *uint32) bool {
 	return true
 }
 
+// ExpectNumber is like Number, but sets the decoder error on failure.
 func (dec *Decoder) ExpectNumber(ptr *uint32) bool {
-	return dec.Expect(dec.Number(ptr), "number")
+	return dec.expect(dec.Number(ptr), "number")
 }
 
+// Number64 decodes a 63-bit number.
 func (dec *Decoder) Number64(ptr *int64) bool {
 	s, ok := dec.numberStr()
 	if !ok {
@@ -321,10 +332,12 @@ func (dec *Decoder) Number64(ptr *int64) bool {
 	return true
 }
 
+// ExpectNumber64 is like Number64, but sets the decoder error on failure.
 func (dec *Decoder) ExpectNumber64(ptr *int64) bool {
-	return dec.Expect(dec.Number64(ptr), "number64")
+	return dec.expect(dec.Number64(ptr), "number64")
 }
 
+// ModSeq decodes a mod-sequence value.
 func (dec *Decoder) ModSeq(ptr *uint64) bool {
 	s, ok := dec.numberStr()
 	if !ok {
@@ -338,10 +351,12 @@ func (dec *Decoder) ModSeq(ptr *uint64) bool {
 	return true
 }
 
+// ExpectModSeq is like ModSeq, but sets the decoder error on failure.
 func (dec *Decoder) ExpectModSeq(ptr *uint64) bool {
-	return dec.Expect(dec.ModSeq(ptr), "mod-sequence-value")
+	return dec.expect(dec.ModSeq(ptr), "mod-sequence-value")
 }
 
+// Quoted decodes a quoted string.
 func (dec *Decoder) Quoted(ptr *string) bool {
 	if !dec.Special('"') {
 		return false
@@ -370,6 +385,8 @@ func (dec *Decoder) Quoted(ptr *string) bool {
 	return true
 }
 
+// ExpectAString decodes an astring: an atom, a quoted string or a literal.
+// The decoder error is set on failure.
 func (dec *Decoder) ExpectAString(ptr *string) bool {
 	if dec.Quoted(ptr) {
 		return true
@@ -381,18 +398,24 @@ func (dec *Decoder) ExpectAString(ptr *string) bool {
 	return dec.ExpectAtom(ptr)
 }
 
+// String decodes a string: a quoted string or a literal.
+//
+// Literals are buffered in memory, see CheckBufferedLiteralFunc.
 func (dec *Decoder) String(ptr *string) bool {
 	return dec.Quoted(ptr) || dec.Literal(ptr)
 }
 
+// ExpectString is like String, but sets the decoder error on failure.
 func (dec *Decoder) ExpectString(ptr *string) bool {
-	return dec.Expect(dec.String(ptr), "string")
+	return dec.expect(dec.String(ptr), "string")
 }
 
+// ExpectNString decodes a string or NIL. NIL is decoded as an empty string.
+// The decoder error is set on failure.
 func (dec *Decoder) ExpectNString(ptr *string) bool {
 	var s string
 	if dec.Atom(&s) {
-		if !dec.Expect(s == "NIL", "nstring") {
+		if !dec.expect(s == "NIL", "nstring") {
 			return false
 		}
 		*ptr = ""
@@ -401,10 +424,15 @@ func (dec *Decoder) ExpectNString(ptr *string) bool {
 	return dec.ExpectString(ptr)
 }
 
+// ExpectNStringReader decodes a string or NIL, and returns a reader for its
+// contents. A nil reader is returned for NIL. The decoder error is set on
+// failure.
+//
+// See LiteralReader for details about streaming literals.
 func (dec *Decoder) ExpectNStringReader() (lit *LiteralReader, nonSync, ok bool) {
 	var s string
 	if dec.Atom(&s) {
-		if !dec.Expect(s == "NIL", "nstring") {
+		if !dec.expect(s == "NIL", "nstring") {
 			return nil, false, false
 		}
 		return nil, true, true
@@ -416,10 +444,13 @@ func (dec *Decoder) ExpectNStringReader() (lit *LiteralReader, nonSync, ok bool)
 	if lit, nonSync, ok = dec.LiteralReader(); ok {
 		return lit, nonSync, true
 	} else {
-		return nil, false, dec.Expect(false, "nstring")
+		return nil, false, dec.expect(false, "nstring")
 	}
 }
 
+// List decodes a parenthesized list. f is called for each list item, and
+// must decode exactly one item. isList is false if the next value isn't a
+// list.
 func (dec *Decoder) List(f func() error) (isList bool, err error) {
 	if !dec.Special('(') {
 		return false, nil
@@ -441,20 +472,23 @@ func (dec *Decoder) List(f func() error) (isList bool, err error) {
 	}
 }
 
+// ExpectList is like List, but returns an error if the next value isn't a
+// list.
 func (dec *Decoder) ExpectList(f func() error) error {
 	isList, err := dec.List(f)
 	if err != nil {
 		return err
-	} else if !dec.Expect(isList, "(") {
+	} else if !dec.expect(isList, "(") {
 		return dec.Err()
 	}
 	return nil
 }
 
+// ExpectNList is like ExpectList, but also accepts NIL.
 func (dec *Decoder) ExpectNList(f func() error) error {
 	var s string
 	if dec.Atom(&s) {
-		if !dec.Expect(s == "NIL", "NIL") {
+		if !dec.expect(s == "NIL", "NIL") {
 			return dec.Err()
 		}
 		return nil
@@ -462,6 +496,9 @@ func (dec *Decoder) ExpectNList(f func() error) error {
 	return dec.ExpectList(f)
 }
 
+// ExpectMailbox decodes a mailbox name. Names encoded with modified UTF-7
+// are converted to UTF-8, and INBOX is case-insensitive. The decoder error is
+// set on failure.
 func (dec *Decoder) ExpectMailbox(ptr *string) bool {
 	var name string
 	if !dec.ExpectAString(&name) {
@@ -478,6 +515,7 @@ func (dec *Decoder) ExpectMailbox(ptr *string) bool {
 	return dec.returnErr(err)
 }
 
+// ExpectUID decodes a UID, and sets the decoder error on failure.
 func (dec *Decoder) ExpectUID(ptr *imap.UID) bool {
 	var num uint32
 	if !dec.ExpectNumber(&num) {
@@ -487,6 +525,8 @@ func (dec *Decoder) ExpectUID(ptr *imap.UID) bool {
 	return true
 }
 
+// ExpectNumSet decodes a sequence set, or "$" which is decoded as
+// imap.SearchRes. The decoder error is set on failure.
 func (dec *Decoder) ExpectNumSet(ptr *imap.NumSet) bool {
 	if dec.Special('$') {
 		*ptr = imap.SearchRes()
@@ -494,7 +534,7 @@ func (dec *Decoder) ExpectNumSet(ptr *imap.NumSet) bool {
 	}
 
 	var s string
-	if !dec.Expect(dec.Func(&s, isNumSetChar), "sequence-set") {
+	if !dec.expect(dec.Func(&s, isNumSetChar), "sequence-set") {
 		return false
 	}
 	seqSet, err := ParseNumSet(s)
@@ -508,6 +548,8 @@ func isNumSetChar(ch byte) bool {
 	return ch == '*' || IsAtomChar(ch)
 }
 
+// Literal decodes a literal, and buffers it in memory. See
+// CheckBufferedLiteralFunc.
 func (dec *Decoder) Literal(ptr *string) bool {
 	lit, nonSync, ok := dec.LiteralReader()
 	if !ok {
@@ -527,6 +569,13 @@ func (dec *Decoder) Literal(ptr *string) bool {
 	return dec.returnErr(err)
 }
 
+// LiteralReader decodes a literal header, and returns a reader for the literal
+// contents. nonSync indicates whether the literal is non-synchronizing, which
+// is only meaningful on the server side: for synchronizing literals, the
+// server must send a continuation request before the client sends the
+// literal contents.
+//
+// See LiteralReader for details about streaming literals.
 func (dec *Decoder) LiteralReader() (lit *LiteralReader, nonSync, ok bool) {
 	if !dec.Special('{') {
 		return nil, false, false
@@ -550,14 +599,19 @@ func (dec *Decoder) LiteralReader() (lit *LiteralReader, nonSync, ok bool) {
 	return lit, nonSync, true
 }
 
+// ExpectLiteralReader is like LiteralReader, but returns an error on failure.
 func (dec *Decoder) ExpectLiteralReader() (lit *LiteralReader, nonSync bool, err error) {
 	lit, nonSync, ok := dec.LiteralReader()
-	if !dec.Expect(ok, "literal") {
+	if !dec.expect(ok, "literal") {
 		return nil, false, dec.Err()
 	}
 	return lit, nonSync, nil
 }
 
+// LiteralReader reads the contents of a literal.
+//
+// The literal must be read until io.EOF before decoding more data with the
+// Decoder.
 type LiteralReader struct {
 	dec  *Decoder
 	size int64
@@ -571,10 +625,12 @@ func newLiteralReaderFromString(s string) *LiteralReader {
 	}
 }
 
+// Size returns the size of the literal, in bytes.
 func (lit *LiteralReader) Size() int64 {
 	return lit.size
 }
 
+// Read implements io.Reader.
 func (lit *LiteralReader) Read(b []byte) (int, error) {
 	n, err := lit.r.Read(b)
 	if err == io.EOF {
@@ -72,18 +72,23 @@ func (enc *Encoder) CRLF() error {
 	return enc.w.Flush()
 }
 
+// Atom writes an atom. The caller is responsible for validating s.
 func (enc *Encoder) Atom(s string) *Encoder {
 	return enc.writeString(s)
 }
 
+// SP writes a space.
 func (enc *Encoder) SP() *Encoder {
 	return enc.writeString(" ")
 }
 
+// Special writes a special character, such as '(' or ')'.
 func (enc *Encoder) Special(ch byte) *Encoder {
 	return enc.writeString(string(ch))
 }
 
+// Quoted writes a quoted string. See String to automatically pick between a
+// quoted string and a literal.
 func (enc *Encoder) Quoted(s string) *Encoder {
 	var sb strings.Builder
 	sb.Grow(2 + len(s))
@@ -99,6 +104,7 @@ func (enc *Encoder) Quoted(s string) *Encoder {
 	return enc.writeString(sb.String())
 }
 
+// String writes a string, as a quoted string if possible or as a literal.
 func (enc *Encoder) String(s string) *Encoder {
 	if !enc.validQuoted(s) {
 		enc.stringLiteral(s)
@@ -149,6 +155,7 @@ func (enc *Encoder) stringLiteral(s string) {
 	}
 }
 
+// Mailbox writes a mailbox name. The name is encoded with modified UTF-7.
 func (enc *Encoder) Mailbox(name string) *Encoder {
 	if strings.EqualFold(name, "INBOX") {
 		return enc.Atom("INBOX")
@@ -158,6 +165,7 @@ func (enc *Encoder) Mailbox(name string) *Encoder {
 	}
 }
 
+// NumSet writes a sequence set. Empty sets are rejected.
 func (enc *Encoder) NumSet(seqSet imap.NumSet) *Encoder {
 	s := seqSet.String()
 	if s == "" {
@@ -167,6 +175,7 @@ func (enc *Encoder) NumSet(seqSet imap.NumSet) *Encoder {
 	return enc.writeString(s)
 }
 
+// Flag writes a flag.
 func (enc *Encoder) Flag(flag imap.Flag) *Encoder {
 	if flag != "\\*" && !isValidFlag(string(flag)) {
 		enc.setErr(fmt.Errorf("imapwire: invalid flag %q", flag))
@@ -175,6 +184,7 @@ func (enc *Encoder) Flag(flag imap.Flag) *Encoder {
 	return enc.writeString(string(flag))
 }
 
+// MailboxAttr writes a mailbox attribute.
 func (enc *Encoder) MailboxAttr(attr imap.MailboxAttr) *Encoder {
 	if !strings.HasPrefix(string(attr), "\\") || !isValidFlag(string(attr)) {
 		enc.setErr(fmt.Errorf("imapwire: invalid mailbox attribute %q", attr))
@@ -201,15 +211,18 @@ func isValidFlag(s string) bool {
 	return len(s) > 0
 }
 
+// Number writes a 32-bit number.
 func (enc *Encoder) Number(v uint32) *Encoder {
 	return enc.writeString(strconv.FormatUint(uint64(v), 10))
 }
 
+// Number64 writes a 63-bit number.
 func (enc *Encoder) Number64(v int64) *Encoder {
 	// TODO: disallow negative values
 	return enc.writeString(strconv.FormatInt(v, 10))
 }
 
+// ModSeq writes a mod-sequence value.
 func (enc *Encoder) ModSeq(v uint64) *Encoder {
 	// TODO: disallow zero values
 	return enc.writeString(strconv.FormatUint(v, 10))
@@ -228,19 +241,24 @@ func (enc *Encoder) List(n int, f func(i int)) *Encoder {
 	return enc
 }
 
+// BeginList starts writing a parenthesized list. This is useful when the
+// number of items isn't known in advance, see List otherwise.
 func (enc *Encoder) BeginList() *ListEncoder {
 	enc.Special('(')
 	return &ListEncoder{enc: enc}
 }
 
+// NIL writes NIL.
 func (enc *Encoder) NIL() *Encoder {
 	return enc.Atom("NIL")
 }
 
+// Text writes human-readable text.
 func (enc *Encoder) Text(s string) *Encoder {
 	return enc.writeString(s)
 }
 
+// UID writes a UID.
 func (enc *Encoder) UID(uid imap.UID) *Encoder {
 	return enc.Number(uint32(uid))
 }
@@ -318,11 +336,13 @@ func (lw *literalWriter) Close() error {
 	return nil
 }
 
+// ListEncoder writes a parenthesized list item by item.
 type ListEncoder struct {
 	enc *Encoder
 	n   int
 }
 
+// Item starts writing a new list item.
 func (le *ListEncoder) Item() *Encoder {
 	if le.n > 0 {
 		le.enc.SP()
@@ -331,6 +351,7 @@ func (le *ListEncoder) Item() *Encoder {
 	return le.enc
 }
 
+// End finishes writing the list.
 func (le *ListEncoder) End() {
 	le.enc.Special(')')
 	le.enc = nil
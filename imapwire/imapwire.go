@@ -0,0 +1,92 @@
+// Package imapwire implements the IMAP wire protocol.
+//
+// The IMAP wire protocol is defined in RFC 9051 section 4.
+//
+// This package is intended for extension authors: it can be used to encode
+// and decode the arguments of commands and responses which aren't natively
+// supported by imapclient and imapserver.
+//
+// # Encoding
+//
+// An Encoder writes IMAP data to a bufio.Writer. Errors are deferred until
+// Encoder.CRLF is called, so that calls can be chained:
+//
+//	enc.Atom("A1").SP().Atom("SELECT").SP().Mailbox("INBOX")
+//	err := enc.CRLF()
+//
+// # Decoding
+//
+// A Decoder reads IMAP data from a bufio.Reader. Decoding methods return a
+// boolean: methods named after a grammar element return false if the next
+// element is something else, and "Expect" methods additionally record an
+// error which can be retrieved with Decoder.Err:
+//
+//	var tag, name string
+//	if !dec.ExpectAtom(&tag) || !dec.ExpectSP() || !dec.ExpectAtom(&name) {
+//		return dec.Err()
+//	}
+//
+// # Literals
+//
+// Literals are used for strings which cannot be represented as quoted
+// strings, for instance because they contain line breaks. Encoder.String and
+// Decoder.String pick the right representation automatically and buffer the
+// string in memory. Large payloads can be streamed with Encoder.Literal and
+// Decoder.LiteralReader.
+//
+// Clients must wait for a continuation request from the server before
+// sending a synchronizing literal. A ContinuationRequest is passed to
+// Encoder.Literal for this purpose: the encoder blocks until the receiving
+// side calls ContinuationRequest.Done, or ContinuationRequest.Cancel to abort
+// the literal. Encoder.NewContinuationRequest is used when Encoder.String
+// needs to send a literal.
+package imapwire
+
+import (
+	"fmt"
+)
+
+// ConnSide describes the side of a connection: client or server.
+type ConnSide int
+
+const (
+	ConnSideClient ConnSide = 1 + iota // client side, sending commands
+	ConnSideServer                     // server side, sending responses
+)
+
+// ContinuationRequest is a continuation request.
+//
+// The sender must call either Done or Cancel. The receiver must call Wait.
+type ContinuationRequest struct {
+	done chan struct{}
+	err  error
+	text string
+}
+
+// NewContinuationRequest creates a new pending continuation request.
+func NewContinuationRequest() *ContinuationRequest {
+	return &ContinuationRequest{done: make(chan struct{})}
+}
+
+// Cancel cancels the continuation request. If err is nil, a generic error is
+// used.
+func (cont *ContinuationRequest) Cancel(err error) {
+	if err == nil {
+		err = fmt.Errorf("imapwire: continuation request cancelled")
+	}
+	cont.err = err
+	close(cont.done)
+}
+
+// Done marks the continuation request as accepted. text is the text sent
+// along the continuation request by the server.
+func (cont *ContinuationRequest) Done(text string) {
+	cont.text = text
+	close(cont.done)
+}
+
+// Wait blocks until Done or Cancel is called.
+func (cont *ContinuationRequest) Wait() (string, error) {
+	<-cont.done
+	return cont.text, cont.err
+}
@@ -0,0 +1,261 @@
+package imapwire_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapwire"
+)
+
+// roundTrip encodes data with encode on the server side, and decodes it on
+// the client side with decode. The decoder is expected to reach the end of
+// the data.
+func roundTrip(t *testing.T, encode func(enc *imapwire.Encoder), decode func(dec *imapwire.Decoder) bool) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	enc := imapwire.NewEncoder(bw, imapwire.ConnSideServer)
+	encode(enc)
+	if err := enc.CRLF(); err != nil {
+		t.Fatalf("Encoder.CRLF() = %v", err)
+	}
+
+	encoded := buf.String()
+	dec := imapwire.NewDecoder(bufio.NewReader(&buf), imapwire.ConnSideClient)
+	if !decode(dec) || !dec.ExpectCRLF() {
+		t.Fatalf("failed to decode %q: %v", encoded, dec.Err())
+	}
+	if !dec.EOF() {
+		t.Fatalf("failed to decode %q: trailing data", encoded)
+	}
+}
+
+func FuzzString(f *testing.F) {
+	f.Add("", false)
+	f.Add("hello", false)
+	f.Add(`quoted "string" with \ backslash`, false)
+	f.Add("line\r\nbreak", false)
+	f.Add("café", false)
+	f.Add("café", true)
+	f.Add(strings.Repeat("a", 4097), false)
+	f.Add("\x00", false)
+	f.Fuzz(func(t *testing.T, s string, quotedUTF8 bool) {
+		var got string
+		roundTrip(t, func(enc *imapwire.Encoder) {
+			enc.QuotedUTF8 = quotedUTF8
+			enc.String(s)
+		}, func(dec *imapwire.Decoder) bool {
+			return dec.ExpectString(&got)
+		})
+		if got != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+	})
+}
+
+func FuzzMailbox(f *testing.F) {
+	f.Add("INBOX")
+	f.Add("inbox")
+	f.Add("Archive/2023")
+	f.Add("Entwürfe")
+	f.Add("&")
+	f.Add("日本語")
+	f.Fuzz(func(t *testing.T, name string) {
+		if !utf8.ValidString(name) {
+			t.Skip("mailbox names must be valid UTF-8")
+		}
+		want := name
+		if strings.EqualFold(name, "INBOX") {
+			want = "INBOX"
+		}
+
+		var got string
+		roundTrip(t, func(enc *imapwire.Encoder) {
+			enc.Mailbox(name)
+		}, func(dec *imapwire.Decoder) bool {
+			return dec.ExpectMailbox(&got)
+		})
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func FuzzNumber(f *testing.F) {
+	f.Add(uint32(0), int64(0), uint64(1))
+	f.Add(uint32(42), int64(1)<<40, uint64(1)<<63)
+	f.Add(^uint32(0), int64(^uint64(0)>>1), ^uint64(0))
+	f.Fuzz(func(t *testing.T, n uint32, n64 int64, modSeq uint64) {
+		if n64 < 0 {
+			t.Skip("number64 must be non-negative")
+		}
+
+		var (
+			gotN      uint32
+			gotN64    int64
+			gotModSeq uint64
+		)
+		roundTrip(t, func(enc *imapwire.Encoder) {
+			enc.Number(n).SP().Number64(n64).SP().ModSeq(modSeq)
+		}, func(dec *imapwire.Decoder) bool {
+			return dec.ExpectNumber(&gotN) && dec.ExpectSP() && dec.ExpectNumber64(&gotN64) && dec.ExpectSP() && dec.ExpectModSeq(&gotModSeq)
+		})
+		if gotN != n || gotN64 != n64 || gotModSeq != modSeq {
+			t.Errorf("got (%v, %v, %v), want (%v, %v, %v)", gotN, gotN64, gotModSeq, n, n64, modSeq)
+		}
+	})
+}
+
+func FuzzNumSet(f *testing.F) {
+	f.Add("1")
+	f.Add("1:*")
+	f.Add("1,3:5,7:*")
+	f.Add("4294967295")
+	f.Fuzz(func(t *testing.T, s string) {
+		numSet, err := imapwire.ParseNumSet(s)
+		if err != nil || numSet.String() == "" {
+			t.Skip("invalid sequence set")
+		}
+
+		var got imap.NumSet
+		roundTrip(t, func(enc *imapwire.Encoder) {
+			enc.NumSet(numSet)
+		}, func(dec *imapwire.Decoder) bool {
+			return dec.ExpectNumSet(&got)
+		})
+		if got.String() != numSet.String() {
+			t.Errorf("got %v, want %v", got, numSet)
+		}
+	})
+}
+
+func FuzzList(f *testing.F) {
+	f.Add("a", "b")
+	f.Add("", "\r\n")
+	f.Add(`"`, "(")
+	f.Fuzz(func(t *testing.T, a, b string) {
+		want := []string{a, b, ""}
+
+		var got []string
+		roundTrip(t, func(enc *imapwire.Encoder) {
+			enc.List(len(want), func(i int) {
+				if want[i] == "" {
+					enc.NIL()
+				} else {
+					enc.String(want[i])
+				}
+			})
+		}, func(dec *imapwire.Decoder) bool {
+			err := dec.ExpectList(func() error {
+				var s string
+				if !dec.ExpectNString(&s) {
+					return dec.Err()
+				}
+				got = append(got, s)
+				return nil
+			})
+			return err == nil
+		})
+		if len(got) != len(want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("item #%v: got %q, want %q", i, got[i], want[i])
+			}
+		}
+	})
+}
+
+func FuzzLiteral(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("Subject: hello\r\n\r\nHi!\r\n"))
+	f.Add([]byte{0, '\r', '\n', 0xff})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var got []byte
+		roundTrip(t, func(enc *imapwire.Encoder) {
+			wc := enc.Literal(int64(len(b)), nil)
+			if _, err := wc.Write(b); err != nil {
+				t.Fatalf("Write() = %v", err)
+			}
+			if err := wc.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+		}, func(dec *imapwire.Decoder) bool {
+			lit, _, err := dec.ExpectLiteralReader()
+			if err != nil {
+				return false
+			}
+			got, err = io.ReadAll(lit)
+			return err == nil
+		})
+		if !bytes.Equal(got, b) {
+			t.Errorf("got %q, want %q", got, b)
+		}
+	})
+}
+
+// FuzzDecoder checks that the decoder doesn't panic on arbitrary input.
+func FuzzDecoder(f *testing.F) {
+	f.Add([]byte("* OK [CAPABILITY IMAP4rev2] Hello\r\n"))
+	f.Add([]byte(`(("a" NIL) {3}` + "\r\nabc (\\Seen))\r\n"))
+	f.Add([]byte("{99999999999999999999}\r\n"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		dec := imapwire.NewDecoder(bufio.NewReader(bytes.NewReader(b)), imapwire.ConnSideServer)
+		for dec.Err() == nil && !dec.EOF() {
+			if !dec.DiscardValue() {
+				dec.DiscardLine()
+			} else {
+				dec.SP()
+			}
+		}
+	})
+}
+
+func TestEncoder_syncLiteral(t *testing.T) {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	enc := imapwire.NewEncoder(bw, imapwire.ConnSideClient)
+
+	contReq := imapwire.NewContinuationRequest()
+	done := make(chan error, 1)
+	go func() {
+		wc := enc.Literal(5, contReq)
+		if _, err := io.WriteString(wc, "hello"); err != nil {
+			done <- err
+			return
+		}
+		if err := wc.Close(); err != nil {
+			done <- err
+			return
+		}
+		done <- enc.CRLF()
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("literal written before continuation request: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	contReq.Done("Ready")
+	if err := <-done; err != nil {
+		t.Fatalf("failed to write literal: %v", err)
+	}
+	if got, want := buf.String(), "{5}\r\nhello\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	contReq = imapwire.NewContinuationRequest()
+	contReq.Cancel(nil)
+	wc := enc.Literal(5, contReq)
+	if _, err := io.WriteString(wc, "hello"); err == nil {
+		t.Errorf("Write() succeeded after cancelled continuation request")
+	}
+}
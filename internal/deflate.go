@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"compress/flate"
+	"io"
+)
+
+// NewDeflateReadWriter returns a reader/writer pair which decompresses data
+// read from r and compresses data written to w with DEFLATE, as defined in
+// RFC 4978.
+//
+// Each Write is followed by a sync flush, so that the peer can decompress
+// everything written so far. The caller is expected to buffer writes (e.g.
+// via a bufio.Writer) so that the flush happens once per IMAP command or
+// response.
+func NewDeflateReadWriter(r io.Reader, w io.Writer) io.ReadWriter {
+	fw, err := flate.NewWriter(w, flate.DefaultCompression)
+	if err != nil {
+		panic(err) // unreachable: the compression level is valid
+	}
+	return &deflateReadWriter{
+		r: flate.NewReader(r),
+		w: fw,
+	}
+}
+
+type deflateReadWriter struct {
+	r io.Reader
+	w *flate.Writer
+}
+
+func (rw *deflateReadWriter) Read(b []byte) (int, error) {
+	return rw.r.Read(b)
+}
+
+func (rw *deflateReadWriter) Write(b []byte) (int, error) {
+	n, err := rw.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, rw.w.Flush()
+}
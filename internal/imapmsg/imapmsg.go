@@ -0,0 +1,428 @@
+// Package imapmsg extracts IMAP data from raw messages.
+//
+// It's used by server backends which store messages in their RFC 5322 form.
+package imapmsg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Fetch writes the message data items which only depend on the message
+// contents: INTERNALDATE, RFC822.SIZE, ENVELOPE, BODYSTRUCTURE and BODY[].
+//
+// The caller is responsible for writing the other items and closing w.
+func Fetch(w *imapserver.FetchResponseWriter, buf []byte, t time.Time, options *imap.FetchOptions) error {
+	if options.InternalDate {
+		w.WriteInternalDate(t)
+	}
+	if options.RFC822Size {
+		w.WriteRFC822Size(int64(len(buf)))
+	}
+	if options.Envelope {
+		w.WriteEnvelope(ReadEnvelope(buf))
+	}
+	if bs := options.BodyStructure; bs != nil {
+		w.WriteBodyStructure(BodyStructure(buf, bs.Extended))
+	}
+
+	for _, bs := range options.BodySection {
+		b := BodySection(buf, bs)
+		wc := w.WriteBodySection(bs, int64(len(b)))
+		_, writeErr := wc.Write(b)
+		closeErr := wc.Close()
+		if writeErr != nil {
+			return writeErr
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+
+	// TODO: BinarySection, BinarySectionSize
+
+	return nil
+}
+
+// ReadEnvelope parses the header of a message and returns its envelope.
+//
+// Nil is returned if the header is malformed.
+func ReadEnvelope(buf []byte) *imap.Envelope {
+	br := bufio.NewReader(bytes.NewReader(buf))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil
+	}
+	return Envelope(header)
+}
+
+// BodyStructure returns the body structure of a message.
+func BodyStructure(buf []byte, extended bool) imap.BodyStructure {
+	br := bufio.NewReader(bytes.NewReader(buf))
+	header, _ := textproto.ReadHeader(br)
+	return bodyStructure(header, br, extended)
+}
+
+// Match checks whether a message matches the criteria fields which only
+// depend on the message contents: Since, Before, SentSince, SentBefore,
+// Header, Body, Text, Larger and Smaller.
+//
+// The other fields, including Not and Or, are ignored.
+func Match(buf []byte, t time.Time, criteria *imap.SearchCriteria) bool {
+	if !MatchDate(t, criteria.Since, criteria.Before) {
+		return false
+	}
+
+	if criteria.Larger != 0 && int64(len(buf)) <= criteria.Larger {
+		return false
+	}
+	if criteria.Smaller != 0 && int64(len(buf)) >= criteria.Smaller {
+		return false
+	}
+
+	if !matchBytes(buf, criteria.Text) {
+		return false
+	}
+
+	br := bufio.NewReader(bytes.NewReader(buf))
+	rawHeader, _ := textproto.ReadHeader(br)
+	header := mail.Header{gomessage.Header{rawHeader}}
+
+	for _, fieldCriteria := range criteria.Header {
+		if !header.Has(fieldCriteria.Key) {
+			return false
+		}
+		if fieldCriteria.Value == "" {
+			continue
+		}
+		found := false
+		for _, v := range header.Values(fieldCriteria.Key) {
+			found = strings.Contains(strings.ToLower(v), strings.ToLower(fieldCriteria.Value))
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !criteria.SentSince.IsZero() || !criteria.SentBefore.IsZero() {
+		t, err := header.Date()
+		if err != nil {
+			return false
+		} else if !MatchDate(t, criteria.SentSince, criteria.SentBefore) {
+			return false
+		}
+	}
+
+	if len(criteria.Body) > 0 {
+		body, _ := io.ReadAll(br)
+		if !matchBytes(body, criteria.Body) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func openMessagePart(header textproto.Header, body io.Reader, parentMediaType string) (textproto.Header, io.Reader) {
+	msgHeader := gomessage.Header{header}
+	mediaType, _, _ := msgHeader.ContentType()
+	if !msgHeader.Has("Content-Type") && parentMediaType == "multipart/digest" {
+		mediaType = "message/rfc822"
+	}
+	if mediaType == "message/rfc822" || mediaType == "message/global" {
+		br := bufio.NewReader(body)
+		header, _ = textproto.ReadHeader(br)
+		return header, br
+	}
+	return header, body
+}
+
+// BodySection extracts a body section from a message.
+//
+// Nil is returned if the section doesn't exist.
+func BodySection(buf []byte, item *imap.FetchItemBodySection) []byte {
+	var (
+		header textproto.Header
+		body   io.Reader
+	)
+
+	br := bufio.NewReader(bytes.NewReader(buf))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil
+	}
+	body = br
+
+	// First part of non-multipart message refers to the message itself
+	msgHeader := gomessage.Header{header}
+	mediaType, _, _ := msgHeader.ContentType()
+	partPath := item.Part
+	if !strings.HasPrefix(mediaType, "multipart/") && len(partPath) > 0 && partPath[0] == 1 {
+		partPath = partPath[1:]
+	}
+
+	// Find the requested part using the provided path
+	var parentMediaType string
+	for i := 0; i < len(partPath); i++ {
+		partNum := partPath[i]
+
+		header, body = openMessagePart(header, body, parentMediaType)
+
+		msgHeader := gomessage.Header{header}
+		mediaType, typeParams, _ := msgHeader.ContentType()
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			if partNum != 1 {
+				return nil
+			}
+			continue
+		}
+
+		mr := textproto.NewMultipartReader(body, typeParams["boundary"])
+		found := false
+		for j := 1; j <= partNum; j++ {
+			p, err := mr.NextPart()
+			if err != nil {
+				return nil
+			}
+
+			if j == partNum {
+				parentMediaType = mediaType
+				header = p.Header
+				body = p
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	if len(item.Part) > 0 {
+		switch item.Specifier {
+		case imap.PartSpecifierHeader, imap.PartSpecifierText:
+			header, body = openMessagePart(header, body, parentMediaType)
+		}
+	}
+
+	// Filter header fields
+	if len(item.HeaderFields) > 0 {
+		keep := make(map[string]struct{})
+		for _, k := range item.HeaderFields {
+			keep[strings.ToLower(k)] = struct{}{}
+		}
+		for field := header.Fields(); field.Next(); {
+			if _, ok := keep[strings.ToLower(field.Key())]; !ok {
+				field.Del()
+			}
+		}
+	}
+	for _, k := range item.HeaderFieldsNot {
+		header.Del(k)
+	}
+
+	// Write the requested data to a buffer
+	var out bytes.Buffer
+
+	writeHeader := true
+	switch item.Specifier {
+	case imap.PartSpecifierNone:
+		writeHeader = len(item.Part) == 0
+	case imap.PartSpecifierText:
+		writeHeader = false
+	}
+	if writeHeader {
+		if err := textproto.WriteHeader(&out, header); err != nil {
+			return nil
+		}
+	}
+
+	switch item.Specifier {
+	case imap.PartSpecifierNone, imap.PartSpecifierText:
+		if _, err := io.Copy(&out, body); err != nil {
+			return nil
+		}
+	}
+
+	// Extract partial if any
+	b := out.Bytes()
+	if partial := item.Partial; partial != nil {
+		end := partial.Offset + partial.Size
+		if partial.Offset > int64(len(b)) {
+			return nil
+		}
+		if end > int64(len(b)) {
+			end = int64(len(b))
+		}
+		b = b[partial.Offset:end]
+	}
+	return b
+}
+
+// MatchDate checks whether the date of t is in the [since, before) range. The
+// time and time zone are ignored. Zero bounds are ignored.
+func MatchDate(t, since, before time.Time) bool {
+	// We discard time zone information by setting it to UTC.
+	// RFC 3501 explicitly requires zone unaware date comparison.
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	if !since.IsZero() && t.Before(since) {
+		return false
+	}
+	if !before.IsZero() && !t.Before(before) {
+		return false
+	}
+	return true
+}
+
+func matchBytes(buf []byte, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	buf = bytes.ToLower(buf)
+	for _, s := range patterns {
+		if !bytes.Contains(buf, bytes.ToLower([]byte(s))) {
+			return false
+		}
+	}
+	return true
+}
+
+// Envelope returns the envelope of a message.
+func Envelope(h textproto.Header) *imap.Envelope {
+	date, _ := netmail.ParseDate(h.Get("Date"))
+	return &imap.Envelope{
+		Date:      date,
+		Subject:   h.Get("Subject"),
+		From:      parseAddressList(h.Get("From")),
+		Sender:    parseAddressList(h.Get("Sender")),
+		ReplyTo:   parseAddressList(h.Get("Reply-To")),
+		To:        parseAddressList(h.Get("To")),
+		Cc:        parseAddressList(h.Get("Cc")),
+		Bcc:       parseAddressList(h.Get("Bcc")),
+		InReplyTo: h.Get("In-Reply-To"),
+		MessageID: h.Get("message-Id"),
+	}
+}
+
+func parseAddressList(s string) []imap.Address {
+	if s == "" {
+		return nil
+	}
+
+	// TODO: leave the quoted words unchanged
+	// TODO: handle groups
+	addrs, _ := mail.ParseAddressList(s)
+	var l []imap.Address
+	for _, addr := range addrs {
+		mailbox, host, ok := strings.Cut(addr.Address, "@")
+		if !ok {
+			continue
+		}
+		l = append(l, imap.Address{
+			Name:    mime.QEncoding.Encode("utf-8", addr.Name),
+			Mailbox: mailbox,
+			Host:    host,
+		})
+	}
+	return l
+}
+
+func bodyStructure(rawHeader textproto.Header, r io.Reader, extended bool) imap.BodyStructure {
+	header := gomessage.Header{rawHeader}
+
+	mediaType, typeParams, _ := header.ContentType()
+	primaryType, subType, _ := strings.Cut(mediaType, "/")
+
+	if primaryType == "multipart" {
+		bs := &imap.BodyStructureMultiPart{Subtype: subType}
+		mr := textproto.NewMultipartReader(r, typeParams["boundary"])
+		for {
+			part, _ := mr.NextPart()
+			if part == nil {
+				break
+			}
+			bs.Children = append(bs.Children, bodyStructure(part.Header, part, extended))
+		}
+		if extended {
+			bs.Extended = &imap.BodyStructureMultiPartExt{
+				Params:      typeParams,
+				Disposition: getContentDisposition(header),
+				Language:    getContentLanguage(header),
+				Location:    header.Get("Content-Location"),
+			}
+		}
+		return bs
+	} else {
+		body, _ := io.ReadAll(r) // TODO: optimize
+		bs := &imap.BodyStructureSinglePart{
+			Type:        primaryType,
+			Subtype:     subType,
+			Params:      typeParams,
+			ID:          header.Get("Content-Id"),
+			Description: header.Get("Content-Description"),
+			Encoding:    header.Get("Content-Transfer-Encoding"),
+			Size:        uint32(len(body)),
+		}
+		if mediaType == "message/rfc822" || mediaType == "message/global" {
+			br := bufio.NewReader(bytes.NewReader(body))
+			childHeader, _ := textproto.ReadHeader(br)
+			bs.MessageRFC822 = &imap.BodyStructureMessageRFC822{
+				Envelope:      Envelope(childHeader),
+				BodyStructure: bodyStructure(childHeader, br, extended),
+				NumLines:      int64(bytes.Count(body, []byte("\n"))),
+			}
+		}
+		if primaryType == "text" {
+			bs.Text = &imap.BodyStructureText{
+				NumLines: int64(bytes.Count(body, []byte("\n"))),
+			}
+		}
+		if extended {
+			bs.Extended = &imap.BodyStructureSinglePartExt{
+				Disposition: getContentDisposition(header),
+				Language:    getContentLanguage(header),
+				Location:    header.Get("Content-Location"),
+			}
+		}
+		return bs
+	}
+}
+
+func getContentDisposition(header gomessage.Header) *imap.BodyStructureDisposition {
+	disp, dispParams, _ := header.ContentDisposition()
+	if disp == "" {
+		return nil
+	}
+	return &imap.BodyStructureDisposition{
+		Value:  disp,
+		Params: dispParams,
+	}
+}
+
+func getContentLanguage(header gomessage.Header) []string {
+	v := header.Get("Content-Language")
+	if v == "" {
+		return nil
+	}
+	// TODO: handle CFWS
+	l := strings.Split(v, ",")
+	for i, lang := range l {
+		l[i] = strings.TrimSpace(lang)
+	}
+	return l
+}
@@ -2,12 +2,13 @@ package internal
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/internal/imapwire"
+	"github.com/emersion/go-imap/v2/imapwire"
 )
 
 const (
@@ -17,6 +18,11 @@ const (
 
 const FlagRecent imap.Flag = "\\Recent" // removed in IMAP4rev2
 
+// NewExpectError returns a syntax error for a missing grammar element.
+func NewExpectError(name string) error {
+	return &imapwire.DecoderExpectError{Message: fmt.Sprintf("expected %v", name)}
+}
+
 func DecodeDateTime(dec *imapwire.Decoder) (time.Time, error) {
 	var s string
 	if !dec.Quoted(&s) {
@@ -34,8 +40,8 @@ func ExpectDateTime(dec *imapwire.Decoder) (time.Time, error) {
 	if err != nil {
 		return t, err
 	}
-	if !dec.Expect(!t.IsZero(), "date-time") {
-		return t, dec.Err()
+	if t.IsZero() {
+		return t, NewExpectError("date-time")
 	}
 	return t, nil
 }
@@ -104,6 +110,49 @@ var (
 	canonMailboxAttr map[string]imap.MailboxAttr
 )
 
+// ExpectIDData decodes ID parameters, as sent in the ID command and response.
+// NIL is decoded as a nil pointer.
+func ExpectIDData(dec *imapwire.Decoder) (*imap.IDData, error) {
+	var data *imap.IDData
+	err := dec.ExpectNList(func() error {
+		if data == nil {
+			data = &imap.IDData{}
+		}
+		var k, v string
+		if !dec.ExpectString(&k) || !dec.ExpectSP() || !dec.ExpectNString(&v) {
+			return dec.Err()
+		}
+		(*data)[k] = v
+		return nil
+	})
+	return data, err
+}
+
+// WriteIDData encodes ID parameters. A nil pointer or empty data is encoded
+// as NIL.
+func WriteIDData(enc *imapwire.Encoder, idData *imap.IDData) {
+	if idData == nil || len(*idData) == 0 {
+		enc.NIL()
+		return
+	}
+
+	keys := make([]string, 0, len(*idData))
+	for k := range *idData {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	enc.List(len(keys), func(i int) {
+		k := keys[i]
+		enc.String(k).SP()
+		if v := (*idData)[k]; v == "" {
+			enc.NIL()
+		} else {
+			enc.String(v)
+		}
+	})
+}
+
 func canonInit() {
 	flags := []imap.Flag{
 		imap.FlagSeen,
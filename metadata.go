@@ -0,0 +1,40 @@
+package imap
+
+import (
+	"fmt"
+)
+
+// GetMetadataDepth is the depth of a GETMETADATA command.
+type GetMetadataDepth int
+
+const (
+	GetMetadataDepthZero     GetMetadataDepth = 0
+	GetMetadataDepthOne      GetMetadataDepth = 1
+	GetMetadataDepthInfinity GetMetadataDepth = -1
+)
+
+func (depth GetMetadataDepth) String() string {
+	switch depth {
+	case GetMetadataDepthZero:
+		return "0"
+	case GetMetadataDepthOne:
+		return "1"
+	case GetMetadataDepthInfinity:
+		return "infinity"
+	default:
+		panic(fmt.Errorf("imap: unknown GETMETADATA depth %d", depth))
+	}
+}
+
+// GetMetadataOptions contains options for the GETMETADATA command.
+type GetMetadataOptions struct {
+	MaxSize *uint32
+	Depth   GetMetadataDepth
+}
+
+// GetMetadataData is the data returned by the GETMETADATA command.
+type GetMetadataData struct {
+	Mailbox     string
+	EntryList   []string
+	EntryValues map[string]*[]byte
+}
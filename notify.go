@@ -0,0 +1,51 @@
+package imap
+
+// NotifyOptions contains options for the NOTIFY command.
+type NotifyOptions struct {
+	// Status requests STATUS responses for all of the watched mailboxes
+	// when the command completes.
+	Status bool
+	Items  []NotifyItem
+}
+
+// NotifyItem is an event group: a set of mailboxes and the events to watch
+// for these mailboxes.
+type NotifyItem struct {
+	MailboxSpec NotifyMailboxSpec
+	// Mailboxes is used by NotifyMailboxSpecSubtree and
+	// NotifyMailboxSpecMailboxes.
+	Mailboxes []string
+	// Events is the list of events to watch. If empty, no events are reported
+	// for the mailboxes.
+	Events []NotifyEvent
+}
+
+// NotifyMailboxSpec describes the mailboxes an event group applies to.
+type NotifyMailboxSpec string
+
+const (
+	NotifyMailboxSpecSelected        NotifyMailboxSpec = "selected"
+	NotifyMailboxSpecSelectedDelayed NotifyMailboxSpec = "selected-delayed"
+	NotifyMailboxSpecInboxes         NotifyMailboxSpec = "inboxes"
+	NotifyMailboxSpecPersonal        NotifyMailboxSpec = "personal"
+	NotifyMailboxSpecSubscribed      NotifyMailboxSpec = "subscribed"
+	NotifyMailboxSpecSubtree         NotifyMailboxSpec = "subtree"
+	NotifyMailboxSpecMailboxes       NotifyMailboxSpec = "mailboxes"
+)
+
+// NotifyEvent is an event which can be watched with NOTIFY.
+type NotifyEvent string
+
+const (
+	// Message events
+	NotifyEventMessageNew       NotifyEvent = "MessageNew"
+	NotifyEventMessageExpunge   NotifyEvent = "MessageExpunge"
+	NotifyEventFlagChange       NotifyEvent = "FlagChange"
+	NotifyEventAnnotationChange NotifyEvent = "AnnotationChange"
+
+	// Mailbox events
+	NotifyEventMailboxName           NotifyEvent = "MailboxName"
+	NotifyEventSubscriptionChange    NotifyEvent = "SubscriptionChange"
+	NotifyEventMailboxMetadataChange NotifyEvent = "MailboxMetadataChange"
+	NotifyEventServerMetadataChange  NotifyEvent = "ServerMetadataChange"
+)
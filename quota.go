@@ -11,3 +11,15 @@ const (
 	QuotaResourceMailbox           QuotaResourceType = "MAILBOX"
 	QuotaResourceAnnotationStorage QuotaResourceType = "ANNOTATION-STORAGE"
 )
+
+// QuotaData is the data returned by a QUOTA response.
+type QuotaData struct {
+	Root      string
+	Resources map[QuotaResourceType]QuotaResourceData
+}
+
+// QuotaResourceData contains the usage and limit for a quota resource.
+type QuotaResourceData struct {
+	Usage int64
+	Limit int64
+}
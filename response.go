@@ -44,11 +44,20 @@ const (
 	ResponseCodeUnknownCTE           ResponseCode = "UNKNOWN-CTE"
 
 	// METADATA
-	ResponseCodeTooMany   ResponseCode = "TOOMANY"
-	ResponseCodeNoPrivate ResponseCode = "NOPRIVATE"
+	ResponseCodeTooMany     ResponseCode = "TOOMANY"
+	ResponseCodeNoPrivate   ResponseCode = "NOPRIVATE"
+	ResponseCodeMaxSize     ResponseCode = "MAXSIZE"
+	ResponseCodeLongEntries ResponseCode = "LONGENTRIES"
 
 	// APPENDLIMIT
 	ResponseCodeTooBig ResponseCode = "TOOBIG"
+
+	// COMPRESS
+	ResponseCodeCompressionActive ResponseCode = "COMPRESSIONACTIVE"
+
+	// NOTIFY
+	ResponseCodeNotificationOverflow ResponseCode = "NOTIFICATIONOVERFLOW"
+	ResponseCodeBadEvent             ResponseCode = "BADEVENT"
 )
 
 // StatusResponse is a generic status response.
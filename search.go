@@ -14,6 +14,14 @@ type SearchOptions struct {
 	ReturnCount bool
 	// Requires IMAP4rev2 or SEARCHRES
 	ReturnSave bool
+	// Requires ESORT
+	ReturnPartial *SearchPartialRange
+}
+
+// SearchPartialRange is a range of positions in the list of results, starting
+// from 1.
+type SearchPartialRange struct {
+	Start, Stop uint32
 }
 
 // SearchCriteria is a criteria for the SEARCH command.
@@ -59,6 +67,9 @@ type SearchCriteria struct {
 	Or  [][2]SearchCriteria
 
 	ModSeq *SearchCriteriaModSeq // requires CONDSTORE
+
+	// Gmail search queries, using the same syntax as the Gmail web interface
+	GmailRaw []string // requires X-GM-EXT-1
 }
 
 // And intersects two search criteria.
@@ -87,6 +98,12 @@ func (criteria *SearchCriteria) And(other *SearchCriteria) {
 
 	criteria.Not = append(criteria.Not, other.Not...)
 	criteria.Or = append(criteria.Or, other.Or...)
+
+	if criteria.ModSeq == nil || (other.ModSeq != nil && other.ModSeq.ModSeq > criteria.ModSeq.ModSeq) {
+		criteria.ModSeq = other.ModSeq
+	}
+
+	criteria.GmailRaw = append(criteria.GmailRaw, other.GmailRaw...)
 }
 
 func intersectSince(t1, t2 time.Time) time.Time {
@@ -145,6 +162,15 @@ type SearchData struct {
 
 	// requires CONDSTORE
 	ModSeq uint64
+
+	// requires ESORT
+	Partial *SearchPartialData
+}
+
+// SearchPartialData is the data returned for SearchOptions.ReturnPartial.
+type SearchPartialData struct {
+	Range SearchPartialRange
+	All   NumSet
 }
 
 // AllNums returns All as a slice of numbers.
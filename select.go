@@ -3,7 +3,29 @@ package imap
 // SelectOptions contains options for the SELECT or EXAMINE command.
 type SelectOptions struct {
 	ReadOnly  bool
-	CondStore bool // requires CONDSTORE
+	CondStore bool           // requires CONDSTORE
+	QResync   *SelectQResync // requires QRESYNC
+}
+
+// SelectQResync contains QRESYNC parameters for the SELECT or EXAMINE command.
+//
+// The client must have enabled QRESYNC with the ENABLE command.
+type SelectQResync struct {
+	// Last known UIDVALIDITY and HIGHESTMODSEQ for the mailbox
+	UIDValidity uint32
+	ModSeq      uint64
+	// UIDs known to the client (optional)
+	KnownUIDs NumSet
+	// Message sequence number to UID mapping known to the client (optional)
+	SeqMatch *SelectQResyncSeqMatch
+}
+
+// SelectQResyncSeqMatch contains a sample of message sequence numbers and
+// their corresponding UIDs, used by the server to compute VANISHED responses
+// more efficiently.
+type SelectQResyncSeqMatch struct {
+	SeqNums NumSet
+	UIDs    NumSet
 }
 
 // SelectData is the data returned by a SELECT command.
@@ -18,6 +40,9 @@ type SelectData struct {
 	NumMessages uint32
 	UIDNext     UID
 	UIDValidity uint32
+	// Whether the mailbox is opened in read-only mode, for instance because
+	// the user isn't allowed to modify it
+	ReadOnly bool
 
 	List *ListData // requires IMAP4rev2
 
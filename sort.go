@@ -0,0 +1,148 @@
+package imap
+
+import (
+	"mime"
+	"strings"
+)
+
+// SortKey is a key used to sort messages.
+type SortKey string
+
+const (
+	SortKeyArrival SortKey = "ARRIVAL"
+	SortKeyCc      SortKey = "CC"
+	SortKeyDate    SortKey = "DATE"
+	SortKeyFrom    SortKey = "FROM"
+	SortKeySize    SortKey = "SIZE"
+	SortKeySubject SortKey = "SUBJECT"
+	SortKeyTo      SortKey = "TO"
+
+	// requires SORT=DISPLAY
+	SortKeyDisplayFrom SortKey = "DISPLAYFROM"
+	SortKeyDisplayTo   SortKey = "DISPLAYTO"
+)
+
+// SortCriterion is a criterion for the SORT command.
+type SortCriterion struct {
+	Key     SortKey
+	Reverse bool
+}
+
+// BaseSubject returns the base subject of a message, as defined in RFC 5256
+// section 2.1.
+//
+// Reply and forward markers, subject blobs (e.g. "[mailing-list]") and
+// "(fwd)" trailers are stripped. The base subject is used by SORT SUBJECT and
+// by the threading algorithms.
+func BaseSubject(subject string) string {
+	base, _ := baseSubject(subject)
+	return base
+}
+
+// baseSubject extracts the base subject and reports whether the subject
+// indicates a reply or a forward.
+func baseSubject(subject string) (base string, isReplyOrFwd bool) {
+	// (1) Decode RFC 2047 encoded-words and normalize whitespace
+	var dec mime.WordDecoder
+	if s, err := dec.DecodeHeader(subject); err == nil {
+		subject = s
+	}
+	s := strings.Join(strings.Fields(subject), " ")
+
+	for {
+		// (2) Remove trailers
+		for {
+			s = strings.TrimRight(s, " ")
+			if !hasSuffixFold(s, "(fwd)") {
+				break
+			}
+			s = s[:len(s)-len("(fwd)")]
+			isReplyOrFwd = true
+		}
+
+		// (5) Repeat (3) and (4) until no more changes
+		for {
+			prev := s
+
+			// (3) Remove leaders
+			for {
+				s = strings.TrimLeft(s, " ")
+				rest, ok := trimSubjectRefwd(s)
+				if !ok {
+					break
+				}
+				s = rest
+				isReplyOrFwd = true
+			}
+
+			// (4) Remove a leading blob, unless it's the whole subject
+			if rest, ok := trimSubjectBlob(s); ok && rest != "" {
+				s = rest
+			}
+
+			if s == prev {
+				break
+			}
+		}
+
+		// (6) Remove the "[fwd:" and "]" wrapper
+		if hasPrefixFold(s, "[fwd:") && strings.HasSuffix(s, "]") {
+			s = s[len("[fwd:") : len(s)-1]
+			isReplyOrFwd = true
+			continue
+		}
+
+		return s, isReplyOrFwd
+	}
+}
+
+// trimSubjectRefwd removes a subj-leader of the form *subj-blob subj-refwd.
+func trimSubjectRefwd(s string) (string, bool) {
+	for {
+		rest, ok := trimSubjectBlob(s)
+		if !ok {
+			break
+		}
+		s = rest
+	}
+
+	switch {
+	case hasPrefixFold(s, "re"):
+		s = s[len("re"):]
+	case hasPrefixFold(s, "fwd"):
+		s = s[len("fwd"):]
+	case hasPrefixFold(s, "fw"):
+		s = s[len("fw"):]
+	default:
+		return "", false
+	}
+	s = strings.TrimLeft(s, " ")
+	if rest, ok := trimSubjectBlob(s); ok {
+		s = rest
+	}
+	if !strings.HasPrefix(s, ":") {
+		return "", false
+	}
+	return s[1:], true
+}
+
+// trimSubjectBlob removes a subj-blob, ie. a bracketed string followed by
+// whitespace.
+func trimSubjectBlob(s string) (string, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return "", false
+	}
+	i := strings.IndexAny(s[1:], "[]")
+	if i < 0 || s[1+i] != ']' {
+		return "", false
+	}
+	return strings.TrimLeft(s[i+2:], " "), true
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
@@ -0,0 +1,39 @@
+package imap_test
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+var baseSubjectTests = []struct {
+	subject, base string
+}{
+	{"", ""},
+	{"Hello", "Hello"},
+	{"  Hello \t world  ", "Hello world"},
+	{"Re: Hello", "Hello"},
+	{"RE: re: Re:Hello", "Hello"},
+	{"Fw: Hello", "Hello"},
+	{"Fwd: Hello (fwd)", "Hello"},
+	{"Hello (fwd) (FWD)", "Hello"},
+	{"Re[2]: Hello", "Hello"},
+	{"Re [list]: Hello", "Hello"},
+	{"[list] Re: Hello", "Hello"},
+	{"Re: [list] Hello", "Hello"},
+	{"[list] [other] Hello", "Hello"},
+	{"[list]", "[list]"},
+	{"[fwd: Re: Hello]", "Hello"},
+	{"Re: [Fwd: [list] Hello (fwd)]", "Hello"},
+	{"Reply", "Reply"},
+	{"Re Hello", "Re Hello"},
+	{"=?utf-8?q?Re:_Caf=C3=A9?=", "Café"},
+}
+
+func TestBaseSubject(t *testing.T) {
+	for _, tc := range baseSubjectTests {
+		if base := imap.BaseSubject(tc.subject); base != tc.base {
+			t.Errorf("BaseSubject(%q) = %q, want %q", tc.subject, base, tc.base)
+		}
+	}
+}
@@ -20,3 +20,14 @@ type StoreFlags struct {
 	Silent bool
 	Flags  []Flag
 }
+
+// StoreGmailLabels alters Gmail message labels.
+//
+// System labels start with a backslash, for instance `\Inbox`.
+//
+// This requires the X-GM-EXT-1 extension.
+type StoreGmailLabels struct {
+	Op     StoreFlagsOp
+	Silent bool
+	Labels []string
+}
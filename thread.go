@@ -1,5 +1,12 @@
 package imap
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
 // ThreadAlgorithm is a threading algorithm.
 type ThreadAlgorithm string
 
@@ -7,3 +14,388 @@ const (
 	ThreadOrderedSubject ThreadAlgorithm = "ORDEREDSUBJECT"
 	ThreadReferences     ThreadAlgorithm = "REFERENCES"
 )
+
+// ThreadData represents a thread of messages.
+//
+// Chain contains the message numbers of a chain of messages, each message
+// being the parent of the next one. SubThreads contains the children of the
+// last message of the chain. If Chain is empty, SubThreads are siblings whose
+// common parent is missing.
+type ThreadData struct {
+	Chain      []uint32
+	SubThreads []ThreadData
+}
+
+// ThreadMessage contains the message data used by a Threader.
+type ThreadMessage struct {
+	// Message number, either a sequence number or a UID
+	Num      uint32
+	Envelope *Envelope
+	// Raw value of the References header field
+	References string
+	// Used instead of Envelope.Date if the message has no valid Date header
+	// field
+	InternalDate time.Time
+}
+
+// Threader builds message threads, as defined in RFC 5256.
+//
+// Messages must be added in mailbox order: it's used to break ties between
+// messages with the same sent date.
+type Threader struct {
+	msgs []ThreadMessage
+}
+
+// Add adds a message to the threader.
+func (t *Threader) Add(msg *ThreadMessage) {
+	t.msgs = append(t.msgs, *msg)
+}
+
+// Thread builds the message threads with the specified algorithm.
+func (t *Threader) Thread(algorithm ThreadAlgorithm) ([]ThreadData, error) {
+	l := make([]*threadContainer, len(t.msgs))
+	for i := range t.msgs {
+		l[i] = newThreadContainer(&t.msgs[i], i)
+	}
+
+	var roots []*threadContainer
+	switch algorithm {
+	case ThreadOrderedSubject:
+		roots = threadOrderedSubject(l)
+	case ThreadReferences:
+		roots = threadReferences(l)
+	default:
+		return nil, fmt.Errorf("imap: unsupported threading algorithm %q", algorithm)
+	}
+
+	threads := make([]ThreadData, len(roots))
+	for i, c := range roots {
+		threads[i] = c.threadData()
+	}
+	return threads, nil
+}
+
+// threadContainer is a node in a thread tree. Dummy containers have a nil
+// msg.
+type threadContainer struct {
+	msg      *ThreadMessage
+	index    int
+	date     time.Time
+	subject  string
+	isReply  bool
+	parent   *threadContainer
+	children []*threadContainer
+}
+
+func newThreadContainer(msg *ThreadMessage, index int) *threadContainer {
+	c := new(threadContainer)
+	c.setMessage(msg, index)
+	return c
+}
+
+func (c *threadContainer) setMessage(msg *ThreadMessage, index int) {
+	c.msg = msg
+	c.index = index
+
+	envelope := msg.Envelope
+	if envelope == nil {
+		envelope = new(Envelope)
+	}
+	c.date = envelope.Date
+	if c.date.IsZero() {
+		c.date = msg.InternalDate
+	}
+	c.subject, c.isReply = baseSubject(envelope.Subject)
+	c.subject = strings.ToLower(c.subject)
+}
+
+// first returns the container used to sort and group a container: dummy
+// containers use their first child.
+func (c *threadContainer) first() *threadContainer {
+	for c.msg == nil && len(c.children) > 0 {
+		c = c.children[0]
+	}
+	return c
+}
+
+func (c *threadContainer) hasAncestor(ancestor *threadContainer) bool {
+	for p := c.parent; p != nil; p = p.parent {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *threadContainer) addChild(child *threadContainer) {
+	child.parent = c
+	c.children = append(c.children, child)
+}
+
+func (c *threadContainer) unlink() {
+	if c.parent == nil {
+		return
+	}
+	siblings := c.parent.children
+	for i, sibling := range siblings {
+		if sibling == c {
+			c.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+	c.parent = nil
+}
+
+func (c *threadContainer) threadData() ThreadData {
+	var data ThreadData
+	for {
+		if c.msg != nil {
+			data.Chain = append(data.Chain, c.msg.Num)
+			if len(c.children) == 1 {
+				c = c.children[0]
+				continue
+			}
+		}
+		for _, child := range c.children {
+			data.SubThreads = append(data.SubThreads, child.threadData())
+		}
+		return data
+	}
+}
+
+func compareThreadContainers(a, b *threadContainer) bool {
+	a, b = a.first(), b.first()
+	if !a.date.Equal(b.date) {
+		return a.date.Before(b.date)
+	}
+	return a.index < b.index
+}
+
+func sortThreadContainers(l []*threadContainer) {
+	sort.SliceStable(l, func(i, j int) bool {
+		return compareThreadContainers(l[i], l[j])
+	})
+}
+
+// sortThreadChildren recursively sorts the children of each container.
+func sortThreadChildren(l []*threadContainer) {
+	for _, c := range l {
+		sortThreadChildren(c.children)
+		sortThreadContainers(c.children)
+	}
+}
+
+// threadOrderedSubject implements the ORDEREDSUBJECT algorithm, see RFC 5256
+// section 4.
+func threadOrderedSubject(l []*threadContainer) []*threadContainer {
+	sort.SliceStable(l, func(i, j int) bool {
+		if l[i].subject != l[j].subject {
+			return l[i].subject < l[j].subject
+		}
+		return compareThreadContainers(l[i], l[j])
+	})
+
+	// The first message of each group with the same base subject is the
+	// parent, the others are its children
+	var roots []*threadContainer
+	for _, c := range l {
+		if n := len(roots); n > 0 && roots[n-1].subject == c.subject {
+			roots[n-1].addChild(c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	sortThreadContainers(roots)
+	return roots
+}
+
+// threadReferences implements the REFERENCES algorithm, see RFC 5256
+// section 4.
+func threadReferences(l []*threadContainer) []*threadContainer {
+	// (1) Link messages together using Message-ID, In-Reply-To and References
+	containers := make([]*threadContainer, 0, len(l))
+	ids := make(map[string]*threadContainer)
+	for i, c := range l {
+		if id := firstMsgID(c.msg.Envelope.messageID()); id != "" {
+			if existing, ok := ids[id]; !ok {
+				ids[id] = c
+			} else if existing.msg == nil {
+				// Fill the dummy container created by a previous reference
+				existing.setMessage(c.msg, c.index)
+				c = existing
+			}
+			// Otherwise, the Message-ID is a duplicate and the message is
+			// treated as if it had none
+		}
+		if c == l[i] {
+			containers = append(containers, c)
+		}
+
+		refs := parseMsgIDs(c.msg.References)
+		if len(refs) == 0 {
+			if id := firstMsgID(c.msg.Envelope.inReplyTo()); id != "" {
+				refs = []string{id}
+			}
+		}
+
+		var parent *threadContainer
+		for _, ref := range refs {
+			rc, ok := ids[ref]
+			if !ok {
+				rc = &threadContainer{}
+				ids[ref] = rc
+				containers = append(containers, rc)
+			}
+			if parent != nil && rc.parent == nil && rc != parent && !parent.hasAncestor(rc) {
+				parent.addChild(rc)
+			}
+			parent = rc
+		}
+
+		if parent != nil && (parent == c || parent.hasAncestor(c)) {
+			continue
+		}
+		c.unlink()
+		if parent != nil {
+			parent.addChild(c)
+		}
+	}
+
+	// (2) Gather the root set
+	var roots []*threadContainer
+	for _, c := range containers {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	// (3) Prune dummy containers
+	roots = pruneThreadContainers(roots, nil)
+
+	// (4) Sort the root set
+	sortThreadChildren(roots)
+	sortThreadContainers(roots)
+
+	// (5) Group the root set by base subject
+	subjects := make(map[string]*threadContainer)
+	for _, c := range roots {
+		first := c.first()
+		if first.subject == "" {
+			continue
+		}
+		existing, ok := subjects[first.subject]
+		if !ok || (existing.msg != nil && c.msg == nil) || (existing.msg != nil && c.msg != nil && existing.isReply && !c.isReply) {
+			subjects[first.subject] = c
+		}
+	}
+
+	merged := make([]*threadContainer, 0, len(roots))
+	for _, c := range roots {
+		first := c.first()
+		if first.subject == "" {
+			merged = append(merged, c)
+			continue
+		}
+		existing := subjects[first.subject]
+		if existing == c {
+			merged = append(merged, c)
+			continue
+		}
+
+		switch {
+		case existing.msg == nil && c.msg == nil:
+			for _, child := range c.children {
+				existing.addChild(child)
+			}
+			c.children = nil
+		case existing.msg == nil:
+			existing.addChild(c)
+		case !existing.isReply && c.isReply:
+			existing.addChild(c)
+		default:
+			dummy := &threadContainer{}
+			replaceThreadContainer(roots, existing, dummy)
+			replaceThreadContainer(merged, existing, dummy)
+			subjects[first.subject] = dummy
+			dummy.addChild(existing)
+			dummy.addChild(c)
+		}
+	}
+	roots = merged
+
+	// (6) Sort the messages at each level of the tree
+	sortThreadChildren(roots)
+
+	return roots
+}
+
+func replaceThreadContainer(l []*threadContainer, old, new *threadContainer) {
+	for i, c := range l {
+		if c == old {
+			l[i] = new
+		}
+	}
+}
+
+// pruneThreadContainers removes dummy containers without children, and
+// promotes the children of other dummy containers, except when that would
+// promote more than one child to the root set.
+func pruneThreadContainers(l []*threadContainer, parent *threadContainer) []*threadContainer {
+	var out []*threadContainer
+	for _, c := range l {
+		c.children = pruneThreadContainers(c.children, c)
+		if c.msg == nil && (parent != nil || len(c.children) <= 1) {
+			for _, child := range c.children {
+				child.parent = parent
+			}
+			out = append(out, c.children...)
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func (envelope *Envelope) messageID() string {
+	if envelope == nil {
+		return ""
+	}
+	return envelope.MessageID
+}
+
+func (envelope *Envelope) inReplyTo() string {
+	if envelope == nil {
+		return ""
+	}
+	return envelope.InReplyTo
+}
+
+// parseMsgIDs extracts the message identifiers from a header field value,
+// ignoring anything outside angle brackets.
+func parseMsgIDs(s string) []string {
+	var ids []string
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			break
+		}
+		s = s[start+1:]
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(s[:end]); id != "" && !strings.ContainsAny(id, "<") {
+			ids = append(ids, id)
+		}
+		s = s[end+1:]
+	}
+	return ids
+}
+
+func firstMsgID(s string) string {
+	if ids := parseMsgIDs(s); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
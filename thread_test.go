@@ -0,0 +1,89 @@
+package imap_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+var threadMessages = []struct {
+	subject, messageID, inReplyTo, references string
+}{
+	{"A", "<1@example.org>", "", ""},
+	{"Re: A", "<2@example.org>", "<1@example.org>", "<1@example.org>"},
+	{"Re: A", "<3@example.org>", "<2@example.org>", "<1@example.org> <2@example.org>"},
+	{"B", "<4@example.org>", "", ""},
+	{"Re: A", "<5@example.org>", "<1@example.org>", ""},
+	{"C", "<6@example.org>", "", "<missing@example.org>"},
+	{"Re: C", "<7@example.org>", "", "<missing@example.org>"},
+	{"Re: B", "<8@example.org>", "", ""},
+}
+
+var threaderTests = []struct {
+	algorithm imap.ThreadAlgorithm
+	threads   []imap.ThreadData
+}{
+	{
+		algorithm: imap.ThreadOrderedSubject,
+		threads: []imap.ThreadData{
+			{
+				Chain: []uint32{1},
+				SubThreads: []imap.ThreadData{
+					{Chain: []uint32{2}},
+					{Chain: []uint32{3}},
+					{Chain: []uint32{5}},
+				},
+			},
+			{Chain: []uint32{4, 8}},
+			{Chain: []uint32{6, 7}},
+		},
+	},
+	{
+		algorithm: imap.ThreadReferences,
+		threads: []imap.ThreadData{
+			{
+				Chain: []uint32{1},
+				SubThreads: []imap.ThreadData{
+					{Chain: []uint32{2, 3}},
+					{Chain: []uint32{5}},
+				},
+			},
+			{Chain: []uint32{4, 8}},
+			{
+				SubThreads: []imap.ThreadData{
+					{Chain: []uint32{6}},
+					{Chain: []uint32{7}},
+				},
+			},
+		},
+	},
+}
+
+func TestThreader(t *testing.T) {
+	date := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	for _, tc := range threaderTests {
+		var threader imap.Threader
+		for i, msg := range threadMessages {
+			threader.Add(&imap.ThreadMessage{
+				Num: uint32(i + 1),
+				Envelope: &imap.Envelope{
+					Date:      date.Add(time.Duration(i) * time.Hour),
+					Subject:   msg.subject,
+					MessageID: msg.messageID,
+					InReplyTo: msg.inReplyTo,
+				},
+				References: msg.references,
+			})
+		}
+
+		threads, err := threader.Thread(tc.algorithm)
+		if err != nil {
+			t.Errorf("Thread(%v) = %v", tc.algorithm, err)
+		} else if !reflect.DeepEqual(threads, tc.threads) {
+			t.Errorf("Thread(%v) = %+v, want %+v", tc.algorithm, threads, tc.threads)
+		}
+	}
+}